@@ -31,7 +31,7 @@ const LOG_FILE_NAME = "data/bumble.log"
 // [BTREE]
 // Listens for SIGINT or SIGTERM and calls table.CloseDB().
 func setupCloseHandler(database *db.Database) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -78,6 +78,13 @@ func main() {
 	var promptFlag = flag.Bool("c", true, "use prompt?")
 	var projectFlag = flag.String("project", "", "choose project: [go,pager,db,query,concurrency,recovery] (required)")
 
+	// [PAGER]
+	var policyFlag = flag.String("policy", pager.DEFAULT_POLICY, "buffer replacement policy: [lru,clock,lru-k,2q]")
+	var pageSizeFlag = flag.Int64("pagesize", pager.PAGESIZE, "page size in bytes")
+	var poolFlag = flag.Int64("pages", 0, fmt.Sprintf("buffer pool size in pages (default %v, or %v for the pager project)", config.BufferPoolPages, pager.MAXPAGES))
+	var trackPinsFlag = flag.Bool("trackpins", false, "record who pins each page, and report pages left pinned on close")
+	var keyFileFlag = flag.String("keyfile", "", fmt.Sprintf("file holding a hex-encoded AES key to encrypt tables and the log with (default $%v)", pager.KEY_ENV_VAR))
+
 	// [BTREE]
 	var dbFlag = flag.String("db", "data/", "DB folder")
 
@@ -86,9 +93,31 @@ func main() {
 
 	flag.Parse()
 
+	// [PAGER]
+	// Load the encryption key, if any.
+	var cipher *pager.Cipher
+	key, err := pager.LoadKey(*keyFileFlag)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if key != nil {
+		if cipher, err = pager.NewCipher(key); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
 	// [BTREE]
 	// Open the db.
-	database, err := db.Open(*dbFlag)
+	dbOpts := []db.Option{db.WithPageSize(*pageSizeFlag), db.WithCipher(cipher)}
+	if *poolFlag > 0 {
+		dbOpts = append(dbOpts, db.WithBufferPoolPages(*poolFlag))
+	}
+	if *trackPinsFlag {
+		dbOpts = append(dbOpts, db.WithPinTracking())
+	}
+	database, err := db.Open(*dbFlag, dbOpts...)
 	if err != nil {
 		panic(err)
 	}
@@ -124,7 +153,25 @@ func main() {
 
 	// [PAGER]
 	case "pager":
-		pRepl, err := pager.PagerRepl()
+		policy, err := pager.NewPolicy(*policyFlag)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		numPages := int64(pager.MAXPAGES)
+		if *poolFlag > 0 {
+			numPages = *poolFlag
+		}
+		pool, err := pager.NewBufferPool(numPages, *pageSizeFlag, policy)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		pagerOpts := []pager.Option{pager.WithBufferPool(pool), pager.WithCipher(cipher)}
+		if *trackPinsFlag {
+			pagerOpts = append(pagerOpts, pager.WithPinTracking())
+		}
+		pRepl, err := pager.PagerRepl(pagerOpts...)
 		if err != nil {
 			fmt.Println(err)
 			return
@@ -161,7 +208,10 @@ func main() {
 		}
 		repls = append(repls, recovery.RecoveryREPL(database, tm, rm))
 		// Recover in this case!
-		rm.Recover()
+		if err = rm.Recover(); err != nil {
+			fmt.Println(err)
+			return
+		}
 
 	default:
 		fmt.Println("must specify -project [go,pager,db,query,concurrency,recovery]")
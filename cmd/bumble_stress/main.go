@@ -23,7 +23,7 @@ var MAX_DELAY int64 = 10
 
 // Listens for SIGINT or SIGTERM and calls table.CloseDB().
 func setupCloseHandler(database *db.Database) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
@@ -33,8 +33,11 @@ func setupCloseHandler(database *db.Database) {
 	}()
 }
 
-// Get delay jitter.
+// Get delay jitter. A maximum delay of zero disables it.
 func jitter() time.Duration {
+	if MAX_DELAY <= 0 {
+		return 0
+	}
 	return time.Duration(rand.Int63n(MAX_DELAY)+1) * time.Millisecond
 }
 
@@ -55,10 +58,10 @@ func parseWorkload(path string) ([]string, error) {
 	return workload, scanner.Err()
 }
 
-// Handle workload
-func handleWorkload(c chan string, wg *sync.WaitGroup, workload []string, idx int, n int) {
+// Handle workload, then close the worker's channel.
+func handleWorkload(c chan string, workload []string, idx int, n int) {
 	// Iterate!
-	defer wg.Done()
+	defer close(c)
 	for i := idx; i < len(workload); i += n {
 		time.Sleep(jitter())
 		c <- workload[i]
@@ -72,7 +75,9 @@ func main() {
 	var workloadFlag = flag.String("workload", "", "workload file (required)")
 	var nFlag = flag.Int("n", 1, "number of threads to run (default: 1)")
 	var verifyFlag = flag.Bool("verify", false, "enable to verify database state at the end of the workload")
+	var delayFlag = flag.Int64("delay", MAX_DELAY, "maximum delay before each command in milliseconds; 0 disables it")
 	flag.Parse()
+	MAX_DELAY = *delayFlag
 	// Open the db.
 	database, err := db.Open("data")
 	if err != nil {
@@ -90,18 +95,30 @@ func main() {
 	// Clean up old db resources.
 	os.Remove("./data/t")
 	os.Remove("./data/t.meta")
-	// Run REPL.
+	// Run a REPL for each thread, so that their commands run concurrently.
+	if *nFlag < 1 {
+		fmt.Println("must run at least one thread")
+		return
+	}
 	r := db.DatabaseRepl(database)
-	c := make(chan string)
-	go r.RunChan(c, uuid.New(), "")
+	var wg sync.WaitGroup
+	channels := make([]chan string, *nFlag)
+	for i := range channels {
+		channels[i] = make(chan string)
+		wg.Add(1)
+		go func(c chan string) {
+			defer wg.Done()
+			r.RunChan(c, uuid.New(), "")
+		}(channels[i])
+	}
 	// Some time to wake up...
 	time.Sleep(STARTUP)
 	// Initialize the db.
 	switch *indexFlag {
 	case "btree":
-		c <- "create btree table t"
+		channels[0] <- "create btree table t"
 	case "hash":
-		c <- "create hash table t"
+		channels[0] <- "create hash table t"
 	default:
 		fmt.Println("must specify -index [btree,hash]")
 		return
@@ -118,12 +135,13 @@ func main() {
 	}
 	// Some time to wake up...
 	time.Sleep(STARTUP)
-	var wg sync.WaitGroup
-	for i := 0; i < *nFlag; i++ {
-		wg.Add(1)
-		go handleWorkload(c, &wg, workload, i, *nFlag)
+	// Run the workload, and wait for every command to finish.
+	start := time.Now()
+	for i, c := range channels {
+		go handleWorkload(c, workload, i, *nFlag)
 	}
 	wg.Wait()
+	fmt.Printf("ran %v commands on %v threads in %v\n", len(workload), *nFlag, time.Since(start))
 	// Verify the structure of the index.
 	if *verifyFlag {
 		index, err := database.GetTable("t")
@@ -131,13 +149,19 @@ func main() {
 			fmt.Println("error getting table t")
 			return
 		}
+		var valid bool
 		switch *indexFlag {
 		case "btree":
 			index := index.(*btree.BTreeIndex)
-			btree.IsBTree(index)
+			_, _, valid, err = btree.IsBTree(index)
 		case "hash":
 			index := index.(*hash.HashIndex)
-			hash.IsHash(index)
+			valid, err = hash.IsHash(index)
+		}
+		if err != nil {
+			fmt.Printf("error verifying table t: %v\n", err)
+			return
 		}
+		fmt.Printf("table t is valid: %v\n", valid)
 	}
 }
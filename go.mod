@@ -8,7 +8,6 @@ require (
 	github.com/google/uuid v1.3.0
 	github.com/icza/backscanner v0.0.0-20230330133933-bf6beb754c70
 	github.com/ncw/directio v1.0.5
-	github.com/otiai10/copy v1.14.0
 	github.com/spaolacci/murmur3 v1.1.0
 	golang.org/x/sync v0.4.0
 )
@@ -0,0 +1,200 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// TreeStats describes the shape of a B+tree.
+type TreeStats struct {
+	Height          int64   // Levels in the tree, counting the leaves.
+	NodesPerLevel   []int64 // Nodes on each level, from the root down.
+	InternalNodes   int64   // Internal nodes, including an internal root.
+	LeafNodes       int64   // Leaves, including a leaf root.
+	Entries         int64   // Entries in the leaves.
+	OverflowValues  int64   // Values kept in overflow pages.
+	InternalFill    float64 // Average fraction of an internal node's space in use.
+	LeafFill        float64 // Average fraction of a leaf's space in use.
+	LeafChainLength int64   // Leaves reached by following right siblings from the leftmost.
+	Pages           int64   // Pages in the table's file.
+	FreePages       int64   // Pages on the table's free page list.
+}
+
+// Number of times Analyze walks the tree before it holds the table for
+// the whole walk, if underfull nodes keep being refilled under it.
+var ANALYZE_ATTEMPTS = 3
+
+// errRefilled stops a walk over the tree that underfull nodes were refilled
+// under, since the nodes it has yet to read may be gone.
+var errRefilled = errors.New("tree was refilled during the walk")
+
+// Analyze walks the whole tree and returns statistics on its structure.
+// How full a node is is measured as splits and merges measure it, on its
+// cells without a shared prefix.
+// [CONCURRENCY] Nodes are read one at a time, as searches read them, each
+// under the table's rebalancing lock held shared, so inserts, updates and
+// deletes can go on during the walk, and the statistics are approximate:
+// a node that splits after its parent was read is counted as it was before
+// the split, and leaves change as they are read. Refilling underfull nodes
+// may free nodes the walk has yet to read, so the walk starts over if it
+// happens. The last attempt holds the lock for the whole walk, which
+// holds up refills, and every operation queued behind them, until it ends.
+func (table *BTreeIndex) Analyze() (utils.IndexStats, error) {
+	for attempt := 1; ; attempt++ {
+		walk := &treeWalk{table: table, held: attempt == ANALYZE_ATTEMPTS}
+		stats, err := walk.analyze()
+		if err != errRefilled {
+			return stats, err
+		}
+	}
+}
+
+// treeWalk reads the nodes of a tree one at a time.
+type treeWalk struct {
+	table *BTreeIndex
+	held  bool  // Whether the walk holds the rebalancing lock throughout.
+	epoch int64 // The table's refill count when the walk began.
+}
+
+// analyze walks the tree and returns statistics on its structure.
+func (walk *treeWalk) analyze() (*TreeStats, error) {
+	table := walk.table
+	table.rebalancing.RLock()
+	walk.epoch = table.epoch
+	if walk.held {
+		defer table.rebalancing.RUnlock()
+	} else {
+		table.rebalancing.RUnlock()
+	}
+	stats := &TreeStats{
+		Pages:     table.pager.GetNumPages(),
+		FreePages: table.pager.GetNumFreePages(),
+	}
+	if err := walk.analyzeNode(stats, table.rootPN, 0); err != nil {
+		return nil, err
+	}
+	stats.Height = int64(len(stats.NodesPerLevel))
+	if stats.InternalNodes > 0 {
+		stats.InternalFill /= float64(stats.InternalNodes)
+	}
+	if stats.LeafNodes > 0 {
+		stats.LeafFill /= float64(stats.LeafNodes)
+	}
+	var err error
+	if stats.LeafChainLength, err = walk.leafChainLength(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// readNode calls read on the node at the given page number, locked for
+// reading. Unless the walk holds the rebalancing lock, it is held shared
+// while the node is read, and the walk fails with errRefilled if the tree
+// was refilled since it began.
+func (walk *treeWalk) readNode(pagenum int64, read func(Node)) error {
+	table := walk.table
+	if !walk.held {
+		table.rebalancing.RLock()
+		defer table.rebalancing.RUnlock()
+		if table.epoch != walk.epoch {
+			return errRefilled
+		}
+	}
+	node, err := table.lockNode(pagenum, false)
+	if err != nil {
+		return err
+	}
+	read(node)
+	releaseNode(node, false)
+	return nil
+}
+
+// analyzeNode adds the node at the given page number and depth, and the
+// subtree under it, to the statistics. Fill fractions are summed, to be
+// averaged once every node is in.
+func (walk *treeWalk) analyzeNode(stats *TreeStats, pagenum int64, depth int) error {
+	var children []int64
+	err := walk.readNode(pagenum, func(n Node) {
+		if depth == len(stats.NodesPerLevel) {
+			stats.NodesPerLevel = append(stats.NodesPerLevel, 0)
+		}
+		stats.NodesPerLevel[depth]++
+		header := n.header()
+		fill := float64(header.used()) / float64(header.capacity())
+		switch n := n.(type) {
+		case *InternalNode:
+			stats.InternalNodes++
+			stats.InternalFill += fill
+			for i := int64(0); i <= n.numKeys; i++ {
+				children = append(children, n.getPNAt(i))
+			}
+		case *LeafNode:
+			stats.LeafNodes++
+			stats.LeafFill += fill
+			stats.Entries += n.numKeys
+			for i := int64(0); i < n.numKeys; i++ {
+				if unmarshalEntry(n.getCell(i)).overflow {
+					stats.OverflowValues++
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, childPN := range children {
+		if err := walk.analyzeNode(stats, childPN, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// leafChainLength follows right siblings from the leftmost leaf and counts
+// the leaves it reaches, which should be every leaf in the tree. It stops
+// after as many leaves as the file has pages, in case the chain loops.
+func (walk *treeWalk) leafChainLength() (int64, error) {
+	// Follow the leftmost children down to the leftmost leaf.
+	pagenum, isLeaf := walk.table.rootPN, false
+	for !isLeaf {
+		err := walk.readNode(pagenum, func(n Node) {
+			if internal, ok := n.(*InternalNode); ok {
+				pagenum = internal.getPNAt(0)
+			} else {
+				isLeaf = true
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	length, limit := int64(0), walk.table.pager.GetNumPages()
+	for pagenum >= 0 && length < limit {
+		err := walk.readNode(pagenum, func(n Node) {
+			pagenum = n.header().rightSiblingPN
+		})
+		if err != nil {
+			return 0, err
+		}
+		length++
+	}
+	return length, nil
+}
+
+// Print writes the statistics out, one per line.
+func (stats *TreeStats) Print(w io.Writer) {
+	io.WriteString(w, fmt.Sprintf("height: %v\n", stats.Height))
+	io.WriteString(w, fmt.Sprintf("nodes per level: %v\n", stats.NodesPerLevel))
+	io.WriteString(w, fmt.Sprintf("internal nodes: %v\n", stats.InternalNodes))
+	io.WriteString(w, fmt.Sprintf("leaf nodes: %v\n", stats.LeafNodes))
+	io.WriteString(w, fmt.Sprintf("entries: %v\n", stats.Entries))
+	io.WriteString(w, fmt.Sprintf("overflow values: %v\n", stats.OverflowValues))
+	io.WriteString(w, fmt.Sprintf("internal fill: %.3f\n", stats.InternalFill))
+	io.WriteString(w, fmt.Sprintf("leaf fill: %.3f\n", stats.LeafFill))
+	io.WriteString(w, fmt.Sprintf("leaf chain length: %v\n", stats.LeafChainLength))
+	io.WriteString(w, fmt.Sprintf("pages: %v\n", stats.Pages))
+	io.WriteString(w, fmt.Sprintf("free pages: %v\n", stats.FreePages))
+}
@@ -0,0 +1,240 @@
+package btree
+
+import (
+	"errors"
+)
+
+// [CONCURRENCY] The tree is a B-link tree (Lehman and Yao, 1981). Every
+// node links to its right sibling and holds a high key; the keys at or
+// above it have moved to the nodes on its right. A search locks one node at
+// a time, reading it and letting go of it before it locks the child it
+// picks, and moves right whenever the key it looks for isn't below a
+// node's high key. It thus finds the key even if a node split after its
+// parent was read, and never waits for a split to reach the parent. Reads
+// take read locks, so readers only wait for a writer changing the very
+// node they read, and never for a split anywhere else.
+//
+// A change locks the leaf it changes for writing. If the leaf splits, the
+// new node is linked in as its right sibling first, so it can be found at
+// once. Then the parent is locked, found again from the path the search
+// took, before the leaf is unlocked, and the new node's separator inserted
+// there, and so on up. Locks are only taken upwards, or rightwards on one
+// level, so operations can't deadlock.
+//
+// Nodes are never removed concurrently, since a search may be about to
+// follow a link to one. A change that leaves a leaf underfull refills it
+// afterwards, holding the table's rebalancing lock exclusively, while every
+// other operation holds it shared.
+
+// lockNode returns the node at the given page number, pinned and locked
+// for reading, or for writing if write is set.
+func (table *BTreeIndex) lockNode(pagenum int64, write bool) (Node, error) {
+	page, err := table.pager.GetPage(pagenum)
+	if err != nil {
+		return nil, err
+	}
+	if write {
+		page.WLock()
+	} else {
+		page.RLock()
+	}
+	return pageToNode(page, table.cmp), nil
+}
+
+// releaseNode unlocks and unpins a node returned by lockNode.
+func releaseNode(node Node, write bool) {
+	page := node.getPage()
+	if write {
+		page.WUnlock()
+	} else {
+		page.RUnlock()
+	}
+	page.Put()
+}
+
+// stepRight locks the node's right sibling, then releases the node.
+func (table *BTreeIndex) stepRight(node Node, write bool) (Node, error) {
+	right, err := table.lockNode(node.header().rightSiblingPN, write)
+	releaseNode(node, write)
+	return right, err
+}
+
+// moveRight follows right links from the locked node to the node on its
+// level that the given key belongs to, and returns it locked. The node is
+// released on failure.
+func (table *BTreeIndex) moveRight(node Node, key []byte, write bool) (Node, error) {
+	for !node.header().belowHighKey(key) {
+		var err error
+		if node, err = table.stepRight(node, write); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// findNode returns the node at the given level that the given key belongs
+// to, pinned and locked for reading, or for writing if write is set.
+// Also returns the path the search took: the page numbers of the internal
+// nodes it went through, from the root down.
+func (table *BTreeIndex) findNode(key []byte, level int64, write bool) (Node, []int64, error) {
+	for {
+		node, path, found, err := table.descend(key, level, write)
+		if err != nil || found {
+			return node, path, err
+		}
+	}
+}
+
+// descend searches for the node at the given level that the given key
+// belongs to, as findNode does. Returns false if the root grew a level
+// while it waited to lock it for writing, in which case the search must
+// start over.
+func (table *BTreeIndex) descend(key []byte, level int64, write bool) (Node, []int64, bool, error) {
+	path := make([]int64, 0)
+	// The root's level isn't known until it is read, since it can grow.
+	pagenum, lockWrite := table.rootPN, false
+	for {
+		node, err := table.lockNode(pagenum, lockWrite)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if write && !lockWrite && node.header().level == level {
+			releaseNode(node, false)
+			if node, err = table.lockNode(pagenum, true); err != nil {
+				return nil, nil, false, err
+			}
+			lockWrite = true
+			if node.header().level != level {
+				releaseNode(node, true)
+				return nil, nil, false, nil
+			}
+		}
+		if node, err = table.moveRight(node, key, lockWrite); err != nil {
+			return nil, nil, false, err
+		}
+		if node.header().level == level {
+			return node, path, true, nil
+		}
+		internal, ok := node.(*InternalNode)
+		if !ok {
+			releaseNode(node, lockWrite)
+			return nil, nil, false, errors.New("tree has no nodes at the level searched for")
+		}
+		// Read the child's page number, then let go of the node.
+		pagenum = internal.getPNAt(internal.search(key))
+		path = append(path, internal.page.GetPageNum())
+		releaseNode(internal, lockWrite)
+		lockWrite = write && internal.level-1 == level
+	}
+}
+
+// findLeaf returns the leaf that the given key belongs to, pinned and
+// locked, and the path to it.
+func (table *BTreeIndex) findLeaf(key []byte, write bool) (*LeafNode, []int64, error) {
+	node, path, err := table.findNode(key, 0, write)
+	if err != nil {
+		return nil, nil, err
+	}
+	return node.(*LeafNode), path, nil
+}
+
+// lockParent locks and returns the node one level above the given one that
+// the given key belongs to, which is where the separator of a split of
+// the node goes. The search starts from the last node on the path to the
+// node and moves right from it, or starts over from the root if the root
+// has grown since. Returns the path to the parent.
+func (table *BTreeIndex) lockParent(node Node, key []byte, path []int64) (*InternalNode, []int64, error) {
+	level := node.header().level + 1
+	if len(path) > 0 {
+		pagenum := path[len(path)-1]
+		path = path[:len(path)-1]
+		parent, err := table.lockNode(pagenum, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parent.header().level == level {
+			parent, err = table.moveRight(parent, key, true)
+			if err != nil {
+				return nil, nil, err
+			}
+			return parent.(*InternalNode), path, nil
+		}
+		releaseNode(parent, true)
+	}
+	parent, path, err := table.findNode(key, level, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return parent.(*InternalNode), path, nil
+}
+
+// postSplit inserts the separator of the locked node's split into its
+// parent, then that of the parent's split, if it splits, and so on up,
+// adding a level to the tree if the root splits. Each node is released
+// once its parent is locked.
+func (table *BTreeIndex) postSplit(node Node, result Split, path []int64) error {
+	for result.err == nil && result.isSplit {
+		if node.getPage().GetPageNum() == table.rootPN {
+			err := table.splitRoot(node, result)
+			releaseNode(node, true)
+			return err
+		}
+		parent, parentPath, err := table.lockParent(node, result.key, path)
+		releaseNode(node, true)
+		if err != nil {
+			return err
+		}
+		node, path = parent, parentPath
+		result = parent.insertSplit(result)
+	}
+	releaseNode(node, true)
+	return result.err
+}
+
+// change runs an insert, update or delete on the leaf that the given key
+// belongs to, and inserts the separator if the leaf splits.
+func (table *BTreeIndex) change(key []byte, op func(*LeafNode) Split) Split {
+	table.rebalancing.RLock()
+	defer table.rebalancing.RUnlock()
+	leaf, path, err := table.findLeaf(key, true)
+	if err != nil {
+		return Split{err: err}
+	}
+	result := op(leaf)
+	if result.err != nil || !result.isSplit {
+		releaseNode(leaf, true)
+		return result
+	}
+	if err := table.postSplit(leaf, result, path); err != nil {
+		result.err = err
+	}
+	return result
+}
+
+// refill rebalances the underfull nodes on the path to the leaf that the
+// given key belongs to, holding the table exclusively. The root loses a
+// level if it is left with a single child, or gains one if it splits.
+func (table *BTreeIndex) refill(key []byte) error {
+	table.rebalancing.Lock()
+	defer table.rebalancing.Unlock()
+	// Cursors must find their leaves again, since this may free them.
+	table.epoch++
+	rootPage, err := table.pager.GetPage(table.rootPN)
+	if err != nil {
+		return err
+	}
+	defer rootPage.Put()
+	rootNode := pageToNode(rootPage, table.cmp)
+	result := rootNode.refill(key)
+	switch {
+	case result.err != nil:
+		return result.err
+	case result.isSplit:
+		return table.splitRoot(rootNode, result)
+	case result.underflows:
+		if root, ok := rootNode.(*InternalNode); ok {
+			return table.collapseRoot(root)
+		}
+	}
+	return nil
+}
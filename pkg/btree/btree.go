@@ -3,6 +3,7 @@ package btree
 import (
 	"errors"
 	"io"
+	"sync"
 
 	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
@@ -10,14 +11,25 @@ import (
 
 // Tables are an abstraction over the entries stored in our database.
 type BTreeIndex struct {
-	pager  *pager.Pager // The page handler to read from files.
-	rootPN int64        // The root page number.
+	pager       *pager.Pager // The page handler to read from files.
+	rootPN      int64        // The root page number.
+	cmp         Comparator   // Orders the keys in the table.
+	rebalancing sync.RWMutex // Held exclusively while underfull nodes are refilled.
+	epoch       int64        // Counts the times the table was held exclusively to free nodes.
 }
 
 // OpenTable returns a table associated with the given database filename.
-func OpenTable(filename string) (table *BTreeIndex, err error) {
+// Options are passed on to the table's pager.
+func OpenTable(filename string, opts ...pager.Option) (table *BTreeIndex, err error) {
+	return OpenTableWithComparator(filename, DefaultComparator, opts...)
+}
+
+// OpenTableWithComparator returns a table associated with the given
+// database filename whose keys are ordered by the given comparator. A
+// table must always be opened with the same comparator.
+func OpenTableWithComparator(filename string, cmp Comparator, opts ...pager.Option) (table *BTreeIndex, err error) {
 	// Create a pager for the table
-	pager := pager.NewPager()
+	pager := pager.NewPager(opts...)
 	err = pager.Open(filename)
 	if err != nil {
 		return nil, err
@@ -30,10 +42,14 @@ func OpenTable(filename string) (table *BTreeIndex, err error) {
 		}
 		defer rootPage.Put()
 		initPage(rootPage, LEAF_NODE)
-		rootNode := pageToLeafNode(rootPage)
-		rootNode.setRightSibling(-1)
 	}
-	return &BTreeIndex{pager: pager, rootPN: ROOT_PN}, nil
+	table = &BTreeIndex{pager: pager, rootPN: ROOT_PN, cmp: cmp}
+	// Rebuild tables written in an older node format.
+	if err := table.upgrade(); err != nil {
+		pager.Close()
+		return nil, err
+	}
+	return table, nil
 }
 
 // Get this index's filename.
@@ -46,6 +62,11 @@ func (table *BTreeIndex) GetPager() *pager.Pager {
 	return table.pager
 }
 
+// Get the comparator that orders this index's keys.
+func (table *BTreeIndex) GetComparator() Comparator {
+	return table.cmp
+}
+
 // Close flushes all changes to disk.
 func (table *BTreeIndex) Close() (err error) {
 	err = table.pager.Close()
@@ -54,19 +75,23 @@ func (table *BTreeIndex) Close() (err error) {
 
 // Finds the given key.
 func (table *BTreeIndex) Find(key int64) (utils.Entry, error) {
-	// Get the root node.
-	rootPage, err := table.pager.GetPage(table.rootPN)
+	return table.FindBytes(utils.EncodeInt(key))
+}
+
+// FindBytes finds the given byte-string key.
+func (table *BTreeIndex) FindBytes(key []byte) (utils.Entry, error) {
+	table.rebalancing.RLock()
+	defer table.rebalancing.RUnlock()
+	// Find the leaf the key belongs to.
+	leaf, _, err := table.findLeaf(key, false)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseNode(leaf, false)
+	value, found, err := leaf.get(key)
 	if err != nil {
 		return nil, err
 	}
-	// [CONCURRENCY] Lock and eventually unlock the root node.
-	lockRoot(rootPage)
-	rootNode := pageToNode(rootPage)
-	initRootNode(rootNode)
-	defer unsafeUnlockRoot(rootNode)
-	defer rootPage.Put()
-	// Insert the entry into the root node.
-	value, found := rootNode.get(key)
 	if found {
 		return BTreeEntry{key: key, value: value}, nil
 	}
@@ -75,102 +100,174 @@ func (table *BTreeIndex) Find(key int64) (utils.Entry, error) {
 
 // Inserts an entry to the table.
 func (table *BTreeIndex) Insert(key int64, value int64) error {
-	// Get the root node.
-	rootPage, err := table.pager.GetPage(table.rootPN)
-	if err != nil {
+	return table.InsertBytes(utils.EncodeInt(key), utils.EncodeInt(value))
+}
+
+// InsertBytes inserts an entry with a byte-string key and value.
+func (table *BTreeIndex) InsertBytes(key []byte, value []byte) error {
+	if err := table.pager.CheckWritable(); err != nil {
 		return err
 	}
-	// [CONCURRENCY] Lock and eventually unlock the root node.
-	lockRoot(rootPage)
-	rootNode := pageToNode(rootPage)
-	initRootNode(rootNode)
-	defer unsafeUnlockRoot(rootNode)
-	defer rootPage.Put()
-	// Insert the entry into the root node.
-	result := rootNode.insert(key, value, false)
-	// Check if we need to split the root node.
-	// Remember to preserve the invariant that the root node occupies page 0.
-	if result.isSplit {
-		// [CONCURRENCY] Unlock the root node.
-		defer SUPER_NODE.unlock()
-		// Ensure that our left PN hasn't changed.
-		if result.leftPN != 0 {
-			return errors.New("splitting was corrupted")
-		}
-		// Create a new node to transfer our data.
-		var newNodePN int64
-		// Depending on whether the root is a leaf or an internal node...
-		if rootNode.getNodeType() == LEAF_NODE {
-			// Create a new leaf node.
-			newNode, err := createLeafNode(table.pager)
-			if err != nil {
-				return errors.New("failed to split root node")
-			}
-			defer newNode.page.Put()
-			// Copy the attributes from the root node.
-			leafyRoot := pageToLeafNode(rootNode.getPage())
-			newNode.copy(leafyRoot)
-			newNodePN = newNode.page.GetPageNum()
-		} else {
-			// Create a new internal node.
-			newNode, err := createInternalNode(table.pager)
-			if err != nil {
-				return errors.New("failed to split root node")
-			}
-			defer newNode.page.Put()
-			// Copy the attributes from the root node.
-			internedRoot := pageToInternalNode(rootNode.getPage())
-			newNode.copy(internedRoot)
-			newNodePN = newNode.page.GetPageNum()
-		}
-		// Reinitialize the root node.
-		initPage(rootNode.getPage(), INTERNAL_NODE)
-		newRoot := pageToInternalNode(rootNode.getPage())
-		// Populate the pointers to children.
-		newRoot.updateKeyAt(0, result.key)
-		newRoot.updatePNAt(0, newNodePN)
-		newRoot.updatePNAt(1, result.rightPN)
-		newRoot.updateNumKeys(1)
+	entry, err := table.newEntry(key, value)
+	if err != nil {
+		return err
 	}
-	return result.err
+	// Insert the entry into its leaf.
+	result := table.change(key, func(leaf *LeafNode) Split {
+		return leaf.insert(entry)
+	})
+	return table.releaseOverflow(entry, result, result.err)
 }
 
 // Update modifies an existing entry.
 func (table *BTreeIndex) Update(key int64, value int64) error {
-	// Get the root node.
-	rootPage, err := table.pager.GetPage(table.rootPN)
+	return table.UpdateBytes(utils.EncodeInt(key), utils.EncodeInt(value))
+}
+
+// UpdateBytes modifies an existing entry with a byte-string key and value.
+func (table *BTreeIndex) UpdateBytes(key []byte, value []byte) error {
+	if err := table.pager.CheckWritable(); err != nil {
+		return err
+	}
+	entry, err := table.newEntry(key, value)
 	if err != nil {
 		return err
 	}
-	// [CONCURRENCY] Lock and eventually unlock the root node.
-	lockRoot(rootPage)
-	rootNode := pageToNode(rootPage)
-	initRootNode(rootNode)
-	defer unsafeUnlockRoot(rootNode)
-	defer rootPage.Put()
-	// Update the entry.
-	result := rootNode.insert(key, value, true)
-	return result.err
+	// Update the entry, then refill its leaf if it was left underfull.
+	result := table.change(key, func(leaf *LeafNode) Split {
+		return leaf.update(entry)
+	})
+	if err := table.releaseOverflow(entry, result, result.err); err != nil || !result.underflows {
+		return err
+	}
+	return table.refill(key)
 }
 
 // Delete removes a key from the table.
 func (table *BTreeIndex) Delete(key int64) error {
-	// Get the root node.
-	rootPage, err := table.pager.GetPage(table.rootPN)
-	if err != nil {
+	return table.DeleteBytes(utils.EncodeInt(key))
+}
+
+// DeleteBytes removes a byte-string key from the table.
+func (table *BTreeIndex) DeleteBytes(key []byte) error {
+	if err := table.pager.CheckWritable(); err != nil {
 		return err
 	}
-	// [CONCURRENCY] Lock and eventually unlock the root node.
-	lockRoot(rootPage)
-	rootNode := pageToNode(rootPage)
-	initRootNode(rootNode)
-	defer unsafeUnlockRoot(rootNode)
-	defer rootPage.Put()
-	// Delete the key.
-	rootNode.delete(key)
+	// Delete the key, then refill its leaf if it was left underfull.
+	result := table.change(key, func(leaf *LeafNode) Split {
+		return leaf.delete(key)
+	})
+	if err := table.releaseOverflow(BTreeEntry{}, result, result.err); err != nil || !result.underflows {
+		return err
+	}
+	return table.refill(key)
+}
+
+// newEntry returns the entry for a key and value, moving the value to
+// overflow pages if the entry doesn't fit in a leaf cell.
+func (table *BTreeIndex) newEntry(key []byte, value []byte) (BTreeEntry, error) {
+	dataSize := table.pager.GetDataSize()
+	if err := checkKeySize(dataSize, key); err != nil {
+		return BTreeEntry{}, err
+	}
+	if fitsInCell(dataSize, key, value) {
+		return BTreeEntry{key: key, value: value}, nil
+	}
+	pagenum, err := table.pager.WriteOverflow(value)
+	if err != nil {
+		return BTreeEntry{}, err
+	}
+	return BTreeEntry{key: key, value: overflowRef(pagenum, int64(len(value))), overflow: true}, nil
+}
+
+// releaseOverflow frees the overflow pages that a change to the table left
+// unreachable: those of the value it deleted or replaced, and those of the
+// new entry if the change failed. Returns the change's error, if any.
+func (table *BTreeIndex) releaseOverflow(entry BTreeEntry, result Split, err error) error {
+	if result.freed != nil {
+		if freeErr := freeOverflow(table.pager, result.freed); err == nil {
+			err = freeErr
+		}
+	}
+	if err != nil && entry.overflow {
+		freeOverflow(table.pager, entry.value)
+	}
+	return err
+}
+
+// splitRoot moves the root's contents to a new page and makes the root an
+// internal node over it and the new node from the split, one level up.
+// Remember to preserve the invariant that the root node occupies page 0.
+// [CONCURRENCY] The root must be locked for writing. The new nodes aren't
+// reachable until it is unlocked.
+func (table *BTreeIndex) splitRoot(rootNode Node, result Split) error {
+	// Ensure that our left PN hasn't changed.
+	if result.leftPN != 0 {
+		return errors.New("splitting was corrupted")
+	}
+	// Create a new node to transfer our data.
+	var newNodePN int64
+	level := rootNode.header().level
+	// Depending on whether the root is a leaf or an internal node...
+	if rootNode.getNodeType() == LEAF_NODE {
+		// Create a new leaf node.
+		newNode, err := createLeafNode(table.pager, table.cmp)
+		if err != nil {
+			return errors.New("failed to split root node")
+		}
+		defer newNode.page.Put()
+		// Copy the attributes from the root node.
+		leafyRoot := pageToLeafNode(rootNode.getPage(), table.cmp)
+		newNode.copy(leafyRoot)
+		newNodePN = newNode.page.GetPageNum()
+		// The new right node is only reachable through the root.
+		rightPage, err := table.pager.GetPage(result.rightPN)
+		if err != nil {
+			return err
+		}
+		defer rightPage.Put()
+		pageToLeafNode(rightPage, table.cmp).setLeftSibling(newNodePN)
+	} else {
+		// Create a new internal node.
+		newNode, err := createInternalNode(table.pager, table.cmp, level)
+		if err != nil {
+			return errors.New("failed to split root node")
+		}
+		defer newNode.page.Put()
+		// Copy the attributes from the root node.
+		internedRoot := pageToInternalNode(rootNode.getPage(), table.cmp)
+		newNode.copy(internedRoot)
+		newNodePN = newNode.page.GetPageNum()
+	}
+	// Reinitialize the root node.
+	initPage(rootNode.getPage(), INTERNAL_NODE)
+	newRoot := pageToInternalNode(rootNode.getPage(), table.cmp)
+	newRoot.setLevel(level + 1)
+	// Populate the pointers to children.
+	newRoot.updateLeftmostPN(newNodePN)
+	newRoot.insertCell(0, internalCell(result.key, result.rightPN))
 	return nil
 }
 
+// collapseRoot moves the only child of the root into the root's page and
+// frees the child's page. The table must be held exclusively.
+func (table *BTreeIndex) collapseRoot(root *InternalNode) error {
+	child, err := root.getChildAt(0)
+	if err != nil {
+		return err
+	}
+	childPN := child.getPage().GetPageNum()
+	// Copy the attributes from the child.
+	switch child := child.(type) {
+	case *LeafNode:
+		pageToLeafNode(root.getPage(), table.cmp).copy(child)
+	case *InternalNode:
+		root.copy(child)
+	}
+	child.getPage().Put()
+	return table.pager.FreePage(childPN)
+}
+
 // Select returns a slice of all entries in the table.
 func (table *BTreeIndex) Select() ([]utils.Entry, error) {
 	// Use a cursor to traverse the table from start to end
@@ -179,6 +276,7 @@ func (table *BTreeIndex) Select() ([]utils.Entry, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer cursor.Close()
 
 	// Traverse over all entries.
 	for {
@@ -200,12 +298,14 @@ func (table *BTreeIndex) Select() ([]utils.Entry, error) {
 
 // Print will pretty-print all nodes in the table.
 func (table *BTreeIndex) Print(w io.Writer) {
+	table.rebalancing.Lock()
+	defer table.rebalancing.Unlock()
 	rootPage, err := table.pager.GetPage(table.rootPN)
 	if err != nil {
 		return
 	}
 	defer rootPage.Put()
-	rootNode := pageToNode(rootPage)
+	rootNode := pageToNode(rootPage, table.cmp)
 	rootNode.printNode(w, "", "")
 }
 
@@ -216,6 +316,6 @@ func (table *BTreeIndex) PrintPN(pagenum int, w io.Writer) {
 		return
 	}
 	defer page.Put()
-	node := pageToNode(page)
+	node := pageToNode(page, table.cmp)
 	node.printNode(w, "", "")
 }
\ No newline at end of file
@@ -1,9 +1,10 @@
 package btree
 
 import (
+	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
+	"reflect"
 
 	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 )
@@ -13,31 +14,156 @@ import (
 // we open the database.
 var ROOT_PN int64 = 0
 
+// Nodes are slotted pages. The header is followed by the prefix shared by
+// all of the node's keys, then by an array of slots, kept in key order, each
+// holding the offset of a cell. Cells are packed at the end of the page and
+// grow towards the slots. A leaf cell holds an entry; an internal cell holds
+// a key and the page number of the child to its right, and the leftmost
+// child's page number is kept in the header. Cells leave the shared prefix
+// out of their keys. Removing a cell leaves a hole, which is reclaimed by
+// compacting the page when a new cell doesn't fit between the slots and the
+// cells.
+
+// The first byte of a node holds its format version above its type bit.
+// Nodes are written in the B-link format. It is the compact format, whose
+// header fields and slots are only as wide as the page size needs and
+// whose internal cells hold page numbers as varints, plus what lets
+// operations find keys that a concurrent split moved: each node's level, a
+// link to its right sibling, and a high key, which bounds its keys from
+// above. Nodes in the original format, with wide fixed fields and no shared
+// prefix, or in the compact format can still be read; a table opened for
+// writing is rebuilt in the B-link format first.
+const (
+	ORIGINAL_FORMAT byte = 0
+	COMPACT_FORMAT  byte = 1
+	BLINK_FORMAT    byte = 2
+)
+
 // Node header constants.
 var NODETYPE_OFFSET int64 = 0
 var NODETYPE_SIZE int64 = 1
 var NUM_KEYS_OFFSET int64 = NODETYPE_OFFSET + NODETYPE_SIZE
+
+// Original format node header constants.
 var NUM_KEYS_SIZE int64 = binary.MaxVarintLen64
-var NODE_HEADER_SIZE int64 = NODETYPE_SIZE + NUM_KEYS_SIZE
+var CELLS_START_OFFSET int64 = NUM_KEYS_OFFSET + NUM_KEYS_SIZE
+var CELLS_START_SIZE int64 = 4
+var CELL_BYTES_OFFSET int64 = CELLS_START_OFFSET + CELLS_START_SIZE
+var CELL_BYTES_SIZE int64 = 4
+var NODE_HEADER_SIZE int64 = NODETYPE_SIZE + NUM_KEYS_SIZE + CELLS_START_SIZE + CELL_BYTES_SIZE
 
-// Leaf node header constants.
+// Original format leaf node header constants.
 var RIGHT_SIBLING_PN_OFFSET int64 = NODE_HEADER_SIZE
 var RIGHT_SIBLING_PN_SIZE int64 = binary.MaxVarintLen64
-var LEAF_NODE_HEADER_SIZE int64 = NODE_HEADER_SIZE + RIGHT_SIBLING_PN_SIZE
-var ENTRIES_PER_LEAF_NODE int64 = ((pager.PAGESIZE - LEAF_NODE_HEADER_SIZE) / ENTRYSIZE) - 1
+var LEFT_SIBLING_PN_OFFSET int64 = RIGHT_SIBLING_PN_OFFSET + RIGHT_SIBLING_PN_SIZE
+var LEFT_SIBLING_PN_SIZE int64 = binary.MaxVarintLen64
+var LEAF_NODE_HEADER_SIZE int64 = NODE_HEADER_SIZE + RIGHT_SIBLING_PN_SIZE + LEFT_SIBLING_PN_SIZE
 
-// Internal node header constants.
-var KEY_SIZE int64 = binary.MaxVarintLen64
+// Original format internal node header constants.
 var PN_SIZE int64 = binary.MaxVarintLen64
-var INTERNAL_NODE_HEADER_SIZE int64 = NODE_HEADER_SIZE
-var ptrSpace int64 = pager.PAGESIZE - INTERNAL_NODE_HEADER_SIZE - KEY_SIZE
-var KEYS_PER_INTERNAL_NODE int64 = (ptrSpace / (KEY_SIZE + PN_SIZE)) - 1
-var KEYS_OFFSET int64 = INTERNAL_NODE_HEADER_SIZE
-var KEYS_SIZE int64 = KEY_SIZE * (KEYS_PER_INTERNAL_NODE + 1)
-var PNS_OFFSET int64 = KEYS_OFFSET + KEYS_SIZE
+var LEFTMOST_PN_OFFSET int64 = NODE_HEADER_SIZE
+var INTERNAL_NODE_HEADER_SIZE int64 = NODE_HEADER_SIZE + PN_SIZE
+
+// Size of a slot in the original format, which holds the offset of a cell.
+var SLOT_SIZE int64 = 4
+
+// In the compact format, the number of keys, the offset of the lowest cell,
+// the total size of the cells and the length of the shared prefix follow
+// the node type, each as wide as a slot, and the B-link format adds the
+// node's level. Page numbers in the header take COMPACT_PN_SIZE bytes: a
+// leaf's right then left sibling, or an internal node's leftmost child
+// then, in the B-link format, its right sibling.
+var COMPACT_PN_SIZE int64 = 8
+
+// A B-link node with a right sibling keeps its high key in the slot after
+// its last key, in a cell like the others. It is the key that separates the
+// node from its sibling in their parent, and the rightmost node on each
+// level has none. Like the shared prefix, it counts towards how full the
+// node is.
+
+// slotWidth returns the size of a slot, and of a compact header field, in
+// nodes of the given format on pages with the given usable size.
+func slotWidth(format byte, dataSize int64) int64 {
+	if format == ORIGINAL_FORMAT {
+		return SLOT_SIZE
+	}
+	if dataSize < 1<<16 {
+		return 2
+	}
+	return 3
+}
+
+// headerFields returns the number of compact header fields in nodes of
+// the given format.
+func headerFields(format byte) int64 {
+	if format == BLINK_FORMAT {
+		return 5
+	}
+	return 4
+}
+
+// nodeHeaderSize returns the size of the header of a node of the given
+// format and type on pages with the given usable size.
+func nodeHeaderSize(format byte, nodeType NodeType, dataSize int64) int64 {
+	size, pnSize := NODE_HEADER_SIZE, PN_SIZE
+	if format != ORIGINAL_FORMAT {
+		size, pnSize = NODETYPE_SIZE+headerFields(format)*slotWidth(format, dataSize), COMPACT_PN_SIZE
+	}
+	if nodeType == LEAF_NODE || format == BLINK_FORMAT {
+		return size + 2*pnSize
+	}
+	return size + pnSize
+}
+
+// Comparator orders keys. It returns a negative number, zero or a positive
+// number when a sorts before, with or after b.
+type Comparator func(a []byte, b []byte) int
+
+// DefaultComparator orders keys byte by byte, which orders keys made by
+// utils.EncodeInt numerically, those made by utils.EncodeString
+// lexicographically, and those made by utils.EncodeTuple by component.
+var DefaultComparator Comparator = bytes.Compare
+
+// byteOrdered returns true if the comparator is the default one. Any key
+// that sorts between two others in byte order shares their common prefix,
+// which compressing the keys of a node relies on, so the keys of tables
+// with other comparators are left whole.
+func byteOrdered(cmp Comparator) bool {
+	return cmp != nil && reflect.ValueOf(cmp).Pointer() == reflect.ValueOf(bytes.Compare).Pointer()
+}
+
+// maxLeafCell returns the size of the largest leaf cell, with its slot,
+// given the usable size of a page. Capping cells at a quarter of a node
+// means that splitting or rebalancing a node never leaves either half
+// underfull.
+func maxLeafCell(dataSize int64) int64 {
+	return (dataSize - nodeHeaderSize(BLINK_FORMAT, LEAF_NODE, dataSize)) / 4
+}
+
+// maxInternalCell returns the size of the largest internal cell, with its
+// slot, given the usable size of a page.
+func maxInternalCell(dataSize int64) int64 {
+	return (dataSize - nodeHeaderSize(BLINK_FORMAT, INTERNAL_NODE, dataSize)) / 4
+}
+
+// checkKeySize returns an error if a key is too large to be stored in a
+// table whose pages have the given usable size, even with its value in
+// overflow pages.
+func checkKeySize(dataSize int64, key []byte) error {
+	slot := slotWidth(BLINK_FORMAT, dataSize)
+	if internalCellSize(key)+slot > maxInternalCell(dataSize) ||
+		leafCellSize(key, make([]byte, OVERFLOW_REF_SIZE))+slot > maxLeafCell(dataSize) {
+		return fmt.Errorf("key of %v bytes is too large", len(key))
+	}
+	return nil
+}
 
-// [CONCURRENCY]
-var SUPER_NODE *InternalNode = &InternalNode{NodeHeader{INTERNAL_NODE, 0, &pager.Page{}}, nil}
+// fitsInCell returns true if an entry fits in a leaf cell of a table whose
+// pages have the given usable size, without moving its value to overflow
+// pages.
+func fitsInCell(dataSize int64, key []byte, value []byte) bool {
+	return leafCellSize(key, value)+slotWidth(BLINK_FORMAT, dataSize) <= maxLeafCell(dataSize)
+}
 
 // NodeType identifies if a node is a leaf node or internal node.
 type NodeType bool
@@ -49,77 +175,641 @@ const (
 
 // NodeHeaders contain metadata common to all types of nodes
 type NodeHeader struct {
-	nodeType NodeType
-	numKeys  int64
-	page     *pager.Page
+	nodeType       NodeType
+	format         byte  // Format version of the node.
+	width          int64 // Size of a slot.
+	numKeys        int64
+	prefixLen      int64 // Length of the prefix shared by the node's keys.
+	level          int64 // Height above the leaves; -1 if unknown, in older formats.
+	rightSiblingPN int64 // Page number of the next node on the same level, or -1.
+	page           *pager.Page
+	cmp            Comparator // Orders the keys in the node.
 }
 
 // Leaf Node definition
 type LeafNode struct {
-	NodeHeader           // Include header information
-	rightSiblingPN int64 // Page number of the right sibling node
-	parent         Node  // Pointer to the parent node for unlocking.
+	NodeHeader          // Include header information
+	leftSiblingPN int64 // Page number of the left sibling node
 }
 
 // Internal Node definition
 type InternalNode struct {
-	NodeHeader      // Include header information
-	parent     Node // Pointer to the parent node for unlocking.
+	NodeHeader // Include header information
 }
 
 /////////////////////////////////////////////////////////////////////////////
 //////////////////////// Generic Helper Functions ///////////////////////////
 /////////////////////////////////////////////////////////////////////////////
 
-// initPage resets the page then sets the nodeType variable.
+// initPage resets the page to an empty compact node of the given type.
 func initPage(page *pager.Page, nodeType NodeType) {
 	page.SetDirty(true)
-	copy(*page.GetData(), make([]byte, pager.PAGESIZE))
+	data := *page.GetData()
+	copy(data, make([]byte, len(data)))
+	data[int(NODETYPE_OFFSET)] = BLINK_FORMAT << 1
+	if nodeType == LEAF_NODE {
+		data[int(NODETYPE_OFFSET)] |= 1 // Set the nodeType bit
+	}
+	// The cells start at the end of the page, and the node has no siblings.
+	node := pageToNodeHeader(page, nil)
+	node.setCellsStart(int64(len(data)))
+	node.setRightSibling(-1)
 	if nodeType == LEAF_NODE {
-		(*page.GetData())[int(NODETYPE_OFFSET)] = 1 // Set the nodeType bit
+		node.setHeaderPN(1, -1)
 	}
 }
 
 // pageToNode returns the node corresponding to the given page.
-func pageToNode(page *pager.Page) Node {
-	nodeHeader := pageToNodeHeader(page)
+func pageToNode(page *pager.Page, cmp Comparator) Node {
+	nodeHeader := pageToNodeHeader(page, cmp)
 	if nodeHeader.nodeType == LEAF_NODE {
-		return pageToLeafNode(page)
+		return pageToLeafNode(page, cmp)
 	}
-	return pageToInternalNode(page)
+	return pageToInternalNode(page, cmp)
 }
 
 // pageToNodeHeader returns node header data from the given page.
-func pageToNodeHeader(page *pager.Page) NodeHeader {
-	var nodeType NodeType
-	if (*page.GetData())[NODETYPE_OFFSET] == 0 {
-		nodeType = INTERNAL_NODE
+func pageToNodeHeader(page *pager.Page, cmp Comparator) NodeHeader {
+	data := *page.GetData()
+	node := NodeHeader{
+		nodeType: NodeType(data[NODETYPE_OFFSET]&1 == 1),
+		format:   data[NODETYPE_OFFSET] >> 1,
+		page:     page,
+		cmp:      cmp,
+	}
+	node.width = slotWidth(node.format, int64(len(data)))
+	if node.format == ORIGINAL_FORMAT {
+		node.numKeys, _ = binary.Varint(data[NUM_KEYS_OFFSET : NUM_KEYS_OFFSET+NUM_KEYS_SIZE])
 	} else {
-		nodeType = LEAF_NODE
+		node.numKeys = node.getField(NUM_KEYS_OFFSET)
+		node.prefixLen = node.getField(NUM_KEYS_OFFSET + 3*node.width)
+	}
+	switch {
+	case node.format == BLINK_FORMAT:
+		node.level = node.getField(NUM_KEYS_OFFSET + 4*node.width)
+	case node.nodeType == INTERNAL_NODE:
+		node.level = -1
+	}
+	node.rightSiblingPN = -1
+	if node.nodeType == LEAF_NODE || node.format == BLINK_FORMAT {
+		node.rightSiblingPN = node.getHeaderPN(node.rightSiblingIndex())
+	}
+	return node
+}
+
+// header returns the node's header.
+func (node *NodeHeader) header() *NodeHeader {
+	return node
+}
+
+// getField returns the slot or compact header field at the given offset.
+func (node *NodeHeader) getField(offset int64) int64 {
+	var value int64
+	for _, b := range (*node.page.GetData())[offset : offset+node.width] {
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+// setField updates the slot or compact header field at the given offset.
+func (node *NodeHeader) setField(offset int64, value int64) {
+	data := make([]byte, node.width)
+	for i := node.width - 1; i >= 0; i-- {
+		data[i] = byte(value)
+		value >>= 8
+	}
+	node.page.Update(data, offset, node.width)
+}
+
+// pnOffset returns the offset of the header's ith page number.
+func (node *NodeHeader) pnOffset(i int64) int64 {
+	if node.format == ORIGINAL_FORMAT {
+		return NODE_HEADER_SIZE + i*PN_SIZE
+	}
+	return NUM_KEYS_OFFSET + headerFields(node.format)*node.width + i*COMPACT_PN_SIZE
+}
+
+// rightSiblingIndex returns the index of the right sibling's page number
+// among the header's page numbers.
+func (node *NodeHeader) rightSiblingIndex() int64 {
+	if node.nodeType == LEAF_NODE {
+		return 0
+	}
+	return 1
+}
+
+// setRightSibling updates the page number of the node's right sibling.
+// Whether a node has a right sibling tells whether it has a high key, so
+// the two are changed together, by setCellsAndLink.
+func (node *NodeHeader) setRightSibling(siblingPN int64) {
+	node.rightSiblingPN = siblingPN
+	node.setHeaderPN(node.rightSiblingIndex(), siblingPN)
+}
+
+// setLevel updates the node's level: zero for a leaf, and one more than
+// its children's for an internal node.
+func (node *NodeHeader) setLevel(level int64) {
+	node.level = level
+	node.setField(NUM_KEYS_OFFSET+4*node.width, level)
+}
+
+// hasHighKey returns true if the node's last slot holds its high key.
+func (node *NodeHeader) hasHighKey() bool {
+	return node.format == BLINK_FORMAT && node.rightSiblingPN >= 0
+}
+
+// numSlots returns the number of slots in use: one per key, and one for
+// the high key if the node has one.
+func (node *NodeHeader) numSlots() int64 {
+	if node.hasHighKey() {
+		return node.numKeys + 1
+	}
+	return node.numKeys
+}
+
+// highKey returns a copy of the node's high key, or nil if it has none.
+func (node *NodeHeader) highKey() []byte {
+	if !node.hasHighKey() {
+		return nil
+	}
+	return node.getKeyAt(node.numKeys)
+}
+
+// belowHighKey returns true if the given key belongs in the node rather
+// than to the right of it.
+func (node *NodeHeader) belowHighKey(key []byte) bool {
+	return !node.hasHighKey() || node.compareKeyAt(node.numKeys, key) > 0
+}
+
+// getHeaderPN returns the header's ith page number.
+func (node *NodeHeader) getHeaderPN(i int64) int64 {
+	offset := node.pnOffset(i)
+	data := *node.page.GetData()
+	if node.format == ORIGINAL_FORMAT {
+		pagenum, _ := binary.Varint(data[offset : offset+PN_SIZE])
+		return pagenum
+	}
+	return int64(binary.BigEndian.Uint64(data[offset : offset+COMPACT_PN_SIZE]))
+}
+
+// setHeaderPN updates the header's ith page number.
+func (node *NodeHeader) setHeaderPN(i int64, pagenum int64) {
+	data := make([]byte, COMPACT_PN_SIZE)
+	binary.BigEndian.PutUint64(data, uint64(pagenum))
+	node.page.Update(data, node.pnOffset(i), COMPACT_PN_SIZE)
+}
+
+// headerSize returns the size of the node's header.
+func (node *NodeHeader) headerSize() int64 {
+	return nodeHeaderSize(node.format, node.nodeType, int64(len(*node.page.GetData())))
+}
+
+// capacity returns the number of bytes the node has for its shared prefix,
+// slots and cells.
+func (node *NodeHeader) capacity() int64 {
+	return int64(len(*node.page.GetData())) - node.headerSize()
+}
+
+// maxCell returns the size of the largest cell the node can hold, with its slot.
+func (node *NodeHeader) maxCell() int64 {
+	return node.capacity() / 4
+}
+
+// used returns the number of bytes the node's slots and cells would take up
+// without sharing a prefix. How full a node is is measured this way, so
+// that it doesn't change when cells move to a node with another prefix.
+func (node *NodeHeader) used() int64 {
+	return node.numSlots()*(node.width+node.prefixLen) + node.getCellBytes()
+}
+
+// freeSpace returns the number of bytes left for new slots and cells.
+func (node *NodeHeader) freeSpace() int64 {
+	return node.capacity() - node.prefixLen - node.numSlots()*node.width - node.getCellBytes()
+}
+
+// getCellsStart returns the offset of the lowest cell in the page.
+func (node *NodeHeader) getCellsStart() int64 {
+	if node.format == ORIGINAL_FORMAT {
+		data := *node.page.GetData()
+		return int64(binary.BigEndian.Uint32(data[CELLS_START_OFFSET : CELLS_START_OFFSET+CELLS_START_SIZE]))
+	}
+	return node.getField(NUM_KEYS_OFFSET + node.width)
+}
+
+// setCellsStart updates the offset of the lowest cell in the page.
+func (node *NodeHeader) setCellsStart(offset int64) {
+	node.setField(NUM_KEYS_OFFSET+node.width, offset)
+}
+
+// getCellBytes returns the total size of the node's cells.
+func (node *NodeHeader) getCellBytes() int64 {
+	if node.format == ORIGINAL_FORMAT {
+		data := *node.page.GetData()
+		return int64(binary.BigEndian.Uint32(data[CELL_BYTES_OFFSET : CELL_BYTES_OFFSET+CELL_BYTES_SIZE]))
+	}
+	return node.getField(NUM_KEYS_OFFSET + 2*node.width)
+}
+
+// setCellBytes updates the total size of the node's cells.
+func (node *NodeHeader) setCellBytes(size int64) {
+	node.setField(NUM_KEYS_OFFSET+2*node.width, size)
+}
+
+// prefix returns the prefix shared by the node's keys, pointing into the page.
+func (node *NodeHeader) prefix() []byte {
+	start := node.headerSize()
+	return (*node.page.GetData())[start : start+node.prefixLen]
+}
+
+// setPrefix updates the prefix shared by the node's keys, which moves the slots.
+func (node *NodeHeader) setPrefix(prefix []byte) {
+	node.prefixLen = int64(len(prefix))
+	node.setField(NUM_KEYS_OFFSET+3*node.width, node.prefixLen)
+	node.page.Update(prefix, node.headerSize(), node.prefixLen)
+}
+
+// slotPos returns the page offset to the slot at the given index.
+func (node *NodeHeader) slotPos(index int64) int64 {
+	return node.headerSize() + node.prefixLen + index*node.width
+}
+
+// cellOffset returns the page offset to the cell in the slot at the given index.
+func (node *NodeHeader) cellOffset(index int64) int64 {
+	return node.getField(node.slotPos(index))
+}
+
+// updateSlot points the slot at the given index to a cell.
+func (node *NodeHeader) updateSlot(index int64, offset int64) {
+	node.setField(node.slotPos(index), offset)
+}
+
+// lengthsSize returns the size of the lengths that start a cell, which are
+// followed by the cell's key without the shared prefix.
+func (node *NodeHeader) lengthsSize(cell []byte) int64 {
+	_, n := binary.Uvarint(cell)
+	if node.nodeType == INTERNAL_NODE {
+		return int64(n)
+	}
+	_, m := binary.Uvarint(cell[n:])
+	return int64(n + m)
+}
+
+// cellSize returns the size of the cell at the given page offset.
+func (node *NodeHeader) cellSize(offset int64) int64 {
+	data := (*node.page.GetData())[offset:]
+	keyLen, n := binary.Uvarint(data)
+	suffixLen := int64(keyLen) - node.prefixLen
+	if node.nodeType == INTERNAL_NODE {
+		if node.format == ORIGINAL_FORMAT {
+			return int64(n) + suffixLen + PN_SIZE
+		}
+		_, m := binary.Uvarint(data[int64(n)+suffixLen:])
+		return int64(n+m) + suffixLen
+	}
+	valueLen, m := binary.Uvarint(data[n:])
+	return int64(n+m) + suffixLen + int64(valueLen>>1)
+}
+
+// storedCell returns the cell in the slot at the given index as it is
+// stored, pointing into the page, so it is only valid until the node changes.
+func (node *NodeHeader) storedCell(index int64) []byte {
+	offset := node.cellOffset(index)
+	return (*node.page.GetData())[offset : offset+node.cellSize(offset)]
+}
+
+// keySuffixAt returns the key at the given index without the shared
+// prefix, pointing into the page.
+func (node *NodeHeader) keySuffixAt(index int64) []byte {
+	cell := node.storedCell(index)
+	keyLen, _ := binary.Uvarint(cell)
+	start := node.lengthsSize(cell)
+	return cell[start : start+int64(keyLen)-node.prefixLen]
+}
+
+// getKeyAt returns a copy of the key stored at the given index of the node.
+func (node *NodeHeader) getKeyAt(index int64) []byte {
+	return append(append([]byte{}, node.prefix()...), node.keySuffixAt(index)...)
+}
+
+// compareKeyAt compares the key at the given index of the node with the
+// given key. Only byte-ordered nodes share a prefix, so the prefix and the
+// rest of the key can be compared separately.
+func (node *NodeHeader) compareKeyAt(index int64, key []byte) int {
+	suffix := node.keySuffixAt(index)
+	if node.prefixLen == 0 {
+		return node.cmp(suffix, key)
 	}
-	numKeys, _ := binary.Varint(
-		(*page.GetData())[NUM_KEYS_OFFSET : NUM_KEYS_OFFSET+NUM_KEYS_SIZE],
-	)
-	return NodeHeader{
+	if int64(len(key)) < node.prefixLen {
+		if c := bytes.Compare(node.prefix()[:len(key)], key); c != 0 {
+			return c
+		}
+		return 1
+	}
+	if c := bytes.Compare(node.prefix(), key[:node.prefixLen]); c != 0 {
+		return c
+	}
+	return bytes.Compare(suffix, key[node.prefixLen:])
+}
+
+// getCell returns a copy of the cell in the slot at the given index, with
+// the shared prefix put back into its key.
+func (node *NodeHeader) getCell(index int64) []byte {
+	stored := node.storedCell(index)
+	if node.nodeType == INTERNAL_NODE && node.format == ORIGINAL_FORMAT {
+		return internalCell(internalCellKey(stored), node.storedPN(stored))
+	}
+	n := node.lengthsSize(stored)
+	cell := make([]byte, 0, int64(len(stored))+node.prefixLen)
+	cell = append(cell, stored[:n]...)
+	cell = append(cell, node.prefix()...)
+	return append(cell, stored[n:]...)
+}
+
+// storedPN returns the page number in a stored internal cell.
+func (node *NodeHeader) storedPN(cell []byte) int64 {
+	keyLen, n := binary.Uvarint(cell)
+	rest := cell[int64(n)+int64(keyLen)-node.prefixLen:]
+	if node.format == ORIGINAL_FORMAT {
+		pagenum, _ := binary.Varint(rest)
+		return pagenum
+	}
+	pagenum, _ := binary.Uvarint(rest)
+	return int64(pagenum)
+}
+
+// getCells returns copies of all of the node's cells, in order.
+func (node *NodeHeader) getCells() [][]byte {
+	cells := make([][]byte, node.numKeys)
+	for i := range cells {
+		cells[i] = node.getCell(int64(i))
+	}
+	return cells
+}
+
+// writeCell writes a cell at the given page offset, leaving the shared
+// prefix out of its key. Returns the number of bytes written.
+func (node *NodeHeader) writeCell(offset int64, cell []byte) int64 {
+	n := node.lengthsSize(cell)
+	node.page.Update(cell[:n], offset, n)
+	rest := cell[n+node.prefixLen:]
+	node.page.Update(rest, offset+n, int64(len(rest)))
+	return n + int64(len(rest))
+}
+
+// setCells replaces the node's cells with the given ones, which must fit
+// along with its high key, and makes the node's shared prefix the one their
+// keys share. Only nodes in the B-link format are written.
+func (node *NodeHeader) setCells(cells [][]byte) {
+	node.setCellsAndLink(cells, node.rightSiblingPN, node.highKey())
+}
+
+// setCellsAndLink replaces the node's cells, right sibling and high key. A
+// node has a high key if and only if it has a right sibling.
+func (node *NodeHeader) setCellsAndLink(cells [][]byte, siblingPN int64, highKey []byte) {
+	layout := node.layout()
+	slotted := layout.withHighKey(cells, highKey)
+	var prefix []byte
+	if len(slotted) > 0 {
+		prefix = layout.cellKey(slotted[0])[:layout.sharedPrefixLen(slotted)]
+	}
+	node.setRightSibling(siblingPN)
+	node.setPrefix(prefix)
+	offset := int64(len(*node.page.GetData()))
+	var cellBytes int64
+	for i, cell := range slotted {
+		offset -= int64(len(cell)) - node.prefixLen
+		cellBytes += node.writeCell(offset, cell)
+		node.updateSlot(int64(i), offset)
+	}
+	node.setCellsStart(offset)
+	node.setCellBytes(cellBytes)
+	node.updateNumKeys(int64(len(cells)))
+}
+
+// insertCell inserts a cell into the slot at the given index, shifting the
+// slots after it to the right. Returns false, leaving the node unchanged,
+// if the cell doesn't fit.
+func (node *NodeHeader) insertCell(index int64, cell []byte) bool {
+	size := int64(len(cell)) - node.prefixLen
+	shared := bytes.HasPrefix(node.layout().cellKey(cell), node.prefix())
+	if shared && node.freeSpace() < size+node.width {
+		return false
+	}
+	// Rewrite the node if the cell's key doesn't share its prefix, or to
+	// close up the holes if the cell doesn't fit in the gap.
+	if !shared || node.getCellsStart()-size < node.slotPos(node.numSlots()+1) {
+		cells := insertCellAt(node.getCells(), index, cell)
+		if !node.fits(cells) {
+			return false
+		}
+		node.setCells(cells)
+		return true
+	}
+	offset := node.getCellsStart() - size
+	node.writeCell(offset, cell)
+	node.setCellsStart(offset)
+	node.setCellBytes(node.getCellBytes() + size)
+	// Shift slots to the right.
+	data := *node.page.GetData()
+	startPos, endPos := node.slotPos(index), node.slotPos(node.numSlots())
+	node.page.Update(data[startPos:endPos], startPos+node.width, endPos-startPos)
+	node.updateSlot(index, offset)
+	node.updateNumKeys(node.numKeys + 1)
+	return true
+}
+
+// removeCell removes the cell in the slot at the given index, shifting the
+// slots after it to the left. The remaining keys still share the prefix.
+func (node *NodeHeader) removeCell(index int64) {
+	offset := node.cellOffset(index)
+	size := node.cellSize(offset)
+	if offset == node.getCellsStart() {
+		node.setCellsStart(offset + size)
+	}
+	node.setCellBytes(node.getCellBytes() - size)
+	// Shift slots to the left.
+	data := *node.page.GetData()
+	startPos, endPos := node.slotPos(index+1), node.slotPos(node.numSlots())
+	node.page.Update(data[startPos:endPos], startPos-node.width, endPos-startPos)
+	node.updateNumKeys(node.numKeys - 1)
+}
+
+// updateNumKeys updates the numKeys field in the node struct and the page.
+func (node *NodeHeader) updateNumKeys(nKeys int64) {
+	node.numKeys = nKeys
+	node.setField(NUM_KEYS_OFFSET, nKeys)
+}
+
+// layout returns how cells are sized in the node.
+func (node *NodeHeader) layout() cellLayout {
+	return cellLayout{
+		nodeType: node.nodeType,
+		width:    node.width,
+		compress: node.format != ORIGINAL_FORMAT && byteOrdered(node.cmp),
+		capacity: node.capacity(),
+	}
+}
+
+// fits returns true if the given cells fit in the node in place of its
+// own, along with its high key.
+func (node *NodeHeader) fits(cells [][]byte) bool {
+	layout := node.layout()
+	return layout.fits(layout.withHighKey(cells, node.highKey()))
+}
+
+// cellLayout describes how cells are sized in B-link nodes of one type.
+type cellLayout struct {
+	nodeType NodeType
+	width    int64 // Size of a slot.
+	compress bool  // Whether the keys of a node share a prefix.
+	capacity int64 // Bytes a node has for its shared prefix, slots and cells.
+}
+
+// compactLayout returns how cells are sized in B-link nodes of the given
+// type in a table whose pages have the given usable size.
+func compactLayout(nodeType NodeType, dataSize int64, cmp Comparator) cellLayout {
+	return cellLayout{
 		nodeType: nodeType,
-		numKeys:  numKeys,
-		page:     page,
+		width:    slotWidth(BLINK_FORMAT, dataSize),
+		compress: byteOrdered(cmp),
+		capacity: dataSize - nodeHeaderSize(BLINK_FORMAT, nodeType, dataSize),
+	}
+}
+
+// cellKey returns the key of a cell, pointing into the cell.
+func (layout cellLayout) cellKey(cell []byte) []byte {
+	if layout.nodeType == LEAF_NODE {
+		return leafCellKey(cell)
+	}
+	return internalCellKey(cell)
+}
+
+// highKeyCell returns the cell that holds the given high key.
+func (layout cellLayout) highKeyCell(key []byte) []byte {
+	if layout.nodeType == LEAF_NODE {
+		return BTreeEntry{key: key}.Marshal()
+	}
+	return internalCell(key, 0)
+}
+
+// withHighKey returns the cells followed by the cell of the given high
+// key, if there is one.
+func (layout cellLayout) withHighKey(cells [][]byte, highKey []byte) [][]byte {
+	if highKey == nil {
+		return cells
+	}
+	return append(cells[:len(cells):len(cells)], layout.highKeyCell(highKey))
+}
+
+// sharedPrefixLen returns the length of the prefix shared by the keys of
+// the given cells, which is zero if keys aren't compressed.
+func (layout cellLayout) sharedPrefixLen(cells [][]byte) int64 {
+	if !layout.compress || len(cells) == 0 {
+		return 0
+	}
+	first := layout.cellKey(cells[0])
+	prefixLen := int64(len(first))
+	for _, cell := range cells[1:] {
+		if n := commonPrefixLen(first, layout.cellKey(cell)); n < prefixLen {
+			prefixLen = n
+		}
+	}
+	return prefixLen
+}
+
+// size returns the space the given cells take up in a node, with their
+// slots, without sharing a prefix.
+func (layout cellLayout) size(cells [][]byte) int64 {
+	var size int64
+	for _, cell := range cells {
+		size += int64(len(cell)) + layout.width
 	}
+	return size
 }
 
-// entryPos computes the position of an entry within a page given a headersize.
-func entryPos(headersize int64, entrynum int64) int64 {
-	return headersize + entrynum*ENTRYSIZE
+// fits returns true if the given cells fit in one node.
+func (layout cellLayout) fits(cells [][]byte) bool {
+	return packedSize(layout.size(cells), int64(len(cells)), layout.sharedPrefixLen(cells)) <= layout.capacity
 }
 
-// keyPos returns the offset in the page to the internal node's ith key.
-func keyPos(index int64) int64 {
-	return KEYS_OFFSET + index*KEY_SIZE
+// splitPoint returns the index that splits the cells into two runs that
+// each fit in a node and are about the same size. The left run takes the
+// key at the index as its high key, and the right run the given high key,
+// if any. If skip is true, the cell at the index is left out of both runs,
+// as when it is promoted to a parent. Runs are compared by their size
+// without a shared prefix, which keeps both halves full enough whatever
+// prefixes they end up sharing.
+func (layout cellLayout) splitPoint(cells [][]byte, highKey []byte, skip bool) int {
+	n := len(cells)
+	// sizes[i] is the size of cells[:i], and fences[i] that of a high key
+	// cell for the key of cells[i]; left[i] and right[i] are the lengths of
+	// the prefixes shared by cells[:i] and by cells[i:] and the high key.
+	sizes, fences := make([]int64, n+1), make([]int64, n)
+	left, right := make([]int64, n+1), make([]int64, n+1)
+	for i, cell := range cells {
+		sizes[i+1] = sizes[i] + int64(len(cell)) + layout.width
+		fences[i] = int64(len(layout.highKeyCell(layout.cellKey(cell)))) + layout.width
+	}
+	var highSize, highCount int64
+	if highKey != nil {
+		highSize, highCount = int64(len(layout.highKeyCell(highKey)))+layout.width, 1
+	}
+	if layout.compress && n > 0 {
+		first, last := layout.cellKey(cells[0]), highKey
+		if last == nil {
+			last = layout.cellKey(cells[n-1])
+		}
+		left[1], right[n] = int64(len(first)), int64(len(last))
+		for i := 2; i <= n; i++ {
+			left[i] = left[i-1]
+			if common := commonPrefixLen(first, layout.cellKey(cells[i-1])); common < left[i] {
+				left[i] = common
+			}
+		}
+		for i := n - 1; i >= 0; i-- {
+			right[i] = right[i+1]
+			if common := commonPrefixLen(last, layout.cellKey(cells[i])); common < right[i] {
+				right[i] = common
+			}
+		}
+	}
+	best, bestFits, bestDiff := 1, false, int64(-1)
+	for i := 1; i < n; i++ {
+		j := i
+		if skip {
+			j++
+		}
+		leftSize, rightSize := sizes[i]+fences[i], sizes[n]-sizes[j]+highSize
+		fits := packedSize(leftSize, int64(i+1), left[i+1]) <= layout.capacity &&
+			packedSize(rightSize, int64(n-j)+highCount, right[j]) <= layout.capacity
+		diff := leftSize - rightSize
+		if diff < 0 {
+			diff = -diff
+		}
+		if bestDiff < 0 || fits && !bestFits || fits == bestFits && diff < bestDiff {
+			best, bestFits, bestDiff = i, fits, diff
+		}
+	}
+	return best
 }
 
-// pnPos returns the page offset to the internal node's ith child's pagenumber
-func pnPos(index int64) int64 {
-	return PNS_OFFSET + index*PN_SIZE
+// packedSize returns the space that count cells, which take up size bytes
+// with their slots, take up in a node once they share a prefix of the given
+// length.
+func packedSize(size int64, count int64, prefixLen int64) int64 {
+	if count == 0 {
+		return 0
+	}
+	return size - (count-1)*prefixLen
+}
+
+// commonPrefixLen returns the length of the longest common prefix of a and b.
+func commonPrefixLen(a []byte, b []byte) int64 {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return int64(n)
 }
 
 /////////////////////////////////////////////////////////////////////////////
@@ -127,28 +817,23 @@ func pnPos(index int64) int64 {
 /////////////////////////////////////////////////////////////////////////////
 
 // pageToLeafNode returns the leaf node at the corresponding page.
-func pageToLeafNode(page *pager.Page) *LeafNode {
-	nodeHeader := pageToNodeHeader(page)
-	rightSiblingPN, _ := binary.Varint(
-		(*page.GetData())[RIGHT_SIBLING_PN_OFFSET : RIGHT_SIBLING_PN_OFFSET+RIGHT_SIBLING_PN_SIZE],
-	)
+func pageToLeafNode(page *pager.Page, cmp Comparator) *LeafNode {
+	nodeHeader := pageToNodeHeader(page, cmp)
 	return &LeafNode{
 		nodeHeader,
-		rightSiblingPN,
-		nil,
+		nodeHeader.getHeaderPN(1),
 	}
 }
 
 // createLeafNode creates and returns a new leaf node.
 // Nodes created with this function must be `Put()` accordingly after use.
-func createLeafNode(pager *pager.Pager) (*LeafNode, error) {
-	newPN := pager.GetFreePN()
-	newPage, err := pager.GetPage(newPN)
+func createLeafNode(pager *pager.Pager, cmp Comparator) (*LeafNode, error) {
+	newPage, err := pager.GetNewPage()
 	if err != nil {
 		return &LeafNode{}, err
 	}
 	initPage(newPage, LEAF_NODE)
-	return pageToLeafNode(newPage), nil
+	return pageToLeafNode(newPage, cmp), nil
 }
 
 // getPage returns a pointer to the leaf node's page.
@@ -163,9 +848,8 @@ func (node *LeafNode) getNodeType() NodeType {
 
 // copy copies the attributes and data of toCopy to the leaf node.
 func (node *LeafNode) copy(toCopy *LeafNode) {
-	copy(*node.page.GetData(), *toCopy.page.GetData())
-	node.updateNumKeys(toCopy.numKeys)
-	node.setRightSibling(toCopy.rightSiblingPN)
+	node.page.Update(*toCopy.page.GetData(), 0, int64(len(*toCopy.page.GetData())))
+	*node = *pageToLeafNode(node.page, node.cmp)
 }
 
 // isRoot returns true if the current node is the root node.
@@ -173,74 +857,51 @@ func (node *LeafNode) isRoot() bool {
 	return node.page.GetPageNum() == ROOT_PN
 }
 
-// setRightSibling sets the right sibling pagenumber attribute of the leaf node
-// and updates the leaf node's page accordingly. returns the old right sibling.
-func (node *LeafNode) setRightSibling(siblingPN int64) int64 {
-	// Retrieve the old sibling data
-	oldSiblingPN := node.rightSiblingPN
-	// Write the new sibling data to the page
-	node.rightSiblingPN = siblingPN
-	siblingData := make([]byte, RIGHT_SIBLING_PN_SIZE)
-	binary.PutVarint(siblingData, node.rightSiblingPN)
-	node.page.Update(
-		siblingData,
-		RIGHT_SIBLING_PN_OFFSET,
-		RIGHT_SIBLING_PN_SIZE,
-	)
+// setLeftSibling sets the left sibling pagenumber attribute of the leaf node
+// and updates the leaf node's page accordingly. returns the old left sibling.
+func (node *LeafNode) setLeftSibling(siblingPN int64) int64 {
+	oldSiblingPN := node.leftSiblingPN
+	node.leftSiblingPN = siblingPN
+	node.setHeaderPN(1, siblingPN)
 	return oldSiblingPN
 }
 
-// entryPos returns the page offset to the entry at the given index.
-func (node *LeafNode) entryPos(index int64) int64 {
-	return entryPos(LEAF_NODE_HEADER_SIZE, index)
-}
-
-// modifyEntry updates the data stored in the entry at the given index.
-func (node *LeafNode) modifyEntry(index int64, entry BTreeEntry) {
-	newdata := entry.Marshal()
-	startPos := node.entryPos(index)
-	node.page.Update(newdata, startPos, ENTRYSIZE)
-}
-
-// getEntry returns the entry stored in the entry at the given index.
-func (node *LeafNode) getEntry(index int64) BTreeEntry {
-	startPos := node.entryPos(index)
-	// Deserialize the entry.
-	entry := unmarshalEntry((*node.page.GetData())[startPos : startPos+ENTRYSIZE])
-	return entry
-}
-
-// getKeyAt returns the key stored at the given index of the leaf node.
-func (node *LeafNode) getKeyAt(index int64) int64 {
-	return node.getEntry(index).GetKey()
-}
-
-// updateKeyAt updates the key at the given index of the leaf node.
-func (node *LeafNode) updateKeyAt(index int64, key int64) {
-	entry := node.getEntry(index)
-	entry.SetKey(key)
-	node.modifyEntry(index, entry)
+// getAndLockLeaf returns the leaf at the given page number, pinned and
+// locked, or nil if the page number is negative. Release it with
+// releaseLeaf.
+// [CONCURRENCY] A leaf's right sibling is only locked while holding the
+// leaf, so that sibling pointers can be fixed up without deadlocking.
+func getAndLockLeaf(p *pager.Pager, pagenum int64, cmp Comparator) (*LeafNode, error) {
+	if pagenum < 0 {
+		return nil, nil
+	}
+	page, err := p.GetPage(pagenum)
+	if err != nil {
+		return nil, err
+	}
+	page.WLock()
+	return pageToLeafNode(page, cmp), nil
 }
 
-// getValueAt returns the value stored at the given index of the leaf node.
-func (node *LeafNode) getValueAt(index int64) int64 {
-	return node.getEntry(index).GetValue()
+// releaseLeaf unlocks and unpins a leaf returned by getAndLockLeaf.
+func releaseLeaf(node *LeafNode) {
+	if node != nil {
+		node.page.WUnlock()
+		node.page.Put()
+	}
 }
 
-// updateValueAt updates the value at the given index of the leaf node.
-func (node *LeafNode) updateValueAt(index int64, value int64) {
-	entry := node.getEntry(index)
-	entry.SetValue(value)
-	node.modifyEntry(index, entry)
+// leafCellKey returns the key of a leaf cell, pointing into the cell.
+func leafCellKey(cell []byte) []byte {
+	keyLen, n := binary.Uvarint(cell)
+	_, m := binary.Uvarint(cell[n:])
+	return cell[n+m : n+m+int(keyLen)]
 }
 
-// updateNumKeys updates the numKeys field in the node struct and the page.
-func (node *LeafNode) updateNumKeys(nKeys int64) {
-	node.numKeys = nKeys
-	// Write the new data to the page
-	nKeysData := make([]byte, NUM_KEYS_SIZE)
-	binary.PutVarint(nKeysData, nKeys)
-	node.page.Update(nKeysData, NUM_KEYS_OFFSET, NUM_KEYS_SIZE)
+// getEntry returns the entry stored at the given index, reading its value
+// from its overflow pages if it has any.
+func (node *LeafNode) getEntry(index int64) (BTreeEntry, error) {
+	return unmarshalEntry(node.getCell(index)).load(node.page.GetPager())
 }
 
 /////////////////////////////////////////////////////////////////////////////
@@ -248,21 +909,22 @@ func (node *LeafNode) updateNumKeys(nKeys int64) {
 /////////////////////////////////////////////////////////////////////////////
 
 // pageToInternalNode returns the internal node corresponding to the given page.
-func pageToInternalNode(page *pager.Page) *InternalNode {
-	nodeHeader := pageToNodeHeader(page)
-	return &InternalNode{nodeHeader, nil}
+func pageToInternalNode(page *pager.Page, cmp Comparator) *InternalNode {
+	nodeHeader := pageToNodeHeader(page, cmp)
+	return &InternalNode{nodeHeader}
 }
 
-// createInternalNode creates and returns a new internal node.
-// Nodes created with this function must be `Put()` accordingly after use.
-func createInternalNode(pager *pager.Pager) (*InternalNode, error) {
-	newPN := pager.GetFreePN()
-	newPage, err := pager.GetPage(newPN)
+// createInternalNode creates and returns a new internal node at the given
+// level. Nodes created with this function must be `Put()` accordingly after use.
+func createInternalNode(pager *pager.Pager, cmp Comparator, level int64) (*InternalNode, error) {
+	newPage, err := pager.GetNewPage()
 	if err != nil {
 		return &InternalNode{}, err
 	}
 	initPage(newPage, INTERNAL_NODE)
-	return pageToInternalNode(newPage), nil
+	node := pageToInternalNode(newPage, cmp)
+	node.setLevel(level)
+	return node, nil
 }
 
 // getPage returns the internal node's page.
@@ -277,8 +939,8 @@ func (node *InternalNode) getNodeType() NodeType {
 
 // copy copies the attributes and data of toCopy to node.
 func (node *InternalNode) copy(toCopy *InternalNode) {
-	copy(*node.page.GetData(), *toCopy.page.GetData())
-	node.updateNumKeys(toCopy.numKeys)
+	node.page.Update(*toCopy.page.GetData(), 0, int64(len(*toCopy.page.GetData())))
+	*node = *pageToInternalNode(node.page, node.cmp)
 }
 
 // isRoot returns true if the current node is the root node.
@@ -286,36 +948,46 @@ func (node *InternalNode) isRoot() bool {
 	return node.page.GetPageNum() == ROOT_PN
 }
 
-// getKeyAt returns the key stored at the given index of the internal node.
-func (node *InternalNode) getKeyAt(index int64) int64 {
-	startPos := keyPos(index)
-	key, _ := binary.Varint((*node.page.GetData())[startPos : startPos+KEY_SIZE])
-	return key
+// internalCellSize returns the largest size of an internal cell of a key.
+func internalCellSize(key []byte) int64 {
+	return uvarintSize(uint64(len(key))) + int64(len(key)) + binary.MaxVarintLen64
+}
+
+// internalCell returns an internal cell holding a key and the page number
+// of the child to its right.
+func internalCell(key []byte, pagenum int64) []byte {
+	cell := make([]byte, 0, internalCellSize(key))
+	cell = appendUvarint(cell, uint64(len(key)))
+	cell = append(cell, key...)
+	return appendUvarint(cell, uint64(pagenum))
+}
+
+// internalCellKey returns the key of an internal cell, pointing into the cell.
+func internalCellKey(cell []byte) []byte {
+	keyLen, n := binary.Uvarint(cell)
+	return cell[n : n+int(keyLen)]
 }
 
-// updateKeyAt updates the key at the given index of the internal node.
-func (node *InternalNode) updateKeyAt(index int64, key int64) {
-	// Serialize the key data
-	data := make([]byte, KEY_SIZE)
-	binary.PutVarint(data, key)
-	startPos := keyPos(int64(index))
-	node.page.Update(data, startPos, KEY_SIZE)
+// internalCellPN returns the page number in an internal cell.
+func internalCellPN(cell []byte) int64 {
+	keyLen, n := binary.Uvarint(cell)
+	pagenum, _ := binary.Uvarint(cell[n+int(keyLen):])
+	return int64(pagenum)
 }
 
 // getPNAt returns the pagenumber stored at the given index of the internal node.
+// Child i is to the left of key i; the last child is to the right of the last key.
 func (node *InternalNode) getPNAt(index int64) int64 {
-	startPos := pnPos(index)
-	pagenum, _ := binary.Varint((*node.page.GetData())[startPos : startPos+PN_SIZE])
-	return pagenum
+	if index == 0 {
+		return node.getHeaderPN(0)
+	}
+	return node.storedPN(node.storedCell(index - 1))
 }
 
-// updatePNAt updates the pagenumber at the given index of the internal node.
-func (node *InternalNode) updatePNAt(index int64, pagenum int64) {
-	// Serialize the pagenum data
-	data := make([]byte, PN_SIZE)
-	binary.PutVarint(data, pagenum)
-	startPos := pnPos(int64(index))
-	node.page.Update(data, startPos, PN_SIZE)
+// updateLeftmostPN updates the page number of the internal node's leftmost
+// child. The others are in cells, which are replaced as a whole.
+func (node *InternalNode) updateLeftmostPN(pagenum int64) {
+	node.setHeaderPN(0, pagenum)
 }
 
 // getChildAt returns the internal node's ith child.
@@ -327,142 +999,35 @@ func (node *InternalNode) getChildAt(index int64) (Node, error) {
 	if err != nil {
 		return &InternalNode{}, err
 	}
-	return pageToNode(page), nil
+	return pageToNode(page, node.cmp), nil
 }
 
-// getAndLockChildAt locks, then returns the internal node's ith child.
-// Nodes created with this function must be `Put()` accordingly after use.
-func (node *InternalNode) getAndLockChildAt(index int64) (Node, error) {
-	// Get the child's page
-	pagenum := node.getPNAt(index)
-	page, err := node.page.GetPager().GetPage(pagenum)
-	if err != nil {
-		return &InternalNode{}, err
-	}
-	page.WLock()
-	return pageToNode(page), nil
+// minUsed returns the number of bytes of slots and cells an internal node
+// other than the root must hold. Splitting a full node leaves at least this
+// much on each side.
+func (node *InternalNode) minUsed() int64 {
+	return node.capacity()/2 - node.maxCell()
 }
 
-// updateNumKeys updates the numKeys field in the node struct and the page.
-func (node *InternalNode) updateNumKeys(nKeys int64) {
-	node.numKeys = nKeys
-	// Write the new data to the page
-	nKeysData := make([]byte, NUM_KEYS_SIZE)
-	binary.PutVarint(nKeysData, nKeys)
-	node.page.Update(nKeysData, NUM_KEYS_OFFSET, NUM_KEYS_SIZE)
-}
-
-/////////////////////////////////////////////////////////////////////////////
-////////////////////////// Lock  Helper Functions ///////////////////////////
-/////////////////////////////////////////////////////////////////////////////
-
-func initRootNode(root Node) {
-	switch castedRootNode := root.(type) {
-	case *InternalNode:
-		castedRootNode.parent = SUPER_NODE
-	case *LeafNode:
-		castedRootNode.parent = SUPER_NODE
-	}
+// minUsed returns the number of bytes of slots and cells a leaf node other
+// than the root must hold. Splitting a full node leaves at least this much
+// on each side.
+func (node *LeafNode) minUsed() int64 {
+	return (node.capacity() - node.maxCell()) / 2
 }
 
-// locks the super node and the root node.
-func lockRoot(page *pager.Page) {
-	SUPER_NODE.page.WLock()
-	page.WLock()
-}
-
-// unlocks the super node and the root node. should only be called
-// if the student has not finished concurrency yet.
-func unsafeUnlockRoot(root Node) {
-	// Lock the root node.
-	switch castedRootNode := root.(type) {
-	case *InternalNode:
-		if castedRootNode.parent != nil {
-			// Emit a warning to disable this function call.
-			fmt.Println("WARNING: unsafeUnlockRoot was called. This function will only be called if theroot node is not being unlocked properly.")
-			castedRootNode.parent = nil
-			castedRootNode.page.WUnlock()
-			SUPER_NODE.page.WUnlock()
-		}
-	case *LeafNode:
-		if castedRootNode.parent != nil {
-			// Emit a warning to disable this function call.
-			fmt.Println("WARNING: unsafeUnlockRoot was called. This function will only be called if the root node is not being unlocked properly.")
-			castedRootNode.parent = nil
-			castedRootNode.page.WUnlock()
-			SUPER_NODE.page.WUnlock()
-		}
+// underflows returns true if the internal node holds too few keys.
+func (node *InternalNode) underflows() bool {
+	if node.isRoot() {
+		return node.numKeys < 1
 	}
+	return node.used() < node.minUsed()
 }
 
-func (node *InternalNode) initChild(child Node) {
-	// Set the NodeLockHeader parent field to be this node and lock the node.
-	// Sets the child's parents to be the node we are calling this function on
-	// you set your child's parent equal to this node
-	switch castedChild := child.(type) {
-	case *InternalNode:
-		castedChild.parent = node
-	case *LeafNode:
-		castedChild.parent = node
+// underflows returns true if the leaf node holds too few entries.
+func (node *LeafNode) underflows() bool {
+	if node.isRoot() {
+		return false
 	}
-}
-
-// unlockParent checks to see if the node could split.
-// if not, will unlock parents. if so, does nothing.
-// only checks if force == false
-func (node *InternalNode) unlockParent(force bool) error {
-	// If we could split and if we're not writing, don't unlock the parents.
-	if !force && node.numKeys == KEYS_PER_INTERNAL_NODE {
-		return nil
-	}
-	// Else, unlock the parents recursively, and remove parent pointers.
-	parent := node.parent
-	// remove parent pointer
-	node.parent = nil
-	for parent != nil {
-		switch castedParent := parent.(type) {
-		case *InternalNode:
-			parent = castedParent.parent
-			// Unlock parent
-			castedParent.unlock()
-		case *LeafNode:
-			return errors.New("should never have a leaf as a parent")
-		}
-	}
-	return nil
-}
-
-// unlock this internal node.
-func (node *InternalNode) unlock() {
-	node.parent = nil
-	node.page.WUnlock()
-}
-
-// unlockParent checks to see if the node could split.
-// if not, will unlock parents. if so, does nothing.
-// only checks if force == false
-func (node *LeafNode) unlockParent(force bool) error {
-	// If we could split and if we're not writing, don't unlock the parents.
-	if !force && node.numKeys == ENTRIES_PER_LEAF_NODE {
-		return nil
-	}
-	// Unlock the parents recursively, and remove parent pointers.
-	parent := node.parent
-	node.parent = nil
-	for parent != nil {
-		switch castedParent := parent.(type) {
-		case *InternalNode:
-			parent = castedParent.parent
-			castedParent.unlock()
-		case *LeafNode:
-			return errors.New("should never have a leaf as a parent")
-		}
-	}
-	return nil
-}
-
-// unlock this leaf node.
-func (node *LeafNode) unlock() {
-	node.parent = nil
-	node.page.WUnlock()
+	return node.used() < node.minUsed()
 }
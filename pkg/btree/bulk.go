@@ -0,0 +1,386 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// A bulk load builds a tree bottom up, instead of inserting one entry at a
+// time: it fills leaves left to right with the sorted entries, then fills
+// each level of internal nodes with the first keys and page numbers of the
+// nodes below, until a level has one node, which becomes the root. Nodes
+// are filled to the fill factor, except that none is left underfull. Each
+// node's high key is the first key of the next node on its level, which it
+// links to.
+
+// Fill factor used when none is given.
+var DEFAULT_FILL_FACTOR float64 = 0.9
+
+// bulkLevel packs the cells of one level of a bulk-loaded tree into nodes.
+// It holds on to the last full node until the next one fills, so that the
+// level's final node can be evened out with it.
+type bulkLevel struct {
+	table     *BTreeIndex
+	layout    cellLayout
+	height    int64       // Level of the nodes, counting up from the leaves.
+	target    int64       // Bytes a node is filled to.
+	minUsed   int64       // Bytes a node must hold, without a shared prefix.
+	pending   [][]byte    // Cells of the last full node, not yet written.
+	cur       [][]byte    // Cells of the node being filled.
+	curUsed   int64       // Size of the current node's stored cells, without a shared prefix.
+	curPrefix []byte      // Prefix shared by the current node's stored keys.
+	next      *pager.Page // Page of the next node, which the last one written links to.
+	nodes     [][]byte    // Internal cells for the nodes written so far.
+	pages     []int64     // Pages written so far.
+}
+
+// newBulkLevel returns a packer for the nodes at the given height.
+func (table *BTreeIndex) newBulkLevel(height int64, fillFactor float64) *bulkLevel {
+	dataSize := table.pager.GetDataSize()
+	nodeType := INTERNAL_NODE
+	if height == 0 {
+		nodeType = LEAF_NODE
+	}
+	level := &bulkLevel{table: table, layout: compactLayout(nodeType, dataSize, table.cmp), height: height}
+	if nodeType == LEAF_NODE {
+		level.minUsed = (level.layout.capacity - maxLeafCell(dataSize)) / 2
+	} else {
+		level.minUsed = level.layout.capacity/2 - maxInternalCell(dataSize)
+	}
+	level.target = int64(fillFactor * float64(level.layout.capacity))
+	return level
+}
+
+// stored returns the cells of a node that it stores. An internal node
+// keeps its first child's page number in its header, and drops the key.
+func (level *bulkLevel) stored(cells [][]byte) [][]byte {
+	if level.layout.nodeType == INTERNAL_NODE && len(cells) > 0 {
+		return cells[1:]
+	}
+	return cells
+}
+
+// add appends a cell to the level, starting a new node if the current one
+// is full enough.
+func (level *bulkLevel) add(cell []byte) error {
+	if len(level.cur) > 0 {
+		// See how much of the node the cell would take up.
+		key := level.layout.cellKey(cell)
+		prefix := level.curPrefix
+		count := int64(len(level.stored(level.cur)))
+		if count == 0 {
+			prefix = key
+		} else {
+			prefix = prefix[:commonPrefixLen(prefix, key)]
+		}
+		if !level.layout.compress {
+			prefix = nil
+		}
+		used := level.curUsed + int64(len(cell)) + level.layout.width
+		size := packedSize(used, count+1, int64(len(prefix)))
+		if size <= level.layout.capacity && (size <= level.target || level.curUsed < level.minUsed) {
+			level.cur = append(level.cur, cell)
+			level.curUsed, level.curPrefix = used, prefix
+			return nil
+		}
+		// The cell's key becomes the node's high key. If that doesn't fit,
+		// the node's last cell moves on to the next node, and its key,
+		// which takes no more room, becomes the high key instead.
+		start := [][]byte{cell}
+		if !level.layout.fits(level.layout.withHighKey(level.stored(level.cur), key)) {
+			last := len(level.cur) - 1
+			start = [][]byte{level.cur[last], cell}
+			level.cur = level.cur[:last]
+		}
+		if level.pending != nil {
+			if err := level.write(level.pending, level.layout.cellKey(level.cur[0])); err != nil {
+				return err
+			}
+		}
+		level.pending = level.cur
+		level.start(start)
+		return nil
+	}
+	level.start([][]byte{cell})
+	return nil
+}
+
+// start starts a new node with the given cells.
+func (level *bulkLevel) start(cells [][]byte) {
+	level.cur = cells
+	stored := level.stored(cells)
+	level.curUsed, level.curPrefix = level.layout.size(stored), nil
+	if len(stored) > 0 && level.layout.compress {
+		level.curPrefix = level.layout.cellKey(stored[0])[:level.layout.sharedPrefixLen(stored)]
+	}
+}
+
+// finish writes the level's last nodes, moving cells into the last one from
+// the one before it if it would be underfull.
+func (level *bulkLevel) finish() error {
+	if level.pending != nil && level.curUsed < level.minUsed {
+		cells := append(level.pending, level.cur...)
+		if level.layout.fits(level.stored(cells)) {
+			level.pending, level.cur = nil, cells
+		} else {
+			mid := level.evenSplit(cells)
+			level.pending, level.cur = cells[:mid], cells[mid:]
+		}
+	}
+	if level.pending != nil {
+		if err := level.write(level.pending, level.layout.cellKey(level.cur[0])); err != nil {
+			return err
+		}
+	}
+	if len(level.cur) > 0 {
+		if err := level.write(level.cur, nil); err != nil {
+			return err
+		}
+	}
+	level.pending, level.cur = nil, nil
+	return nil
+}
+
+// release unpins the page reserved for the next node, and adds it to the
+// pages written so that it is freed if the load fails.
+func (level *bulkLevel) release() {
+	if level.next != nil {
+		level.pages = append(level.pages, level.next.GetPageNum())
+		level.next.Put()
+		level.next = nil
+	}
+}
+
+// evenSplit returns the index that splits the cells into two nodes that
+// use about the same number of bytes. The first cell of the second of two
+// internal nodes is the one whose key is dropped, as if it were promoted.
+func (level *bulkLevel) evenSplit(cells [][]byte) int {
+	if level.layout.nodeType == INTERNAL_NODE {
+		return 1 + level.layout.splitPoint(cells[1:], nil, true)
+	}
+	return level.layout.splitPoint(cells, nil, false)
+}
+
+// write writes a node holding the given cells, with the given high key if
+// another node follows it on its level, to the page reserved for it.
+func (level *bulkLevel) write(cells [][]byte, highKey []byte) error {
+	p := level.table.pager
+	page := level.next
+	level.next = nil
+	if page == nil {
+		var err error
+		if page, err = p.GetNewPage(); err != nil {
+			return err
+		}
+	}
+	defer page.Put()
+	level.pages = append(level.pages, page.GetPageNum())
+	// Reserve the page of the node that follows, to link to it.
+	siblingPN := int64(-1)
+	if highKey != nil {
+		next, err := p.GetNewPage()
+		if err != nil {
+			return err
+		}
+		level.next, siblingPN = next, next.GetPageNum()
+	}
+	initPage(page, level.layout.nodeType)
+	node := pageToNodeHeader(page, level.table.cmp)
+	stored := level.stored(cells)
+	if level.layout.nodeType == LEAF_NODE {
+		// Link the leaf to the one before it.
+		if len(level.pages) > 1 {
+			node.setHeaderPN(1, level.pages[len(level.pages)-2])
+		}
+	} else {
+		node.setLevel(level.height)
+		node.setHeaderPN(0, internalCellPN(cells[0]))
+	}
+	node.setCellsAndLink(stored, siblingPN, highKey)
+	level.nodes = append(level.nodes, internalCell(level.layout.cellKey(cells[0]), page.GetPageNum()))
+	return nil
+}
+
+// build writes the last nodes of the given level of leaves and the levels
+// of internal nodes above it, then moves the top node into the root page.
+// On failure, it frees every page it and the level wrote.
+func (level *bulkLevel) build(rootPage *pager.Page, fillFactor float64) (err error) {
+	table := level.table
+	var pages []int64
+	defer func() {
+		if err != nil {
+			level.release()
+			pages, level.pages = append(pages, level.pages...), nil
+			for _, pn := range pages {
+				table.pager.FreePage(pn)
+			}
+		}
+	}()
+	// Fill each level of internal nodes from the one below it.
+	for {
+		if err := level.finish(); err != nil {
+			return err
+		}
+		if len(level.nodes) == 1 {
+			break
+		}
+		below := level.nodes
+		pages, level.pages = append(pages, level.pages...), nil
+		level = table.newBulkLevel(level.height+1, fillFactor)
+		for _, cell := range below {
+			if err := level.add(cell); err != nil {
+				return err
+			}
+		}
+	}
+	// Move the top node into the root page.
+	topPN := level.pages[0]
+	topPage, err := table.pager.GetPage(topPN)
+	if err != nil {
+		return err
+	}
+	defer topPage.Put()
+	rootPage.Update(*topPage.GetData(), 0, int64(len(*topPage.GetData())))
+	// The tree is in place; only the old copy of the top node is left over.
+	pages, level.pages = nil, nil
+	return table.pager.FreePage(topPN)
+}
+
+// BulkLoad fills an empty table with entries given in strictly increasing
+// key order, filling each node to the given fraction of a page.
+func (table *BTreeIndex) BulkLoad(entries utils.Iterator, fillFactor float64) (err error) {
+	defer entries.Close()
+	if err := table.pager.CheckWritable(); err != nil {
+		return err
+	}
+	if fillFactor <= 0 || fillFactor > 1 {
+		return fmt.Errorf("bulk load: fill factor %v is not between 0 and 1", fillFactor)
+	}
+	// [CONCURRENCY] Hold the table until the tree is built.
+	table.rebalancing.Lock()
+	defer table.rebalancing.Unlock()
+	table.epoch++
+	rootPage, err := table.pager.GetPage(table.rootPN)
+	if err != nil {
+		return err
+	}
+	defer rootPage.Put()
+	if root := pageToNode(rootPage, table.cmp); root.getNodeType() != LEAF_NODE || root.(*LeafNode).numKeys != 0 {
+		return errors.New("bulk load: table is not empty")
+	}
+	// On failure, free everything written so far.
+	var overflows [][]byte
+	level := table.newBulkLevel(0, fillFactor)
+	defer func() {
+		if err != nil {
+			level.release()
+			for _, ref := range overflows {
+				freeOverflow(table.pager, ref)
+			}
+			for _, pn := range level.pages {
+				table.pager.FreePage(pn)
+			}
+		}
+	}()
+	// Fill the leaves.
+	var lastKey []byte
+	for entries.Next() {
+		key, value := entries.Entry().GetKeyBytes(), entries.Entry().GetValueBytes()
+		if lastKey != nil && table.cmp(lastKey, key) >= 0 {
+			return fmt.Errorf("bulk load: key %v is out of order or repeated", utils.FormatBytes(key))
+		}
+		lastKey = key
+		entry, err := table.newEntry(key, value)
+		if err != nil {
+			return err
+		}
+		if entry.overflow {
+			overflows = append(overflows, entry.value)
+		}
+		if err := level.add(entry.Marshal()); err != nil {
+			return err
+		}
+	}
+	if err := entries.Err(); err != nil {
+		return err
+	}
+	if lastKey == nil {
+		return nil
+	}
+	if err := level.build(rootPage, fillFactor); err != nil {
+		return err
+	}
+	overflows = nil
+	return nil
+}
+
+// addSubtree adds the cells of the leaves under the given node to the
+// level, in key order, and appends the page numbers of the nodes below it
+// to pages.
+func (level *bulkLevel) addSubtree(node Node, pages *[]int64) error {
+	switch node := node.(type) {
+	case *LeafNode:
+		for i := int64(0); i < node.numKeys; i++ {
+			if err := level.add(node.getCell(i)); err != nil {
+				return err
+			}
+		}
+	case *InternalNode:
+		for i := int64(0); i <= node.numKeys; i++ {
+			child, err := node.getChildAt(i)
+			if err != nil {
+				return err
+			}
+			*pages = append(*pages, child.getPage().GetPageNum())
+			err = level.addSubtree(child, pages)
+			child.getPage().Put()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// upgrade rebuilds a table whose nodes are in an older format in the
+// B-link format, leaving its values' overflow pages where they are. A
+// read-only table is left as it is.
+func (table *BTreeIndex) upgrade() error {
+	if table.pager.IsReadOnly() {
+		return nil
+	}
+	rootPage, err := table.pager.GetPage(table.rootPN)
+	if err != nil {
+		return err
+	}
+	defer rootPage.Put()
+	if pageToNodeHeader(rootPage, table.cmp).format == BLINK_FORMAT {
+		return nil
+	}
+	// Write the new nodes beside the old ones, then replace the root.
+	var oldPages []int64
+	level := table.newBulkLevel(0, DEFAULT_FILL_FACTOR)
+	if err := level.addSubtree(pageToNode(rootPage, table.cmp), &oldPages); err != nil {
+		level.release()
+		for _, pn := range level.pages {
+			table.pager.FreePage(pn)
+		}
+		return err
+	}
+	if len(level.cur) > 0 {
+		if err := level.build(rootPage, DEFAULT_FILL_FACTOR); err != nil {
+			return err
+		}
+	} else {
+		initPage(rootPage, LEAF_NODE)
+	}
+	for _, pn := range oldPages {
+		if err := table.pager.FreePage(pn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
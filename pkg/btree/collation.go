@@ -0,0 +1,52 @@
+package btree
+
+import (
+	"bytes"
+	"strings"
+
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// A collation orders the values of one component of a tuple key. Tuples
+// made by utils.EncodeTuple already sort by component in the default byte
+// order; a table whose components need another order is opened with a
+// TupleComparator built from a collation for each of them.
+
+// TupleComparator returns a comparator that orders tuples lexicographically
+// by component, comparing the i-th components with the i-th collation, or
+// byte by byte if it is nil or there is none. Keys that aren't tuples are
+// ordered byte by byte.
+func TupleComparator(collations ...Comparator) Comparator {
+	collate := func(i int, a []byte, b []byte) int {
+		if i < len(collations) && collations[i] != nil {
+			return collations[i](a, b)
+		}
+		return bytes.Compare(a, b)
+	}
+	return func(a []byte, b []byte) int {
+		return utils.CompareTuples(a, b, collate)
+	}
+}
+
+// Descending returns a collation that reverses the order of the given
+// one, or of byte order if it is nil.
+func Descending(cmp Comparator) Comparator {
+	if cmp == nil {
+		cmp = bytes.Compare
+	}
+	return func(a []byte, b []byte) int {
+		return cmp(b, a)
+	}
+}
+
+// FoldCase is a collation that orders strings made by utils.EncodeString
+// without regard to case, so strings that differ only in case are equal.
+// Other values are ordered byte by byte.
+func FoldCase(a []byte, b []byte) int {
+	aString, aOk := utils.DecodeString(a)
+	bString, bOk := utils.DecodeString(b)
+	if !aOk || !bOk {
+		return bytes.Compare(a, b)
+	}
+	return strings.Compare(strings.ToLower(aString), strings.ToLower(bString))
+}
@@ -7,94 +7,193 @@ import (
 	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
-// Cursors are an abstration to represent locations in a table.
+// Cursors are an abstration to represent locations in a table. A cursor
+// keeps the leaf it points into pinned until it moves off of it or is
+// closed, so that the leaf can't be evicted from under it.
+// [CONCURRENCY] A cursor only locks the leaf it reads, and only while it
+// reads it, holding the table's rebalancing lock shared. Since the leaf can
+// split or be refilled between two steps, the cursor remembers the key it
+// points to rather than its cell number, and finds that key again in the
+// leaf, or to the right of it, on each step.
 type BTreeCursor struct {
 	table   *BTreeIndex  // The table that this cursor point to.
 	cellnum int64        // The cell number within a leaf node.
 	isEnd   bool         // Indicates that this cursor points beyond the table/at the end of the table.
-	curNode *LeafNode    // Current node.
+	curNode *LeafNode    // Current node; nil once the cursor is closed.
+	key     []byte       // Key of the current entry, or the key sought past the end.
+	epoch   int64        // The table's refill count when curNode was found.
 	mu      sync.RWMutex // Mutex for cursor
 }
 
 // TableStart returns a cursor pointing to the first entry of the table.
 func (table *BTreeIndex) TableStart() (utils.Cursor, error) {
-	cursor := BTreeCursor{table: table, cellnum: 0}
-	// Get the root page.
-	curPage, err := table.pager.GetPage(table.rootPN)
+	table.rebalancing.RLock()
+	defer table.rebalancing.RUnlock()
+	cursor := BTreeCursor{table: table, epoch: table.epoch}
+	// Traverse the leftmost children until we reach a leaf node.
+	leaf, err := table.edgeLeaf(false)
 	if err != nil {
 		return nil, err
 	}
-	defer curPage.Put()
-	curHeader := pageToNodeHeader(curPage)
-	// Traverse the leftmost children until we reach a leaf node.
-	for curHeader.nodeType != LEAF_NODE {
-		curNode := pageToInternalNode(curPage)
-		leftmostPN := curNode.getPNAt(0)
-		curPage, err = table.pager.GetPage(leftmostPN)
-		if err != nil {
-			return nil, err
-		}
-		defer curPage.Put()
-		curHeader = pageToNodeHeader(curPage)
+	// Point to the first entry, skipping over empty leaves.
+	leaf, cellnum, err := table.scanRight(leaf, 0)
+	if err != nil {
+		return nil, err
 	}
-	// Set the cursor to point to the first entry in the leftmost leaf node.
-	leftmostNode := pageToLeafNode(curPage)
-	cursor.isEnd = (leftmostNode.numKeys == 0)
-	cursor.curNode = leftmostNode
+	cursor.moveTo(leaf, cellnum)
+	releaseNode(leaf, false)
 	return &cursor, nil
 }
 
 // TableEnd returns a cursor pointing to the last entry in the db.
 // If the db is empty, returns a cursor to the new insertion position.
 func (table *BTreeIndex) TableEnd() (utils.Cursor, error) {
-	cursor := BTreeCursor{table: table, cellnum: 0}
-	// Get the root page.
-	curPage, err := table.pager.GetPage(table.rootPN)
+	table.rebalancing.RLock()
+	defer table.rebalancing.RUnlock()
+	cursor := BTreeCursor{table: table, epoch: table.epoch}
+	// Traverse the rightmost children until we reach a leaf node.
+	leaf, err := table.edgeLeaf(true)
 	if err != nil {
 		return &BTreeCursor{}, err
 	}
-	defer curPage.Put()
-	curHeader := pageToNodeHeader(curPage)
-	// Traverse the rightmost children until we reach a leaf node.
-	for curHeader.nodeType != LEAF_NODE {
-		curNode := pageToInternalNode(curPage)
-		rightmostPN := curNode.getPNAt(curHeader.numKeys)
-		curPage, err = table.pager.GetPage(rightmostPN)
+	// Point to the last entry, skipping back over empty leaves.
+	leaf, cellnum, err := table.scanLeft(leaf, leaf.numKeys)
+	if err != nil {
+		return &BTreeCursor{}, err
+	}
+	if cellnum < 0 {
+		cellnum = leaf.numKeys
+	}
+	cursor.moveTo(leaf, cellnum)
+	releaseNode(leaf, false)
+	return &cursor, nil
+}
+
+// edgeLeaf follows the leftmost child of each internal node down from the
+// root, or the rightmost one, and returns the leaf it reaches, pinned and
+// locked for reading.
+// [CONCURRENCY] The rightmost node on each level is the one with no right
+// sibling, which a node being split may have just handed the rightmost
+// child to.
+func (table *BTreeIndex) edgeLeaf(rightmost bool) (*LeafNode, error) {
+	node, err := table.lockNode(table.rootPN, false)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		for rightmost && node.header().rightSiblingPN >= 0 {
+			if node, err = table.stepRight(node, false); err != nil {
+				return nil, err
+			}
+		}
+		internal, ok := node.(*InternalNode)
+		if !ok {
+			return node.(*LeafNode), nil
+		}
+		childPN := internal.getPNAt(0)
+		if rightmost {
+			childPN = internal.getPNAt(internal.numKeys)
+		}
+		releaseNode(internal, false)
+		if node, err = table.lockNode(childPN, false); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// scanRight returns the leaf and cell number of the first entry at or after
+// the given cell of the given leaf, following right siblings past the end of
+// the leaf. The leaf returned is locked for reading; if there is no such
+// entry, it is the last leaf and the cell number is its number of keys.
+func (table *BTreeIndex) scanRight(leaf *LeafNode, cellnum int64) (*LeafNode, int64, error) {
+	for cellnum >= leaf.numKeys && leaf.rightSiblingPN >= 0 {
+		next, err := table.stepRight(leaf, false)
 		if err != nil {
-			return &BTreeCursor{}, err
+			return nil, 0, err
 		}
-		defer curPage.Put()
-		curHeader = pageToNodeHeader(curPage)
-	}
-	// Set the cursor to point to the last entry in the rightmost leaf node.
-	rightmostNode := pageToLeafNode(curPage)
-	cursor.isEnd = false
-	cursor.cellnum = rightmostNode.numKeys - 1
-	cursor.curNode = rightmostNode
-	return &cursor, nil
+		leaf, cellnum = next.(*LeafNode), 0
+	}
+	return leaf, cellnum, nil
+}
+
+// scanLeft returns the leaf and cell number of the last entry before the
+// given cell of the given leaf, following left siblings past the start of
+// the leaf. The leaf returned is locked for reading; if there is no such
+// entry, it is the first leaf and the cell number is -1.
+// [CONCURRENCY] Locks are only taken rightwards, so the leaf is released
+// before its left sibling is locked. The left sibling may have split in the
+// meantime, in which case the leaf before this one is further right.
+func (table *BTreeIndex) scanLeft(leaf *LeafNode, cellnum int64) (*LeafNode, int64, error) {
+	for cellnum <= 0 && leaf.leftSiblingPN >= 0 {
+		pagenum, leftPN := leaf.page.GetPageNum(), leaf.leftSiblingPN
+		releaseNode(leaf, false)
+		node, err := table.lockNode(leftPN, false)
+		if err != nil {
+			return nil, 0, err
+		}
+		for node.header().rightSiblingPN != pagenum {
+			if node, err = table.stepRight(node, false); err != nil {
+				return nil, 0, err
+			}
+		}
+		leaf = node.(*LeafNode)
+		cellnum = leaf.numKeys
+	}
+	return leaf, cellnum - 1, nil
+}
+
+// lockLeaf returns the leaf that the cursor's key belongs to, pinned and
+// locked for reading. It is the current leaf, or one to its right if the
+// leaf split, unless the table was refilled since the cursor found it, in
+// which case the leaf may be gone and is found again from the root.
+func (cursor *BTreeCursor) lockLeaf() (*LeafNode, error) {
+	table := cursor.table
+	if cursor.epoch == table.epoch {
+		page := cursor.curNode.page
+		page.Get()
+		page.RLock()
+		if node, ok := pageToNode(page, table.cmp).(*LeafNode); ok {
+			leaf, err := table.moveRight(node, cursor.key, false)
+			if err != nil {
+				return nil, err
+			}
+			return leaf.(*LeafNode), nil
+		}
+		// The root leaf has split, and is now an internal node.
+		page.RUnlock()
+		page.Put()
+	}
+	cursor.epoch = table.epoch
+	leaf, _, err := table.findLeaf(cursor.key, false)
+	return leaf, err
+}
+
+// moveTo points the cursor to the given cell of the given locked leaf, or
+// beyond the end of the table if the cell is past the leaf's last one.
+func (cursor *BTreeCursor) moveTo(leaf *LeafNode, cellnum int64) {
+	leaf.page.Get()
+	cursor.setNode(leaf)
+	cursor.cellnum = cellnum
+	cursor.isEnd = cellnum >= leaf.numKeys
+	if !cursor.isEnd {
+		cursor.key = leaf.getKeyAt(cellnum)
+	}
+	cursor.readAhead()
 }
 
 // TableFind returns a cursor pointing to the given key.
 // If the key is not found, returns a cursor to the new insertion position.
-// Hint: use keyToNodeEntry
 func (table *BTreeIndex) TableFind(key int64) (utils.Cursor, error) {
+	return table.TableFindBytes(utils.EncodeInt(key))
+}
+
+// TableFindBytes returns a cursor pointing to the given byte-string key.
+// If the key is not found, returns a cursor to the new insertion position.
+func (table *BTreeIndex) TableFindBytes(key []byte) (utils.Cursor, error) {
 	cursor := BTreeCursor{table: table}
-	// Get the root page.
-	rootPage, err := table.pager.GetPage(table.rootPN)
-	if err != nil {
-		return &BTreeCursor{}, err
-	}
-	defer rootPage.Put()
-	rootNode := pageToNode(rootPage)
-	// Find the leaf node and cellnum that this key belongs to.
-	leaf, cellnum, err := rootNode.keyToNodeEntry(key)
-	if err != nil {
+	if err := cursor.Seek(key); err != nil {
 		return &BTreeCursor{}, err
 	}
-	// Initialize cursor.
-	cursor.cellnum = cellnum
-	cursor.isEnd = (cellnum == leaf.numKeys)
-	cursor.curNode = leaf
 	return &cursor, nil
 }
 
@@ -107,67 +206,151 @@ func (table *BTreeIndex) TableFindRange(startKey int64, endKey int64) ([]utils.E
 	if err != nil {
 		return entries, err
 	}
+	defer cursor.Close()
 	// Keep advancing the cursor and adding the current entry to the list of
 	// entries until reaching the end key.
-	curEntry, err := cursor.GetEntry()
-	if err != nil {
-		return entries, err
-	}
-	for endKey > curEntry.GetKey() && !cursor.IsEnd() {
-		entries = append(entries, curEntry)
-		cursor.StepForward()
-		curEntry, err = cursor.GetEntry()
+	end := utils.EncodeInt(endKey)
+	for !cursor.IsEnd() {
+		curEntry, err := cursor.GetEntry()
 		if err != nil {
 			return entries, err
 		}
+		if table.cmp(end, curEntry.GetKeyBytes()) <= 0 {
+			break
+		}
+		entries = append(entries, curEntry)
+		if cursor.StepForward() {
+			break
+		}
 	}
 	return entries, nil
 	/* SOLUTION }}} */
 }
 
-// stepForward moves the cursor ahead by one entry. Returns true at the end of the BTree.
+// Seek moves the cursor to the first entry whose key is at least the given
+// key. If there is none, the cursor points beyond the end of the table.
+func (cursor *BTreeCursor) Seek(key []byte) error {
+	table := cursor.table
+	table.rebalancing.RLock()
+	defer table.rebalancing.RUnlock()
+	// Find the leaf node and cellnum that this key belongs to; if every key
+	// in the leaf is smaller, the next one is in a leaf further right.
+	cursor.epoch = table.epoch
+	leaf, _, err := table.findLeaf(key, false)
+	if err != nil {
+		return err
+	}
+	leaf, cellnum, err := table.scanRight(leaf, leaf.search(key))
+	if err != nil {
+		return err
+	}
+	defer releaseNode(leaf, false)
+	cursor.key = key
+	cursor.moveTo(leaf, cellnum)
+	return nil
+}
+
+// setNode moves the cursor onto a pinned leaf, releasing the one it was on.
+func (cursor *BTreeCursor) setNode(node *LeafNode) {
+	if cursor.curNode != nil {
+		cursor.curNode.page.Put()
+	}
+	cursor.curNode = node
+}
+
+// StepForward moves the cursor ahead by one entry. Returns true at the end of the BTree.
 func (cursor *BTreeCursor) StepForward() (atEnd bool) {
-	// If the cursor is at the end of the node, go to the next node.
-	if cursor.cellnum+1 >= cursor.curNode.numKeys {
-		// Get the next node's page number.
-		nextPN := cursor.curNode.rightSiblingPN
-		if nextPN < 0 {
-			return true
-		}
-		// Convert the page into a node.
-		nextPage, err := cursor.table.pager.GetPage(nextPN)
-		if err != nil {
-			return true
-		}
-		defer nextPage.Put()
-		nextNode := pageToLeafNode(nextPage)
-		// Reinitialize the cursor.
-		cursor.cellnum = 0
-		cursor.curNode = nextNode
-		// If the next node is empty, step to the next node.
-		if cursor.cellnum == nextNode.numKeys {
-			return cursor.StepForward()
-		}
-		return false
+	if cursor.curNode == nil || cursor.isEnd {
+		return true
+	}
+	cursor.table.rebalancing.RLock()
+	defer cursor.table.rebalancing.RUnlock()
+	leaf, err := cursor.lockLeaf()
+	if err != nil {
+		return true
+	}
+	// Find the first key after the current one, wherever it is now.
+	cellnum := leaf.search(cursor.key)
+	if cellnum < leaf.numKeys && leaf.compareKeyAt(cellnum, cursor.key) == 0 {
+		cellnum++
 	}
-	// Else, just move the cursor forward.
-	cursor.cellnum++
+	leaf, cellnum, err = cursor.table.scanRight(leaf, cellnum)
+	if err != nil {
+		return true
+	}
+	defer releaseNode(leaf, false)
+	if cellnum >= leaf.numKeys {
+		return true
+	}
+	cursor.moveTo(leaf, cellnum)
+	return false
+}
+
+// StepBackward moves the cursor back by one entry. Returns true at the start of the BTree.
+func (cursor *BTreeCursor) StepBackward() (atStart bool) {
+	if cursor.curNode == nil || cursor.key == nil {
+		return true
+	}
+	cursor.table.rebalancing.RLock()
+	defer cursor.table.rebalancing.RUnlock()
+	leaf, err := cursor.lockLeaf()
+	if err != nil {
+		return true
+	}
+	// Find the last key before the current one, wherever it is now.
+	leaf, cellnum, err := cursor.table.scanLeft(leaf, leaf.search(cursor.key))
+	if err != nil {
+		return true
+	}
+	defer releaseNode(leaf, false)
+	if cellnum < 0 {
+		return true
+	}
+	cursor.moveTo(leaf, cellnum)
 	return false
 }
 
+// readAhead starts reading the leaf to the right of the current one, so that
+// it is in the buffer pool by the time the cursor steps onto it.
+func (cursor *BTreeCursor) readAhead() {
+	if nextPN := cursor.curNode.rightSiblingPN; nextPN >= 0 {
+		cursor.table.pager.Prefetch(nextPN)
+	}
+}
+
 // IsEnd returns true if at end.
 func (cursor *BTreeCursor) IsEnd() bool {
 	return cursor.isEnd
 }
 
-// getEntry returns the entry currently pointed to by the cursor.
+// getEntry returns the entry currently pointed to by the cursor. If that
+// entry has been deleted, the cursor moves on to the one after it.
 func (cursor *BTreeCursor) GetEntry() (utils.Entry, error) {
 	// Check if we're retrieving a non-existent entry.
+	if cursor.isEnd || cursor.curNode == nil {
+		return BTreeEntry{}, errors.New("getEntry: entry is non-existent")
+	}
+	cursor.table.rebalancing.RLock()
+	defer cursor.table.rebalancing.RUnlock()
+	leaf, err := cursor.lockLeaf()
+	if err != nil {
+		return BTreeEntry{}, err
+	}
+	leaf, cellnum, err := cursor.table.scanRight(leaf, leaf.search(cursor.key))
+	if err != nil {
+		return BTreeEntry{}, err
+	}
+	defer releaseNode(leaf, false)
+	cursor.moveTo(leaf, cellnum)
 	if cursor.isEnd {
 		return BTreeEntry{}, errors.New("getEntry: entry is non-existent")
 	}
-	cursor.curNode.page.WLock()
-	defer cursor.curNode.page.WUnlock()
-	entry := cursor.curNode.getEntry(cursor.cellnum)
-	return entry, nil
-}
\ No newline at end of file
+	return leaf.getEntry(cellnum)
+}
+
+// Close releases the leaf the cursor points into. The cursor can't be
+// used afterwards.
+func (cursor *BTreeCursor) Close() {
+	cursor.setNode(nil)
+	cursor.isEnd = true
+}
@@ -2,55 +2,138 @@ package btree
 
 import (
 	"encoding/binary"
-)
 
-// Global size for Entries.
-var ENTRYSIZE int64 = binary.MaxVarintLen64 * 2
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
 
 // Entry is a struct of one unit of information in our table.
 type BTreeEntry struct {
-	key   int64
-	value int64
+	key      []byte
+	value    []byte
+	overflow bool // Set if value is a reference to the value's overflow pages.
+}
+
+// NewBTreeEntry returns an entry with the given key and value.
+func NewBTreeEntry(key []byte, value []byte) BTreeEntry {
+	return BTreeEntry{key: key, value: value}
 }
 
-// Get key.
+// Size of a reference to a value's overflow pages: the first page number
+// and the value's length.
+var OVERFLOW_REF_SIZE int64 = 16
+
+// Get key. Returns 0 if the key isn't an integer.
 func (entry BTreeEntry) GetKey() int64 {
-	return entry.key
+	key, _ := utils.DecodeInt(entry.key)
+	return key
 }
 
-// Get value.
+// Get value. Returns 0 if the value isn't an integer.
 func (entry BTreeEntry) GetValue() int64 {
+	value, _ := utils.DecodeInt(entry.value)
+	return value
+}
+
+// Get key as a byte string.
+func (entry BTreeEntry) GetKeyBytes() []byte {
+	return entry.key
+}
+
+// Get value as a byte string.
+func (entry BTreeEntry) GetValueBytes() []byte {
 	return entry.value
 }
 
 // Set key.
-func (entry *BTreeEntry) SetKey(key int64) {
+func (entry *BTreeEntry) SetKey(key []byte) {
 	entry.key = key
 }
 
 // Set value.
-func (entry *BTreeEntry) SetValue(value int64) {
+func (entry *BTreeEntry) SetValue(value []byte) {
 	entry.value = value
 }
 
-// Marshal serializes a given entry into a byte array.
+// Marshal serializes a given entry into a leaf cell: the lengths of the
+// key and value, followed by the key and value themselves. The lowest bit
+// of the value's length is set if the value is an overflow reference.
 func (entry BTreeEntry) Marshal() []byte {
-	// Marshall the key field.
-	var newdata []byte
-	bin := make([]byte, binary.MaxVarintLen64)
-	binary.PutVarint(bin, entry.GetKey())
-	newdata = bin
-	// Marshall the value field.
-	bin = make([]byte, binary.MaxVarintLen64)
-	binary.PutVarint(bin, entry.GetValue())
-	newdata = append(newdata, bin...)
-	// Return the combined byte array.
-	return newdata
-}
-
-// unmarshalEntry deserializes a byte array into an entry.
+	valueLen := uint64(len(entry.value)) << 1
+	if entry.overflow {
+		valueLen |= 1
+	}
+	newdata := make([]byte, 0, leafCellSize(entry.key, entry.value))
+	newdata = appendUvarint(newdata, uint64(len(entry.key)))
+	newdata = appendUvarint(newdata, valueLen)
+	newdata = append(newdata, entry.key...)
+	return append(newdata, entry.value...)
+}
+
+// unmarshalEntry deserializes a leaf cell into an entry. The entry's key
+// and value are copies, so they stay valid once the page changes.
 func unmarshalEntry(data []byte) (entry BTreeEntry) {
-	k, _ := binary.Varint(data[:len(data)/2])
-	v, _ := binary.Varint(data[len(data)/2:])
-	return BTreeEntry{key: k, value: v}
+	keyLen, n := binary.Uvarint(data)
+	valueLen, m := binary.Uvarint(data[n:])
+	overflow := valueLen&1 == 1
+	valueLen >>= 1
+	start := int64(n + m)
+	key := append([]byte{}, data[start:start+int64(keyLen)]...)
+	value := append([]byte{}, data[start+int64(keyLen):start+int64(keyLen+valueLen)]...)
+	return BTreeEntry{key: key, value: value, overflow: overflow}
+}
+
+// leafCellSize returns the size of the leaf cell of an entry.
+func leafCellSize(key []byte, value []byte) int64 {
+	return uvarintSize(uint64(len(key))) + uvarintSize(uint64(len(value))<<1) + int64(len(key)+len(value))
+}
+
+// overflowRef returns a reference to a value of the given length stored in
+// overflow pages starting at the given page.
+func overflowRef(pagenum int64, size int64) []byte {
+	ref := make([]byte, OVERFLOW_REF_SIZE)
+	binary.BigEndian.PutUint64(ref[:8], uint64(pagenum))
+	binary.BigEndian.PutUint64(ref[8:], uint64(size))
+	return ref
+}
+
+// parseOverflowRef returns the first page and the length of the value an
+// overflow reference refers to.
+func parseOverflowRef(ref []byte) (pagenum int64, size int64) {
+	return int64(binary.BigEndian.Uint64(ref[:8])), int64(binary.BigEndian.Uint64(ref[8:]))
+}
+
+// load returns the entry with its value read from its overflow pages, if
+// it has any.
+func (entry BTreeEntry) load(p *pager.Pager) (BTreeEntry, error) {
+	if !entry.overflow {
+		return entry, nil
+	}
+	value, err := p.ReadOverflow(parseOverflowRef(entry.value))
+	if err != nil {
+		return BTreeEntry{}, err
+	}
+	return BTreeEntry{key: entry.key, value: value}, nil
+}
+
+// freeOverflow frees an overflow reference's pages.
+func freeOverflow(p *pager.Pager, ref []byte) error {
+	pagenum, _ := parseOverflowRef(ref)
+	return p.FreeOverflow(pagenum)
+}
+
+// appendUvarint appends a uvarint to a byte slice.
+func appendUvarint(data []byte, x uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, x)
+	return append(data, buf[:n]...)
+}
+
+// uvarintSize returns the number of bytes a uvarint takes.
+func uvarintSize(x uint64) int64 {
+	size := int64(1)
+	for ; x >= 0x80; x >>= 7 {
+		size++
+	}
+	return size
 }
@@ -0,0 +1,148 @@
+package btree
+
+import (
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// BTreeIterator streams the entries in a range of keys, reading one leaf at
+// a time through a cursor.
+type BTreeIterator struct {
+	table   *BTreeIndex
+	cursor  utils.Cursor
+	rng     utils.Range
+	started bool        // Whether the cursor has been read from yet.
+	count   int64       // Number of entries returned so far.
+	entry   utils.Entry // Current entry.
+	err     error
+}
+
+// TableRange returns an iterator over the entries in the given range.
+func (table *BTreeIndex) TableRange(rng utils.Range) (utils.Iterator, error) {
+	var cursor utils.Cursor
+	var err error
+	// Start at the bound the scan begins from, or at the edge of the table.
+	switch {
+	case !rng.Reverse && rng.Lo.Key != nil:
+		cursor, err = table.TableFindBytes(rng.Lo.Key)
+	case !rng.Reverse:
+		cursor, err = table.TableStart()
+	case rng.Hi.Key != nil:
+		cursor, err = table.TableFindBytes(rng.Hi.Key)
+	default:
+		cursor, err = table.TableEnd()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &BTreeIterator{table: table, cursor: cursor, rng: rng}, nil
+}
+
+// TablePrefix returns an iterator over the entries whose keys are tuples
+// that start with the given components, such as every key of one tenant in
+// a table keyed by tenant and id.
+func (table *BTreeIndex) TablePrefix(components ...[]byte) (utils.Iterator, error) {
+	return table.TableRange(utils.PrefixRange(components...))
+}
+
+// Next moves the iterator to the next entry in the range.
+func (it *BTreeIterator) Next() bool {
+	if it.cursor == nil {
+		return false
+	}
+	if it.rng.Limit > 0 && it.count >= it.rng.Limit {
+		it.Close()
+		return false
+	}
+	for {
+		if !it.step() {
+			it.Close()
+			return false
+		}
+		entry, err := it.cursor.GetEntry()
+		if err != nil {
+			it.err = err
+			it.Close()
+			return false
+		}
+		key := entry.GetKeyBytes()
+		// [CONCURRENCY] If the entry the cursor stepped back onto has since
+		// been deleted, the cursor moved on to the entry after it, which a
+		// reverse scan has already passed.
+		if it.rng.Reverse && it.entry != nil && it.table.cmp(key, it.entry.GetKeyBytes()) >= 0 {
+			continue
+		}
+		// Skip keys on the near side of the starting bound; stop at the
+		// first key past the other one.
+		lo, hi := it.aboveLo(key), it.belowHi(key)
+		if it.rng.Reverse {
+			lo, hi = hi, lo
+		}
+		if !lo {
+			continue
+		}
+		if !hi {
+			it.Close()
+			return false
+		}
+		it.entry = entry
+		it.count++
+		return true
+	}
+}
+
+// step moves the cursor to the next entry to look at. Returns false if
+// there are none.
+func (it *BTreeIterator) step() bool {
+	if !it.started {
+		it.started = true
+		// A reverse scan whose bound is past the last key starts past the
+		// end of the table, and steps back onto the last entry.
+		if !it.cursor.IsEnd() {
+			return true
+		}
+		return it.rng.Reverse && !it.cursor.StepBackward()
+	}
+	if it.rng.Reverse {
+		return !it.cursor.StepBackward()
+	}
+	return !it.cursor.StepForward()
+}
+
+// aboveLo returns true if a key isn't below the range's lower bound.
+func (it *BTreeIterator) aboveLo(key []byte) bool {
+	lo := it.rng.Lo
+	if lo.Key == nil {
+		return true
+	}
+	c := it.table.cmp(key, lo.Key)
+	return c > 0 || (c == 0 && lo.Inclusive)
+}
+
+// belowHi returns true if a key isn't above the range's upper bound.
+func (it *BTreeIterator) belowHi(key []byte) bool {
+	hi := it.rng.Hi
+	if hi.Key == nil {
+		return true
+	}
+	c := it.table.cmp(key, hi.Key)
+	return c < 0 || (c == 0 && hi.Inclusive)
+}
+
+// Entry returns the current entry.
+func (it *BTreeIterator) Entry() utils.Entry {
+	return it.entry
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *BTreeIterator) Err() error {
+	return it.err
+}
+
+// Close releases the iterator's cursor. Iterators close themselves once
+// they run out of entries.
+func (it *BTreeIterator) Close() {
+	if it.cursor != nil {
+		it.cursor.Close()
+		it.cursor = nil
+	}
+}
@@ -0,0 +1,204 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// A MultiIndex is a B+tree that allows duplicate keys. Each entry is
+// stored under a tuple of its key and a row id that tells apart the
+// entries with the same key, such as the key of the row in the table being
+// indexed. Tuples sort by component, so the entries of one key sort
+// together, in row id order. They share the start of their tuples as the
+// prefix of the nodes they fill, so a run of duplicates is stored much
+// like a posting list.
+
+// compositeKey returns the composite key of a key and a row id.
+func compositeKey(key []byte, rowid []byte) []byte {
+	return utils.EncodeTuple(key, rowid)
+}
+
+// keyBound returns the composite key that every composite key of the given
+// key sorts after, or, if after is set, before.
+func keyBound(key []byte, after bool) []byte {
+	if after {
+		return utils.EncodeTuplePrefixEnd(key)
+	}
+	return utils.EncodeTuple(key)
+}
+
+// splitCompositeKey returns the key and row id in a composite key.
+func splitCompositeKey(composite []byte) (key []byte, rowid []byte, err error) {
+	components, ok := utils.DecodeTuple(composite)
+	if !ok || len(components) != 2 {
+		return nil, nil, errors.New("invalid composite key")
+	}
+	return components[0], components[1], nil
+}
+
+// MultiIndex is a table of entries whose keys needn't be unique.
+type MultiIndex struct {
+	table *BTreeIndex // Holds an entry with an empty value under each composite key.
+}
+
+// OpenMultiTable returns a table that allows duplicate keys, associated
+// with the given database filename. Options are passed on to the table's
+// pager.
+func OpenMultiTable(filename string, opts ...pager.Option) (*MultiIndex, error) {
+	table, err := OpenTableWithComparator(filename, bytes.Compare, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiIndex{table: table}, nil
+}
+
+// Get this index's filename.
+func (index *MultiIndex) GetName() string {
+	return index.table.GetName()
+}
+
+// Get this index's pager.
+func (index *MultiIndex) GetPager() *pager.Pager {
+	return index.table.GetPager()
+}
+
+// GetTable returns the B+tree that holds the index's composite keys.
+func (index *MultiIndex) GetTable() *BTreeIndex {
+	return index.table
+}
+
+// Analyze returns statistics on the structure of the index's B+tree.
+func (index *MultiIndex) Analyze() (utils.IndexStats, error) {
+	return index.table.Analyze()
+}
+
+// Close flushes all changes to disk.
+func (index *MultiIndex) Close() error {
+	return index.table.Close()
+}
+
+// Insert adds an entry with the given key and row id. A key may have any
+// number of entries, but each must have a different row id.
+func (index *MultiIndex) Insert(key []byte, rowid []byte) error {
+	return index.table.InsertBytes(compositeKey(key, rowid), nil)
+}
+
+// Delete removes the entry with the given key and row id.
+func (index *MultiIndex) Delete(key []byte, rowid []byte) error {
+	return index.table.DeleteBytes(compositeKey(key, rowid))
+}
+
+// Find returns an iterator over the entries with the given key, in row id
+// order. Each entry's value is its row id.
+func (index *MultiIndex) Find(key []byte) (utils.Iterator, error) {
+	return index.TableRange(utils.Range{
+		Lo: utils.Bound{Key: key, Inclusive: true},
+		Hi: utils.Bound{Key: key, Inclusive: true},
+	})
+}
+
+// TableRange returns an iterator over the entries whose keys are in the
+// given range. Each entry's value is its row id.
+func (index *MultiIndex) TableRange(rng utils.Range) (utils.Iterator, error) {
+	// Map the bounds onto composite keys: a bound sits just before or just
+	// after every composite key of its key.
+	if rng.Lo.Key != nil {
+		rng.Lo = utils.Bound{Key: keyBound(rng.Lo.Key, !rng.Lo.Inclusive), Inclusive: true}
+	}
+	if rng.Hi.Key != nil {
+		rng.Hi = utils.Bound{Key: keyBound(rng.Hi.Key, rng.Hi.Inclusive), Inclusive: false}
+	}
+	it, err := index.table.TableRange(rng)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiIterator{it: it}, nil
+}
+
+// Select returns a slice of all entries in the index, in key order.
+func (index *MultiIndex) Select() ([]utils.Entry, error) {
+	it, err := index.TableRange(utils.Range{})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	entries := make([]utils.Entry, 0)
+	for it.Next() {
+		entries = append(entries, it.Entry())
+	}
+	return entries, it.Err()
+}
+
+// BulkLoad fills an empty index with the given entries, whose values are
+// their row ids, in any order; see BTreeIndex.BulkLoad.
+func (index *MultiIndex) BulkLoad(entries utils.Iterator, fillFactor float64) error {
+	sorted, err := index.table.SortEntries(&compositeIterator{it: entries})
+	if err != nil {
+		return err
+	}
+	return index.table.BulkLoad(sorted, fillFactor)
+}
+
+// MultiIterator yields the entries of a MultiIndex, with their composite
+// keys split into keys and row ids.
+type MultiIterator struct {
+	it    utils.Iterator
+	entry utils.Entry
+	err   error
+}
+
+// Next moves the iterator to the next entry.
+func (it *MultiIterator) Next() bool {
+	if it.err != nil || !it.it.Next() {
+		return false
+	}
+	key, rowid, err := splitCompositeKey(it.it.Entry().GetKeyBytes())
+	if err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	it.entry = BTreeEntry{key: key, value: rowid}
+	return true
+}
+
+// Entry returns the current entry.
+func (it *MultiIterator) Entry() utils.Entry {
+	return it.entry
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *MultiIterator) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.it.Err()
+}
+
+// Close releases the iterator's cursor.
+func (it *MultiIterator) Close() {
+	it.it.Close()
+}
+
+// compositeIterator turns entries whose values are row ids into entries
+// under their composite keys.
+type compositeIterator struct {
+	it    utils.Iterator
+	entry utils.Entry
+}
+
+func (it *compositeIterator) Next() bool {
+	if !it.it.Next() {
+		return false
+	}
+	entry := it.it.Entry()
+	it.entry = BTreeEntry{key: compositeKey(entry.GetKeyBytes(), entry.GetValueBytes())}
+	return true
+}
+
+func (it *compositeIterator) Entry() utils.Entry { return it.entry }
+func (it *compositeIterator) Err() error         { return it.it.Err() }
+func (it *compositeIterator) Close()             { it.it.Close() }
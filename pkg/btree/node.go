@@ -8,30 +8,42 @@ import (
 	"strconv"
 
 	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 // Split is a supporting data structure to propagate keys up our B+ tree.
 type Split struct {
-	isSplit bool  // A flag that's set if a split occurs.
-	key     int64 // The key to promote.
-	leftPN  int64 // The pagenumber for the left node.
-	rightPN int64 // The pagenumber for the right node.
-	err     error // Used to propagate errors upwards.
+	isSplit    bool   // A flag that's set if a split occurs.
+	key        []byte // The key to promote.
+	leftPN     int64  // The pagenumber for the left node.
+	rightPN    int64  // The pagenumber for the right node.
+	underflows bool   // A flag that's set if the node was left underfull.
+	freed      []byte // Overflow reference of a deleted or replaced value, whose pages are to be freed.
+	err        error  // Used to propagate errors upwards.
 }
 
 // Node defines a common interface for leaf and internal nodes.
 type Node interface {
 	// Interface for main node functions.
-	search(int64) int64
-	insert(int64, int64, bool) Split
-	delete(int64)
-	get(int64) (int64, bool)
+	search([]byte) int64
+	refill([]byte) Split
 
 	// Interface for helper functions.
-	keyToNodeEntry(int64) (*LeafNode, int64, error)
+	underflows() bool
 	printNode(io.Writer, string, string)
 	getPage() *pager.Page
 	getNodeType() NodeType
+	header() *NodeHeader
+}
+
+// [CONCURRENCY] Node methods don't lock anything but the siblings whose
+// links they fix up. Inserts, updates and deletes run on a leaf that the
+// caller has locked, and hand splits up to the caller; see blink.go.
+// Underfull nodes are refilled while the table is held exclusively.
+
+// insertCellAt returns the cells with the given cell inserted at the index.
+func insertCellAt(cells [][]byte, index int64, cell []byte) [][]byte {
+	return append(cells[:index], append([][]byte{cell}, cells[index:]...)...)
 }
 
 /////////////////////////////////////////////////////////////////////////////
@@ -40,13 +52,13 @@ type Node interface {
 
 // search returns the first index where key >= given key.
 // If no key satisfies this condition, returns numKeys.
-func (node *LeafNode) search(key int64) int64 {
+func (node *LeafNode) search(key []byte) int64 {
 	/* SOLUTION {{{ */
 	// Binary search for the key.
 	minIndex := sort.Search(
 		int(node.numKeys),
 		func(idx int) bool {
-			return node.getKeyAt(int64(idx)) >= key
+			return node.compareKeyAt(int64(idx), key) >= 0
 		},
 	)
 	return int64(minIndex)
@@ -54,86 +66,99 @@ func (node *LeafNode) search(key int64) int64 {
 }
 
 // insert finds the appropriate place in a leaf node to insert a new tuple.
-// if update is true, allow overwriting existing keys. else, error.
-func (node *LeafNode) insert(key int64, value int64, update bool) Split {
+func (node *LeafNode) insert(entry BTreeEntry) Split {
 	/* SOLUTION {{{ */
-	node.unlockParent(false)
-	defer node.unlock()
 	// Get insert position.
+	key := entry.key
 	insertPos := node.search(key)
 	// Check if this is a duplicate entry.
-	if insertPos < node.numKeys && node.getKeyAt(insertPos) == key {
-		defer node.unlockParent(true)
-		if update {
-			node.updateValueAt(insertPos, value)
-			return Split{}
-		} else {
-			return Split{err: errors.New("cannot insert duplicate key")}
-		}
+	if insertPos < node.numKeys && node.compareKeyAt(insertPos, key) == 0 {
+		return Split{err: errors.New("cannot insert duplicate key")}
+	}
+	// Insert the entry at this position, or split the node if it doesn't fit.
+	cell := entry.Marshal()
+	if !node.insertCell(insertPos, cell) {
+		return node.split(insertCellAt(node.getCells(), insertPos, cell))
 	}
-	// Return an error if we're updating a non-existent entry.
-	if update {
-		node.unlockParent(true)
+	return Split{}
+	/* SOLUTION }}} */
+}
+
+// update replaces the value of an existing key in the leaf node. The new
+// entry may not fit, splitting the node, or may leave it underfull.
+func (node *LeafNode) update(entry BTreeEntry) Split {
+	updatePos := node.search(entry.key)
+	if updatePos >= node.numKeys || node.compareKeyAt(updatePos, entry.key) != 0 {
 		return Split{err: errors.New("cannot update non-existent entry")}
 	}
-	// Shift entries to the right if needed.
-	for i := node.numKeys - 1; i >= insertPos; i-- {
-		node.updateKeyAt(i+1, node.getKeyAt(i))
-		node.updateValueAt(i+1, node.getValueAt(i))
+	var freed []byte
+	if old := unmarshalEntry(node.getCell(updatePos)); old.overflow {
+		freed = old.value
 	}
-	node.updateNumKeys(node.numKeys + 1)
-	// Modify the Entry at this position.
-	node.modifyEntry(insertPos, BTreeEntry{key: key, value: value})
-	// Check if we need to split the node.
-	if node.numKeys > ENTRIES_PER_LEAF_NODE {
-		return node.split()
+	cell := entry.Marshal()
+	if node.freeSpace()+int64(len(node.getCell(updatePos))) < int64(len(cell)) {
+		cells := node.getCells()
+		cells[updatePos] = cell
+		split := node.split(cells)
+		if split.err == nil {
+			split.freed = freed
+		}
+		return split
 	}
-	node.unlockParent(true)
-	return Split{}
-	/* SOLUTION }}} */
+	node.removeCell(updatePos)
+	node.insertCell(updatePos, cell)
+	return Split{underflows: node.underflows(), freed: freed}
 }
 
 // delete removes a given tuple from the leaf node, if the given key exists.
-func (node *LeafNode) delete(key int64) {
+// The node may be left underfull.
+func (node *LeafNode) delete(key []byte) Split {
 	// Find entry.
-	node.unlockParent(true)
-	defer node.unlock()
 	deletePos := node.search(key)
-	if deletePos >= node.numKeys || node.getKeyAt(deletePos) != key {
+	if deletePos >= node.numKeys || node.compareKeyAt(deletePos, key) != 0 {
 		// Thank you Mario! But our key is in another castle!
-		return
+		return Split{}
 	}
-	// Shift entries to the left.
-	for i := deletePos; i < node.numKeys-1; i++ {
-		node.updateKeyAt(i, node.getKeyAt(i+1))
-		node.updateValueAt(i, node.getValueAt(i+1))
+	var freed []byte
+	if old := unmarshalEntry(node.getCell(deletePos)); old.overflow {
+		freed = old.value
 	}
-	node.updateNumKeys(node.numKeys - 1)
+	node.removeCell(deletePos)
+	return Split{underflows: node.underflows(), freed: freed}
 }
 
-// split is a helper function to split a leaf node, then propagate the split upwards.
-func (node *LeafNode) split() Split {
+// split is a helper function that divides the given cells, which don't fit
+// in the leaf node, between it and a new leaf node to its right, then
+// propagates the split upwards. The new node isn't reachable until the
+// locked node links to it, so it needn't be locked.
+func (node *LeafNode) split(cells [][]byte) Split {
 	/* SOLUTION {{{ */
+	// Get the right sibling, whose left sibling changes.
+	next, err := getAndLockLeaf(node.page.GetPager(), node.rightSiblingPN, node.cmp)
+	if err != nil {
+		return Split{err: err}
+	}
+	defer releaseLeaf(next)
 	// Create a new leaf node to split our keys.
-	newNode, err := createLeafNode(node.page.GetPager())
+	newNode, err := createLeafNode(node.page.GetPager(), node.cmp)
 	if err != nil {
 		return Split{err: err}
 	}
 	defer newNode.getPage().Put()
-	// Set the right sibling for our two nodes.
-	prevSiblingPN := node.setRightSibling(newNode.page.GetPageNum())
-	newNode.setRightSibling(prevSiblingPN)
-	// Transfer entries to the new node (plus the new entry) accordingly.
-	midpoint := node.numKeys / 2
-	for i := midpoint; i < node.numKeys; i++ {
-		newNode.updateKeyAt(newNode.numKeys, node.getKeyAt(i))
-		newNode.updateValueAt(newNode.numKeys, node.getValueAt(i))
-		newNode.updateNumKeys(newNode.numKeys + 1)
-	}
-	node.updateNumKeys(midpoint)
+	// Transfer the second half of the entries to the new node, which takes
+	// over our right sibling and high key. Its first key becomes ours.
+	highKey := node.highKey()
+	midpoint := node.layout().splitPoint(cells, highKey, false)
+	newNode.setLeftSibling(node.page.GetPageNum())
+	newNode.setCellsAndLink(cells[midpoint:], node.rightSiblingPN, highKey)
+	separator := newNode.getKeyAt(0)
+	node.setCellsAndLink(cells[:midpoint], newNode.page.GetPageNum(), separator)
+	if next != nil {
+		next.setLeftSibling(newNode.page.GetPageNum())
+	}
 	return Split{
 		isSplit: true,
-		key:     newNode.getKeyAt(0), // Get the right node's first key
+		key:     separator,
 		leftPN:  node.page.GetPageNum(),
 		rightPN: newNode.page.GetPageNum(),
 	}
@@ -141,23 +166,24 @@ func (node *LeafNode) split() Split {
 }
 
 // get returns the value associated with a given key from the leaf node.
-func (node *LeafNode) get(key int64) (value int64, found bool) {
-	// Unlock parents, eventually unlock this node.
-	node.unlockParent(true)
-	defer node.unlock()
+// Fails if the value's overflow pages can't be read.
+func (node *LeafNode) get(key []byte) (value []byte, found bool, err error) {
 	// Find index.
 	index := node.search(key)
-	if index >= node.numKeys || node.getKeyAt(index) != key {
+	if index >= node.numKeys || node.compareKeyAt(index, key) != 0 {
 		// Thank you Mario! But our key is in another castle!
-		return 0, false
+		return nil, false, nil
 	}
-	entry := node.getEntry(index)
-	return entry.GetValue(), true
+	entry, err := node.getEntry(index)
+	if err != nil {
+		return nil, false, err
+	}
+	return entry.GetValueBytes(), true, nil
 }
 
-// keyToNodeEntry is a helper function to create cursors that point to a given index within a leaf node.
-func (node *LeafNode) keyToNodeEntry(key int64) (*LeafNode, int64, error) {
-	return node, node.search(key), nil
+// refill reports whether the leaf node is underfull. Its parent refills it.
+func (node *LeafNode) refill(key []byte) Split {
+	return Split{underflows: node.underflows()}
 }
 
 // printNode pretty prints our leaf node.
@@ -174,9 +200,13 @@ func (node *LeafNode) printNode(w io.Writer, firstPrefix string, prefix string)
 		firstPrefix, node.page.GetPageNum(), nodeType, isRoot, numKeys))
 	// Print entries.
 	for Entrynum := int64(0); Entrynum < node.numKeys; Entrynum++ {
-		entry := node.getEntry(Entrynum)
+		entry, err := node.getEntry(Entrynum)
+		if err != nil {
+			io.WriteString(w, fmt.Sprintf("%v |--> %v\n", prefix, err))
+			continue
+		}
 		io.WriteString(w, fmt.Sprintf("%v |--> (%v, %v)\n",
-			prefix, entry.GetKey(), entry.GetValue()))
+			prefix, utils.FormatBytes(entry.GetKeyBytes()), utils.FormatBytes(entry.GetValueBytes())))
 	}
 	if node.rightSiblingPN > 0 {
 		io.WriteString(w, fmt.Sprintf("%v |--+\n", prefix))
@@ -192,143 +222,192 @@ func (node *LeafNode) printNode(w io.Writer, firstPrefix string, prefix string)
 
 // search returns the first index where key > given key.
 // If no such index exists, it returns numKeys.
-func (node *InternalNode) search(key int64) int64 {
+func (node *InternalNode) search(key []byte) int64 {
 	/* SOLUTION {{{ */
 	// Binary search for the key.
 	minIndex := sort.Search(
 		int(node.numKeys),
 		func(idx int) bool {
-			return node.getKeyAt(int64(idx)) > key
+			return node.compareKeyAt(int64(idx), key) > 0
 		},
 	)
 	return int64(minIndex)
 	/* SOLUTION }}} */
 }
 
-// insert finds the appropriate place in a leaf node to insert a new tuple.
-func (node *InternalNode) insert(key int64, value int64, update bool) Split {
+// insertSplit inserts a split result into an internal node.
+// If this insertion results in another split, the split is cascaded upwards.
+func (node *InternalNode) insertSplit(split Split) Split {
 	/* SOLUTION {{{ */
-	// Insert the entry into the appropriate child node.
-	node.unlockParent(false)
+	insertPos := node.search(split.key)
+	// Insert the new key and pagenumber at this position.
+	cell := internalCell(split.key, split.rightPN)
+	// Split if it doesn't fit.
+	if !node.insertCell(insertPos, cell) {
+		return node.split(insertCellAt(node.getCells(), insertPos, cell))
+	}
+	return Split{}
+	/* SOLUTION }}} */
+}
+
+// refill rebalances the underfull nodes on the path from the internal
+// node to the leaf that the given key belongs to, from the bottom up.
+// Refilling a child may split the node, or leave it underfull in turn.
+func (node *InternalNode) refill(key []byte) Split {
 	childIdx := node.search(key)
-	child, err := node.getAndLockChildAt(childIdx)
+	child, err := node.getChildAt(childIdx)
 	if err != nil {
 		return Split{err: err}
 	}
-	node.initChild(child)
 	defer child.getPage().Put()
-	// Insert value into the child.
-	result := child.insert(key, value, update)
-	// Insert a new key into our node if necessary.
-	if result.isSplit {
-		split := node.insertSplit(result)
-		defer node.unlock()
-		if !split.isSplit {
-			node.unlockParent(true)
-		}
+	result := child.refill(key)
+	var split Split
+	switch {
+	case result.err != nil:
+		return result
+	case result.isSplit:
+		split = node.insertSplit(result)
+	case result.underflows:
+		split = node.rebalance(childIdx, child)
+	}
+	if split.err != nil || split.isSplit {
 		return split
 	}
-	return Split{err: result.err}
-	/* SOLUTION }}} */
+	return Split{underflows: node.underflows()}
 }
 
-// insertSplit inserts a split result into an internal node.
-// If this insertion results in another split, the split is cascaded upwards.
-func (node *InternalNode) insertSplit(split Split) Split {
-	/* SOLUTION {{{ */
-	insertPos := node.search(split.key)
-	// Shift keys to the right.
-	for i := node.numKeys - 1; i >= insertPos; i-- {
-		node.updateKeyAt(i+1, node.getKeyAt(i))
+// rebalance refills the underfull child at the given index with entries
+// from a sibling, or merges the two if they fit in one node. Changing the
+// key that separates them may split this node.
+func (node *InternalNode) rebalance(childIdx int64, child Node) Split {
+	// Pair the child with its left sibling, or its right one if it has none.
+	siblingIdx, keyIdx := childIdx-1, childIdx-1
+	if childIdx == 0 {
+		siblingIdx, keyIdx = 1, 0
 	}
-	// Shift children to the right.
-	for i := node.numKeys; i > insertPos; i-- {
-		node.updatePNAt(i+1, node.getPNAt(i))
+	sibling, err := node.getChildAt(siblingIdx)
+	if err != nil {
+		return Split{err: err}
 	}
-	// Insert the new key and pagenumber at this position.
-	node.updateKeyAt(insertPos, split.key)
-	node.updatePNAt(insertPos+1, split.rightPN)
-	node.updateNumKeys(node.numKeys + 1)
-	// Check if we need to split.
-	if node.numKeys > KEYS_PER_INTERNAL_NODE {
-		return node.split()
+	defer sibling.getPage().Put()
+	left, right := sibling, child
+	if childIdx == 0 {
+		left, right = child, sibling
+	}
+	var separator []byte
+	var merged bool
+	switch left := left.(type) {
+	case *LeafNode:
+		separator, merged, err = rebalanceLeaves(left, right.(*LeafNode))
+		if err != nil {
+			return Split{err: err}
+		}
+	case *InternalNode:
+		separator, merged = rebalanceInternals(left, right.(*InternalNode), node.getKeyAt(keyIdx))
+	}
+	if !merged {
+		return node.replaceKeyAt(keyIdx, separator)
+	}
+	// The right node is now empty and unreachable.
+	node.removeCell(keyIdx)
+	if err := node.page.GetPager().FreePage(right.getPage().GetPageNum()); err != nil {
+		return Split{err: err}
 	}
 	return Split{}
-	/* SOLUTION }}} */
 }
 
-// delete removes a given tuple from the leaf node, if the given key exists.
-func (node *InternalNode) delete(key int64) {
-	// Get child.
-	node.unlockParent(true)
-	childIdx := node.search(key)
-	child, err := node.getAndLockChildAt(childIdx)
-	if err != nil {
-		return
+// rebalanceLeaves merges two adjacent leaves, one of which is underfull,
+// into the left one if they fit, or else evens out their entries. Returns
+// the right leaf's new first key, which is the left leaf's high key, if
+// they weren't merged.
+func rebalanceLeaves(left *LeafNode, right *LeafNode) (separator []byte, merged bool, err error) {
+	cells := append(left.getCells(), right.getCells()...)
+	highKey := right.highKey()
+	layout := left.layout()
+	if layout.fits(layout.withHighKey(cells, highKey)) {
+		// Unlink the right leaf from its right sibling too.
+		next, err := getAndLockLeaf(left.page.GetPager(), right.rightSiblingPN, left.cmp)
+		if err != nil {
+			return nil, false, err
+		}
+		defer releaseLeaf(next)
+		left.setCellsAndLink(cells, right.rightSiblingPN, highKey)
+		if next != nil {
+			next.setLeftSibling(left.page.GetPageNum())
+		}
+		return nil, true, nil
 	}
-	node.initChild(child)
-	defer child.getPage().Put()
-	// Delete from child.
-	child.delete(key)
+	midpoint := layout.splitPoint(cells, highKey, false)
+	separator = layout.cellKey(cells[midpoint])
+	left.setCellsAndLink(cells[:midpoint], right.page.GetPageNum(), separator)
+	right.setCells(cells[midpoint:])
+	return separator, false, nil
+}
+
+// rebalanceInternals merges two adjacent internal nodes, one of which is
+// underfull, into the left one if they fit along with the separator
+// between them, or else evens out their keys by rotating them through the
+// separator. Returns the new separator if they weren't merged.
+func rebalanceInternals(left *InternalNode, right *InternalNode, separator []byte) ([]byte, bool) {
+	cells := append(left.getCells(), internalCell(separator, right.getPNAt(0)))
+	cells = append(cells, right.getCells()...)
+	highKey := right.highKey()
+	layout := left.layout()
+	if layout.fits(layout.withHighKey(cells, highKey)) {
+		left.setCellsAndLink(cells, right.rightSiblingPN, highKey)
+		return nil, true
+	}
+	midpoint := layout.splitPoint(cells, highKey, true)
+	separator = append([]byte{}, internalCellKey(cells[midpoint])...)
+	left.setCellsAndLink(cells[:midpoint], right.page.GetPageNum(), separator)
+	right.updateLeftmostPN(internalCellPN(cells[midpoint]))
+	right.setCells(cells[midpoint+1:])
+	return separator, false
 }
 
-// split is a helper function that splits an internal node, then propagates the split upwards.
-func (node *InternalNode) split() Split {
+// replaceKeyAt replaces the key at the given index, keeping the child to
+// its right. If the new key doesn't fit, the node is split.
+func (node *InternalNode) replaceKeyAt(index int64, key []byte) Split {
+	cells := node.getCells()
+	cells[index] = internalCell(key, node.getPNAt(index+1))
+	if !node.fits(cells) {
+		return node.split(cells)
+	}
+	node.setCells(cells)
+	return Split{}
+}
+
+// split is a helper function that divides the given cells, which don't fit
+// in the internal node, between it and a new internal node to its right,
+// promoting the key in between, then propagates the split upwards.
+func (node *InternalNode) split(cells [][]byte) Split {
 	/* SOLUTION {{{ */
 	// Create a new internal node to split our keys.
-	newNode, err := createInternalNode(node.page.GetPager())
+	newNode, err := createInternalNode(node.page.GetPager(), node.cmp, node.level)
 	if err != nil {
 		return Split{err: err}
 	}
 	defer newNode.getPage().Put()
-	// Compute the midpoint based on the number of children to move.
-	midpoint := (node.numKeys - 1) / 2
-	// Transfer the keys to the new node.
-	for i := midpoint; i <= node.numKeys; i++ {
-		newNode.updatePNAt(newNode.numKeys, node.getPNAt(i))
-		if i < node.numKeys {
-			newNode.updateKeyAt(newNode.numKeys, node.getKeyAt(i))
-			newNode.updateNumKeys(newNode.numKeys + 1)
-		}
-	}
-	middleKey := node.getKeyAt(midpoint - 1)
-	node.updateNumKeys(midpoint - 1)
+	// Compute the midpoint based on the size of the cells on either side.
+	highKey := node.highKey()
+	midpoint := node.layout().splitPoint(cells, highKey, true)
+	promoted := append([]byte{}, internalCellKey(cells[midpoint])...)
+	// Transfer the keys after the midpoint to the new node, along with our
+	// right sibling and high key. The midpoint's child becomes the new
+	// node's leftmost child, and its key our high key.
+	newNode.updateLeftmostPN(internalCellPN(cells[midpoint]))
+	newNode.setCellsAndLink(cells[midpoint+1:], node.rightSiblingPN, highKey)
+	node.setCellsAndLink(cells[:midpoint], newNode.page.GetPageNum(), promoted)
 	// Propagate the split.
 	return Split{
 		isSplit: true,
-		key:     middleKey,
+		key:     promoted,
 		leftPN:  node.page.GetPageNum(),
 		rightPN: newNode.page.GetPageNum(),
 	}
 	/* SOLUTION }}} */
 }
 
-// get returns the value associated with a given key from the leaf node.
-func (node *InternalNode) get(key int64) (value int64, found bool) {
-	// [CONCURRENCY] Unlock parents.
-	node.unlockParent(true)
-	// Find the child.
-	childIdx := node.search(key)
-	child, err := node.getAndLockChildAt(childIdx)
-	if err != nil {
-		return 0, false
-	}
-	node.initChild(child)
-	defer child.getPage().Put()
-	return child.get(key)
-}
-
-// keyToNodeEntry is a helper function to create cursors that point to a given index within a leaf node.
-func (node *InternalNode) keyToNodeEntry(key int64) (n *LeafNode, idx int64, err error) {
-	index := node.search(key)
-	child, err := node.getChildAt(index)
-	if err != nil {
-		return &LeafNode{}, 0, err
-	}
-	defer child.getPage().Put()
-	return child.keyToNodeEntry(key)
-}
-
 // printNode pretty prints our internal node.
 func (node *InternalNode) printNode(w io.Writer, firstPrefix string, prefix string) {
 	// Format header data.
@@ -353,7 +432,7 @@ func (node *InternalNode) printNode(w io.Writer, firstPrefix string, prefix stri
 		defer child.getPage().Put()
 		child.printNode(w, nextFirstPrefix, nextPrefix)
 		if idx != node.numKeys {
-			io.WriteString(w, fmt.Sprintf("\n%v[KEY] %v\n", nextPrefix, node.getKeyAt(idx)))
+			io.WriteString(w, fmt.Sprintf("\n%v[KEY] %v\n", nextPrefix, utils.FormatBytes(node.getKeyAt(idx))))
 		}
 	}
-}
\ No newline at end of file
+}
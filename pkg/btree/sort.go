@@ -0,0 +1,220 @@
+package btree
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+	"sort"
+
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// Bytes of keys and values sorted in memory at a time by SortEntries.
+// Larger inputs are sorted in runs, which are written to temporary files
+// and merged.
+var SORT_RUN_SIZE int64 = 64 << 20
+
+// entrySource yields entries in key order.
+type entrySource interface {
+	next() (BTreeEntry, bool, error)
+}
+
+// memoryRun yields the entries of a run sorted in memory.
+type memoryRun struct {
+	entries []BTreeEntry
+}
+
+func (run *memoryRun) next() (BTreeEntry, bool, error) {
+	if len(run.entries) == 0 {
+		return BTreeEntry{}, false, nil
+	}
+	entry := run.entries[0]
+	run.entries = run.entries[1:]
+	return entry, true, nil
+}
+
+// fileRun yields the entries of a run written to a temporary file, each as
+// the lengths of its key and value followed by the key and value.
+type fileRun struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+func (run *fileRun) next() (BTreeEntry, bool, error) {
+	keyLen, err := binary.ReadUvarint(run.reader)
+	if err == io.EOF {
+		return BTreeEntry{}, false, nil
+	} else if err != nil {
+		return BTreeEntry{}, false, err
+	}
+	valueLen, err := binary.ReadUvarint(run.reader)
+	if err != nil {
+		return BTreeEntry{}, false, err
+	}
+	data := make([]byte, keyLen+valueLen)
+	if _, err := io.ReadFull(run.reader, data); err != nil {
+		return BTreeEntry{}, false, err
+	}
+	return BTreeEntry{key: data[:keyLen], value: data[keyLen:]}, true, nil
+}
+
+// writeRun writes sorted entries to a temporary file, and returns the file
+// rewound to be read back.
+func writeRun(entries []BTreeEntry) (*fileRun, error) {
+	file, err := os.CreateTemp("", "bumble-sort-*")
+	if err != nil {
+		return nil, err
+	}
+	// Unlink the file right away, so it goes away however the sort ends.
+	os.Remove(file.Name())
+	writer := bufio.NewWriter(file)
+	for _, entry := range entries {
+		data := appendUvarint(nil, uint64(len(entry.key)))
+		data = appendUvarint(data, uint64(len(entry.value)))
+		data = append(append(data, entry.key...), entry.value...)
+		if _, err := writer.Write(data); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &fileRun{file: file, reader: bufio.NewReader(file)}, nil
+}
+
+// mergeHead is the next entry of one of the runs being merged.
+type mergeHead struct {
+	entry  BTreeEntry
+	source entrySource
+}
+
+// mergeHeap orders the runs being merged by their next entries.
+type mergeHeap struct {
+	heads []mergeHead
+	cmp   Comparator
+}
+
+func (h *mergeHeap) Len() int { return len(h.heads) }
+func (h *mergeHeap) Less(i, j int) bool {
+	return h.cmp(h.heads[i].entry.key, h.heads[j].entry.key) < 0
+}
+func (h *mergeHeap) Swap(i, j int)      { h.heads[i], h.heads[j] = h.heads[j], h.heads[i] }
+func (h *mergeHeap) Push(x interface{}) { h.heads = append(h.heads, x.(mergeHead)) }
+func (h *mergeHeap) Pop() interface{} {
+	head := h.heads[len(h.heads)-1]
+	h.heads = h.heads[:len(h.heads)-1]
+	return head
+}
+
+// SortedIterator yields the entries of sorted runs in key order, merging
+// the runs as it goes.
+type SortedIterator struct {
+	heap  *mergeHeap
+	files []*os.File
+	entry utils.Entry
+	err   error
+}
+
+// SortEntries returns an iterator over the given entries in the table's key
+// order.
+func (table *BTreeIndex) SortEntries(entries utils.Iterator) (utils.Iterator, error) {
+	defer entries.Close()
+	it := &SortedIterator{heap: &mergeHeap{cmp: table.cmp}}
+	sources := make([]entrySource, 0)
+	run := make([]BTreeEntry, 0)
+	var runSize int64
+	sortRun := func() {
+		sort.SliceStable(run, func(i, j int) bool {
+			return table.cmp(run[i].key, run[j].key) < 0
+		})
+	}
+	for entries.Next() {
+		entry := entries.Entry()
+		key := append([]byte{}, entry.GetKeyBytes()...)
+		value := append([]byte{}, entry.GetValueBytes()...)
+		run = append(run, BTreeEntry{key: key, value: value})
+		runSize += int64(len(key) + len(value))
+		// Spill the run once it is large enough.
+		if runSize >= SORT_RUN_SIZE {
+			sortRun()
+			fr, err := writeRun(run)
+			if err != nil {
+				it.Close()
+				return nil, err
+			}
+			it.files = append(it.files, fr.file)
+			sources = append(sources, fr)
+			run, runSize = make([]BTreeEntry, 0), 0
+		}
+	}
+	if err := entries.Err(); err != nil {
+		it.Close()
+		return nil, err
+	}
+	sortRun()
+	sources = append(sources, &memoryRun{entries: run})
+	// Start the merge with the first entry of each run.
+	for _, source := range sources {
+		entry, ok, err := source.next()
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		if ok {
+			it.heap.heads = append(it.heap.heads, mergeHead{entry, source})
+		}
+	}
+	heap.Init(it.heap)
+	return it, nil
+}
+
+// Next moves the iterator to the next entry.
+func (it *SortedIterator) Next() bool {
+	if it.err != nil || it.heap.Len() == 0 {
+		it.Close()
+		return false
+	}
+	head := &it.heap.heads[0]
+	it.entry = head.entry
+	// Replace the entry with the next one from its run.
+	entry, ok, err := head.source.next()
+	if err != nil {
+		it.err = err
+		it.Close()
+		return false
+	}
+	if ok {
+		head.entry = entry
+		heap.Fix(it.heap, 0)
+	} else {
+		heap.Pop(it.heap)
+	}
+	return true
+}
+
+// Entry returns the current entry.
+func (it *SortedIterator) Entry() utils.Entry {
+	return it.entry
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *SortedIterator) Err() error {
+	return it.err
+}
+
+// Close closes the iterator's temporary files.
+func (it *SortedIterator) Close() {
+	for _, file := range it.files {
+		file.Close()
+	}
+	it.files = nil
+	it.heap.heads = nil
+}
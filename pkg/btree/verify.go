@@ -4,34 +4,101 @@ import (
 	"errors"
 )
 
-func IsBTree(index *BTreeIndex) (l int64, r int64, isbtree bool, err error) {
+// IsBTree checks that the keys of every node are in order and within the
+// bounds set by its parent, and that no node other than the root is less
+// than half full. In the B-link format, it also checks that each node is
+// one level above its children, that the children of a node link to each
+// other, and that each node's high key is the separator after it in its
+// parent. Returns the smallest and largest keys in the tree.
+func IsBTree(index *BTreeIndex) (l []byte, r []byte, isbtree bool, err error) {
 	// Get the node from the page
 	rootPage, err := index.pager.GetPage(index.rootPN)
 	if err != nil {
-		return 0, 0, false, err
+		return nil, nil, false, err
+	}
+	defer rootPage.Put()
+	n := pageToNode(rootPage, index.cmp)
+	l, r, isbtree, err = isBTree(n, index.cmp, nil)
+	if err != nil || !isbtree {
+		return l, r, isbtree, err
+	}
+	linked, err := siblingsLinked(index)
+	return l, r, linked, err
+}
+
+// siblingsLinked checks that each leaf's left sibling is the leaf whose
+// right sibling it is.
+func siblingsLinked(index *BTreeIndex) (bool, error) {
+	// Find the leftmost leaf.
+	curPage, err := index.pager.GetPage(index.rootPN)
+	if err != nil {
+		return false, err
+	}
+	for pageToNodeHeader(curPage, index.cmp).nodeType != LEAF_NODE {
+		childPN := pageToInternalNode(curPage, index.cmp).getPNAt(0)
+		curPage.Put()
+		if curPage, err = index.pager.GetPage(childPN); err != nil {
+			return false, err
+		}
+	}
+	// Walk the leaves left to right.
+	prevPN := int64(-1)
+	for {
+		leaf := pageToLeafNode(curPage, index.cmp)
+		nextPN := leaf.rightSiblingPN
+		curPage.Put()
+		if leaf.leftSiblingPN != prevPN {
+			return false, nil
+		}
+		if nextPN < 0 {
+			return true, nil
+		}
+		prevPN = leaf.page.GetPageNum()
+		if curPage, err = index.pager.GetPage(nextPN); err != nil {
+			return false, err
+		}
 	}
-	n := pageToNode(rootPage)
-	return isBTree(n)
 }
 
-func isBTree(n Node) (l int64, r int64, isbtree bool, err error) {
+// isBTree checks the subtree under a node whose high key, the separator
+// after it in its parent, should be the given one.
+func isBTree(n Node, cmp Comparator, highKey []byte) (l []byte, r []byte, isbtree bool, err error) {
+	if n.header().format == BLINK_FORMAT && !sameKey(cmp, n.header().highKey(), highKey) {
+		return nil, nil, false, nil
+	}
 	// Depending on the node type...
 	switch n := n.(type) {
 	case *InternalNode:
+		// Check that the node is full enough.
+		if n.underflows() {
+			return nil, nil, false, nil
+		}
+		blink := n.format == BLINK_FORMAT
 		// Check that each key is less than the bounds of the node it goes around.
-		var lowest, highest int64
+		var lowest, highest []byte
 		for i := int64(0); i < n.numKeys+1; i++ {
 			// Get child
 			c, err := n.getChildAt(i)
 			if err != nil {
-				return -1, -1, false, err
+				return nil, nil, false, err
+			}
+			// Check that the child is one level down and links to the
+			// next one, then check if child is BTree.
+			childHighKey := highKey
+			if i < n.numKeys {
+				childHighKey = n.getKeyAt(i)
+			}
+			if blink && (c.header().level != n.level-1 ||
+				i < n.numKeys && c.header().rightSiblingPN != n.getPNAt(i+1)) {
+				c.getPage().Put()
+				return nil, nil, false, nil
 			}
-			// Check if child is BTree
-			cl, cr, cisbtree, err := isBTree(c)
+			cl, cr, cisbtree, err := isBTree(c, cmp, childHighKey)
+			c.getPage().Put()
 			if err != nil {
-				return -1, -1, false, err
+				return nil, nil, false, err
 			} else if !cisbtree {
-				return -1, -1, false, nil
+				return nil, nil, false, nil
 			}
 			// Set conditions.
 			if i == 0 {
@@ -43,29 +110,50 @@ func isBTree(n Node) (l int64, r int64, isbtree bool, err error) {
 			// If it is, check that the key bounds work out.
 			if i-1 >= 0 {
 				k := n.getKeyAt(i - 1)
-				if k > cl {
-					return -1, -1, false, nil
+				if cmp(k, cl) > 0 {
+					return nil, nil, false, nil
 				}
 			}
 			if i < n.numKeys {
 				k := n.getKeyAt(i)
-				if k < cr {
-					return -1, -1, false, nil
+				if cmp(k, cr) < 0 {
+					return nil, nil, false, nil
 				}
 			}
 		}
 		// Return bounds.
 		return lowest, highest, true, nil
 	case *LeafNode:
-		// Check that each key is less than the one after it.
+		// Check that the node is full enough.
+		if n.underflows() {
+			return nil, nil, false, nil
+		}
+		// An empty leaf has no bounds.
+		if n.numKeys == 0 {
+			return nil, nil, true, nil
+		}
+		// Check that each key is less than the one after it, and the last
+		// one less than the high key.
 		for i := int64(0); i < n.numKeys-1; i++ {
-			if n.getKeyAt(i) > n.getKeyAt(i+1) {
-				return -1, -1, false, nil
+			if cmp(n.getKeyAt(i), n.getKeyAt(i+1)) >= 0 {
+				return nil, nil, false, nil
 			}
 		}
+		if !n.belowHighKey(n.getKeyAt(n.numKeys - 1)) {
+			return nil, nil, false, nil
+		}
 		// If good, return bounds.
 		return n.getKeyAt(0), n.getKeyAt(n.numKeys - 1), true, nil
 	default:
-		return -1, -1, false, errors.New("should not have gotten here")
+		return nil, nil, false, errors.New("should not have gotten here")
+	}
+}
+
+// sameKey returns true if two keys, either of which may be missing, are
+// equal.
+func sameKey(cmp Comparator, a []byte, b []byte) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
 	}
+	return cmp(a, b) == 0
 }
@@ -10,6 +10,9 @@ const Prompt = DBName + "> "
 // Number of pages.
 const NumPages = 32
 
+// Number of pages in the buffer pool shared by all tables of a database.
+const BufferPoolPages = 1024
+
 // Name of log file.
 const LogFileName = "./db.log"
 
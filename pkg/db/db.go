@@ -9,6 +9,7 @@ import (
 	"strings"
 
 	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	config "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/config"
 	hash "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/hash"
 	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
@@ -16,8 +17,15 @@ import (
 
 // Database interface.
 type Database struct {
-	basepath string
-	tables   map[string]Index
+	basepath  string
+	tables    map[string]Index
+	pool      *pager.BufferPool     // Buffer pool shared by every table.
+	poolPages int64                 // Number of pages in the buffer pool.
+	pageSize  int64                 // Page size of every table.
+	cipher    *pager.Cipher         // Encrypts every table; nil if unencrypted.
+	writeback pager.WritebackConfig // Configuration of each table's background flusher.
+	backend   pager.Backend         // Backend every table's file is kept in.
+	trackPins bool                  // Whether tables record who pins their pages.
 }
 
 // Index interface.
@@ -29,10 +37,16 @@ type Index interface {
 	Insert(int64, int64) error
 	Update(int64, int64) error
 	Delete(int64) error
+	FindBytes([]byte) (utils.Entry, error)
+	InsertBytes([]byte, []byte) error
+	UpdateBytes([]byte, []byte) error
+	DeleteBytes([]byte) error
 	Select() ([]utils.Entry, error)
 	Print(io.Writer)
 	PrintPN(int, io.Writer)
 	TableStart() (utils.Cursor, error)
+	TableRange(utils.Range) (utils.Iterator, error)
+	Analyze() (utils.IndexStats, error)
 }
 
 // An index can either be a B+Tree or a Hash Table.
@@ -43,8 +57,59 @@ const (
 	HashIndexType  IndexType = 1
 )
 
+// Option configures a Database when it is opened.
+type Option func(*Database)
+
+// WithBufferPoolPages sets the number of pages in the buffer pool that all
+// of the database's tables share.
+func WithBufferPoolPages(numPages int64) Option {
+	return func(db *Database) {
+		db.poolPages = numPages
+	}
+}
+
+// WithPageSize sets the page size of the database's tables. Existing
+// tables with a different page size can't be opened.
+func WithPageSize(pageSize int64) Option {
+	return func(db *Database) {
+		db.pageSize = pageSize
+	}
+}
+
+// WithCipher encrypts the database's tables with the given cipher. Tables
+// encrypted with a different key, or not at all, can't be opened.
+func WithCipher(c *pager.Cipher) Option {
+	return func(db *Database) {
+		db.cipher = c
+	}
+}
+
+// WithWriteback sets the configuration of the background flusher that each
+// table runs; a zero config turns the flushers off.
+func WithWriteback(config pager.WritebackConfig) Option {
+	return func(db *Database) {
+		db.writeback = config
+	}
+}
+
+// WithBackend keeps the database's tables in the given storage backend
+// instead of on disk.
+func WithBackend(backend pager.Backend) Option {
+	return func(db *Database) {
+		db.backend = backend
+	}
+}
+
+// WithPinTracking makes every table record who pins its pages; see
+// pager.WithPinTracking.
+func WithPinTracking() Option {
+	return func(db *Database) {
+		db.trackPins = true
+	}
+}
+
 // Opens a database given a data folder.
-func Open(folder string) (*Database, error) {
+func Open(folder string, opts ...Option) (*Database, error) {
 	// Ensure folder is of the form */
 	if !strings.HasSuffix(folder, "/") {
 		folder += "/"
@@ -55,10 +120,22 @@ func Open(folder string) (*Database, error) {
 		return nil, err
 	}
 	// Return an empty database.
-	return &Database{
-		basepath: folder,
-		tables:   make(map[string]Index),
-	}, nil
+	db := &Database{
+		basepath:  folder,
+		tables:    make(map[string]Index),
+		poolPages: config.BufferPoolPages,
+		pageSize:  pager.PAGESIZE,
+		writeback: pager.DEFAULT_WRITEBACK,
+		backend:   pager.FileBackend,
+	}
+	for _, opt := range opts {
+		opt(db)
+	}
+	db.pool, err = pager.NewBufferPool(db.poolPages, db.pageSize, nil)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
 }
 
 // Close each table in the database, then close the database.
@@ -72,20 +149,34 @@ func (db *Database) Close() (err error) {
 	return err
 }
 
-// Create a log file for the database.
+// Create a log file for the database, in the backend its tables are kept in.
 func (db *Database) CreateLogFile(filename string) error {
-	if _, err := os.Stat(filename); err == nil {
+	if db.backend.Exists(filename) {
 		return nil
 	}
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	file, err := db.backend.Open(filename, false)
 	if err != nil {
 		return err
 	}
 	return file.Close()
 }
 
-// Create a table with the given type.
-func (db *Database) createTable(name string, indexType IndexType) (index Index, err error) {
+// tableOptions returns the pager options every table is opened with.
+func (db *Database) tableOptions() []pager.Option {
+	opts := []pager.Option{
+		pager.WithBufferPool(db.pool),
+		pager.WithCipher(db.cipher),
+		pager.WithWriteback(db.writeback),
+		pager.WithBackend(db.backend),
+	}
+	if db.trackPins {
+		opts = append(opts, pager.WithPinTracking())
+	}
+	return opts
+}
+
+// Create a table with the given type. The options are passed to the table's pager.
+func (db *Database) createTable(name string, indexType IndexType, opts ...pager.Option) (index Index, err error) {
 	// Ensure the db name is alphanumeric.
 	alphanumeric, _ := regexp.Compile(`\W`)
 	if alphanumeric.MatchString(name) {
@@ -93,18 +184,19 @@ func (db *Database) createTable(name string, indexType IndexType) (index Index,
 	}
 	// Create the file, if not exists.
 	path := filepath.Join(db.basepath, name)
-	if _, err := os.Stat(path); err == nil {
+	if db.backend.Exists(path) {
 		return nil, errors.New("table already exists")
 	}
 	// Open the right type of index.
+	opts = append(db.tableOptions(), opts...)
 	switch indexType {
 	case BTreeIndexType:
-		index, err = btree.OpenTable(path)
+		index, err = btree.OpenTable(path, opts...)
 		if err != nil {
 			return nil, err
 		}
 	case HashIndexType:
-		index, err = hash.OpenTable(path)
+		index, err = hash.OpenTable(path, opts...)
 		if err != nil {
 			return nil, err
 		}
@@ -123,32 +215,66 @@ func (db *Database) GetTable(name string) (index Index, err error) {
 	}
 	// Check if file exists; if not, error.
 	path := filepath.Join(db.basepath, name)
-	if _, err := os.Stat(path); err != nil {
+	if !db.backend.Exists(path) {
 		return nil, errors.New("table not found")
 	}
 	// Else, open from disk.
 	// NOTE: This is janky; assumes that if a .meta file exists, then it is a hash index,
 	// else, it is a btree index.
 	// if _, err := os.Stat(path + ".meta"); err == nil {
-	// 	index, err = hash.OpenTable(path)
+	// 	index, err = hash.OpenTable(path, pager.WithBufferPool(db.pool))
 	// 	if err != nil {
 	// 		return nil, err
 	// 	}
 	// } else {
-	index, err = btree.OpenTable(path)
+	index, err = btree.OpenTable(path, db.tableOptions()...)
 	if err != nil {
 		return nil, err
 	}
 	// }
+	// Open the table's value index along with it, if it has one.
+	indexed, err := db.openValueIndex(name, index)
+	if err != nil {
+		index.Close()
+		return nil, err
+	}
+	index = indexed
 	db.tables[name] = index
 	return index, nil
 }
 
+// Get the buffer pool shared by the database's tables.
+func (db *Database) GetBufferPool() *pager.BufferPool {
+	return db.pool
+}
+
+// Get the cipher that encrypts the database, or nil if it is unencrypted.
+func (db *Database) GetCipher() *pager.Cipher {
+	return db.cipher
+}
+
+// Get the backend the database's tables are kept in.
+func (db *Database) GetBackend() pager.Backend {
+	return db.backend
+}
+
 // Get a database's tables.
 func (db *Database) GetTables() map[string]Index {
 	return db.tables
 }
 
+// Get the pagers of the database's tables and of their value indexes.
+func (db *Database) GetPagers() []*pager.Pager {
+	pagers := make([]*pager.Pager, 0, len(db.tables))
+	for _, table := range db.tables {
+		pagers = append(pagers, table.GetPager())
+		if indexed, ok := table.(*IndexedTable); ok {
+			pagers = append(pagers, indexed.values.GetPager())
+		}
+	}
+	return pagers
+}
+
 // Returns the basepath of the database.
 func (db *Database) GetBasePath() string {
 	return db.basepath
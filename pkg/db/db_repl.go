@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 
+	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 	repl "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/repl"
 	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
@@ -16,19 +18,34 @@ func DatabaseRepl(db *Database) *repl.REPL {
 	r := repl.NewRepl()
 	r.AddCommand("create", func(payload string, replConfig *repl.REPLConfig) error {
 		return HandleCreateTable(db, payload, replConfig.GetWriter())
-	}, "Create a table. usage: create table <table>")
+	}, "Create a table, or an index on a table's values. usage: create <btree|hash> table <table> [compressed] | create index on <table>")
 	r.AddCommand("find", func(payload string, replConfig *repl.REPLConfig) error {
 		return HandleFind(db, payload, replConfig.GetWriter())
-	}, "Find an element. usage: find <key> from <table>")
+	}, "Find an element, or the elements with a value. Keys and values are integers, quoted strings, or tuples of them like [7, \"a\"]. usage: find <key> from <table> | find value <value> from <table>")
 	r.AddCommand("insert", func(payload string, replConfig *repl.REPLConfig) error { return HandleInsert(db, payload) }, "Insert an element. usage: insert <key> <value> into <table>")
 	r.AddCommand("update", func(payload string, replConfig *repl.REPLConfig) error { return HandleUpdate(db, payload) }, "Update en element. usage: update <table> <key> <value>")
 	r.AddCommand("delete", func(payload string, replConfig *repl.REPLConfig) error { return HandleDelete(db, payload) }, "Delete an element. usage: delete <key> from <table>")
 	r.AddCommand("select", func(payload string, replConfig *repl.REPLConfig) error {
 		return HandleSelect(db, payload, replConfig.GetWriter())
 	}, "Select elements from a table. usage: select from <table>")
+	r.AddCommand("range", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandleRange(db, payload, replConfig.GetWriter())
+	}, "Select the elements in a range of keys. Bounds are inclusive; write (lo or hi) to exclude one, or * for no bound. usage: range <table> <lo> <hi> [limit <n>] [reverse]")
+	r.AddCommand("prefix", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandlePrefix(db, payload, replConfig.GetWriter())
+	}, "Select the elements whose keys are tuples that start with the given components. A key that isn't a tuple is a single component. usage: prefix <table> <tuple> [limit <n>] [reverse]")
+	r.AddCommand("load", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandleLoad(db, payload, replConfig.GetWriter())
+	}, "Fill an empty B+tree table from a file with a key and a value on each line, sorting it first if need be. usage: load <table> <file> [fill <fraction>]")
 	r.AddCommand("pretty", func(payload string, replConfig *repl.REPLConfig) error {
 		return HandlePretty(db, payload, replConfig.GetWriter())
 	}, "Print out the internal data representation. usage: pretty")
+	r.AddCommand("stats", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandleStats(db, payload, replConfig.GetWriter())
+	}, "Print buffer pool statistics for the database or a table. usage: stats [table]")
+	r.AddCommand("analyze", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandleAnalyze(db, payload, replConfig.GetWriter())
+	}, "Print statistics on the structure of a table, and of its value index if it has one. usage: analyze <table>")
 	return r
 }
 
@@ -36,9 +53,25 @@ func DatabaseRepl(db *Database) *repl.REPL {
 func HandleCreateTable(d *Database, payload string, w io.Writer) (err error) {
 	fields := strings.Fields(payload)
 	numFields := len(fields)
-	// Usage: create <type> table <table>
-	if numFields != 4 || fields[2] != "table" || (fields[1] != "btree" && fields[1] != "hash") {
-		return fmt.Errorf("usage: create <btree|hash> table <table>")
+	// Usage: create index on <table>
+	if numFields > 1 && fields[1] == "index" {
+		if numFields != 4 || fields[2] != "on" {
+			return fmt.Errorf("usage: create index on <table>")
+		}
+		if err = d.CreateValueIndex(fields[3]); err != nil {
+			return fmt.Errorf("create error: %v", err)
+		}
+		io.WriteString(w, fmt.Sprintf("index on %s created.\n", fields[3]))
+		return nil
+	}
+	// Usage: create <type> table <table> [compressed]
+	if numFields < 4 || numFields > 5 || fields[2] != "table" || (fields[1] != "btree" && fields[1] != "hash") ||
+		(numFields == 5 && fields[4] != "compressed") {
+		return fmt.Errorf("usage: create <btree|hash> table <table> [compressed]")
+	}
+	opts := make([]pager.Option, 0)
+	if numFields == 5 {
+		opts = append(opts, pager.WithCompression())
 	}
 	var tableType IndexType
 	switch fields[1] {
@@ -50,7 +83,7 @@ func HandleCreateTable(d *Database, payload string, w io.Writer) (err error) {
 		return errors.New("create error: internal error")
 	}
 	tableName := fields[3]
-	_, err = d.createTable(tableName, tableType)
+	_, err = d.createTable(tableName, tableType, opts...)
 	if err != nil {
 		return err
 	}
@@ -60,14 +93,21 @@ func HandleCreateTable(d *Database, payload string, w io.Writer) (err error) {
 
 // Handle find.
 func HandleFind(d *Database, payload string, w io.Writer) (err error) {
-	fields := strings.Fields(payload)
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("find error: %v", err)
+	}
 	numFields := len(fields)
+	// Usage: find value <value> from <table>
+	if numFields > 1 && fields[1] == "value" {
+		return handleFindValue(d, fields, w)
+	}
 	// Usage: find <key> from <table>
-	var key int
+	var key []byte
 	if numFields != 4 || fields[2] != "from" {
 		return fmt.Errorf("usage: find <key> from <table>")
 	}
-	if key, err = strconv.Atoi(fields[1]); err != nil {
+	if key, err = parseLiteral(fields[1]); err != nil {
 		return fmt.Errorf("find error: %v", err)
 	}
 	tableName := fields[3]
@@ -75,28 +115,52 @@ func HandleFind(d *Database, payload string, w io.Writer) (err error) {
 	if err != nil {
 		return fmt.Errorf("find error: %v", err)
 	}
-	entry, err := table.Find(int64(key))
+	entry, err := table.FindBytes(key)
 	if err != nil || entry == nil {
 		return fmt.Errorf("find error: %v", err)
 	}
-	io.WriteString(w, fmt.Sprintf("found entry: (%d, %d)\n",
-		entry.GetKey(), entry.GetValue()))
+	io.WriteString(w, fmt.Sprintf("found entry: (%v, %v)\n",
+		utils.FormatBytes(entry.GetKeyBytes()), utils.FormatBytes(entry.GetValueBytes())))
+	return nil
+}
+
+// handleFindValue prints the entries of a table with a given value.
+func handleFindValue(d *Database, fields []string, w io.Writer) (err error) {
+	var value []byte
+	if len(fields) != 5 || fields[3] != "from" {
+		return fmt.Errorf("usage: find value <value> from <table>")
+	}
+	if value, err = parseLiteral(fields[2]); err != nil {
+		return fmt.Errorf("find error: %v", err)
+	}
+	table, err := d.GetTable(fields[4])
+	if err != nil {
+		return fmt.Errorf("find error: %v", err)
+	}
+	results, err := FindValue(table, value)
+	if err != nil {
+		return fmt.Errorf("find error: %v", err)
+	}
+	printResults(results, w)
 	return nil
 }
 
 // Handle insert.
 func HandleInsert(d *Database, payload string) (err error) {
-	fields := strings.Fields(payload)
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("insert error: %v", err)
+	}
 	numFields := len(fields)
 	// Usage: insert <key> <value> into <table>
-	var key, value int
+	var key, value []byte
 	if numFields != 5 || fields[3] != "into" {
 		return fmt.Errorf("usage: insert <key> <value> into <table>")
 	}
-	if key, err = strconv.Atoi(fields[1]); err != nil {
+	if key, err = parseLiteral(fields[1]); err != nil {
 		return fmt.Errorf("insert error: %v", err)
 	}
-	if value, err = strconv.Atoi(fields[2]); err != nil {
+	if value, err = parseLiteral(fields[2]); err != nil {
 		return fmt.Errorf("insert error: %v", err)
 	}
 	tableName := fields[4]
@@ -104,11 +168,11 @@ func HandleInsert(d *Database, payload string) (err error) {
 	if err != nil {
 		return fmt.Errorf("insert error: %v", err)
 	}
-	val, _ := table.Find(int64(key))
+	val, _ := table.FindBytes(key)
 	if val != nil {
 		return fmt.Errorf("insert error: key already in table")
 	}
-	err = table.Insert(int64(key), int64(value))
+	err = table.InsertBytes(key, value)
 	if err != nil {
 		return fmt.Errorf("insert error: %v", err)
 	}
@@ -117,17 +181,20 @@ func HandleInsert(d *Database, payload string) (err error) {
 
 // Handle update.
 func HandleUpdate(d *Database, payload string) (err error) {
-	fields := strings.Fields(payload)
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("update error: %v", err)
+	}
 	numFields := len(fields)
 	// Usage: update <table> <key> <value>
-	var key, value int
+	var key, value []byte
 	if numFields != 4 {
 		return fmt.Errorf("usage: update <table> <key> <value>")
 	}
-	if key, err = strconv.Atoi(fields[2]); err != nil {
+	if key, err = parseLiteral(fields[2]); err != nil {
 		return fmt.Errorf("update error: %v", err)
 	}
-	if value, err = strconv.Atoi(fields[3]); err != nil {
+	if value, err = parseLiteral(fields[3]); err != nil {
 		return fmt.Errorf("update error: %v", err)
 	}
 	tableName := fields[1]
@@ -135,7 +202,7 @@ func HandleUpdate(d *Database, payload string) (err error) {
 	if err != nil {
 		return fmt.Errorf("update error: %v", err)
 	}
-	err = table.Update(int64(key), int64(value))
+	err = table.UpdateBytes(key, value)
 	if err != nil {
 		return fmt.Errorf("update error: %v", err)
 	}
@@ -144,14 +211,17 @@ func HandleUpdate(d *Database, payload string) (err error) {
 
 // Handle delete.
 func HandleDelete(d *Database, payload string) (err error) {
-	fields := strings.Fields(payload)
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("delete error: %v", err)
+	}
 	numFields := len(fields)
 	// Usage: delete <key> from <table>
-	var key int
+	var key []byte
 	if numFields != 4 || fields[2] != "from" {
 		return fmt.Errorf("usage: delete <key> from <table>")
 	}
-	if key, err = strconv.Atoi(fields[1]); err != nil {
+	if key, err = parseLiteral(fields[1]); err != nil {
 		return fmt.Errorf("delete error: %v", err)
 	}
 	tableName := fields[3]
@@ -159,7 +229,7 @@ func HandleDelete(d *Database, payload string) (err error) {
 	if err != nil {
 		return fmt.Errorf("delete error: %v", err)
 	}
-	err = table.Delete(int64(key))
+	err = table.DeleteBytes(key)
 	if err != nil {
 		return fmt.Errorf("delete error: %v", err)
 	}
@@ -187,6 +257,161 @@ func HandleSelect(d *Database, payload string, w io.Writer) (err error) {
 	return nil
 }
 
+// Handle range.
+func HandleRange(d *Database, payload string, w io.Writer) (err error) {
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("range error: %v", err)
+	}
+	// Usage: range <table> <lo> <hi> [limit <n>] [reverse]
+	usage := fmt.Errorf("usage: range <table> <lo> <hi> [limit <n>] [reverse]")
+	if len(fields) < 4 {
+		return usage
+	}
+	var rng utils.Range
+	if rng.Lo, err = parseBound(fields[2], "(", ""); err != nil {
+		return fmt.Errorf("range error: %v", err)
+	}
+	if rng.Hi, err = parseBound(fields[3], "", ")"); err != nil {
+		return fmt.Errorf("range error: %v", err)
+	}
+	if err = parseScanOptions("range", fields[4:], &rng, usage); err != nil {
+		return err
+	}
+	if err = printRange(d, fields[1], rng, w); err != nil {
+		return fmt.Errorf("range error: %v", err)
+	}
+	return nil
+}
+
+// Handle prefix.
+func HandlePrefix(d *Database, payload string, w io.Writer) (err error) {
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("prefix error: %v", err)
+	}
+	// Usage: prefix <table> <tuple> [limit <n>] [reverse]
+	usage := fmt.Errorf("usage: prefix <table> <tuple> [limit <n>] [reverse]")
+	if len(fields) < 3 {
+		return usage
+	}
+	// A literal that isn't a tuple is the prefix's only component.
+	prefix, err := parseLiteral(fields[2])
+	if err != nil {
+		return fmt.Errorf("prefix error: %v", err)
+	}
+	components, ok := utils.DecodeTuple(prefix)
+	if !ok {
+		components = [][]byte{prefix}
+	}
+	rng := utils.PrefixRange(components...)
+	if err = parseScanOptions("prefix", fields[3:], &rng, usage); err != nil {
+		return err
+	}
+	if err = printRange(d, fields[1], rng, w); err != nil {
+		return fmt.Errorf("prefix error: %v", err)
+	}
+	return nil
+}
+
+// parseScanOptions parses the [limit <n>] [reverse] options of a scan
+// command into the range.
+func parseScanOptions(command string, options []string, rng *utils.Range, usage error) (err error) {
+	for rest := options; len(rest) > 0; {
+		switch {
+		case rest[0] == "limit" && len(rest) > 1:
+			if rng.Limit, err = strconv.ParseInt(rest[1], 10, 64); err != nil || rng.Limit <= 0 {
+				return fmt.Errorf("%v error: invalid limit %v", command, rest[1])
+			}
+			rest = rest[2:]
+		case rest[0] == "reverse":
+			rng.Reverse = true
+			rest = rest[1:]
+		default:
+			return usage
+		}
+	}
+	return nil
+}
+
+// printRange prints the entries of a table in the given range.
+func printRange(d *Database, tableName string, rng utils.Range, w io.Writer) error {
+	table, err := d.GetTable(tableName)
+	if err != nil {
+		return err
+	}
+	it, err := table.TableRange(rng)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	// Print each entry as it is read, rather than collecting the range first.
+	for it.Next() {
+		printResults([]utils.Entry{it.Entry()}, w)
+	}
+	return it.Err()
+}
+
+// Handle load.
+func HandleLoad(d *Database, payload string, w io.Writer) (err error) {
+	fields, err := splitFields(payload)
+	if err != nil {
+		return fmt.Errorf("load error: %v", err)
+	}
+	numFields := len(fields)
+	// Usage: load <table> <file> [fill <fraction>]
+	if (numFields != 3 && numFields != 5) || (numFields == 5 && fields[3] != "fill") {
+		return fmt.Errorf("usage: load <table> <file> [fill <fraction>]")
+	}
+	fillFactor := btree.DEFAULT_FILL_FACTOR
+	if numFields == 5 {
+		if fillFactor, err = strconv.ParseFloat(fields[4], 64); err != nil {
+			return fmt.Errorf("load error: %v", err)
+		}
+	}
+	table, err := d.GetTable(fields[1])
+	if err != nil {
+		return fmt.Errorf("load error: %v", err)
+	}
+	// An indexed table's value index is filled once the table is.
+	indexed, _ := table.(*IndexedTable)
+	if indexed != nil {
+		indexed.mtx.Lock()
+		defer indexed.mtx.Unlock()
+		table = indexed.GetPrimary()
+	}
+	bt, ok := table.(*btree.BTreeIndex)
+	if !ok {
+		return fmt.Errorf("load error: only B+tree tables can be bulk loaded")
+	}
+	path := fields[2]
+	// Read the file once to see whether it has to be sorted.
+	sorted, err := fileIsSorted(path, bt.GetComparator())
+	if err != nil {
+		return fmt.Errorf("load error: %v", err)
+	}
+	entries, err := openFileEntries(path)
+	if err != nil {
+		return fmt.Errorf("load error: %v", err)
+	}
+	var input utils.Iterator = entries
+	if !sorted {
+		if input, err = bt.SortEntries(entries); err != nil {
+			return fmt.Errorf("load error: %v", err)
+		}
+	}
+	if err = bt.BulkLoad(input, fillFactor); err != nil {
+		return fmt.Errorf("load error: %v", err)
+	}
+	if indexed != nil {
+		if err = indexed.reindex(); err != nil {
+			return fmt.Errorf("load error: %v", err)
+		}
+	}
+	io.WriteString(w, fmt.Sprintf("loaded %d entries into %s.\n", entries.count, fields[1]))
+	return nil
+}
+
 // Handle pretty printing.
 func HandlePretty(d *Database, payload string, w io.Writer) (err error) {
 	fields := strings.Fields(payload)
@@ -216,10 +441,59 @@ func HandlePretty(d *Database, payload string, w io.Writer) (err error) {
 	return nil
 }
 
+// Handle stats.
+func HandleStats(d *Database, payload string, w io.Writer) (err error) {
+	fields := strings.Fields(payload)
+	numFields := len(fields)
+	// Usage: stats [table]
+	switch numFields {
+	case 1:
+		pool := d.GetBufferPool()
+		io.WriteString(w, fmt.Sprintf("pages: %v (page size: %v)\n", pool.GetNumPages(), pool.GetPageSize()))
+		pool.GetStats().Print(w)
+	case 2:
+		table, err := d.GetTable(fields[1])
+		if err != nil {
+			return fmt.Errorf("stats error: %v", err)
+		}
+		table.GetPager().GetStats().Print(w)
+	default:
+		return fmt.Errorf("usage: stats [table]")
+	}
+	return nil
+}
+
+// Handle analyze.
+func HandleAnalyze(d *Database, payload string, w io.Writer) (err error) {
+	fields := strings.Fields(payload)
+	numFields := len(fields)
+	// Usage: analyze <table>
+	if numFields != 2 {
+		return fmt.Errorf("usage: analyze <table>")
+	}
+	table, err := d.GetTable(fields[1])
+	if err != nil {
+		return fmt.Errorf("analyze error: %v", err)
+	}
+	stats, err := table.Analyze()
+	if err != nil {
+		return fmt.Errorf("analyze error: %v", err)
+	}
+	stats.Print(w)
+	if indexed, ok := table.(*IndexedTable); ok {
+		if stats, err = indexed.GetValueIndex().Analyze(); err != nil {
+			return fmt.Errorf("analyze error: %v", err)
+		}
+		io.WriteString(w, "value index:\n")
+		stats.Print(w)
+	}
+	return nil
+}
+
 // printResults prints all given entries in a standard format.
 func printResults(entries []utils.Entry, w io.Writer) {
 	for _, entry := range entries {
 		io.WriteString(w, fmt.Sprintf("(%v, %v)\n",
-			entry.GetKey(), entry.GetValue()))
+			utils.FormatBytes(entry.GetKeyBytes()), utils.FormatBytes(entry.GetValueBytes())))
 	}
 }
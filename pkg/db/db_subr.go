@@ -1,7 +1,18 @@
 package db
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 // Get a temporary db file.
@@ -13,3 +24,214 @@ func GetTempDB() (string, error) {
 	defer tmpfile.Close()
 	return tmpfile.Name(), nil
 }
+
+// splitFields splits a command into fields around whitespace, like
+// strings.Fields, except that a double-quoted string or a tuple in square
+// brackets is one field, even if it contains whitespace.
+func splitFields(payload string) ([]string, error) {
+	fields := make([]string, 0)
+	start, depth, inQuote, escaped := -1, 0, false, false
+	for i, r := range payload {
+		switch {
+		case inQuote && escaped:
+			escaped = false
+		case inQuote && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case !inQuote && r == '[':
+			depth++
+		case !inQuote && r == ']':
+			if depth == 0 {
+				return nil, errors.New("unbalanced brackets")
+			}
+			depth--
+		case !inQuote && depth == 0 && unicode.IsSpace(r):
+			if start >= 0 {
+				fields = append(fields, payload[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unterminated quoted string")
+	}
+	if depth > 0 {
+		return nil, errors.New("unbalanced brackets")
+	}
+	if start >= 0 {
+		fields = append(fields, payload[start:])
+	}
+	return fields, nil
+}
+
+// parseLiteral parses a key or value typed at the REPL: a double-quoted
+// string, a tuple of literals in square brackets, or else an integer.
+func parseLiteral(field string) ([]byte, error) {
+	if strings.HasPrefix(field, "\"") {
+		s, err := strconv.Unquote(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %v", field)
+		}
+		return utils.EncodeString(s), nil
+	}
+	if strings.HasPrefix(field, "[") && strings.HasSuffix(field, "]") {
+		return parseTuple(field[1 : len(field)-1])
+	}
+	i, err := strconv.Atoi(field)
+	if err != nil {
+		return nil, err
+	}
+	return utils.EncodeInt(int64(i)), nil
+}
+
+// parseTuple parses the comma-separated literals inside a tuple's
+// brackets.
+func parseTuple(inner string) ([]byte, error) {
+	components := make([][]byte, 0)
+	if strings.TrimSpace(inner) == "" {
+		return utils.EncodeTuple(components...), nil
+	}
+	start, depth, inQuote, escaped := 0, 0, false, false
+	parts := make([]string, 0)
+	for i, r := range inner {
+		switch {
+		case inQuote && escaped:
+			escaped = false
+		case inQuote && r == '\\':
+			escaped = true
+		case r == '"':
+			inQuote = !inQuote
+		case !inQuote && r == '[':
+			depth++
+		case !inQuote && r == ']':
+			depth--
+		case !inQuote && depth == 0 && r == ',':
+			parts = append(parts, inner[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, inner[start:])
+	for _, part := range parts {
+		component, err := parseLiteral(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		components = append(components, component)
+	}
+	return utils.EncodeTuple(components...), nil
+}
+
+// parseBound parses one end of a range typed at the REPL: * for no bound,
+// or a literal, which is excluded if it has the given prefix or suffix.
+func parseBound(field string, exclusivePrefix string, exclusiveSuffix string) (utils.Bound, error) {
+	if field == "*" {
+		return utils.Bound{}, nil
+	}
+	bound := utils.Bound{Inclusive: true}
+	if exclusivePrefix != "" && strings.HasPrefix(field, exclusivePrefix) {
+		field, bound.Inclusive = strings.TrimPrefix(field, exclusivePrefix), false
+	}
+	if exclusiveSuffix != "" && strings.HasSuffix(field, exclusiveSuffix) {
+		field, bound.Inclusive = strings.TrimSuffix(field, exclusiveSuffix), false
+	}
+	key, err := parseLiteral(field)
+	if err != nil {
+		return utils.Bound{}, err
+	}
+	bound.Key = key
+	return bound, nil
+}
+
+// fileEntries reads entries from a file with a key and a value on each
+// line, written as they would be typed at the REPL. Blank lines are skipped.
+type fileEntries struct {
+	file    *os.File
+	scanner *bufio.Scanner
+	line    int
+	count   int64 // Number of entries read so far.
+	entry   utils.Entry
+	err     error
+}
+
+// openFileEntries opens a file of entries.
+func openFileEntries(path string) (*fileEntries, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), math.MaxInt32)
+	return &fileEntries{file: file, scanner: scanner}, nil
+}
+
+// Next reads the next entry.
+func (entries *fileEntries) Next() bool {
+	for entries.err == nil && entries.scanner.Scan() {
+		entries.line++
+		fields, err := splitFields(entries.scanner.Text())
+		if err == nil && len(fields) == 0 {
+			continue
+		}
+		if err == nil && len(fields) != 2 {
+			err = errors.New("expected a key and a value")
+		}
+		var key, value []byte
+		if err == nil {
+			key, err = parseLiteral(fields[0])
+		}
+		if err == nil {
+			value, err = parseLiteral(fields[1])
+		}
+		if err != nil {
+			entries.err = fmt.Errorf("line %v: %v", entries.line, err)
+			break
+		}
+		entries.entry = btree.NewBTreeEntry(key, value)
+		entries.count++
+		return true
+	}
+	if entries.err == nil {
+		entries.err = entries.scanner.Err()
+	}
+	entries.Close()
+	return false
+}
+
+// Entry returns the current entry.
+func (entries *fileEntries) Entry() utils.Entry {
+	return entries.entry
+}
+
+// Err returns the error that stopped the reader, if any.
+func (entries *fileEntries) Err() error {
+	return entries.err
+}
+
+// Close closes the file.
+func (entries *fileEntries) Close() {
+	entries.file.Close()
+}
+
+// fileIsSorted returns true if the keys in a file of entries are in
+// strictly increasing order.
+func fileIsSorted(path string, cmp btree.Comparator) (bool, error) {
+	entries, err := openFileEntries(path)
+	if err != nil {
+		return false, err
+	}
+	defer entries.Close()
+	var lastKey []byte
+	for entries.Next() {
+		key := entries.Entry().GetKeyBytes()
+		if lastKey != nil && cmp(lastKey, key) >= 0 {
+			return false, nil
+		}
+		lastKey = key
+	}
+	return true, entries.Err()
+}
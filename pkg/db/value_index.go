@@ -0,0 +1,246 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+
+	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// Suffix of the file that holds a table's value index, next to the table's
+// own file. Table names are alphanumeric, so it can't be a table's file.
+const VALUE_INDEX_SUFFIX = ".values"
+
+// IndexedTable is a table with a secondary index on its values: a B+tree
+// that maps each value to the keys of the entries that hold it. The index
+// is kept up to date as entries are inserted, updated and deleted.
+type IndexedTable struct {
+	Index
+	values *btree.MultiIndex
+	mtx    sync.Mutex // Keeps changes to the table and to its index in step.
+}
+
+// Get the table's value index.
+func (table *IndexedTable) GetValueIndex() *btree.MultiIndex {
+	return table.values
+}
+
+// Get the table without its value index.
+func (table *IndexedTable) GetPrimary() Index {
+	return table.Index
+}
+
+// Close closes the table and its value index.
+func (table *IndexedTable) Close() error {
+	err := table.Index.Close()
+	if valuesErr := table.values.Close(); err == nil {
+		err = valuesErr
+	}
+	return err
+}
+
+// Inserts an entry to the table and its value index.
+func (table *IndexedTable) Insert(key int64, value int64) error {
+	return table.InsertBytes(utils.EncodeInt(key), utils.EncodeInt(value))
+}
+
+// InsertBytes inserts an entry to the table and its value index.
+func (table *IndexedTable) InsertBytes(key []byte, value []byte) error {
+	table.mtx.Lock()
+	defer table.mtx.Unlock()
+	if err := table.Index.InsertBytes(key, value); err != nil {
+		return err
+	}
+	if err := table.values.Insert(value, key); err != nil {
+		// Take the entry back out, so the table matches its index.
+		table.Index.DeleteBytes(key)
+		return err
+	}
+	return nil
+}
+
+// Update modifies an existing entry and its value index.
+func (table *IndexedTable) Update(key int64, value int64) error {
+	return table.UpdateBytes(utils.EncodeInt(key), utils.EncodeInt(value))
+}
+
+// UpdateBytes modifies an existing entry and moves its key in the value
+// index from the old value to the new one.
+func (table *IndexedTable) UpdateBytes(key []byte, value []byte) error {
+	table.mtx.Lock()
+	defer table.mtx.Unlock()
+	entry, err := table.Index.FindBytes(key)
+	if err != nil {
+		return err
+	}
+	old := append([]byte{}, entry.GetValueBytes()...)
+	if err := table.Index.UpdateBytes(key, value); err != nil {
+		return err
+	}
+	if bytes.Equal(old, value) {
+		return nil
+	}
+	if err := table.values.Delete(old, key); err != nil {
+		// Put the old value back, so the table matches its index.
+		table.Index.UpdateBytes(key, old)
+		return err
+	}
+	if err := table.values.Insert(value, key); err != nil {
+		table.values.Insert(old, key)
+		table.Index.UpdateBytes(key, old)
+		return err
+	}
+	return nil
+}
+
+// Delete removes a key from the table and its value index.
+func (table *IndexedTable) Delete(key int64) error {
+	return table.DeleteBytes(utils.EncodeInt(key))
+}
+
+// DeleteBytes removes a key from the table and its value index.
+func (table *IndexedTable) DeleteBytes(key []byte) error {
+	table.mtx.Lock()
+	defer table.mtx.Unlock()
+	entry, err := table.Index.FindBytes(key)
+	if err != nil {
+		return err
+	}
+	old := append([]byte{}, entry.GetValueBytes()...)
+	if err := table.Index.DeleteBytes(key); err != nil {
+		return err
+	}
+	if err := table.values.Delete(old, key); err != nil {
+		// Put the entry back, so the table matches its index.
+		table.Index.InsertBytes(key, old)
+		return err
+	}
+	return nil
+}
+
+// reindex fills the table's empty value index from the table's entries.
+func (table *IndexedTable) reindex() error {
+	cursor, err := table.Index.TableStart()
+	if err != nil {
+		return err
+	}
+	return table.values.BulkLoad(&valueEntries{cursor: cursor}, btree.DEFAULT_FILL_FACTOR)
+}
+
+// valueEntries reads a table's entries through a cursor, with their keys
+// and values swapped.
+type valueEntries struct {
+	cursor  utils.Cursor
+	started bool
+	entry   utils.Entry
+	err     error
+}
+
+// Next reads the next entry.
+func (entries *valueEntries) Next() bool {
+	for entries.cursor != nil {
+		if entries.started && entries.cursor.StepForward() {
+			break
+		}
+		entries.started = true
+		if entries.cursor.IsEnd() {
+			continue
+		}
+		entry, err := entries.cursor.GetEntry()
+		if err != nil {
+			entries.err = err
+			break
+		}
+		entries.entry = btree.NewBTreeEntry(entry.GetValueBytes(), entry.GetKeyBytes())
+		return true
+	}
+	entries.Close()
+	return false
+}
+
+// Entry returns the current entry.
+func (entries *valueEntries) Entry() utils.Entry {
+	return entries.entry
+}
+
+// Err returns the error that stopped the reader, if any.
+func (entries *valueEntries) Err() error {
+	return entries.err
+}
+
+// Close releases the cursor.
+func (entries *valueEntries) Close() {
+	if entries.cursor != nil {
+		entries.cursor.Close()
+		entries.cursor = nil
+	}
+}
+
+// CreateValueIndex adds a secondary index on the values of the named table,
+// filled from the entries already in it.
+func (db *Database) CreateValueIndex(name string) (err error) {
+	table, err := db.GetTable(name)
+	if err != nil {
+		return err
+	}
+	if _, ok := table.(*IndexedTable); ok {
+		return errors.New("table already has a value index")
+	}
+	path := filepath.Join(db.basepath, name) + VALUE_INDEX_SUFFIX
+	values, err := btree.OpenMultiTable(path, db.tableOptions()...)
+	if err != nil {
+		return err
+	}
+	indexed := &IndexedTable{Index: table, values: values}
+	if err := indexed.reindex(); err != nil {
+		values.Close()
+		db.backend.Remove(path)
+		return err
+	}
+	db.tables[name] = indexed
+	return nil
+}
+
+// openValueIndex returns the table with its value index, if it has one.
+func (db *Database) openValueIndex(name string, table Index) (Index, error) {
+	path := filepath.Join(db.basepath, name) + VALUE_INDEX_SUFFIX
+	if !db.backend.Exists(path) {
+		return table, nil
+	}
+	values, err := btree.OpenMultiTable(path, db.tableOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexedTable{Index: table, values: values}, nil
+}
+
+// FindValue returns the entries of a table that hold the given value, in
+// key order, looking them up in the table's value index if it has one, or
+// else scanning the table.
+func FindValue(table Index, value []byte) ([]utils.Entry, error) {
+	results := make([]utils.Entry, 0)
+	if indexed, ok := table.(*IndexedTable); ok {
+		it, err := indexed.values.Find(value)
+		if err != nil {
+			return nil, err
+		}
+		defer it.Close()
+		for it.Next() {
+			results = append(results, btree.NewBTreeEntry(it.Entry().GetValueBytes(), value))
+		}
+		return results, it.Err()
+	}
+	entries, err := table.Select()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if bytes.Equal(entry.GetValueBytes(), value) {
+			results = append(results, entry)
+		}
+	}
+	return results, nil
+}
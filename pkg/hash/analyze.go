@@ -0,0 +1,83 @@
+package hash
+
+import (
+	"fmt"
+	"io"
+
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+)
+
+// TableStats describes how a hash table's entries are spread over its
+// buckets.
+type TableStats struct {
+	GlobalDepth   int64     // Bits of the hash that index the bucket directory.
+	LocalDepths   []int64   // Buckets at each local depth, from 0 up to the global depth.
+	Buckets       int64     // Distinct buckets; several directory slots can share one.
+	Entries       int64     // Entries in all buckets.
+	EmptyBuckets  int64     // Buckets holding no entries.
+	MaxBucketKeys int64     // Entries in the fullest bucket.
+	BucketSize    int64     // Entries a full bucket holds.
+	Fill          float64   // Fraction of the space in all buckets in use.
+	Occupancy     [10]int64 // Buckets by how full they are, in tenths of a bucket.
+}
+
+// Analyze reads every bucket and returns statistics on the table's structure.
+func (table *HashTable) Analyze() (*TableStats, error) {
+	table.RLock()
+	defer table.RUnlock()
+	stats := &TableStats{
+		GlobalDepth: table.depth,
+		LocalDepths: make([]int64, table.depth+1),
+	}
+	for _, pn := range table.GetBucketPNs() {
+		bucket, err := table.GetAndLockBucketByPN(pn, READ_LOCK)
+		if err != nil {
+			return nil, err
+		}
+		depth, numKeys, maxKeys := bucket.depth, bucket.numKeys, bucket.maxKeys()
+		bucket.RUnlock()
+		bucket.page.Put()
+		stats.Buckets++
+		stats.Entries += numKeys
+		stats.BucketSize = maxKeys
+		if depth >= 0 && depth <= table.depth {
+			stats.LocalDepths[depth]++
+		}
+		if numKeys == 0 {
+			stats.EmptyBuckets++
+		}
+		if numKeys > stats.MaxBucketKeys {
+			stats.MaxBucketKeys = numKeys
+		}
+		band := numKeys * int64(len(stats.Occupancy)) / maxKeys
+		if band >= int64(len(stats.Occupancy)) {
+			band = int64(len(stats.Occupancy)) - 1
+		}
+		stats.Occupancy[band]++
+	}
+	if stats.Buckets > 0 {
+		stats.Fill = float64(stats.Entries) / float64(stats.Buckets*stats.BucketSize)
+	}
+	return stats, nil
+}
+
+// Print writes the statistics out, one per line.
+func (stats *TableStats) Print(w io.Writer) {
+	io.WriteString(w, fmt.Sprintf("global depth: %v\n", stats.GlobalDepth))
+	io.WriteString(w, fmt.Sprintf("buckets per local depth: %v\n", stats.LocalDepths))
+	io.WriteString(w, fmt.Sprintf("buckets: %v\n", stats.Buckets))
+	io.WriteString(w, fmt.Sprintf("entries: %v\n", stats.Entries))
+	io.WriteString(w, fmt.Sprintf("empty buckets: %v\n", stats.EmptyBuckets))
+	io.WriteString(w, fmt.Sprintf("fullest bucket: %v of %v\n", stats.MaxBucketKeys, stats.BucketSize))
+	io.WriteString(w, fmt.Sprintf("fill: %.3f\n", stats.Fill))
+	io.WriteString(w, fmt.Sprintf("buckets per tenth full: %v\n", stats.Occupancy))
+}
+
+// Analyze returns statistics on the table's structure.
+func (index *HashIndex) Analyze() (utils.IndexStats, error) {
+	stats, err := index.table.Analyze()
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
@@ -18,8 +18,7 @@ type HashBucket struct {
 
 // Construct a new HashBucket.
 func NewHashBucket(pager *pager.Pager, depth int64) (*HashBucket, error) {
-	newPN := pager.GetFreePN()
-	newPage, err := pager.GetPage(newPN)
+	newPage, err := pager.GetNewPage()
 	if err != nil {
 		return nil, err
 	}
@@ -53,7 +52,7 @@ func (bucket *HashBucket) Insert(key int64, value int64) (bool, error) {
 	/* SOLUTION {{{ */
 	bucket.modifyEntry(bucket.numKeys, HashEntry{key, value})
 	bucket.updateNumKeys(bucket.numKeys + 1)
-	return bucket.numKeys >= BUCKETSIZE, nil
+	return bucket.numKeys >= bucket.maxKeys(), nil
 	/* SOLUTION }}} */
 }
 
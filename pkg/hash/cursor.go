@@ -6,9 +6,15 @@ import (
 	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
-// HashCursor points to a spot in the hash table.
+// Number of buckets a cursor reads ahead of the one it is in.
+const PREFETCH_BUCKETS = 8
+
+// HashCursor points to a spot in the hash table. A cursor keeps the bucket
+// it points into pinned until it moves off of it or is closed.
 type HashCursor struct {
 	table     *HashIndex
+	pns       []int64 // Bucket page numbers, in visiting order.
+	bucketnum int     // Index of the current bucket in pns.
 	cellnum   int64
 	isEnd     bool
 	curBucket *HashBucket
@@ -16,39 +22,70 @@ type HashCursor struct {
 
 // TableStart returns a cursor to the first entry in the hash table.
 func (table *HashIndex) TableStart() (utils.Cursor, error) {
-	cursor := HashCursor{table: table, cellnum: 0}
-	curPage, err := table.pager.GetPage(ROOT_PN)
-	if err != nil {
+	// Walk the bucket index rather than the file, since freed pages are not buckets.
+	table.table.RLock()
+	pns := table.table.GetBucketPNs()
+	table.table.RUnlock()
+	cursor := HashCursor{table: table, pns: pns, bucketnum: 0, cellnum: 0}
+	if err := cursor.moveTo(0); err != nil {
 		return nil, err
 	}
-	defer curPage.Put()
-	cursor.curBucket = pageToBucket(curPage)
 	cursor.isEnd = (cursor.curBucket.numKeys == 0)
+	// Start reading the next few buckets; each step reads one more.
+	ahead := pns[1:]
+	if len(ahead) > PREFETCH_BUCKETS {
+		ahead = ahead[:PREFETCH_BUCKETS]
+	}
+	table.pager.Prefetch(ahead...)
 	return &cursor, nil
 }
 
+// moveTo moves the cursor onto the bucket at the given index in pns,
+// releasing the bucket it was on.
+func (cursor *HashCursor) moveTo(bucketnum int) error {
+	page, err := cursor.table.pager.GetPage(cursor.pns[bucketnum])
+	if err != nil {
+		return err
+	}
+	cursor.release()
+	page.RLock()
+	cursor.curBucket = pageToBucket(page)
+	page.RUnlock()
+	cursor.bucketnum = bucketnum
+	return nil
+}
+
+// release unpins the bucket the cursor is on, if any.
+func (cursor *HashCursor) release() {
+	if cursor.curBucket != nil {
+		cursor.curBucket.page.Put()
+		cursor.curBucket = nil
+	}
+}
+
 // StepForward moves the cursor ahead by one entry.
 // Lock cursor and remember to unlock the cursor
 // Lock new page and unlock new page before returning
 func (cursor *HashCursor) StepForward() bool {
+	if cursor.curBucket == nil {
+		return true
+	}
 	// If the cursor is at the end of the bucket, try visiting the next bucket.
 	if cursor.isEnd {
 		// Get the next page number.
-		nextPN := cursor.curBucket.page.GetPageNum() + 1
-		if nextPN >= cursor.curBucket.page.GetPager().GetNumPages() {
+		if cursor.bucketnum+1 >= len(cursor.pns) {
 			return true
 		}
-		// Convert the page to a bucket.
-		nextPage, err := cursor.table.pager.GetPage(nextPN)
-		if err != nil {
+		if next := cursor.bucketnum + 1 + PREFETCH_BUCKETS; next < len(cursor.pns) {
+			cursor.table.pager.Prefetch(cursor.pns[next])
+		}
+		// Move onto the next bucket.
+		if err := cursor.moveTo(cursor.bucketnum + 1); err != nil {
 			return true
 		}
-		defer nextPage.Put()
-		nextBucket := pageToBucket(nextPage)
 		// Reinitialize the cursor.
 		cursor.cellnum = 0
-		cursor.isEnd = (cursor.cellnum == nextBucket.numKeys)
-		cursor.curBucket = nextBucket
+		cursor.isEnd = (cursor.cellnum == cursor.curBucket.numKeys)
 		if cursor.isEnd {
 			return cursor.StepForward()
 		}
@@ -62,6 +99,75 @@ func (cursor *HashCursor) StepForward() bool {
 	return false
 }
 
+// StepBackward moves the cursor back by one entry. Returns true at the
+// first entry of the first bucket.
+func (cursor *HashCursor) StepBackward() bool {
+	if cursor.curBucket == nil {
+		return true
+	}
+	// If the cursor is at the start of the bucket, visit the previous bucket.
+	if cursor.cellnum <= 0 {
+		if cursor.bucketnum <= 0 {
+			return true
+		}
+		if err := cursor.moveTo(cursor.bucketnum - 1); err != nil {
+			return true
+		}
+		cursor.cellnum = cursor.curBucket.numKeys
+		cursor.isEnd = true
+		if cursor.cellnum == 0 {
+			return cursor.StepBackward()
+		}
+	}
+	cursor.cellnum--
+	cursor.isEnd = false
+	return false
+}
+
+// Seek moves the cursor to the entry with the given key. Hash tables aren't
+// ordered, so the key must be in the table.
+func (cursor *HashCursor) Seek(key []byte) error {
+	intKey, ok := utils.DecodeInt(key)
+	if !ok {
+		return errNotInt
+	}
+	table := cursor.table.table
+	table.RLock()
+	pn := table.buckets[Hasher(intKey, table.depth)]
+	bucketnum := indexOf(cursor.pns, pn)
+	// The bucket was split off after the cursor was made.
+	if bucketnum < 0 {
+		cursor.pns = table.GetBucketPNs()
+		bucketnum = indexOf(cursor.pns, pn)
+	}
+	table.RUnlock()
+	if err := cursor.moveTo(bucketnum); err != nil {
+		return err
+	}
+	cursor.curBucket.RLock()
+	defer cursor.curBucket.RUnlock()
+	for i := int64(0); i < cursor.curBucket.numKeys; i++ {
+		if cursor.curBucket.getKeyAt(i) == intKey {
+			cursor.cellnum = i
+			cursor.isEnd = false
+			return nil
+		}
+	}
+	cursor.cellnum = cursor.curBucket.numKeys
+	cursor.isEnd = true
+	return errors.New("not found")
+}
+
+// indexOf returns the index of a page number in a list, or -1.
+func indexOf(pns []int64, pn int64) int {
+	for i, other := range pns {
+		if other == pn {
+			return i
+		}
+	}
+	return -1
+}
+
 // IsEnd returns true if at end.
 func (cursor *HashCursor) IsEnd() bool {
 	return cursor.isEnd
@@ -69,9 +175,16 @@ func (cursor *HashCursor) IsEnd() bool {
 
 // GetEntry returns the entry currently pointed to by the cursor.
 func (cursor *HashCursor) GetEntry() (utils.Entry, error) {
-	if cursor.isEnd {
+	if cursor.isEnd || cursor.curBucket == nil {
 		return HashEntry{}, errors.New("getEntry: entry is non-existent")
 	}
 	entry := cursor.curBucket.getEntry(cursor.cellnum)
 	return entry, nil
-}
\ No newline at end of file
+}
+
+// Close releases the bucket the cursor points into. The cursor can't be
+// used afterwards.
+func (cursor *HashCursor) Close() {
+	cursor.release()
+	cursor.isEnd = true
+}
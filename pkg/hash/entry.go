@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 // HashEntry is a single entry in a hashtable. Implements utils.Entry.
@@ -22,6 +24,16 @@ func (entry HashEntry) GetValue() int64 {
 	return entry.value
 }
 
+// Get key as a byte string.
+func (entry HashEntry) GetKeyBytes() []byte {
+	return utils.EncodeInt(entry.key)
+}
+
+// Get value as a byte string.
+func (entry HashEntry) GetValueBytes() []byte {
+	return utils.EncodeInt(entry.value)
+}
+
 // Set key.
 func (entry *HashEntry) SetKey(key int64) {
 	entry.key = key
@@ -1,6 +1,7 @@
 package hash
 
 import (
+	"errors"
 	"io"
 
 	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
@@ -14,9 +15,10 @@ type HashIndex struct {
 }
 
 // Opens the pager with the given table name.
-func OpenTable(filename string) (*HashIndex, error) {
+// Options are passed on to the table's pager.
+func OpenTable(filename string, opts ...pager.Option) (*HashIndex, error) {
 	// Create a pager for the table.
-	pager := pager.NewPager()
+	pager := pager.NewPager(opts...)
 	err := pager.Open(filename)
 	if err != nil {
 		return nil, err
@@ -61,24 +63,91 @@ func (index *HashIndex) Find(key int64) (utils.Entry, error) {
 
 // Insert given element.
 func (index *HashIndex) Insert(key int64, value int64) error {
+	if err := index.pager.CheckWritable(); err != nil {
+		return err
+	}
 	return index.table.Insert(key, value)
 }
 
 // Update given element.
 func (index *HashIndex) Update(key int64, value int64) error {
+	if err := index.pager.CheckWritable(); err != nil {
+		return err
+	}
 	return index.table.Update(key, value)
 }
 
 // Delete given element.
 func (index *HashIndex) Delete(key int64) error {
+	if err := index.pager.CheckWritable(); err != nil {
+		return err
+	}
 	return index.table.Delete(key)
 }
 
+// errNotInt is returned when a hash table is given a key or value that
+// isn't an integer.
+var errNotInt = errors.New("hash tables only hold integer keys and values")
+
+// decodeInts decodes byte string keys and values made by utils.EncodeInt.
+func decodeInts(data ...[]byte) ([]int64, error) {
+	ints := make([]int64, len(data))
+	for i, d := range data {
+		n, ok := utils.DecodeInt(d)
+		if !ok {
+			return nil, errNotInt
+		}
+		ints[i] = n
+	}
+	return ints, nil
+}
+
+// Find element by a byte string key, which must be an integer.
+func (index *HashIndex) FindBytes(key []byte) (utils.Entry, error) {
+	ints, err := decodeInts(key)
+	if err != nil {
+		return nil, err
+	}
+	return index.Find(ints[0])
+}
+
+// Insert given element, whose key and value must be integers.
+func (index *HashIndex) InsertBytes(key []byte, value []byte) error {
+	ints, err := decodeInts(key, value)
+	if err != nil {
+		return err
+	}
+	return index.Insert(ints[0], ints[1])
+}
+
+// Update given element, whose key and value must be integers.
+func (index *HashIndex) UpdateBytes(key []byte, value []byte) error {
+	ints, err := decodeInts(key, value)
+	if err != nil {
+		return err
+	}
+	return index.Update(ints[0], ints[1])
+}
+
+// Delete given element, whose key must be an integer.
+func (index *HashIndex) DeleteBytes(key []byte) error {
+	ints, err := decodeInts(key)
+	if err != nil {
+		return err
+	}
+	return index.Delete(ints[0])
+}
+
 // Select all elements.
 func (index *HashIndex) Select() ([]utils.Entry, error) {
 	return index.table.Select()
 }
 
+// TableRange fails, since a hash table doesn't keep its keys in order.
+func (index *HashIndex) TableRange(rng utils.Range) (utils.Iterator, error) {
+	return nil, errors.New("hash tables don't support range scans")
+}
+
 // Print all elements.
 func (index *HashIndex) Print(w io.Writer) {
 	index.table.Print(w)
@@ -10,15 +10,25 @@ import (
 
 // Hash table variables
 var ROOT_PN int64 = 0
-var PAGESIZE int64 = pager.PAGESIZE
 var DIRECTORY_HEADER_SIZE int64 = binary.MaxVarintLen64 * 2 // Must store global depth and next pointer
 var DEPTH_OFFSET int64 = 0
 var DEPTH_SIZE int64 = binary.MaxVarintLen64
 var NUM_KEYS_OFFSET int64 = DEPTH_OFFSET + DEPTH_SIZE
 var NUM_KEYS_SIZE int64 = binary.MaxVarintLen64
 var BUCKET_HEADER_SIZE int64 = DEPTH_SIZE + NUM_KEYS_SIZE
-var ENTRYSIZE int64 = binary.MaxVarintLen64 * 2                    // int64 key, int64 value
-var BUCKETSIZE int64 = (PAGESIZE-BUCKET_HEADER_SIZE)/ENTRYSIZE - 1 // num entries
+var ENTRYSIZE int64 = binary.MaxVarintLen64 * 2         // int64 key, int64 value
+var BUCKETSIZE int64 = bucketSize(pager.PAGE_DATA_SIZE) // num entries at the default page size
+
+// bucketSize returns the number of entries in a full bucket, given the
+// usable size of a page.
+func bucketSize(dataSize int64) int64 {
+	return (dataSize-BUCKET_HEADER_SIZE)/ENTRYSIZE - 1
+}
+
+// maxKeys returns the number of entries in a full bucket.
+func (bucket *HashBucket) maxKeys() int64 {
+	return bucketSize(bucket.page.GetPager().GetDataSize())
+}
 
 // Lock Types
 type BucketLockType int
@@ -175,8 +185,12 @@ func (table *HashTable) GetAndLockBucket(hash int64, lock BucketLockType) (*Hash
 
 // Read hash table in from memory.
 func ReadHashTable(bucketPager *pager.Pager) (*HashTable, error) {
-	indexPager := pager.NewPager()
-	err := indexPager.Open(bucketPager.GetFileName() + ".meta")
+	opts := []pager.Option{pager.WithCipher(bucketPager.GetCipher()), pager.WithBackend(bucketPager.GetBackend())}
+	if bucketPager.IsMapped() {
+		opts = append(opts, pager.WithMmap())
+	}
+	indexPager := pager.NewPager(opts...)
+	err := indexPager.Open(bucketPager.GetFilePath() + ".meta")
 	if err != nil {
 		return nil, err
 	}
@@ -193,7 +207,7 @@ func ReadHashTable(bucketPager *pager.Pager) (*HashTable, error) {
 	numHashes := powInt(2, depth)
 	buckets := make([]int64, numHashes)
 	for i := int64(0); i < numHashes; i++ {
-		if bytesRead+pnSize > PAGESIZE {
+		if bytesRead+pnSize > indexPager.GetDataSize() {
 			page.Put()
 			metaPN++
 			page, err = indexPager.GetPage(metaPN)
@@ -211,15 +225,22 @@ func ReadHashTable(bucketPager *pager.Pager) (*HashTable, error) {
 	return &HashTable{depth: depth, buckets: buckets, pager: bucketPager}, nil
 }
 
-// Write hash table out to memory.
+// Write hash table out to memory. The buckets are flushed first, so that
+// the index never refers to buckets that aren't on disk, and nothing is
+// written if the bucket pager is read-only.
 func WriteHashTable(bucketPager *pager.Pager, table *HashTable) error {
-	if bucketPager.HasFile() {
-		indexPager := pager.NewPager()
-		err := indexPager.Open(bucketPager.GetFileName() + ".meta")
+	if err := bucketPager.FlushAllPages(); err != nil {
+		bucketPager.Close()
+		return err
+	}
+	if bucketPager.HasFile() && !bucketPager.IsReadOnly() {
+		indexPager := pager.NewPager(pager.WithCipher(bucketPager.GetCipher()), pager.WithBackend(bucketPager.GetBackend()))
+		err := indexPager.Open(bucketPager.GetFilePath() + ".meta")
 		if err != nil {
 			return err
 		}
-		metaPN := indexPager.GetFreePN()
+		// Overwrite the previous index, starting from the first page.
+		metaPN := int64(0)
 		page, err := indexPager.GetPage(metaPN)
 		if err != nil {
 			return err
@@ -234,9 +255,9 @@ func WriteHashTable(bucketPager *pager.Pager, table *HashTable) error {
 		pnSize := int64(binary.MaxVarintLen64)
 		pnData := make([]byte, pnSize)
 		for _, pn := range table.buckets {
-			if bytesWritten+pnSize > PAGESIZE {
+			if bytesWritten+pnSize > indexPager.GetDataSize() {
 				page.Put()
-				metaPN = indexPager.GetFreePN()
+				metaPN++
 				page, err = indexPager.GetPage(metaPN)
 				if err != nil {
 					return err
@@ -249,7 +270,10 @@ func WriteHashTable(bucketPager *pager.Pager, table *HashTable) error {
 			bytesWritten += pnSize
 		}
 		page.Put()
-		indexPager.Close()
+		if err := indexPager.Close(); err != nil {
+			bucketPager.Close()
+			return err
+		}
 	}
 	return bucketPager.Close()
 }
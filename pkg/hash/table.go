@@ -8,7 +8,7 @@ import (
 	"sync"
 
 	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
-	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"	
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 // HashTable definitions.
@@ -64,6 +64,20 @@ func (table *HashTable) GetBuckets() []int64 {
 	return table.buckets
 }
 
+// GetBucketPNs returns the page number of each distinct bucket, in the order
+// they first appear in the bucket index.
+func (table *HashTable) GetBucketPNs() []int64 {
+	pns := make([]int64, 0)
+	seen := make(map[int64]bool)
+	for _, pn := range table.buckets {
+		if !seen[pn] {
+			seen[pn] = true
+			pns = append(pns, pn)
+		}
+	}
+	return pns
+}
+
 // Get pager.
 func (table *HashTable) GetPager() *pager.Pager {
 	return table.pager
@@ -147,10 +161,10 @@ func (table *HashTable) Split(bucket *HashBucket, hash int64) error {
 		i += powInt(2, power)
 	}
 	// Check if recursive splitting is required
-	if oldNKeys >= BUCKETSIZE {
+	if oldNKeys >= bucket.maxKeys() {
 		return table.Split(bucket, oldHash)
 	}
-	if newNKeys >= BUCKETSIZE {
+	if newNKeys >= newBucket.maxKeys() {
 		return table.Split(newBucket, newHash)
 	}
 	return nil
@@ -202,20 +216,91 @@ func (table *HashTable) Update(key int64, value int64) error {
 	return err2
 }
 
-// Delete the given key-value pair, does not coalesce.
+// Delete the given key-value pair. Buckets left empty are merged into
+// their buddy and their page is handed back to the pager.
 func (table *HashTable) Delete(key int64) error {
-	table.RLock()
+	// Lock table; merging may rewrite the bucket index.
+	table.WLock()
+	defer table.WUnlock()
 	hash := Hasher(key, table.depth)
 	bucket, err := table.GetAndLockBucket(hash, WRITE_LOCK)
 	if err != nil {
-		table.RUnlock()
 		return err
 	}
-	defer bucket.page.Put()
-	table.RUnlock()
-	defer bucket.WUnlock()
-	err2 := bucket.Delete(key)
-	return err2
+	err = bucket.Delete(key)
+	empty := bucket.numKeys == 0
+	bucket.WUnlock()
+	bucket.page.Put()
+	if err != nil || !empty {
+		return err
+	}
+	return table.Merge(hash)
+}
+
+// Merge folds the empty bucket at the given hash into its buddy, the bucket
+// it was split from, then frees its page. Repeats while the merged bucket
+// is empty, and shrinks the table once no bucket needs the full depth.
+// Expects the table to be write locked.
+func (table *HashTable) Merge(hash int64) error {
+	for {
+		bucket, err := table.GetAndLockBucket(hash, WRITE_LOCK)
+		if err != nil {
+			return err
+		}
+		depth := bucket.depth
+		if bucket.numKeys > 0 || depth == 0 {
+			bucket.WUnlock()
+			bucket.page.Put()
+			break
+		}
+		// Find the buddy; only buckets of the same depth can be merged.
+		localHash := hash % powInt(2, depth)
+		buddyHash := localHash ^ powInt(2, depth-1)
+		buddy, err := table.GetAndLockBucket(buddyHash, WRITE_LOCK)
+		if err != nil {
+			bucket.WUnlock()
+			bucket.page.Put()
+			return err
+		}
+		if buddy.depth != depth {
+			buddy.WUnlock()
+			buddy.page.Put()
+			bucket.WUnlock()
+			bucket.page.Put()
+			break
+		}
+		// Point the empty bucket's slots at the buddy.
+		emptyPN := bucket.page.GetPageNum()
+		for i := localHash; i < powInt(2, table.depth); i += powInt(2, depth) {
+			table.buckets[i] = buddy.page.GetPageNum()
+		}
+		buddy.updateDepth(depth - 1)
+		buddy.WUnlock()
+		buddy.page.Put()
+		bucket.WUnlock()
+		bucket.page.Put()
+		if err := table.pager.FreePage(emptyPN); err != nil {
+			return err
+		}
+		hash = buddyHash % powInt(2, depth-1)
+	}
+	table.ShrinkTable()
+	return nil
+}
+
+// ShrinkTable halves the bucket index while both halves are identical,
+// decreasing the global depth of the table accordingly.
+func (table *HashTable) ShrinkTable() {
+	for table.depth > 0 {
+		half := powInt(2, table.depth-1)
+		for i := int64(0); i < half; i++ {
+			if table.buckets[i] != table.buckets[i+half] {
+				return
+			}
+		}
+		table.depth = table.depth - 1
+		table.buckets = table.buckets[:half]
+	}
 }
 
 // Select all entries in this table.
@@ -224,8 +309,8 @@ func (table *HashTable) Select() ([]utils.Entry, error) {
 	defer table.RUnlock()
 	ret := make([]utils.Entry, 0)
 	// Lock the table before iterating over buckets
-	for i := int64(0); i < table.pager.GetNumPages(); i++ {
-		bucket, err := table.GetAndLockBucketByPN(i, READ_LOCK)
+	for _, pn := range table.GetBucketPNs() {
+		bucket, err := table.GetAndLockBucketByPN(pn, READ_LOCK)
 		if err != nil {
 			return nil, err
 		}
@@ -282,4 +367,4 @@ func (table *HashTable) PrintPN(pn int, w io.Writer) {
 // x^y
 func powInt(x, y int64) int64 {
 	return int64(math.Pow(float64(x), float64(y)))
-}
\ No newline at end of file
+}
@@ -0,0 +1,83 @@
+package pager
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Every page on disk starts with a small header holding a CRC32C of the
+// rest of the page and, in encrypted files, the nonce and authentication
+// tag. Index code only sees the bytes after the header.
+const PAGE_HEADER_SIZE = int64(32)
+
+// Number of bytes in a page of the default size that are available to
+// index code; see Pager.GetDataSize for other page sizes.
+const PAGE_DATA_SIZE = PAGESIZE - PAGE_HEADER_SIZE
+
+// Page header constants.
+var CHECKSUM_OFFSET int64 = 0
+var CHECKSUM_SIZE int64 = 4
+var NONCE_OFFSET int64 = CHECKSUM_OFFSET + CHECKSUM_SIZE
+var NONCE_SIZE int64 = 12
+var TAG_OFFSET int64 = NONCE_OFFSET + NONCE_SIZE
+var TAG_SIZE int64 = 16
+
+// Castagnoli polynomial table, as used by CRC32C.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CorruptPageError is returned when a page read from disk fails its checksum.
+type CorruptPageError struct {
+	File     string // Name of the file the page was read from.
+	PageNum  int64  // Page number of the corrupted page, or NOPAGE for the file header.
+	Stored   uint32 // Checksum stored in the page header.
+	Computed uint32 // Checksum of the page's contents.
+}
+
+// Error describes the corrupted page.
+func (err *CorruptPageError) Error() string {
+	page := fmt.Sprintf("page %v", err.PageNum)
+	if err.PageNum == NOPAGE {
+		page = "file header"
+	}
+	return fmt.Sprintf(
+		"%v: %v is corrupted: stored checksum %08x does not match computed checksum %08x",
+		err.File, page, err.Stored, err.Computed,
+	)
+}
+
+// pageChecksum returns the CRC32C of everything in a frame after the checksum.
+func pageChecksum(frame []byte) uint32 {
+	return crc32.Checksum(frame[CHECKSUM_OFFSET+CHECKSUM_SIZE:], crcTable)
+}
+
+// setChecksum stores the frame's checksum in its header.
+func setChecksum(frame []byte) {
+	binary.LittleEndian.PutUint32(frame[CHECKSUM_OFFSET:CHECKSUM_OFFSET+CHECKSUM_SIZE], pageChecksum(frame))
+}
+
+// verifyChecksum checks the frame against the checksum in its header.
+// Pages that were never written are all zeroes and have no checksum.
+func (pager *Pager) verifyChecksum(frame []byte, pagenum int64) error {
+	stored := binary.LittleEndian.Uint32(frame[CHECKSUM_OFFSET : CHECKSUM_OFFSET+CHECKSUM_SIZE])
+	computed := pageChecksum(frame)
+	if stored == computed || (stored == 0 && isZero(frame)) {
+		return nil
+	}
+	return &CorruptPageError{
+		File:     pager.GetFileName(),
+		PageNum:  pagenum,
+		Stored:   stored,
+		Computed: computed,
+	}
+}
+
+// isZero returns true if every byte in the buffer is zero.
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,335 @@
+package pager
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Compressed files store each page, compressed with DEFLATE, in a run of
+// sectors after the header page. A page map, indexed by page number, holds
+// the location of every page, so page numbers seen by the index code never
+// change. Pages are written copy-on-write: a page's old sectors are only
+// reused once a page map that no longer refers to them has been written.
+// Compressed files don't use direct IO, since sectors are smaller than the
+// direct IO block size.
+
+// Size of the units compressed pages are stored in.
+const SECTOR_SIZE = int64(512)
+
+// extent is the location of a page in a compressed file.
+type extent struct {
+	sector int64 // First sector, or NOPAGE if the page was never written.
+	length int64 // Length in bytes; a page-sized extent holds an uncompressed page.
+	fresh  bool  // Whether the extent was written after the last page map.
+}
+
+// run is a range of free sectors.
+type run struct {
+	sector int64 // First sector.
+	count  int64 // Number of sectors.
+}
+
+// compressedFile is the state of a pager whose file is compressed.
+type compressedFile struct {
+	extents    []extent      // Location of each page, indexed by pagenum.
+	free       []run         // Free sectors, sorted and coalesced.
+	pending    []run         // Sectors to free once the page map is written.
+	numSectors int64         // Number of sectors after the header page.
+	writer     *flate.Writer // Compressor, reused between pages.
+	buf        bytes.Buffer  // Buffer the compressor writes to.
+}
+
+// WithCompression makes the pager compress new files. Existing files keep
+// the mode they were created with.
+func WithCompression() Option {
+	return func(pager *Pager) {
+		pager.compressNew = true
+	}
+}
+
+// IsCompressed returns true if the pager's file is compressed.
+func (pager *Pager) IsCompressed() bool {
+	return pager.compression != nil
+}
+
+// numPages returns the number of pages in the page map.
+func (cf *compressedFile) numPages() int {
+	return len(cf.extents)
+}
+
+// sectorsFor returns the number of sectors needed to hold length bytes.
+func sectorsFor(length int64) int64 {
+	return (length + SECTOR_SIZE - 1) / SECTOR_SIZE
+}
+
+// sectorOffset returns the position of a sector in the file.
+func (pager *Pager) sectorOffset(sector int64) int64 {
+	return HEADER_PAGES*pager.GetPageSize() + sector*SECTOR_SIZE
+}
+
+// openCompressed reopens the pager's file without direct IO and loads its
+// page map. The header must have been read already.
+func (pager *Pager) openCompressed(filename string, size int64) (err error) {
+	pager.file.Close()
+	pager.file, err = pager.backend.Open(filename, false)
+	if err != nil {
+		return err
+	}
+	writer, _ := flate.NewWriter(nil, flate.BestSpeed)
+	cf := &compressedFile{writer: writer, extents: make([]extent, 0)}
+	if size > pager.GetPageSize() {
+		cf.numSectors = sectorsFor(size - pager.GetPageSize())
+	}
+	pager.compression = cf
+	// Read and check the page map.
+	if pager.header.mapSector != NOPAGE {
+		data := make([]byte, pager.header.mapLength)
+		n, err := pager.file.ReadAt(data, pager.sectorOffset(pager.header.mapSector))
+		pager.count(statBytesRead, int64(n))
+		if err != nil {
+			return err
+		}
+		if computed := crc32.Checksum(data, crcTable); computed != pager.header.mapChecksum {
+			return &CorruptPageError{
+				File:     pager.GetFileName(),
+				PageNum:  NOPAGE,
+				Stored:   pager.header.mapChecksum,
+				Computed: computed,
+			}
+		}
+		if cf.extents, err = unmarshalPageMap(data); err != nil {
+			return err
+		}
+	}
+	// Every sector not used by a page or the page map is free.
+	used := make([]run, 0, len(cf.extents)+1)
+	for _, ext := range cf.extents {
+		if ext.sector != NOPAGE {
+			used = append(used, run{ext.sector, sectorsFor(ext.length)})
+		}
+	}
+	if pager.header.mapSector != NOPAGE {
+		used = append(used, run{pager.header.mapSector, sectorsFor(pager.header.mapLength)})
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i].sector < used[j].sector })
+	next := int64(0)
+	for _, r := range used {
+		if r.sector > next {
+			cf.free = append(cf.free, run{next, r.sector - next})
+		}
+		if r.sector+r.count > next {
+			next = r.sector + r.count
+		}
+	}
+	if next < cf.numSectors {
+		cf.free = append(cf.free, run{next, cf.numSectors - next})
+	}
+	return nil
+}
+
+// marshalPageMap serializes the page map.
+func marshalPageMap(extents []extent) []byte {
+	data := make([]byte, 0, (len(extents)+1)*binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen64)
+	put := func(x int64) {
+		n := binary.PutVarint(buf, x)
+		data = append(data, buf[:n]...)
+	}
+	put(int64(len(extents)))
+	for _, ext := range extents {
+		put(ext.sector)
+		put(ext.length)
+	}
+	return data
+}
+
+// unmarshalPageMap deserializes a page map.
+func unmarshalPageMap(data []byte) ([]extent, error) {
+	reader := bytes.NewReader(data)
+	numPages, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	extents := make([]extent, numPages)
+	for i := range extents {
+		if extents[i].sector, err = binary.ReadVarint(reader); err != nil {
+			return nil, err
+		}
+		if extents[i].length, err = binary.ReadVarint(reader); err != nil {
+			return nil, err
+		}
+	}
+	return extents, nil
+}
+
+// allocate returns the first run of count free sectors, growing the file
+// if there is none.
+func (cf *compressedFile) allocate(count int64) int64 {
+	for i, r := range cf.free {
+		if r.count < count {
+			continue
+		}
+		if r.count == count {
+			cf.free = append(cf.free[:i], cf.free[i+1:]...)
+		} else {
+			cf.free[i] = run{r.sector + count, r.count - count}
+		}
+		return r.sector
+	}
+	sector := cf.numSectors
+	cf.numSectors += count
+	return sector
+}
+
+// release returns a run of sectors to the free list, coalescing neighbors.
+func (cf *compressedFile) release(r run) {
+	i := sort.Search(len(cf.free), func(i int) bool { return cf.free[i].sector > r.sector })
+	cf.free = append(cf.free, run{})
+	copy(cf.free[i+1:], cf.free[i:])
+	cf.free[i] = r
+	// Merge with the next run, then with the previous one.
+	if i+1 < len(cf.free) && r.sector+r.count == cf.free[i+1].sector {
+		cf.free[i].count += cf.free[i+1].count
+		cf.free = append(cf.free[:i+1], cf.free[i+2:]...)
+	}
+	if i > 0 && cf.free[i-1].sector+cf.free[i-1].count == cf.free[i].sector {
+		cf.free[i-1].count += cf.free[i].count
+		cf.free = append(cf.free[:i], cf.free[i+1:]...)
+	}
+}
+
+// extentOf returns the location of a page; pages that were never written
+// have no sectors.
+func (cf *compressedFile) extentOf(pagenum int64) extent {
+	if pagenum >= int64(len(cf.extents)) {
+		return extent{sector: NOPAGE}
+	}
+	return cf.extents[pagenum]
+}
+
+// readCompressedPage reads, decrypts and decompresses a page into its
+// frame, then verifies it.
+// the pool mutex should be locked on entry
+func (pager *Pager) readCompressedPage(page *Page, pagenum int64) error {
+	return pager.readExtent(*page.frame, pagenum, pager.compression.extentOf(pagenum))
+}
+
+// readExtent reads a page stored in the given extent into a frame. It
+// doesn't need the pager's locks, so prefetches can use it.
+func (pager *Pager) readExtent(frame []byte, pagenum int64, ext extent) error {
+	// Pages that were allocated but never written are empty.
+	if ext.sector == NOPAGE {
+		for i := range frame {
+			frame[i] = 0
+		}
+		return nil
+	}
+	data := make([]byte, ext.length)
+	n, err := pager.file.ReadAt(data, pager.sectorOffset(ext.sector))
+	pager.count(statBytesRead, int64(n))
+	if err != nil {
+		return err
+	}
+	if ext.length == pager.GetPageSize() {
+		copy(frame, data)
+		return pager.checkFrame(frame, pagenum)
+	}
+	if pager.IsEncrypted() {
+		if data, err = pager.cipher.Open(data, pageAAD(pagenum)); err != nil {
+			return &AuthError{File: pager.GetFileName(), PageNum: pagenum}
+		}
+	}
+	reader := flate.NewReader(bytes.NewReader(data))
+	defer reader.Close()
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return fmt.Errorf("%v: page %v could not be decompressed: %v", pager.GetFileName(), pagenum, err)
+	}
+	return pager.verifyChecksum(frame, pagenum)
+}
+
+// writeCompressedPage compresses a page, encrypting it if the file is
+// encrypted, and writes it to a new extent. Pages that don't compress are
+// stored as they would be in an uncompressed file.
+func (pager *Pager) writeCompressedPage(page *Page) (int, error) {
+	cf := pager.compression
+	cf.buf.Reset()
+	cf.writer.Reset(&cf.buf)
+	if _, err := cf.writer.Write(*page.frame); err != nil {
+		return 0, err
+	}
+	if err := cf.writer.Close(); err != nil {
+		return 0, err
+	}
+	data := cf.buf.Bytes()
+	if pager.IsEncrypted() {
+		data = pager.cipher.Seal(data, pageAAD(page.pagenum))
+	}
+	if int64(len(data)) >= pager.GetPageSize() {
+		data = *page.frame
+		if pager.IsEncrypted() {
+			data = pager.sealFrame(data, page.pagenum)
+		}
+	}
+	for int64(len(cf.extents)) <= page.pagenum {
+		cf.extents = append(cf.extents, extent{sector: NOPAGE})
+	}
+	// Give up the old extent; right away if no page map refers to it.
+	if old := cf.extents[page.pagenum]; old.sector != NOPAGE {
+		if old.fresh {
+			cf.release(run{old.sector, sectorsFor(old.length)})
+		} else {
+			cf.pending = append(cf.pending, run{old.sector, sectorsFor(old.length)})
+		}
+	}
+	length := int64(len(data))
+	sector := cf.allocate(sectorsFor(length))
+	cf.extents[page.pagenum] = extent{sector: sector, length: length, fresh: true}
+	pager.header.dirty = true
+	return pager.file.WriteAt(data, pager.sectorOffset(sector))
+}
+
+// writePageMap writes the page map to new sectors and points the header at
+// it. Sectors given up since the last page map can be reused afterwards.
+// The pages and the map are synced before the header is written, so that
+// a crash leaves the header pointing at a whole map, old or new. Failures
+// switch the pager to read-only mode.
+// the pool mutex should be locked on entry
+func (pager *Pager) writePageMap() error {
+	cf := pager.compression
+	if !pager.header.dirty {
+		return nil
+	}
+	data := marshalPageMap(cf.extents)
+	sector := cf.allocate(sectorsFor(int64(len(data))))
+	n, err := pager.file.WriteAt(data, pager.sectorOffset(sector))
+	pager.count(statBytesWritten, int64(n))
+	if err != nil {
+		return pager.fail(NOPAGE, err)
+	}
+	if err := pager.file.Sync(); err != nil {
+		return pager.failSync(err)
+	}
+	if pager.header.mapSector != NOPAGE {
+		cf.pending = append(cf.pending, run{pager.header.mapSector, sectorsFor(pager.header.mapLength)})
+	}
+	pager.header.mapSector = sector
+	pager.header.mapLength = int64(len(data))
+	pager.header.mapChecksum = crc32.Checksum(data, crcTable)
+	if err := pager.writeHeader(); err != nil {
+		return pager.fail(NOPAGE, err)
+	}
+	// The new page map is in place; nothing refers to the old sectors.
+	for _, r := range cf.pending {
+		cf.release(r)
+	}
+	cf.pending = cf.pending[:0]
+	for i := range cf.extents {
+		cf.extents[i].fresh = false
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package pager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	directio "github.com/ncw/directio"
+)
+
+// Encrypted files seal the data of every page with AES-GCM. The nonce and
+// authentication tag live in the page header, and the page number is
+// authenticated along with the data, so pages can't be moved around. The
+// checksum covers the encrypted page, so a damaged page is still reported
+// as a CorruptPageError; a page that passes its checksum but fails
+// authentication was written with a different key. The file header stays
+// in the clear, but holds a key check that Open uses to reject a wrong key.
+
+// Environment variable that a hex-encoded key is read from.
+const KEY_ENV_VAR = "BUMBLE_KEY"
+
+// Cipher encrypts and authenticates data with AES-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a Cipher using the given 16, 24 or 32-byte AES key.
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %v", err)
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// ParseKey decodes a hex-encoded key, ignoring surrounding whitespace.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, errors.New("encryption: key must be hex-encoded")
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return nil, fmt.Errorf("encryption: key must be 16, 24 or 32 bytes long, not %v", len(key))
+	}
+	return key, nil
+}
+
+// LoadKey reads a hex-encoded key from the given file, or from the
+// KEY_ENV_VAR environment variable if no file is given. Returns nil if
+// there is neither.
+func LoadKey(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseKey(string(data))
+	}
+	if s, ok := os.LookupEnv(KEY_ENV_VAR); ok {
+		return ParseKey(s)
+	}
+	return nil, nil
+}
+
+// Seal encrypts and authenticates a message along with some additional
+// data, returning the nonce followed by the ciphertext and tag.
+func (c *Cipher) Seal(plaintext []byte, additional []byte) []byte {
+	sealed := make([]byte, NONCE_SIZE, NONCE_SIZE+int64(len(plaintext))+TAG_SIZE)
+	rand.Read(sealed)
+	return c.aead.Seal(sealed, sealed, plaintext, additional)
+}
+
+// Open authenticates and decrypts a message returned by Seal.
+func (c *Cipher) Open(sealed []byte, additional []byte) ([]byte, error) {
+	if int64(len(sealed)) < NONCE_SIZE+TAG_SIZE {
+		return nil, errors.New("encryption: message is too short")
+	}
+	return c.aead.Open(nil, sealed[:NONCE_SIZE], sealed[NONCE_SIZE:], additional)
+}
+
+// AuthError is returned when encrypted data fails authentication, which
+// almost always means it was encrypted with a different key.
+type AuthError struct {
+	File    string // Name of the file the data was read from.
+	PageNum int64  // Page number of the page, or NOPAGE for the file as a whole.
+}
+
+// Error describes the failed authentication.
+func (err *AuthError) Error() string {
+	if err.PageNum == NOPAGE {
+		return fmt.Sprintf("%v: authentication failed; wrong encryption key", err.File)
+	}
+	return fmt.Sprintf("%v: page %v failed authentication; wrong encryption key or tampered page", err.File, err.PageNum)
+}
+
+// WithCipher makes the pager encrypt new files with the given cipher, and
+// open encrypted files with it. A nil cipher leaves the pager unencrypted.
+func WithCipher(c *Cipher) Option {
+	return func(pager *Pager) {
+		pager.cipher = c
+	}
+}
+
+// GetCipher returns the pager's cipher, or nil if it doesn't encrypt.
+func (pager *Pager) GetCipher() *Cipher {
+	return pager.cipher
+}
+
+// IsEncrypted returns true if the pager's file is encrypted.
+func (pager *Pager) IsEncrypted() bool {
+	return pager.HasFile() && pager.header.flags&FLAG_ENCRYPTED != 0
+}
+
+// keyCheckData is authenticated by the key check in the file header.
+var keyCheckData = []byte(FILE_MAGIC)
+
+// checkKey checks that the pager's cipher matches the file it opened.
+func (pager *Pager) checkKey() error {
+	encrypted := pager.header.flags&FLAG_ENCRYPTED != 0
+	switch {
+	case encrypted && pager.cipher == nil:
+		return fmt.Errorf("open: %v is encrypted, but no key was given", pager.GetFileName())
+	case !encrypted && pager.cipher != nil:
+		return fmt.Errorf("open: %v is not encrypted, but a key was given", pager.GetFileName())
+	case encrypted:
+		if _, err := pager.cipher.Open(pager.header.keyCheck, keyCheckData); err != nil {
+			return &AuthError{File: pager.GetFileName(), PageNum: NOPAGE}
+		}
+	}
+	return nil
+}
+
+// pageAAD returns the additional data authenticated with a page.
+func pageAAD(pagenum int64) []byte {
+	aad := make([]byte, 8)
+	binary.LittleEndian.PutUint64(aad, uint64(pagenum))
+	return aad
+}
+
+// scratch returns the pager's buffer for sealing pages, big enough for a
+// page and a tag.
+// the pool mutex should be locked on entry
+func (pager *Pager) scratch() []byte {
+	if int64(len(pager.cryptBuf)) != pager.GetPageSize()+TAG_SIZE {
+		pager.cryptBuf = directio.AlignedBlock(int(pager.GetPageSize() + TAG_SIZE))
+	}
+	return pager.cryptBuf
+}
+
+// sealFrame encrypts a frame into the pager's scratch buffer, leaving the
+// frame alone, and returns the page as it should be written.
+// the pool mutex should be locked on entry
+func (pager *Pager) sealFrame(frame []byte, pagenum int64) []byte {
+	size := int64(len(frame))
+	sealed := pager.scratch()
+	nonce := sealed[NONCE_OFFSET : NONCE_OFFSET+NONCE_SIZE]
+	rand.Read(nonce)
+	// The tag is appended after the ciphertext; move it into the header.
+	pager.cipher.aead.Seal(sealed[PAGE_HEADER_SIZE:PAGE_HEADER_SIZE], nonce, frame[PAGE_HEADER_SIZE:], pageAAD(pagenum))
+	copy(sealed[TAG_OFFSET:TAG_OFFSET+TAG_SIZE], sealed[size:size+TAG_SIZE])
+	setChecksum(sealed[:size])
+	return sealed[:size]
+}
+
+// openFrame decrypts a frame read from disk in place. Pages that were
+// never written are all zeroes and are left alone.
+func (pager *Pager) openFrame(frame []byte, pagenum int64) error {
+	if isZero(frame) {
+		return nil
+	}
+	// Open wants the tag after the ciphertext.
+	size := int64(len(frame)) - PAGE_HEADER_SIZE
+	sealed := make([]byte, size+TAG_SIZE)
+	copy(sealed, frame[PAGE_HEADER_SIZE:])
+	copy(sealed[size:], frame[TAG_OFFSET:TAG_OFFSET+TAG_SIZE])
+	nonce := frame[NONCE_OFFSET : NONCE_OFFSET+NONCE_SIZE]
+	if _, err := pager.cipher.aead.Open(frame[PAGE_HEADER_SIZE:PAGE_HEADER_SIZE], nonce, sealed[:size+TAG_SIZE], pageAAD(pagenum)); err != nil {
+		return &AuthError{File: pager.GetFileName(), PageNum: pagenum}
+	}
+	return nil
+}
+
+// checkFrame verifies a page read from disk as is, and decrypts it if the
+// file is encrypted.
+func (pager *Pager) checkFrame(frame []byte, pagenum int64) error {
+	if err := pager.verifyChecksum(frame, pagenum); err != nil {
+		return err
+	}
+	if !pager.IsEncrypted() {
+		return nil
+	}
+	return pager.openFrame(frame, pagenum)
+}
@@ -0,0 +1,229 @@
+package pager
+
+import (
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// A FaultBackend wraps another backend and makes some of the reads, writes
+// and syncs to it go wrong, so that tests can check how the pager copes
+// with a failing disk. A schedule picks the fault, if any, for each
+// operation.
+
+// Fault is something that can go wrong with a read, a write or a sync.
+type Fault int
+
+const (
+	FaultNone      Fault = iota // The operation goes through.
+	FaultDropWrite              // The write reports success but never happens.
+	FaultTearWrite              // Only the first half of the write happens, but it reports success.
+	FaultFailWrite              // The write fails with EIO and nothing is written.
+	FaultFailRead               // The read fails with EIO.
+	FaultShortRead              // Only the first half is read, and the read returns io.EOF.
+	FaultFailSync               // The sync fails with EIO.
+)
+
+// Op describes a read, write or sync that a schedule decides the fate of.
+type Op struct {
+	Write  bool   // Whether this is a write.
+	Sync   bool   // Whether this is a sync.
+	N      int64  // Number of earlier operations of the same kind, counting from 0.
+	Name   string // Name of the storage.
+	Offset int64  // Position in the storage; 0 for a sync.
+	Length int    // Number of bytes; 0 for a sync.
+}
+
+// Schedule decides which fault, if any, hits an operation. Faults that
+// don't apply to the kind of operation are ignored.
+type Schedule func(op Op) Fault
+
+// FaultAt returns a schedule that hits the given operations with a fault.
+// Operations are numbered separately for reads, writes and syncs, and only
+// operations of the kind the fault applies to are hit.
+func FaultAt(fault Fault, ns ...int64) Schedule {
+	return func(op Op) Fault {
+		if !fault.appliesTo(op) {
+			return FaultNone
+		}
+		for _, n := range ns {
+			if op.N == n {
+				return fault
+			}
+		}
+		return FaultNone
+	}
+}
+
+// FaultAfter returns a schedule that hits every operation of the kind the
+// fault applies to from the nth one onwards.
+func FaultAfter(fault Fault, n int64) Schedule {
+	return func(op Op) Fault {
+		if !fault.appliesTo(op) || op.N < n {
+			return FaultNone
+		}
+		return fault
+	}
+}
+
+// appliesTo returns true if the fault applies to the kind of operation.
+func (fault Fault) appliesTo(op Op) bool {
+	switch fault {
+	case FaultDropWrite, FaultTearWrite, FaultFailWrite:
+		return op.Write
+	case FaultFailSync:
+		return op.Sync
+	}
+	return !op.Write && !op.Sync
+}
+
+// FaultBackend injects faults into the storage of another backend.
+type FaultBackend struct {
+	inner    Backend
+	mtx      sync.Mutex
+	schedule Schedule
+	reads    int64
+	writes   int64
+	syncs    int64
+}
+
+// faultStorage is storage opened through a FaultBackend.
+type faultStorage struct {
+	Storage
+	backend *FaultBackend
+}
+
+// NewFaultBackend returns a FaultBackend wrapping the given backend. It
+// doesn't inject any faults until it is given a schedule.
+func NewFaultBackend(inner Backend) *FaultBackend {
+	return &FaultBackend{inner: inner}
+}
+
+// SetSchedule replaces the backend's schedule and restarts the count of
+// operations. A nil schedule stops injecting faults.
+func (backend *FaultBackend) SetSchedule(schedule Schedule) {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	backend.schedule = schedule
+	backend.reads = 0
+	backend.writes = 0
+	backend.syncs = 0
+}
+
+// Reads returns the number of reads since the schedule was last set.
+func (backend *FaultBackend) Reads() int64 {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	return backend.reads
+}
+
+// Writes returns the number of writes since the schedule was last set.
+func (backend *FaultBackend) Writes() int64 {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	return backend.writes
+}
+
+// Syncs returns the number of syncs since the schedule was last set.
+func (backend *FaultBackend) Syncs() int64 {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	return backend.syncs
+}
+
+// Open opens storage in the wrapped backend.
+func (backend *FaultBackend) Open(name string, direct bool) (Storage, error) {
+	storage, err := backend.inner.Open(name, direct)
+	if err != nil {
+		return nil, err
+	}
+	return &faultStorage{Storage: storage, backend: backend}, nil
+}
+
+// Exists checks the wrapped backend.
+func (backend *FaultBackend) Exists(name string) bool {
+	return backend.inner.Exists(name)
+}
+
+// Remove deletes storage from the wrapped backend.
+func (backend *FaultBackend) Remove(name string) error {
+	return backend.inner.Remove(name)
+}
+
+// List lists the wrapped backend's storage.
+func (backend *FaultBackend) List(dir string) ([]string, error) {
+	return backend.inner.List(dir)
+}
+
+// next counts an operation and returns the fault that hits it.
+func (backend *FaultBackend) next(op Op) Fault {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	if op.Write {
+		op.N = backend.writes
+		backend.writes++
+	} else if op.Sync {
+		op.N = backend.syncs
+		backend.syncs++
+	} else {
+		op.N = backend.reads
+		backend.reads++
+	}
+	if backend.schedule == nil {
+		return FaultNone
+	}
+	return backend.schedule(op)
+}
+
+// ReadAt reads from the wrapped storage, unless a fault gets in the way.
+func (storage *faultStorage) ReadAt(p []byte, off int64) (int, error) {
+	op := Op{Name: storage.Name(), Offset: off, Length: len(p)}
+	switch storage.backend.next(op) {
+	case FaultFailRead:
+		return 0, &os.PathError{Op: "read", Path: storage.Name(), Err: syscall.EIO}
+	case FaultShortRead:
+		n, err := storage.Storage.ReadAt(p[:halfOf(len(p))], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return storage.Storage.ReadAt(p, off)
+}
+
+// WriteAt writes to the wrapped storage, unless a fault gets in the way.
+func (storage *faultStorage) WriteAt(p []byte, off int64) (int, error) {
+	op := Op{Write: true, Name: storage.Name(), Offset: off, Length: len(p)}
+	switch storage.backend.next(op) {
+	case FaultDropWrite:
+		return len(p), nil
+	case FaultTearWrite:
+		if _, err := storage.Storage.WriteAt(p[:halfOf(len(p))], off); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case FaultFailWrite:
+		return 0, &os.PathError{Op: "write", Path: storage.Name(), Err: syscall.EIO}
+	}
+	return storage.Storage.WriteAt(p, off)
+}
+
+// Sync syncs the wrapped storage, unless a fault gets in the way.
+func (storage *faultStorage) Sync() error {
+	op := Op{Sync: true, Name: storage.Name()}
+	if storage.backend.next(op) == FaultFailSync {
+		return &os.PathError{Op: "sync", Path: storage.Name(), Err: syscall.EIO}
+	}
+	return storage.Storage.Sync()
+}
+
+// halfOf returns half of a length, rounded down to a whole number of
+// sectors where possible, the way a torn write usually ends.
+func halfOf(length int) int {
+	half := length / 2
+	if aligned := half - half%int(SECTOR_SIZE); aligned > 0 {
+		return aligned
+	}
+	return half
+}
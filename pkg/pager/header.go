@@ -0,0 +1,232 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	directio "github.com/ncw/directio"
+)
+
+// Every file managed by a pager starts with a header page that identifies
+// the file and holds the head of the free page list. Page numbers handed
+// out by the pager start at the page after the header.
+const HEADER_PAGES = 1
+
+// Magic string at the start of every pager file.
+const FILE_MAGIC = "BUMBLEDB"
+
+// Current file format version. Version 2 added page checksums, version 3
+// recorded the page size, version 4 added compressed files, version 5
+// added encrypted files and widened the page header.
+const FILE_VERSION = 5
+
+// Header flags.
+const FLAG_COMPRESSED = 1 // Pages are compressed; see compress.go.
+const FLAG_ENCRYPTED = 2  // Pages are encrypted; see encrypt.go.
+
+// File header constants.
+var MAGIC_OFFSET int64 = 0
+var MAGIC_SIZE int64 = int64(len(FILE_MAGIC))
+var VERSION_OFFSET int64 = MAGIC_OFFSET + MAGIC_SIZE
+var VERSION_SIZE int64 = binary.MaxVarintLen64
+var PAGE_SIZE_OFFSET int64 = VERSION_OFFSET + VERSION_SIZE
+var PAGE_SIZE_SIZE int64 = binary.MaxVarintLen64
+var FLAGS_OFFSET int64 = PAGE_SIZE_OFFSET + PAGE_SIZE_SIZE
+var FLAGS_SIZE int64 = binary.MaxVarintLen64
+var MAP_SECTOR_OFFSET int64 = FLAGS_OFFSET + FLAGS_SIZE
+var MAP_SECTOR_SIZE int64 = binary.MaxVarintLen64
+var MAP_LENGTH_OFFSET int64 = MAP_SECTOR_OFFSET + MAP_SECTOR_SIZE
+var MAP_LENGTH_SIZE int64 = binary.MaxVarintLen64
+var MAP_CHECKSUM_OFFSET int64 = MAP_LENGTH_OFFSET + MAP_LENGTH_SIZE
+var MAP_CHECKSUM_SIZE int64 = 4
+var FREE_HEAD_OFFSET int64 = MAP_CHECKSUM_OFFSET + MAP_CHECKSUM_SIZE
+var FREE_HEAD_SIZE int64 = binary.MaxVarintLen64
+var NUM_FREE_OFFSET int64 = FREE_HEAD_OFFSET + FREE_HEAD_SIZE
+var NUM_FREE_SIZE int64 = binary.MaxVarintLen64
+var KEY_CHECK_OFFSET int64 = NUM_FREE_OFFSET + NUM_FREE_SIZE
+var KEY_CHECK_SIZE int64 = NONCE_SIZE + TAG_SIZE
+var HEADER_CHECKSUM_OFFSET int64 = KEY_CHECK_OFFSET + KEY_CHECK_SIZE
+var HEADER_CHECKSUM_SIZE int64 = 4
+
+// Freed pages are chained together; each one holds a marker and the
+// page number of the next free page.
+const FREE_PAGE_MAGIC = "FREEPAGE"
+
+// Free page constants.
+var FREE_MAGIC_OFFSET int64 = 0
+var FREE_MAGIC_SIZE int64 = int64(len(FREE_PAGE_MAGIC))
+var FREE_NEXT_OFFSET int64 = FREE_MAGIC_OFFSET + FREE_MAGIC_SIZE
+var FREE_NEXT_SIZE int64 = binary.MaxVarintLen64
+
+// fileHeader is the in-memory copy of a file's header page.
+type fileHeader struct {
+	version     int64  // Format version the file was written with.
+	pageSize    int64  // Size of every page in the file, including the header page.
+	flags       int64  // Header flags.
+	mapSector   int64  // First sector of a compressed file's page map, or NOPAGE.
+	mapLength   int64  // Length of the page map in bytes.
+	mapChecksum uint32 // CRC32C of the page map.
+	freeHead    int64  // First page of the free page list, or NOPAGE.
+	numFree     int64  // Number of pages on the free page list.
+	keyCheck    []byte // Empty message sealed with an encrypted file's key.
+	dirty       bool   // Whether the header has to be written back.
+}
+
+// newFileHeader returns the header of an empty file with the given page size.
+func newFileHeader(pageSize int64) fileHeader {
+	return fileHeader{version: FILE_VERSION, pageSize: pageSize, mapSector: NOPAGE, freeHead: NOPAGE, dirty: true}
+}
+
+// marshal serializes the header into a page-sized buffer.
+func (header *fileHeader) marshal() []byte {
+	data := directio.AlignedBlock(int(header.pageSize))
+	copy(data[MAGIC_OFFSET:MAGIC_OFFSET+MAGIC_SIZE], FILE_MAGIC)
+	binary.PutVarint(data[VERSION_OFFSET:VERSION_OFFSET+VERSION_SIZE], header.version)
+	binary.PutVarint(data[PAGE_SIZE_OFFSET:PAGE_SIZE_OFFSET+PAGE_SIZE_SIZE], header.pageSize)
+	binary.PutVarint(data[FLAGS_OFFSET:FLAGS_OFFSET+FLAGS_SIZE], header.flags)
+	binary.PutVarint(data[MAP_SECTOR_OFFSET:MAP_SECTOR_OFFSET+MAP_SECTOR_SIZE], header.mapSector)
+	binary.PutVarint(data[MAP_LENGTH_OFFSET:MAP_LENGTH_OFFSET+MAP_LENGTH_SIZE], header.mapLength)
+	binary.LittleEndian.PutUint32(data[MAP_CHECKSUM_OFFSET:MAP_CHECKSUM_OFFSET+MAP_CHECKSUM_SIZE], header.mapChecksum)
+	binary.PutVarint(data[FREE_HEAD_OFFSET:FREE_HEAD_OFFSET+FREE_HEAD_SIZE], header.freeHead)
+	binary.PutVarint(data[NUM_FREE_OFFSET:NUM_FREE_OFFSET+NUM_FREE_SIZE], header.numFree)
+	copy(data[KEY_CHECK_OFFSET:KEY_CHECK_OFFSET+KEY_CHECK_SIZE], header.keyCheck)
+	binary.LittleEndian.PutUint32(
+		data[HEADER_CHECKSUM_OFFSET:HEADER_CHECKSUM_OFFSET+HEADER_CHECKSUM_SIZE],
+		crc32.Checksum(data[:HEADER_CHECKSUM_OFFSET], crcTable),
+	)
+	return data
+}
+
+// unmarshalFileHeader deserializes a header page.
+func unmarshalFileHeader(data []byte) (header fileHeader, err error) {
+	if !bytes.Equal(data[MAGIC_OFFSET:MAGIC_OFFSET+MAGIC_SIZE], []byte(FILE_MAGIC)) {
+		return header, errors.New("open: file is missing its header; not a database file")
+	}
+	header.version, _ = binary.Varint(data[VERSION_OFFSET : VERSION_OFFSET+VERSION_SIZE])
+	if header.version != FILE_VERSION {
+		return header, fmt.Errorf("open: unsupported file format version %v", header.version)
+	}
+	stored := binary.LittleEndian.Uint32(data[HEADER_CHECKSUM_OFFSET : HEADER_CHECKSUM_OFFSET+HEADER_CHECKSUM_SIZE])
+	if computed := crc32.Checksum(data[:HEADER_CHECKSUM_OFFSET], crcTable); stored != computed {
+		return header, &CorruptPageError{PageNum: NOPAGE, Stored: stored, Computed: computed}
+	}
+	header.pageSize, _ = binary.Varint(data[PAGE_SIZE_OFFSET : PAGE_SIZE_OFFSET+PAGE_SIZE_SIZE])
+	header.flags, _ = binary.Varint(data[FLAGS_OFFSET : FLAGS_OFFSET+FLAGS_SIZE])
+	header.mapSector, _ = binary.Varint(data[MAP_SECTOR_OFFSET : MAP_SECTOR_OFFSET+MAP_SECTOR_SIZE])
+	header.mapLength, _ = binary.Varint(data[MAP_LENGTH_OFFSET : MAP_LENGTH_OFFSET+MAP_LENGTH_SIZE])
+	header.mapChecksum = binary.LittleEndian.Uint32(data[MAP_CHECKSUM_OFFSET : MAP_CHECKSUM_OFFSET+MAP_CHECKSUM_SIZE])
+	header.freeHead, _ = binary.Varint(data[FREE_HEAD_OFFSET : FREE_HEAD_OFFSET+FREE_HEAD_SIZE])
+	header.numFree, _ = binary.Varint(data[NUM_FREE_OFFSET : NUM_FREE_OFFSET+NUM_FREE_SIZE])
+	header.keyCheck = append([]byte{}, data[KEY_CHECK_OFFSET:KEY_CHECK_OFFSET+KEY_CHECK_SIZE]...)
+	return header, nil
+}
+
+// readHeader reads the header page from disk, checking that the file uses
+// the same page size as the pager's buffer pool. The header's fields all
+// fit in the smallest page size, so only that much is read.
+func (pager *Pager) readHeader() error {
+	data := directio.AlignedBlock(int(PAGESIZE))
+	n, err := pager.file.ReadAt(data, 0)
+	pager.count(statBytesRead, int64(n))
+	if err != nil {
+		return err
+	}
+	header, err := unmarshalFileHeader(data)
+	if corrupt, ok := err.(*CorruptPageError); ok {
+		corrupt.File = pager.GetFileName()
+	}
+	if err != nil {
+		return err
+	}
+	if header.pageSize != pager.GetPageSize() {
+		return fmt.Errorf(
+			"open: %v has a page size of %v, but the buffer pool uses %v",
+			pager.GetFileName(), header.pageSize, pager.GetPageSize(),
+		)
+	}
+	pager.header = header
+	return nil
+}
+
+// writeHeader writes the header page back to disk if it has changed.
+func (pager *Pager) writeHeader() error {
+	if !pager.HasFile() || !pager.header.dirty {
+		return nil
+	}
+	n, err := pager.file.WriteAt(pager.header.marshal(), 0)
+	pager.count(statBytesWritten, int64(n))
+	if err != nil {
+		return err
+	}
+	pager.header.dirty = false
+	return nil
+}
+
+// GetNumFreePages returns the number of pages on the free page list.
+func (pager *Pager) GetNumFreePages() int64 {
+	pager.lock()
+	defer pager.unlock()
+	return pager.header.numFree
+}
+
+// isFreePage returns true if the page holds a free page marker.
+func isFreePage(page *Page) bool {
+	marker := (*page.data)[FREE_MAGIC_OFFSET : FREE_MAGIC_OFFSET+FREE_MAGIC_SIZE]
+	return bytes.Equal(marker, []byte(FREE_PAGE_MAGIC))
+}
+
+// popFreePage takes the first page off the free page list and zeroes it.
+// Returns NOPAGE if the list is empty.
+// the pool mutex should be locked on entry
+func (pager *Pager) popFreePage() (pagenum int64, err error) {
+	if pager.header.freeHead == NOPAGE {
+		return NOPAGE, nil
+	}
+	page, err := pager.getPage(pager.header.freeHead)
+	if err != nil {
+		return NOPAGE, err
+	}
+	defer pager.unpin(page)
+	if !isFreePage(page) {
+		return NOPAGE, fmt.Errorf("free page list is corrupted at page %v", page.pagenum)
+	}
+	next, _ := binary.Varint((*page.data)[FREE_NEXT_OFFSET : FREE_NEXT_OFFSET+FREE_NEXT_SIZE])
+	// Hand the page out looking like a freshly allocated one.
+	page.zero()
+	pager.header.freeHead = next
+	pager.header.numFree--
+	pager.header.dirty = true
+	return page.pagenum, nil
+}
+
+// FreePage returns a page to the free page list so that GetFreePN can hand
+// it out again. The caller must not use the page afterwards.
+func (pager *Pager) FreePage(pagenum int64) error {
+	pager.lock()
+	defer pager.unlock()
+	if err := pager.CheckWritable(); err != nil {
+		return err
+	}
+	if pagenum < 0 || pagenum >= pager.maxPageNum {
+		return fmt.Errorf("free: invalid pagenum %v", pagenum)
+	}
+	page, err := pager.getPage(pagenum)
+	if err != nil {
+		return err
+	}
+	defer pager.unpin(page)
+	if isFreePage(page) {
+		return fmt.Errorf("free: page %v is already free", pagenum)
+	}
+	// Overwrite the page with a link to the rest of the list.
+	page.zero()
+	copy((*page.data)[FREE_MAGIC_OFFSET:FREE_MAGIC_OFFSET+FREE_MAGIC_SIZE], FREE_PAGE_MAGIC)
+	binary.PutVarint((*page.data)[FREE_NEXT_OFFSET:FREE_NEXT_OFFSET+FREE_NEXT_SIZE], pager.header.freeHead)
+	pager.header.freeHead = pagenum
+	pager.header.numFree++
+	pager.header.dirty = true
+	return nil
+}
@@ -0,0 +1,167 @@
+package pager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// A memory-mapped pager opens an existing file read-only and serves pages
+// straight out of a mapping of it, so scans don't copy pages into the
+// buffer pool or evict anyone else's. Pages are Page structs whose frames
+// point into the mapping; they live only while pinned, so that concurrent
+// users of a page share its locks. Each page's checksum is verified the
+// first time it is used. Compressed and encrypted files can't be mapped,
+// since their pages aren't stored as they are used.
+
+// Mappable is implemented by storage whose contents can be mapped into
+// memory.
+type Mappable interface {
+	// Map returns the storage's contents, which must not be written to,
+	// and a function that releases them.
+	Map() (data []byte, unmap func() error, err error)
+}
+
+// mapping is the state of a memory-mapped pager.
+type mapping struct {
+	mtx      sync.Mutex
+	data     []byte          // Contents of the file; nil once closed.
+	unmap    func() error    // Releases data.
+	pages    map[int64]*Page // Pinned pages.
+	verified []bool          // Pages that passed their checksum, indexed by pagenum.
+}
+
+// errMapped is why a memory-mapped pager is read-only.
+var errMapped = errors.New("memory-mapped pagers never write")
+
+// WithMmap makes the pager open an existing file read-only and serve pages
+// from a memory mapping of it instead of the buffer pool.
+func WithMmap() Option {
+	return func(pager *Pager) {
+		pager.mapNew = true
+	}
+}
+
+// IsMapped returns true if the pager serves pages from a memory mapping.
+func (pager *Pager) IsMapped() bool {
+	return pager.mapping != nil
+}
+
+// openMapped opens and maps a file for Open.
+func (pager *Pager) openMapped(filename string) (err error) {
+	if !pager.backend.Exists(filename) {
+		return fmt.Errorf("open: %v doesn't exist; memory-mapped pagers can't create files", filename)
+	}
+	if pager.file, err = pager.backend.Open(filename, false); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			pager.file.Close()
+			pager.file = nil
+		}
+	}()
+	size, err := pager.file.Size()
+	if err != nil {
+		return err
+	}
+	if err = pager.readHeader(); err != nil {
+		return err
+	}
+	if err = pager.checkKey(); err != nil {
+		return err
+	}
+	if pager.header.flags&(FLAG_COMPRESSED|FLAG_ENCRYPTED) != 0 {
+		return fmt.Errorf("open: %v is compressed or encrypted, so it can't be memory-mapped", pager.GetFileName())
+	}
+	if size%pager.GetPageSize() != 0 {
+		return errors.New("open: DB file has been corrupted")
+	}
+	mappable, ok := pager.file.(Mappable)
+	if !ok {
+		return fmt.Errorf("open: %v is kept in a backend that can't be memory-mapped", pager.GetFileName())
+	}
+	data, unmap, err := mappable.Map()
+	if err != nil {
+		return err
+	}
+	pager.maxPageNum = size/pager.GetPageSize() - HEADER_PAGES
+	pager.mapping = &mapping{
+		data:     data,
+		unmap:    unmap,
+		pages:    make(map[int64]*Page),
+		verified: make([]bool, pager.maxPageNum),
+	}
+	return nil
+}
+
+// closeMapped releases the mapping for Close.
+func (pager *Pager) closeMapped() (err error) {
+	m := pager.mapping
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if len(m.pages) > 0 {
+		fmt.Println("ERROR: pages are still pinned on close")
+		if pager.IsTrackingPins() {
+			pager.ReportPins(os.Stdout)
+		}
+	}
+	if m.data != nil {
+		err = m.unmap()
+		m.data = nil
+	}
+	if closeErr := pager.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// getMappedPage returns a page of a memory-mapped pager, pinned.
+func (pager *Pager) getMappedPage(pagenum int64) (*Page, error) {
+	if pagenum < 0 {
+		return nil, errors.New("invalid pagenum")
+	}
+	if pagenum >= pager.maxPageNum {
+		return nil, pager.CheckWritable()
+	}
+	m := pager.mapping
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if m.data == nil {
+		return nil, errors.New("pager is closed")
+	}
+	if page, ok := m.pages[pagenum]; ok {
+		page.Get()
+		return page, nil
+	}
+	offset := pager.pageOffset(pagenum)
+	frame := m.data[offset : offset+pager.GetPageSize() : offset+pager.GetPageSize()]
+	if !m.verified[pagenum] {
+		if err := pager.verifyChecksum(frame, pagenum); err != nil {
+			return nil, err
+		}
+		m.verified[pagenum] = true
+	}
+	data := frame[PAGE_HEADER_SIZE:]
+	page := &Page{pager: pager, pagenum: pagenum, pinCount: 1, frame: &frame, data: &data}
+	m.pages[pagenum] = page
+	pager.trackPin(page)
+	return page, nil
+}
+
+// putMapped releases a reference to a page of a memory-mapped pager.
+func (pager *Pager) putMapped(page *Page) {
+	m := pager.mapping
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	pager.trackUnpin(page)
+	ret := atomic.AddInt64(&page.pinCount, -1)
+	if ret == 0 {
+		delete(m.pages, page.pagenum)
+	}
+	if ret < 0 {
+		fmt.Println("ERROR: pinCount for page is < 0")
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package pager
+
+import (
+	"errors"
+)
+
+// Map fails; memory-mapped pagers aren't supported on this platform.
+func (storage fileStorage) Map() ([]byte, func() error, error) {
+	return nil, nil, errors.New("open: memory mapping isn't supported on this platform")
+}
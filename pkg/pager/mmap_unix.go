@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package pager
+
+import (
+	"os"
+	"syscall"
+)
+
+// Map maps the file into memory read-only.
+func (storage fileStorage) Map() ([]byte, func() error, error) {
+	size, err := storage.Size()
+	if err != nil {
+		return nil, nil, err
+	}
+	if size == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(storage.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, &os.PathError{Op: "mmap", Path: storage.Name(), Err: err}
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
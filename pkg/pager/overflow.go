@@ -0,0 +1,92 @@
+package pager
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Values too large for an index's pages are kept in chains of overflow
+// pages. Each overflow page holds the page number of the next page in the
+// chain, or NOPAGE, followed by as much of the value as fits. The index
+// keeps the first page number and the value's length, which tells how
+// much of the last page is used.
+
+// Overflow page constants.
+var OVERFLOW_NEXT_OFFSET int64 = 0
+var OVERFLOW_NEXT_SIZE int64 = binary.MaxVarintLen64
+var OVERFLOW_DATA_OFFSET int64 = OVERFLOW_NEXT_OFFSET + OVERFLOW_NEXT_SIZE
+
+// overflowChunk returns the number of bytes of a value each overflow page holds.
+func (pager *Pager) overflowChunk() int64 {
+	return pager.GetDataSize() - OVERFLOW_DATA_OFFSET
+}
+
+// WriteOverflow stores a value in a new chain of overflow pages and returns
+// the chain's first page, or NOPAGE if the value is empty.
+func (pager *Pager) WriteOverflow(data []byte) (int64, error) {
+	if err := pager.CheckWritable(); err != nil {
+		return NOPAGE, err
+	}
+	chunk := pager.overflowChunk()
+	// Write the chain back to front, so that each page can link to the next.
+	next := int64(NOPAGE)
+	for end := int64(len(data)); end > 0; {
+		start := ((end - 1) / chunk) * chunk
+		page, err := pager.GetNewPage()
+		if err != nil {
+			pager.FreeOverflow(next)
+			return NOPAGE, err
+		}
+		nextData := make([]byte, OVERFLOW_NEXT_SIZE)
+		binary.PutVarint(nextData, next)
+		page.Update(nextData, OVERFLOW_NEXT_OFFSET, OVERFLOW_NEXT_SIZE)
+		page.Update(data[start:end], OVERFLOW_DATA_OFFSET, end-start)
+		next = page.GetPageNum()
+		page.Put()
+		end = start
+	}
+	return next, nil
+}
+
+// ReadOverflow returns the value of the given length stored in the chain
+// of overflow pages that starts at the given page.
+func (pager *Pager) ReadOverflow(pagenum int64, size int64) ([]byte, error) {
+	data := make([]byte, 0, size)
+	chunk := pager.overflowChunk()
+	for int64(len(data)) < size {
+		if pagenum == NOPAGE {
+			return nil, fmt.Errorf("overflow chain ends %v bytes short", size-int64(len(data)))
+		}
+		page, err := pager.GetPage(pagenum)
+		if err != nil {
+			return nil, err
+		}
+		n := size - int64(len(data))
+		if n > chunk {
+			n = chunk
+		}
+		pageData := *page.GetData()
+		data = append(data, pageData[OVERFLOW_DATA_OFFSET:OVERFLOW_DATA_OFFSET+n]...)
+		pagenum, _ = binary.Varint(pageData[OVERFLOW_NEXT_OFFSET : OVERFLOW_NEXT_OFFSET+OVERFLOW_NEXT_SIZE])
+		page.Put()
+	}
+	return data, nil
+}
+
+// FreeOverflow returns every page in the chain of overflow pages that
+// starts at the given page to the free page list.
+func (pager *Pager) FreeOverflow(pagenum int64) error {
+	for pagenum != NOPAGE {
+		page, err := pager.GetPage(pagenum)
+		if err != nil {
+			return err
+		}
+		next, _ := binary.Varint((*page.GetData())[OVERFLOW_NEXT_OFFSET : OVERFLOW_NEXT_OFFSET+OVERFLOW_NEXT_SIZE])
+		page.Put()
+		if err := pager.FreePage(pagenum); err != nil {
+			return err
+		}
+		pagenum = next
+	}
+	return nil
+}
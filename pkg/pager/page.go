@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // pagenum for when there is no page being held
@@ -11,13 +12,16 @@ const NOPAGE = -1
 
 // A page is a unit that is read from and written to disk.
 type Page struct {
-	pager      *Pager       // Pointer to the pager that this page belongs to.
-	pagenum    int64        // Position of the page in the file.
-	pinCount   int64        // The number of active references to this page.
-	dirty      bool         // Flag on whether data has to be written back.
-	rwlock     sync.RWMutex // Readers-writers lock on the page itself
-	updateLock sync.Mutex   // Mutex for updating data in a page
-	data       *[]byte      // Serialized data.
+	pager      *Pager        // Pointer to the pager that this page belongs to.
+	pagenum    int64         // Position of the page in the file.
+	pinCount   int64         // The number of active references to this page.
+	dirty      int32         // Set if data has to be written back; accessed atomically.
+	dirtiedAt  time.Time     // When the page was last made dirty while clean.
+	loading    chan struct{} // Closed once a prefetch of the page is done; nil if not loading.
+	rwlock     sync.RWMutex  // Readers-writers lock on the page itself
+	updateLock sync.Mutex    // Mutex for updating data in a page
+	frame      *[]byte       // Buffer frame holding the page as stored on disk.
+	data       *[]byte       // Serialized data; the frame after the page header.
 }
 
 // Get the pager.
@@ -32,12 +36,24 @@ func (page *Page) GetPageNum() int64 {
 
 // Is dirty?
 func (page *Page) IsDirty() bool {
-	return page.dirty
+	return atomic.LoadInt32(&page.dirty) != 0
 }
 
 // Set dirty.
 func (page *Page) SetDirty(dirty bool) {
-	page.dirty = dirty
+	if dirty {
+		page.markDirty()
+	} else {
+		atomic.StoreInt32(&page.dirty, 0)
+	}
+}
+
+// markDirty marks the page dirty, noting when it stopped being clean.
+func (page *Page) markDirty() {
+	if !page.IsDirty() {
+		page.dirtiedAt = time.Now()
+	}
+	atomic.StoreInt32(&page.dirty, 1)
 }
 
 // Get data.
@@ -48,31 +64,56 @@ func (page *Page) GetData() *[]byte {
 // Increment the pincount.
 func (page *Page) Get() {
 	atomic.AddInt64(&page.pinCount, 1)
+	page.pager.trackPin(page)
 }
 
 // Release a reference to the page.
 func (page *Page) Put() {
 	pager := page.pager
-	pager.ptMtx.Lock()
+	if pager.IsMapped() {
+		pager.putMapped(page)
+		return
+	}
+	pager.lock()
+	defer pager.unlock()
+	pager.unpin(page)
+}
+
+// unpin releases a reference to the page.
+// the pool mutex should be locked on entry
+func (pager *Pager) unpin(page *Page) {
+	pool := pager.pool
+	pager.trackUnpin(page)
 	ret := atomic.AddInt64(&page.pinCount, -1)
 	// Check if we can unpin this page; if so, move from pinned to unpinned list.
 	if ret == 0 {
-		link := pager.pageTable[page.pagenum]
+		link := pool.pageTable[page.key()]
 		link.PopSelf()
-		newLink := pager.unpinnedList.PushTail(page)
-		pager.pageTable[page.pagenum] = newLink
+		pool.pageTable[page.key()] = pool.unpinnedList.PushTail(page)
+		// Pages that aren't backed by disk can never be evicted.
+		if pager.HasFile() {
+			pool.policy.Unpin(page)
+		}
 	}
-	page.pager.ptMtx.Unlock()
 	if ret < 0 {
 		fmt.Println("ERROR: pinCount for page is < 0")
 	}
 }
 
+// zero clears the page's frame and marks it dirty.
+func (page *Page) zero() {
+	data := *page.frame
+	for i := range data {
+		data[i] = 0
+	}
+	page.markDirty()
+}
+
 // Update the target page with `size` bytes of the the given data.
 func (page *Page) Update(data []byte, offset int64, size int64) {
 	page.updateLock.Lock()
 	defer page.updateLock.Unlock()
-	page.dirty = true
+	page.markDirty()
 	copy((*page.data)[offset:offset+size], data)
 }
 
@@ -104,4 +145,4 @@ func (page *Page) LockUpdates() {
 // [RECOVERY] Release the update lock.
 func (page *Page) UnlockUpdates() {
 	page.updateLock.Unlock()
-}
\ No newline at end of file
+}
@@ -6,53 +6,94 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
-	"sync"	
+	"sync"
 
 	config "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/config"
-	list "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/list"
 	directio "github.com/ncw/directio"
 )
 
-// Page size - defaults to 4kb.
+// Default page size - 4kb. Page sizes must be a multiple of it.
 const PAGESIZE = int64(directio.BlockSize)
 
-// Maximum number of pages.
+// Largest supported page size.
+const MAX_PAGESIZE = 1 << 20
+
+// Number of frames in a pager's private buffer pool.
 const MAXPAGES = config.NumPages
 
 // Pagers manage pages of data read from a file.
 type Pager struct {
-	file         *os.File             // File descriptor.
-	maxPageNum   int64                // The number of pages used by this database.
-	ptMtx        sync.Mutex           // Page table mutex.
-	freeList     *list.List           // Free page list.
-	unpinnedList *list.List           // Unpinned page list.
-	pinnedList   *list.List           // Pinned page list.
-	pageTable    map[int64]*list.Link // Page table.
+	file            Storage         // Storage holding the pager's file.
+	backend         Backend         // Backend the file is opened in.
+	maxPageNum      int64           // The number of pages used by this database.
+	ptMtx           sync.Mutex      // Serializes this pager's operations; taken before the pool mutex.
+	pool            *BufferPool     // Frames this pager reads pages into; may be shared.
+	header          fileHeader      // Copy of the file's header page.
+	lockedPages     []*Page         // Pages pinned by LockAllUpdates.
+	counters        statCounters    // Statistics of this pager.
+	compressNew     bool            // Whether a new file should be compressed.
+	compression     *compressedFile // State of a compressed file, or nil.
+	cipher          *Cipher         // Encrypts pages; nil if the file isn't encrypted.
+	cryptBuf        []byte          // Scratch buffer for encrypting and decrypting pages.
+	writebackConfig WritebackConfig // Configuration of the background flusher.
+	writeback       *writeback      // The running background flusher, or nil.
+	loads           sync.WaitGroup  // Prefetches that are still reading.
+	mapNew          bool            // Whether Open should map the file instead.
+	mapping         *mapping        // State of a memory-mapped pager, or nil.
+	pins            *pinTracker     // Outstanding pins, or nil if they aren't tracked.
+	failMtx         sync.Mutex      // Guards writeErr.
+	writeErr        error           // First write error; the pager is read-only once set.
 }
 
-// Construct a new Pager.
-func NewPager() (pager *Pager) {
+// Option configures a Pager at construction time.
+type Option func(*Pager)
+
+// WithPolicy gives the pager a private buffer pool that evicts pages using
+// the given replacement policy. Policies keep per-pool state, so each pool
+// needs its own instance.
+func WithPolicy(policy ReplacementPolicy) Option {
+	return func(pager *Pager) {
+		pager.pool, _ = NewBufferPool(MAXPAGES, PAGESIZE, policy)
+	}
+}
+
+// WithBufferPool makes the pager read pages into the given buffer pool,
+// which may be shared with other pagers.
+func WithBufferPool(pool *BufferPool) Option {
+	return func(pager *Pager) {
+		pager.pool = pool
+	}
+}
+
+// Construct a new Pager. Unless given a pool, the pager gets a private
+// buffer pool of MAXPAGES frames.
+func NewPager(opts ...Option) (pager *Pager) {
 	pager = &Pager{}
-	pager.pageTable = make(map[int64]*list.Link)
-	pager.freeList = list.NewList()
-	pager.unpinnedList = list.NewList()
-	pager.pinnedList = list.NewList()
-	frames := directio.AlignedBlock(int(PAGESIZE * MAXPAGES))
-	for i := 0; i < MAXPAGES; i++ {
-		frame := frames[i*int(PAGESIZE) : (i+1)*int(PAGESIZE)]
-		page := Page{
-			pager:    pager,
-			pagenum:  NOPAGE,
-			pinCount: 0,
-			dirty:    false,
-			data:     &frame,
-		}
-		pager.freeList.PushTail(&page)
+	for _, opt := range opts {
+		opt(pager)
+	}
+	if pager.pool == nil {
+		pager.pool, _ = NewBufferPool(MAXPAGES, PAGESIZE, nil)
 	}
+	if pager.backend == nil {
+		pager.backend = FileBackend
+	}
+	pager.header = newFileHeader(pager.pool.pageSize)
 	return pager
 }
 
+// lock grabs the pager's mutex and then its pool's mutex.
+func (pager *Pager) lock() {
+	pager.ptMtx.Lock()
+	pager.pool.mtx.Lock()
+}
+
+// unlock releases the locks taken by lock.
+func (pager *Pager) unlock() {
+	pager.pool.mtx.Unlock()
+	pager.ptMtx.Unlock()
+}
+
 // HasFile checks if the pager is backed by disk.
 func (pager *Pager) HasFile() (hasFile bool) {
 	return pager.file != nil
@@ -63,123 +104,261 @@ func (pager *Pager) GetFileName() string {
 	return filepath.Base(pager.file.Name())
 }
 
+// GetFilePath returns the path the pager's file was opened with.
+func (pager *Pager) GetFilePath() string {
+	return pager.file.Name()
+}
+
+// GetPolicy returns the replacement policy of the pager's buffer pool.
+func (pager *Pager) GetPolicy() ReplacementPolicy {
+	return pager.pool.policy
+}
+
+// GetBufferPool returns the pager's buffer pool.
+func (pager *Pager) GetBufferPool() *BufferPool {
+	return pager.pool
+}
+
 // GetNumPages returns the number of pages.
 func (pager *Pager) GetNumPages() (numPages int64) {
+	pager.lock()
+	defer pager.unlock()
 	return pager.maxPageNum
 }
 
-// GetFreePN returns the next available page number.
-func (pager *Pager) GetFreePN() (nextPN int64) {
-	// Assign the first page number beyond the end of the file.
-	return pager.maxPageNum
+// GetFreePN returns the next available page number, reusing a page from
+// the free page list if there is one. Fails if the free page list is
+// corrupted, rather than growing the file and losing the pages on it.
+func (pager *Pager) GetFreePN() (nextPN int64, err error) {
+	pager.lock()
+	defer pager.unlock()
+	return pager.freePN()
+}
+
+// freePN returns the first page on the free page list, taking it off the
+// list, or else the first page number beyond the end of the file.
+// the pool mutex should be locked on entry
+func (pager *Pager) freePN() (int64, error) {
+	pn, err := pager.popFreePage()
+	if err != nil {
+		return NOPAGE, err
+	}
+	if pn == NOPAGE {
+		return pager.maxPageNum, nil
+	}
+	return pn, nil
+}
+
+// GetNewPage returns a new page, pinned, reusing a page from the free page
+// list if there is one. Unlike getting the page numbered by GetFreePN, no
+// one else can be handed the same page.
+func (pager *Pager) GetNewPage() (*Page, error) {
+	if pager.IsMapped() {
+		return nil, pager.CheckWritable()
+	}
+	pager.lock()
+	defer pager.unlock()
+	pagenum, err := pager.freePN()
+	if err != nil {
+		return nil, err
+	}
+	return pager.getPage(pagenum)
+}
+
+// GetPageSize returns the size of the pager's pages.
+func (pager *Pager) GetPageSize() int64 {
+	return pager.pool.pageSize
+}
+
+// GetDataSize returns the number of bytes in a page available to index code.
+func (pager *Pager) GetDataSize() int64 {
+	return pager.pool.pageSize - PAGE_HEADER_SIZE
+}
+
+// pageOffset returns the position of a page in the file.
+func (pager *Pager) pageOffset(pagenum int64) int64 {
+	return (pagenum + HEADER_PAGES) * pager.GetPageSize()
 }
 
 // Open initializes our page with a given database file.
 func (pager *Pager) Open(filename string) (err error) {
-	// Create the necessary prerequisite directories.
-	if idx := strings.LastIndex(filename, "/"); idx != -1 {
-		err = os.MkdirAll(filename[:idx], 0775)
-		if err != nil {
-			return err
-		}
+	pager.writeErr = nil
+	if pager.mapNew {
+		return pager.openMapped(filename)
 	}
 	// Open or create the db file.
-	pager.file, err = directio.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0666)
+	pager.file, err = pager.backend.Open(filename, true)
 	if err != nil {
 		return err
 	}
 	// Get info about the size of the pager.
-	var info os.FileInfo
-	var len int64
-	if info, err = pager.file.Stat(); err == nil {
-		len = info.Size()
-		if len%PAGESIZE != 0 {
-			return errors.New("open: DB file has been corrupted")
+	len, err := pager.file.Size()
+	if err != nil {
+		pager.file.Close()
+		pager.file = nil
+		return err
+	}
+	// Write the header of a new file, or read the header of an existing one.
+	pager.compression = nil
+	if len == 0 {
+		pager.header = newFileHeader(pager.GetPageSize())
+		if pager.compressNew {
+			pager.header.flags |= FLAG_COMPRESSED
+		}
+		if pager.cipher != nil {
+			pager.header.flags |= FLAG_ENCRYPTED
+			pager.header.keyCheck = pager.cipher.Seal(nil, keyCheckData)
+		}
+		err = pager.writeHeader()
+	} else {
+		err = pager.readHeader()
+	}
+	if err == nil {
+		err = pager.checkKey()
+	}
+	if err == nil && pager.header.flags&FLAG_COMPRESSED != 0 {
+		err = pager.openCompressed(filename, len)
+	} else if err == nil && len%pager.GetPageSize() != 0 {
+		err = errors.New("open: DB file has been corrupted")
+	}
+	if err != nil {
+		// openCompressed leaves no file behind if it couldn't reopen it.
+		if pager.file != nil {
+			pager.file.Close()
 		}
+		pager.file = nil
+		pager.compression = nil
+		return err
 	}
 	// Set the number of pages and hand off initialization to someone else.
-	pager.maxPageNum = len / PAGESIZE
+	if pager.IsCompressed() {
+		pager.maxPageNum = int64(pager.compression.numPages())
+	} else {
+		pager.maxPageNum = len/pager.GetPageSize() - HEADER_PAGES
+	}
+	if pager.maxPageNum < 0 {
+		pager.maxPageNum = 0
+	}
+	pager.startWriteback()
 	return nil
 }
 
-// Close signals our pager to flush all dirty pages to disk.
+// Close signals our pager to flush all dirty pages to disk, and hands its
+// unpinned frames back to the buffer pool. Returns a *FlushError if some
+// dirty pages couldn't be written; their changes are lost.
 func (pager *Pager) Close() (err error) {
+	if pager.IsMapped() {
+		return pager.closeMapped()
+	}
+	// Stop the flusher before it can get in the way, and let prefetches finish.
+	pager.stopWriteback()
+	pager.loads.Wait()
 	// Prevent new data from being paged in.
-	pager.ptMtx.Lock()
+	pager.lock()
+	defer pager.unlock()
+	// Cleanup.
+	err = pager.flushAllPages()
+	pinned := false
+	for _, page := range pager.pool.pagesOf(pager) {
+		if page.pinCount > 0 {
+			pinned = true
+			continue
+		}
+		pager.pool.release(page)
+	}
 	// Check if all refcounts are 0.
-	curLink := pager.pinnedList.PeekHead()
-	if curLink != nil {
+	if pinned {
 		fmt.Println("ERROR: pages are still pinned on close")
+		if pager.IsTrackingPins() {
+			pager.ReportPins(os.Stdout)
+		}
 	}
-	// Cleanup.
-	pager.FlushAllPages()
 	if pager.file != nil {
-		err = pager.file.Close()
+		if closeErr := pager.file.Close(); err == nil {
+			err = closeErr
+		}
 	}
-	pager.ptMtx.Unlock()
 	return err
 }
 
 // Populate a page's data field, given a pagenumber.
+// Returns a *CorruptPageError if the page fails its checksum, and an
+// *AuthError if an encrypted page fails authentication.
 func (pager *Pager) ReadPageFromDisk(page *Page, pagenum int64) (err error) {
-	if _, err := pager.file.Seek(pagenum*PAGESIZE, 0); err != nil {
-		return err
+	if pager.IsCompressed() {
+		return pager.readCompressedPage(page, pagenum)
 	}
-	if _, err := pager.file.Read(*page.data); err != nil && err != io.EOF {
+	return pager.readFrame(*page.frame, pagenum)
+}
+
+// readFrame reads a page of an uncompressed file into a frame and verifies
+// it. It doesn't need the pager's locks, so prefetches can use it.
+func (pager *Pager) readFrame(frame []byte, pagenum int64) error {
+	n, err := pager.file.ReadAt(frame, pager.pageOffset(pagenum))
+	pager.count(statBytesRead, int64(n))
+	if err != nil && err != io.EOF {
 		return err
 	}
-	return nil
+	return pager.checkFrame(frame, pagenum)
 }
 
-// newPage returns an unused buffer from the free or unpinned list
-// the ptMtx should be locked on entry
+// newPage returns an unused buffer from the pool's free or unpinned list
+// the pool mutex should be locked on entry
 func (pager *Pager) NewPage(pagenum int64) (newPage *Page, err error) {
 	/* SOLUTION {{{ */
-	if freeLink := pager.freeList.PeekHead(); freeLink != nil {
-		// Check the free list first
-		freeLink.PopSelf()
-		newPage = freeLink.GetKey().(*Page)
-	} else if unpinLink := pager.unpinnedList.PeekHead(); pager.HasFile() && unpinLink != nil {
-		// If no page was found, evict a page from the unpinned list.
-		// But skip this if our pager isn't backed by disk.
-		unpinLink.PopSelf()
-		newPage = unpinLink.GetKey().(*Page)
-		pager.FlushPage(newPage)
-		delete(pager.pageTable, newPage.pagenum)
-	} else {
-		// If still no page is found, error.
-		return nil, errors.New("no available pages")
+	newPage, err = pager.pool.newFrame()
+	if err != nil {
+		return nil, err
 	}
+	newPage.pager = pager
 	newPage.pagenum = pagenum
-	newPage.dirty = false
+	newPage.SetDirty(false)
 	newPage.pinCount = 1
+	pager.trackPin(newPage)
 	return newPage, nil
 	/* SOLUTION }}} */
 }
 
 // GetPage returns the page corresponding to the given pagenum.
 func (pager *Pager) GetPage(pagenum int64) (page *Page, err error) {
+	if pager.IsMapped() {
+		return pager.getMappedPage(pagenum)
+	}
+	pager.lock()
+	defer pager.unlock()
+	return pager.getPage(pagenum)
+}
+
+// getPage returns the page corresponding to the given pagenum.
+// the pool mutex should be locked on entry
+func (pager *Pager) getPage(pagenum int64) (page *Page, err error) {
+	if pager.IsMapped() {
+		return pager.getMappedPage(pagenum)
+	}
 	/* SOLUTION {{{ */
 	// Input checking.
 	if pagenum < 0 {
 		return nil, errors.New("invalid pagenum")
 	}
 	// Try to get from page table.
-	var newLink *list.Link
-	pager.ptMtx.Lock()
-	defer pager.ptMtx.Unlock()
-	link, ok := pager.pageTable[pagenum]
-	if ok {
+	pool := pager.pool
+	key := pageKey{pager: pager, pagenum: pagenum}
+	if link, ok := pool.pageTable[key]; ok {
 		page = link.GetKey().(*Page)
-		// Move the page to the pinned list if needed.
-		if link.GetList() == pager.unpinnedList {
-			link.PopSelf()
-			newLink = pager.pinnedList.PushTail(page)
-			pager.pageTable[pagenum] = newLink
+		// Wait for a prefetch of the page to finish, then look again.
+		if loading := page.loading; loading != nil {
+			pool.mtx.Unlock()
+			<-loading
+			pool.mtx.Lock()
+			return pager.getPage(pagenum)
 		}
-		page.Get()
+		// Move the page to the pinned list if needed.
+		pool.pin(page)
+		pool.policy.Access(page)
+		pager.count(statHits, 1)
 		return page, nil
 	}
+	pager.count(statMisses, 1)
 	// Else, create a buffer to hold the new page in.
 	page, err = pager.NewPage(pagenum)
 	if err != nil {
@@ -188,61 +367,141 @@ func (pager *Pager) GetPage(pagenum int64) (page *Page, err error) {
 
 	// Check if we need to create a new page.
 	if pagenum >= pager.maxPageNum {
+		if err = pager.CheckWritable(); err != nil {
+			pager.forgetPins(page)
+			page.pagenum = NOPAGE
+			pool.freeList.PushTail(page)
+			return nil, err
+		}
 		pager.maxPageNum++
-		page.dirty = true
+		page.zero()
 	} else {
 		// Read an existing page in.
-		page.dirty = false
+		page.SetDirty(false)
 		err = pager.ReadPageFromDisk(page, pagenum)
 		if err != nil {
-			pager.freeList.PushTail(page)
+			pager.forgetPins(page)
+			page.pagenum = NOPAGE
+			pool.freeList.PushTail(page)
 			return nil, err
 		}
 	}
 	// Insert the page into our list of pages.
-	newLink = pager.pinnedList.PushTail(page)
-	pager.pageTable[pagenum] = newLink
+	pool.pageTable[key] = pool.pinnedList.PushTail(page)
+	pool.policy.Access(page)
 	return page, nil
 	/* SOLUTION }}} */
 }
 
-// Flush a particular page to disk.
-func (pager *Pager) FlushPage(page *Page) {
+// Flush a particular page to disk. Returns a *WriteError if the write
+// fails, which switches the pager to read-only mode, and a *ReadOnlyError
+// if it already was; either way the page stays dirty.
+func (pager *Pager) FlushPage(page *Page) (err error) {
 	/* SOLUTION {{{ */
 	if pager.HasFile() && page.IsDirty() {
-		pager.file.WriteAt(
-			*page.data,
-			page.pagenum*PAGESIZE,
-		)
+		if err = pager.CheckWritable(); err != nil {
+			return err
+		}
+		setChecksum(*page.frame)
+		var n int
+		var length int
+		if pager.IsCompressed() {
+			n, err = pager.writeCompressedPage(page)
+			length = n
+		} else {
+			frame := *page.frame
+			if pager.IsEncrypted() {
+				frame = pager.sealFrame(frame, page.pagenum)
+			}
+			n, err = pager.file.WriteAt(
+				frame,
+				pager.pageOffset(page.pagenum),
+			)
+			length = len(frame)
+		}
+		pager.count(statBytesWritten, int64(n))
+		if err == nil && n < length {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return pager.fail(page.pagenum, err)
+		}
+		pager.count(statDirtyFlushes, 1)
 		page.SetDirty(false)
 	}
+	return nil
 	/* SOLUTION }}} */
 }
 
 // Flushes all dirty pages.
-func (pager *Pager) FlushAllPages() {
+func (pager *Pager) FlushAllPages() error {
+	pager.pool.mtx.Lock()
+	defer pager.pool.mtx.Unlock()
+	return pager.flushAllPages()
+}
+
+// flushAllPages flushes all dirty pages and the file header, then syncs
+// the file. Returns a *FlushError naming the pages that couldn't be
+// written; the header isn't written unless every page was.
+// the pool mutex should be locked on entry
+func (pager *Pager) flushAllPages() error {
+	var flushErr *FlushError
 	/* SOLUTION {{{ */
-	writer := func(link *list.Link) {
-		page := link.GetKey().(*Page)
-		pager.FlushPage(page)
+	for _, page := range pager.pool.pagesOf(pager) {
+		if err := pager.FlushPage(page); err != nil {
+			if flushErr == nil {
+				flushErr = &FlushError{File: pager.GetFileName(), Err: err}
+			}
+			flushErr.PageNums = append(flushErr.PageNums, page.pagenum)
+		}
 	}
-	pager.pinnedList.Map(writer)
-	pager.unpinnedList.Map(writer)
 	/* SOLUTION }}} */
+	if flushErr != nil {
+		return flushErr
+	}
+	if !pager.HasFile() || (!pager.header.dirty && pager.IsReadOnly()) {
+		return nil
+	}
+	if err := pager.CheckWritable(); err != nil {
+		return err
+	}
+	if pager.header.dirty {
+		if pager.IsCompressed() {
+			if err := pager.writePageMap(); err != nil {
+				return err
+			}
+		} else if err := pager.writeHeader(); err != nil {
+			return pager.fail(NOPAGE, err)
+		}
+	}
+	// Make the writes durable, so that they survive a crash.
+	if err := pager.file.Sync(); err != nil {
+		return pager.failSync(err)
+	}
+	return nil
 }
 
-// [RECOVERY] Block all updates.
+// [RECOVERY] Block all updates. Resident pages stay pinned until
+// UnlockAllUpdates so the pool cannot hand their frames to other pagers.
 func (pager *Pager) LockAllUpdates() {
 	pager.ptMtx.Lock()
-	for _, page := range pager.pageTable {
-		page.GetKey().(*Page).LockUpdates()
+	pager.pool.mtx.Lock()
+	defer pager.pool.mtx.Unlock()
+	pager.lockedPages = pager.pool.pagesOf(pager)
+	for _, page := range pager.lockedPages {
+		pager.pool.pin(page)
+		page.LockUpdates()
 	}
 }
 
 // [RECOVERY] Enable updates.
 func (pager *Pager) UnlockAllUpdates() {
-	for _, page := range pager.pageTable {
-		page.GetKey().(*Page).UnlockUpdates()
+	pager.pool.mtx.Lock()
+	for _, page := range pager.lockedPages {
+		page.UnlockUpdates()
+		pager.unpin(page)
 	}
+	pager.lockedPages = nil
+	pager.pool.mtx.Unlock()
 	pager.ptMtx.Unlock()
 }
@@ -12,9 +12,9 @@ import (
 )
 
 // Creates a Pager REPL for testing the Pager with.
-func PagerRepl() (*repl.REPL, error) {
+func PagerRepl(opts ...Option) (*repl.REPL, error) {
 	// Initialize pager.
-	p := NewPager()
+	p := NewPager(opts...)
 	err := p.Open("data/pager.tmp")
 	if err != nil {
 		return nil, err
@@ -48,6 +48,12 @@ func PagerRepl() (*repl.REPL, error) {
 	r.AddCommand("pager_flushall", func(payload string, replConfig *repl.REPLConfig) error {
 		return HandlePagerFlushAll(p, payload, replConfig.GetWriter())
 	}, "Flush all pages. usage: pager_flushall")
+	r.AddCommand("pager_free", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandlePagerFree(p, payload, replConfig.GetWriter())
+	}, "Return a page to the free page list. usage: pager_free <page_num>")
+	r.AddCommand("pager_pins", func(payload string, replConfig *repl.REPLConfig) error {
+		return HandlePagerPins(p, payload, replConfig.GetWriter())
+	}, "List outstanding pins and who took them. usage: pager_pins")
 	return r, nil
 }
 
@@ -59,30 +65,50 @@ func HandlePagerPrint(p *Pager, payload string, w io.Writer) (err error) {
 	if numFields != 1 {
 		return fmt.Errorf("usage: pager_print")
 	}
-	// Print maxPageNum, freeList, unpinnedList, pinnedList, pageTable.
+	// Print policy, maxPageNum, freeList, unpinnedList, pinnedList, pageTable.
+	// The lists belong to the pager's buffer pool and may hold other pagers' pages.
+	pool := p.pool
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	io.WriteString(w, fmt.Sprintf("policy: %v\n", pool.policy.Name()))
 	io.WriteString(w, fmt.Sprintf("maxPageNum: %v\n", p.maxPageNum))
+	io.WriteString(w, fmt.Sprintf("freePages: (head: %v, count: %v)\n", p.header.freeHead, p.header.numFree))
 	io.WriteString(w, "freeList: ")
-	p.freeList.Map(func(l *list.Link) {
+	pool.freeList.Map(func(l *list.Link) {
 		io.WriteString(w, fmt.Sprintf("(pagenum: %v), ", l.GetKey().(*Page).GetPageNum()))
 	})
 	io.WriteString(w, "\nunpinnedList: ")
-	p.unpinnedList.Map(func(l *list.Link) {
+	pool.unpinnedList.Map(func(l *list.Link) {
 		page := l.GetKey().(*Page)
 		io.WriteString(w, fmt.Sprintf("(pagenum: %v, pincount: %v), ", page.GetPageNum(), page.pinCount))
 	})
 	io.WriteString(w, "\npinnedList: ")
-	p.pinnedList.Map(func(l *list.Link) {
+	pool.pinnedList.Map(func(l *list.Link) {
 		page := l.GetKey().(*Page)
 		io.WriteString(w, fmt.Sprintf("(pagenum: %v, pincount: %v), ", page.GetPageNum(), page.pinCount))
 	})
 	io.WriteString(w, "\npageTable: ")
-	for pNum := range p.pageTable {
-		io.WriteString(w, fmt.Sprintf("%v, ", pNum))
+	for key := range pool.pageTable {
+		if key.pager == p {
+			io.WriteString(w, fmt.Sprintf("%v, ", key.pagenum))
+		}
 	}
 	io.WriteString(w, "\n")
 	return nil
 }
 
+// residentPage returns the pager's page with the given pagenum if it is
+// in the buffer pool.
+func (p *Pager) residentPage(pagenum int64) (*Page, bool) {
+	p.pool.mtx.Lock()
+	defer p.pool.mtx.Unlock()
+	link, found := p.pool.pageTable[pageKey{pager: p, pagenum: pagenum}]
+	if !found {
+		return nil, false
+	}
+	return link.GetKey().(*Page), true
+}
+
 // Function to get an existing page and pull; errors if requesting a page that has not been allocated.
 func HandlePagerGet(p *Pager, payload string, w io.Writer) (err error) {
 	fields := strings.Fields(payload)
@@ -130,12 +156,11 @@ func HandlePagerWrite(p *Pager, payload string, w io.Writer) (err error) {
 		return err
 	}
 	// Check that this page is in our pageTable
-	link, found := p.pageTable[int64(pNum)]
+	page, found := p.residentPage(int64(pNum))
 	if !found {
 		return errors.New("page not found; did you pager_get it first?")
 	}
 	// Cast and write.
-	page := link.GetKey().(*Page)
 	page.Get()
 	data := []byte(fields[2])
 	page.Update(data, 0, int64(len(data)))
@@ -157,12 +182,11 @@ func HandlePagerRead(p *Pager, payload string, w io.Writer) (err error) {
 		return err
 	}
 	// Check that this page is in our pageTable
-	link, found := p.pageTable[int64(pNum)]
+	page, found := p.residentPage(int64(pNum))
 	if !found {
 		return errors.New("page not found; did you pager_get it first?")
 	}
 	// Print.
-	page := link.GetKey().(*Page)
 	page.Get()
 	io.WriteString(w, string(*page.GetData()))
 	io.WriteString(w, "\n")
@@ -184,19 +208,12 @@ func HandlePagerPin(p *Pager, payload string, w io.Writer) (err error) {
 		return err
 	}
 	// Check that this page is in our pageTable
-	link, found := p.pageTable[int64(pNum)]
-	if !found {
+	if _, found := p.residentPage(int64(pNum)); !found {
 		return errors.New("page not found; did you pager_get it first?")
 	}
-	// Pin.
-	if link.GetList() == p.unpinnedList {
-		link.PopSelf()
-		newLink := p.pinnedList.PushHead(link.GetKey())
-		p.pageTable[int64(pNum)] = newLink
-	}
-	page := link.GetKey().(*Page)
-	page.Get()
-	return nil
+	// Pin; going through GetPage keeps the replacement policy informed.
+	_, err = p.GetPage(int64(pNum))
+	return err
 }
 
 // Function to unpin a page.
@@ -213,12 +230,11 @@ func HandlePagerUnpin(p *Pager, payload string, w io.Writer) (err error) {
 		return err
 	}
 	// Check that this page is in our pageTable
-	link, found := p.pageTable[int64(pNum)]
+	page, found := p.residentPage(int64(pNum))
 	if !found {
 		return errors.New("page not found; did you pager_get it first?")
 	}
 	// Unpin.
-	page := link.GetKey().(*Page)
 	page.Put()
 	return nil
 }
@@ -237,14 +253,12 @@ func HandlePagerFlush(p *Pager, payload string, w io.Writer) (err error) {
 		return err
 	}
 	// Check that this page is in our pageTable
-	link, found := p.pageTable[int64(pNum)]
+	page, found := p.residentPage(int64(pNum))
 	if !found {
 		return errors.New("page not found; did you pager_get it first?")
 	}
 	// Flush.
-	page := link.GetKey().(*Page)
-	p.FlushPage(page)
-	return nil
+	return p.FlushPage(page)
 }
 
 // Function to flush all pages.
@@ -256,6 +270,35 @@ func HandlePagerFlushAll(p *Pager, payload string, w io.Writer) (err error) {
 		return fmt.Errorf("usage: pager_flushall")
 	}
 	// Flush all.
-	p.FlushAllPages()
+	return p.FlushAllPages()
+}
+
+// Function to return a page to the free page list.
+func HandlePagerFree(p *Pager, payload string, w io.Writer) (err error) {
+	fields := strings.Fields(payload)
+	numFields := len(fields)
+	// Usage: pager_free <page_num>
+	if numFields != 2 {
+		return fmt.Errorf("usage: pager_free <page_num>")
+	}
+	// Get page num.
+	var pNum int
+	if pNum, err = strconv.Atoi(fields[1]); err != nil {
+		return err
+	}
+	return p.FreePage(int64(pNum))
+}
+
+// Function to list outstanding pins.
+func HandlePagerPins(p *Pager, payload string, w io.Writer) (err error) {
+	fields := strings.Fields(payload)
+	numFields := len(fields)
+	// Usage: pager_pins
+	if numFields != 1 {
+		return fmt.Errorf("usage: pager_pins")
+	}
+	if n := p.ReportPins(w); n == 0 && p.IsTrackingPins() {
+		fmt.Fprintln(w, "no pages are pinned")
+	}
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,139 @@
+package pager
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Pin tracking records the call stack of every pin a pager hands out, so
+// that a missing Put can be traced to the code that took the pin. Pins of
+// a page aren't told apart, so when a page has several outstanding pins,
+// a Put forgets the most recent one. Recording a stack on every GetPage is
+// slow, so tracking is off unless asked for.
+
+// Maximum number of frames recorded per pin.
+const PIN_STACK_DEPTH = 32
+
+// Pin is an outstanding pin recorded by pin tracking.
+type Pin struct {
+	PageNum int64  // Page that is pinned.
+	Stack   string // Call stack of the code that took the pin.
+	seq     int64  // Order in which pins were taken.
+}
+
+// pinTracker holds a pager's outstanding pins.
+type pinTracker struct {
+	mtx  sync.Mutex
+	pins map[*Page][]Pin // Outstanding pins of each page, oldest first.
+	seq  int64           // Number of pins taken.
+}
+
+// WithPinTracking makes the pager record who takes each pin, and report
+// the outstanding ones when it is closed with pages still pinned.
+func WithPinTracking() Option {
+	return func(pager *Pager) {
+		pager.pins = &pinTracker{pins: make(map[*Page][]Pin)}
+	}
+}
+
+// IsTrackingPins returns true if the pager records who takes each pin.
+func (pager *Pager) IsTrackingPins() bool {
+	return pager.pins != nil
+}
+
+// callers returns the call stack above the pager's pin bookkeeping.
+func callers() string {
+	pcs := make([]uintptr, PIN_STACK_DEPTH)
+	// Skip runtime.Callers, callers and the tracking method.
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var sb strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&sb, "%v\n\t%v:%v\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+	return sb.String()
+}
+
+// trackPin records a new pin of the page.
+func (pager *Pager) trackPin(page *Page) {
+	if pager == nil || pager.pins == nil {
+		return
+	}
+	stack := callers()
+	pt := pager.pins
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	pt.seq++
+	pt.pins[page] = append(pt.pins[page], Pin{PageNum: page.pagenum, Stack: stack, seq: pt.seq})
+}
+
+// trackUnpin forgets the most recent pin of the page. Releasing a page
+// that has no recorded pins prints the stack of the release.
+func (pager *Pager) trackUnpin(page *Page) {
+	if pager.pins == nil {
+		return
+	}
+	pt := pager.pins
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	pins := pt.pins[page]
+	if len(pins) == 0 {
+		fmt.Printf("ERROR: page %v released without a pin by:\n%v", page.pagenum, callers())
+		return
+	}
+	if len(pins) == 1 {
+		delete(pt.pins, page)
+	} else {
+		pt.pins[page] = pins[:len(pins)-1]
+	}
+}
+
+// forgetPins forgets every pin of a page that is handed back to the pool
+// without being released.
+func (pager *Pager) forgetPins(page *Page) {
+	if pager.pins == nil {
+		return
+	}
+	pager.pins.mtx.Lock()
+	defer pager.pins.mtx.Unlock()
+	delete(pager.pins.pins, page)
+}
+
+// GetPins returns the pager's outstanding pins, oldest first, or nil if
+// it doesn't track pins.
+func (pager *Pager) GetPins() []Pin {
+	if pager.pins == nil {
+		return nil
+	}
+	pt := pager.pins
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+	all := make([]Pin, 0)
+	for _, pins := range pt.pins {
+		all = append(all, pins...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].seq < all[j].seq })
+	return all
+}
+
+// ReportPins writes every outstanding pin and the stack that took it.
+// Returns the number of pins.
+func (pager *Pager) ReportPins(w io.Writer) int {
+	if pager.pins == nil {
+		fmt.Fprintln(w, "pin tracking is off")
+		return 0
+	}
+	pins := pager.GetPins()
+	for _, pin := range pins {
+		fmt.Fprintf(w, "page %v pinned by:\n%v", pin.PageNum, pin.Stack)
+	}
+	return len(pins)
+}
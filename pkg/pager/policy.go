@@ -0,0 +1,444 @@
+package pager
+
+import (
+	"fmt"
+	"strings"
+
+	list "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/list"
+)
+
+// Name of the replacement policy used when none is given.
+const DEFAULT_POLICY = "lru"
+
+// Number of references tracked per page by the LRU-K policy.
+const LRUK_K = 2
+
+// ReplacementPolicy decides which unpinned page gets evicted when the pager
+// runs out of free frames. The buffer pool calls every method with its mutex held.
+type ReplacementPolicy interface {
+	// Name returns the name the policy is selected by.
+	Name() string
+	// Access records a request for the page; called on every GetPage,
+	// including the one that first reads the page into the buffer.
+	Access(page *Page)
+	// Pin marks the page as in use; pinned pages are never victims.
+	Pin(page *Page)
+	// Unpin marks the page as evictable.
+	Unpin(page *Page)
+	// Victim chooses an unpinned page to evict and forgets it.
+	// Returns nil if every tracked page is pinned.
+	Victim() *Page
+	// Remove forgets a page that leaves the buffer without being evicted.
+	Remove(page *Page)
+}
+
+// NewPolicy returns a fresh replacement policy given its name.
+func NewPolicy(name string) (ReplacementPolicy, error) {
+	switch strings.ToLower(name) {
+	case "lru":
+		return NewLRUPolicy(), nil
+	case "clock":
+		return NewClockPolicy(), nil
+	case "lru-k", "lruk":
+		return NewLRUKPolicy(LRUK_K), nil
+	case "2q":
+		return NewTwoQPolicy(), nil
+	default:
+		return nil, fmt.Errorf("unknown replacement policy %q; expected one of lru, clock, lru-k, 2q", name)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////// LRU /////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////
+
+// LRUPolicy evicts the unpinned page that was requested least recently.
+type LRUPolicy struct {
+	recency   *list.List           // Resident pages, least recently used first.
+	links     map[*Page]*list.Link // Position of each page in the recency list.
+	evictable map[*Page]bool       // Set of unpinned pages.
+}
+
+// Construct a new LRUPolicy.
+func NewLRUPolicy() *LRUPolicy {
+	return &LRUPolicy{
+		recency:   list.NewList(),
+		links:     make(map[*Page]*list.Link),
+		evictable: make(map[*Page]bool),
+	}
+}
+
+// Name returns "lru".
+func (policy *LRUPolicy) Name() string {
+	return "lru"
+}
+
+// Access moves the page to the most recently used position.
+func (policy *LRUPolicy) Access(page *Page) {
+	if link, ok := policy.links[page]; ok {
+		link.PopSelf()
+	}
+	policy.links[page] = policy.recency.PushTail(page)
+}
+
+// Pin marks the page as in use.
+func (policy *LRUPolicy) Pin(page *Page) {
+	delete(policy.evictable, page)
+}
+
+// Unpin marks the page as evictable.
+func (policy *LRUPolicy) Unpin(page *Page) {
+	policy.evictable[page] = true
+}
+
+// Victim returns the least recently used unpinned page.
+func (policy *LRUPolicy) Victim() *Page {
+	link := policy.recency.Find(func(l *list.Link) bool {
+		return policy.evictable[l.GetKey().(*Page)]
+	})
+	if link == nil {
+		return nil
+	}
+	page := link.GetKey().(*Page)
+	policy.Remove(page)
+	return page
+}
+
+// Remove forgets the page.
+func (policy *LRUPolicy) Remove(page *Page) {
+	if link, ok := policy.links[page]; ok {
+		link.PopSelf()
+		delete(policy.links, page)
+	}
+	delete(policy.evictable, page)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+////////////////////////////////// CLOCK ////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////
+
+// ClockPolicy approximates LRU with a single reference bit per page and a
+// hand that sweeps over the resident pages.
+type ClockPolicy struct {
+	ring       *list.List           // Resident pages in insertion order; wraps around.
+	hand       *list.Link           // Next page to be considered for eviction.
+	links      map[*Page]*list.Link // Position of each page in the ring.
+	referenced map[*Page]bool       // Reference bit of each page.
+	evictable  map[*Page]bool       // Set of unpinned pages.
+}
+
+// Construct a new ClockPolicy.
+func NewClockPolicy() *ClockPolicy {
+	return &ClockPolicy{
+		ring:       list.NewList(),
+		links:      make(map[*Page]*list.Link),
+		referenced: make(map[*Page]bool),
+		evictable:  make(map[*Page]bool),
+	}
+}
+
+// Name returns "clock".
+func (policy *ClockPolicy) Name() string {
+	return "clock"
+}
+
+// Access sets the page's reference bit, adding it to the ring if needed.
+func (policy *ClockPolicy) Access(page *Page) {
+	if _, ok := policy.links[page]; !ok {
+		policy.links[page] = policy.ring.PushTail(page)
+		if policy.hand == nil {
+			policy.hand = policy.links[page]
+		}
+	}
+	policy.referenced[page] = true
+}
+
+// Pin marks the page as in use.
+func (policy *ClockPolicy) Pin(page *Page) {
+	delete(policy.evictable, page)
+}
+
+// Unpin marks the page as evictable.
+func (policy *ClockPolicy) Unpin(page *Page) {
+	policy.evictable[page] = true
+}
+
+// advance moves the hand to the next page in the ring.
+func (policy *ClockPolicy) advance() {
+	if policy.hand == nil {
+		return
+	}
+	if next := policy.hand.GetNext(); next != nil {
+		policy.hand = next
+	} else {
+		policy.hand = policy.ring.PeekHead()
+	}
+}
+
+// Victim sweeps the hand until it finds an unpinned page whose reference
+// bit is clear, clearing the bits of the pages it passes over.
+func (policy *ClockPolicy) Victim() *Page {
+	// Two full sweeps are enough to clear every bit and come back around.
+	for i := 0; i < 2*len(policy.links); i++ {
+		page := policy.hand.GetKey().(*Page)
+		if policy.evictable[page] {
+			if !policy.referenced[page] {
+				policy.Remove(page)
+				return page
+			}
+			policy.referenced[page] = false
+		}
+		policy.advance()
+	}
+	return nil
+}
+
+// Remove forgets the page, moving the hand past it if necessary.
+func (policy *ClockPolicy) Remove(page *Page) {
+	link, ok := policy.links[page]
+	if !ok {
+		return
+	}
+	if policy.hand == link {
+		policy.advance()
+		if policy.hand == link {
+			policy.hand = nil
+		}
+	}
+	link.PopSelf()
+	delete(policy.links, page)
+	delete(policy.referenced, page)
+	delete(policy.evictable, page)
+}
+
+/////////////////////////////////////////////////////////////////////////////
+////////////////////////////////// LRU-K ////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////
+
+// LRUKPolicy evicts the unpinned page whose K-th most recent reference is
+// furthest in the past. Pages with fewer than K references are evicted
+// first, oldest reference first. Reference history outlives eviction so
+// that a page that comes straight back is not treated as new.
+type LRUKPolicy struct {
+	k         int                 // Number of references to track.
+	clock     int64               // Logical time; incremented on every access.
+	history   map[pageKey][]int64 // Most recent reference times per page, newest first.
+	resident  map[*Page]bool      // Set of pages currently in the buffer.
+	evictable map[*Page]bool      // Set of unpinned pages.
+}
+
+// Construct a new LRUKPolicy that tracks k references per page.
+func NewLRUKPolicy(k int) *LRUKPolicy {
+	if k < 1 {
+		k = 1
+	}
+	return &LRUKPolicy{
+		k:         k,
+		history:   make(map[pageKey][]int64),
+		resident:  make(map[*Page]bool),
+		evictable: make(map[*Page]bool),
+	}
+}
+
+// Name returns "lru-k".
+func (policy *LRUKPolicy) Name() string {
+	return "lru-k"
+}
+
+// Access records a reference to the page.
+func (policy *LRUKPolicy) Access(page *Page) {
+	policy.clock++
+	policy.resident[page] = true
+	refs := append([]int64{policy.clock}, policy.history[page.key()]...)
+	if len(refs) > policy.k {
+		refs = refs[:policy.k]
+	}
+	policy.history[page.key()] = refs
+}
+
+// Pin marks the page as in use.
+func (policy *LRUKPolicy) Pin(page *Page) {
+	delete(policy.evictable, page)
+}
+
+// Unpin marks the page as evictable.
+func (policy *LRUKPolicy) Unpin(page *Page) {
+	policy.evictable[page] = true
+}
+
+// Victim returns the unpinned page with the largest backward K-distance.
+func (policy *LRUKPolicy) Victim() *Page {
+	var victim *Page
+	var victimFull bool
+	var victimTime int64
+	for page := range policy.evictable {
+		refs := policy.history[page.key()]
+		full := len(refs) >= policy.k
+		// Compare on the K-th reference if known, else on the last one.
+		time := refs[0]
+		if full {
+			time = refs[policy.k-1]
+		}
+		if victim == nil || (victimFull && !full) ||
+			(victimFull == full && time < victimTime) {
+			victim, victimFull, victimTime = page, full, time
+		}
+	}
+	if victim == nil {
+		return nil
+	}
+	delete(policy.resident, victim)
+	delete(policy.evictable, victim)
+	policy.prune()
+	return victim
+}
+
+// Remove forgets the page along with its reference history.
+func (policy *LRUKPolicy) Remove(page *Page) {
+	delete(policy.resident, page)
+	delete(policy.evictable, page)
+	delete(policy.history, page.key())
+}
+
+// prune bounds the history kept for evicted pages to a few times the
+// number of resident pages, dropping the least recently referenced.
+func (policy *LRUKPolicy) prune() {
+	limit := 4 * (len(policy.resident) + 1)
+	if len(policy.history) <= limit {
+		return
+	}
+	residentKeys := make(map[pageKey]bool)
+	for page := range policy.resident {
+		residentKeys[page.key()] = true
+	}
+	for len(policy.history) > limit {
+		var oldestKey pageKey
+		oldestTime := int64(-1)
+		for key, refs := range policy.history {
+			if !residentKeys[key] && (oldestTime == -1 || refs[0] < oldestTime) {
+				oldestKey, oldestTime = key, refs[0]
+			}
+		}
+		if oldestTime == -1 {
+			return
+		}
+		delete(policy.history, oldestKey)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////// 2Q //////////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////
+
+// TwoQPolicy implements the full version of 2Q. Pages seen once live in a
+// FIFO queue (A1in); pages evicted from it are remembered in a ghost queue
+// (A1out); pages requested again while remembered are promoted to an LRU
+// queue (Am). Scans therefore flow through A1in without flushing Am.
+type TwoQPolicy struct {
+	a1in      *list.List             // FIFO of pages referenced once.
+	am        *list.List             // LRU of pages referenced again.
+	a1out     *list.List             // Ghost FIFO of pages evicted from A1in.
+	links     map[*Page]*list.Link   // Position of each resident page.
+	inAm      map[*Page]bool         // Set of resident pages in Am.
+	ghosts    map[pageKey]*list.Link // Position of each ghost in A1out.
+	evictable map[*Page]bool         // Set of unpinned pages.
+	numA1in   int                    // Length of A1in.
+}
+
+// Construct a new TwoQPolicy.
+func NewTwoQPolicy() *TwoQPolicy {
+	return &TwoQPolicy{
+		a1in:      list.NewList(),
+		am:        list.NewList(),
+		a1out:     list.NewList(),
+		links:     make(map[*Page]*list.Link),
+		inAm:      make(map[*Page]bool),
+		ghosts:    make(map[pageKey]*list.Link),
+		evictable: make(map[*Page]bool),
+	}
+}
+
+// Name returns "2q".
+func (policy *TwoQPolicy) Name() string {
+	return "2q"
+}
+
+// Access admits new pages to A1in, or to Am if recently evicted, and
+// refreshes pages already in Am.
+func (policy *TwoQPolicy) Access(page *Page) {
+	link, resident := policy.links[page]
+	switch {
+	case resident && policy.inAm[page]:
+		link.PopSelf()
+		policy.links[page] = policy.am.PushTail(page)
+	case resident:
+		// Correlated references within A1in do not promote the page.
+	case policy.ghosts[page.key()] != nil:
+		policy.ghosts[page.key()].PopSelf()
+		delete(policy.ghosts, page.key())
+		policy.links[page] = policy.am.PushTail(page)
+		policy.inAm[page] = true
+	default:
+		policy.links[page] = policy.a1in.PushTail(page)
+		policy.numA1in++
+	}
+}
+
+// Pin marks the page as in use.
+func (policy *TwoQPolicy) Pin(page *Page) {
+	delete(policy.evictable, page)
+}
+
+// Unpin marks the page as evictable.
+func (policy *TwoQPolicy) Unpin(page *Page) {
+	policy.evictable[page] = true
+}
+
+// Victim evicts from A1in while it holds more than a quarter of the
+// resident pages, and from Am otherwise.
+func (policy *TwoQPolicy) Victim() *Page {
+	isEvictable := func(l *list.Link) bool {
+		return policy.evictable[l.GetKey().(*Page)]
+	}
+	kin := len(policy.links) / 4
+	first, second := policy.am, policy.a1in
+	if policy.numA1in > kin {
+		first, second = policy.a1in, policy.am
+	}
+	link := first.Find(isEvictable)
+	if link == nil {
+		link = second.Find(isEvictable)
+	}
+	if link == nil {
+		return nil
+	}
+	page := link.GetKey().(*Page)
+	wasInAm := policy.inAm[page]
+	policy.Remove(page)
+	// Remember pages evicted from A1in, keeping A1out at half the buffer.
+	if !wasInAm {
+		policy.ghosts[page.key()] = policy.a1out.PushTail(page.key())
+		kout := (len(policy.links) + 1) / 2
+		for len(policy.ghosts) > kout {
+			oldest := policy.a1out.PeekHead()
+			oldest.PopSelf()
+			delete(policy.ghosts, oldest.GetKey().(pageKey))
+		}
+	}
+	return page
+}
+
+// Remove forgets the page.
+func (policy *TwoQPolicy) Remove(page *Page) {
+	link, ok := policy.links[page]
+	if !ok {
+		return
+	}
+	link.PopSelf()
+	if !policy.inAm[page] {
+		policy.numA1in--
+	}
+	delete(policy.links, page)
+	delete(policy.inAm, page)
+	delete(policy.evictable, page)
+}
@@ -0,0 +1,175 @@
+package pager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	list "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/list"
+	directio "github.com/ncw/directio"
+)
+
+// pageKey identifies a page in a buffer pool. Each pager owns one file, so
+// the pager and page number together name a page of a particular file.
+type pageKey struct {
+	pager   *Pager
+	pagenum int64
+}
+
+// key returns the key of the page in its buffer pool.
+func (page *Page) key() pageKey {
+	return pageKey{pager: page.pager, pagenum: page.pagenum}
+}
+
+// BufferPools hold the frames that pages are read into. A pool can be
+// shared by any number of pagers, which then compete for the same frames.
+type BufferPool struct {
+	mtx          sync.Mutex             // Guards the lists, page table and policy.
+	numPages     int64                  // Number of frames in the pool.
+	pageSize     int64                  // Size of each frame; every file in the pool uses it.
+	freeList     *list.List             // Free page list.
+	unpinnedList *list.List             // Unpinned page list.
+	pinnedList   *list.List             // Pinned page list.
+	pageTable    map[pageKey]*list.Link // Page table.
+	policy       ReplacementPolicy      // Decides which unpinned page to evict.
+	counters     statCounters           // Statistics of every pager in the pool.
+}
+
+// Construct a new BufferPool with the given number of frames of the given
+// page size. A nil policy selects the default replacement policy.
+func NewBufferPool(numPages int64, pageSize int64, policy ReplacementPolicy) (*BufferPool, error) {
+	if numPages <= 0 {
+		return nil, fmt.Errorf("buffer pool: invalid number of pages %v", numPages)
+	}
+	if err := checkPageSize(pageSize); err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		policy, _ = NewPolicy(DEFAULT_POLICY)
+	}
+	pool := &BufferPool{
+		numPages:     numPages,
+		pageSize:     pageSize,
+		freeList:     list.NewList(),
+		unpinnedList: list.NewList(),
+		pinnedList:   list.NewList(),
+		pageTable:    make(map[pageKey]*list.Link),
+		policy:       policy,
+	}
+	frames := directio.AlignedBlock(int(pageSize * numPages))
+	for i := int64(0); i < numPages; i++ {
+		frame := frames[i*pageSize : (i+1)*pageSize]
+		data := frame[PAGE_HEADER_SIZE:]
+		page := Page{
+			pagenum:  NOPAGE,
+			pinCount: 0,
+			dirty:    0,
+			frame:    &frame,
+			data:     &data,
+		}
+		pool.freeList.PushTail(&page)
+	}
+	return pool, nil
+}
+
+// checkPageSize checks that pages of the given size can be used for direct IO.
+func checkPageSize(pageSize int64) error {
+	if pageSize < PAGESIZE || pageSize > MAX_PAGESIZE || pageSize%PAGESIZE != 0 {
+		return fmt.Errorf("invalid page size %v; must be a multiple of %v no larger than %v", pageSize, PAGESIZE, MAX_PAGESIZE)
+	}
+	return nil
+}
+
+// GetNumPages returns the number of frames in the pool.
+func (pool *BufferPool) GetNumPages() int64 {
+	return pool.numPages
+}
+
+// GetPageSize returns the size of the pool's frames.
+func (pool *BufferPool) GetPageSize() int64 {
+	return pool.pageSize
+}
+
+// GetPolicy returns the pool's replacement policy.
+func (pool *BufferPool) GetPolicy() ReplacementPolicy {
+	return pool.policy
+}
+
+// newFrame returns an unused frame from the free list, or evicts a page.
+// the pool mutex should be locked on entry
+func (pool *BufferPool) newFrame() (page *Page, err error) {
+	if freeLink := pool.freeList.PeekHead(); freeLink != nil {
+		// Check the free list first
+		freeLink.PopSelf()
+		return freeLink.GetKey().(*Page), nil
+	}
+	// If no page was found, evict the page chosen by the replacement policy.
+	// Dirty pages of read-only pagers can't be written, so they are passed
+	// over; a page whose write fails is kept and the error returned.
+	skipped := make([]*Page, 0)
+	defer func() {
+		for _, page := range skipped {
+			pool.keep(page)
+		}
+	}()
+	for victim := pool.policy.Victim(); victim != nil; victim = pool.policy.Victim() {
+		if victim.IsDirty() && victim.pager.IsReadOnly() {
+			skipped = append(skipped, victim)
+			continue
+		}
+		if err = victim.pager.FlushPage(victim); err != nil {
+			skipped = append(skipped, victim)
+			return nil, err
+		}
+		victim.pager.count(statEvictions, 1)
+		pool.pageTable[victim.key()].PopSelf()
+		delete(pool.pageTable, victim.key())
+		return victim, nil
+	}
+	// If still no page is found, error.
+	return nil, errors.New("no available pages")
+}
+
+// keep hands a victim that couldn't be evicted back to the policy.
+// the pool mutex should be locked on entry
+func (pool *BufferPool) keep(page *Page) {
+	pool.policy.Access(page)
+	pool.policy.Unpin(page)
+}
+
+// pin moves a page to the pinned list if needed and takes a reference.
+// the pool mutex should be locked on entry
+func (pool *BufferPool) pin(page *Page) {
+	link := pool.pageTable[page.key()]
+	if link.GetList() == pool.unpinnedList {
+		link.PopSelf()
+		pool.pageTable[page.key()] = pool.pinnedList.PushTail(page)
+		pool.policy.Pin(page)
+	}
+	page.Get()
+}
+
+// release returns a page's frame to the free list.
+// the pool mutex should be locked on entry
+func (pool *BufferPool) release(page *Page) {
+	pool.pageTable[page.key()].PopSelf()
+	delete(pool.pageTable, page.key())
+	pool.policy.Remove(page)
+	page.pagenum = NOPAGE
+	page.SetDirty(false)
+	pool.freeList.PushTail(page)
+}
+
+// pagesOf returns the pages in the pool that belong to the given pager.
+// the pool mutex should be locked on entry
+func (pool *BufferPool) pagesOf(pager *Pager) []*Page {
+	pages := make([]*Page, 0)
+	collect := func(link *list.Link) {
+		if page := link.GetKey().(*Page); page.pager == pager {
+			pages = append(pages, page)
+		}
+	}
+	pool.pinnedList.Map(collect)
+	pool.unpinnedList.Map(collect)
+	return pages
+}
@@ -0,0 +1,79 @@
+package pager
+
+// Prefetching lets a scan ask for the pages it will need next, so that
+// they are read in the background while it works on the current one. A
+// page being prefetched is in the page table and pinned, with its loading
+// channel set; getPage waits for the channel to close before using it.
+// Frames come from the pool like any other, so once the pool is full a
+// prefetch evicts the page the replacement policy picks.
+
+// Prefetch starts reading the given pages in the background, so that a
+// later GetPage finds them in the buffer pool. Pages that are already
+// resident or beyond the end of the file are skipped, and prefetching
+// stops once every frame in the pool is pinned.
+func (pager *Pager) Prefetch(pagenums ...int64) {
+	// Mapped pages are already in memory.
+	if pager.IsMapped() {
+		return
+	}
+	pager.lock()
+	defer pager.unlock()
+	if !pager.HasFile() {
+		return
+	}
+	pool := pager.pool
+	for _, pagenum := range pagenums {
+		key := pageKey{pager: pager, pagenum: pagenum}
+		if pagenum < 0 || pagenum >= pager.maxPageNum {
+			continue
+		}
+		if _, ok := pool.pageTable[key]; ok {
+			continue
+		}
+		page, err := pool.newFrame()
+		if err != nil {
+			return
+		}
+		page.pager = pager
+		page.pagenum = pagenum
+		page.pinCount = 1
+		pager.trackPin(page)
+		page.SetDirty(false)
+		page.loading = make(chan struct{})
+		pool.pageTable[key] = pool.pinnedList.PushTail(page)
+		// A compressed page's location can't change while it is loading, since
+		// only a resident page can be written.
+		ext := extent{sector: NOPAGE}
+		if pager.IsCompressed() {
+			ext = pager.compression.extentOf(pagenum)
+		}
+		pager.loads.Add(1)
+		go pager.load(page, ext)
+	}
+}
+
+// load reads a prefetched page, then unpins it, or gives its frame back if
+// the read failed; the next GetPage will then read it again and see the error.
+func (pager *Pager) load(page *Page, ext extent) {
+	defer pager.loads.Done()
+	var err error
+	if pager.IsCompressed() {
+		err = pager.readExtent(*page.frame, page.pagenum, ext)
+	} else {
+		err = pager.readFrame(*page.frame, page.pagenum)
+	}
+	pool := pager.pool
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	close(page.loading)
+	page.loading = nil
+	if err != nil {
+		page.pinCount = 0
+		pager.forgetPins(page)
+		pool.release(page)
+		return
+	}
+	pager.count(statPrefetches, 1)
+	pool.policy.Access(page)
+	pager.unpin(page)
+}
@@ -0,0 +1,120 @@
+package pager
+
+import (
+	"fmt"
+)
+
+// A write that fails leaves the file in an unknown state: the page may be
+// half written, and a compressed file's page map may already point at
+// sectors that never made it to disk. Retrying can't fix that, so the
+// first failed write switches the pager to read-only mode. Its dirty pages
+// stay in the buffer pool, flushes and new pages fail with a
+// *ReadOnlyError, and the indexes refuse further changes. Reads still
+// work, and the pager can be closed; reopening the file and recovering
+// from the log brings back the changes that didn't make it.
+
+// WriteError is returned when a page or the file header couldn't be
+// written, or the file couldn't be synced. A failed sync is as bad as a
+// failed write, since it isn't known which writes reached the disk.
+type WriteError struct {
+	File    string // Name of the file.
+	PageNum int64  // Page number of the page, or NOPAGE for the header or a sync.
+	Sync    bool   // Set if the file couldn't be synced.
+	Err     error  // Error from the storage.
+}
+
+// Error describes the failed write.
+func (err *WriteError) Error() string {
+	if err.Sync {
+		return fmt.Sprintf("%v: writes could not be synced: %v", err.File, err.Err)
+	}
+	if err.PageNum == NOPAGE {
+		return fmt.Sprintf("%v: header could not be written: %v", err.File, err.Err)
+	}
+	return fmt.Sprintf("%v: page %v could not be written: %v", err.File, err.PageNum, err.Err)
+}
+
+// Unwrap returns the error from the storage.
+func (err *WriteError) Unwrap() error {
+	return err.Err
+}
+
+// ReadOnlyError is returned by operations that would change a pager that
+// is memory-mapped or has switched to read-only mode.
+type ReadOnlyError struct {
+	File  string // Name of the file.
+	Cause error  // Why the pager is read-only; usually the write error that made it so.
+}
+
+// Error describes why the pager is read-only.
+func (err *ReadOnlyError) Error() string {
+	return fmt.Sprintf("%v is read-only: %v", err.File, err.Cause)
+}
+
+// Unwrap returns the reason the pager is read-only.
+func (err *ReadOnlyError) Unwrap() error {
+	return err.Cause
+}
+
+// FlushError is returned by FlushAllPages and Close when some dirty pages
+// couldn't be written.
+type FlushError struct {
+	File     string  // Name of the file.
+	PageNums []int64 // Pages that weren't written, in the order they were tried.
+	Err      error   // Error from the first page that wasn't written.
+}
+
+// Error describes the failed flush.
+func (err *FlushError) Error() string {
+	return fmt.Sprintf("%v: %v dirty pages could not be written %v: %v", err.File, len(err.PageNums), err.PageNums, err.Err)
+}
+
+// Unwrap returns the error from the first page that wasn't written.
+func (err *FlushError) Unwrap() error {
+	return err.Err
+}
+
+// IsReadOnly returns true if the pager is memory-mapped or has switched to
+// read-only mode.
+func (pager *Pager) IsReadOnly() bool {
+	return pager.CheckWritable() != nil
+}
+
+// CheckWritable returns a *ReadOnlyError if the pager is memory-mapped or
+// has switched to read-only mode, and nil otherwise.
+func (pager *Pager) CheckWritable() error {
+	if pager.IsMapped() {
+		return &ReadOnlyError{File: pager.GetFileName(), Cause: errMapped}
+	}
+	pager.failMtx.Lock()
+	defer pager.failMtx.Unlock()
+	if pager.writeErr == nil {
+		return nil
+	}
+	return &ReadOnlyError{File: pager.GetFileName(), Cause: pager.writeErr}
+}
+
+// fail switches the pager to read-only mode after a write error, and
+// returns the error wrapped in a *WriteError.
+// the pool mutex should be locked on entry
+func (pager *Pager) fail(pagenum int64, err error) error {
+	return pager.failWith(&WriteError{File: pager.GetFileName(), PageNum: pagenum, Err: err})
+}
+
+// failSync switches the pager to read-only mode after a sync error, and
+// returns the error wrapped in a *WriteError.
+// the pool mutex should be locked on entry
+func (pager *Pager) failSync(err error) error {
+	return pager.failWith(&WriteError{File: pager.GetFileName(), PageNum: NOPAGE, Sync: true, Err: err})
+}
+
+// failWith switches the pager to read-only mode with the given error, unless
+// it already failed, and returns the error.
+func (pager *Pager) failWith(writeErr *WriteError) error {
+	pager.failMtx.Lock()
+	defer pager.failMtx.Unlock()
+	if pager.writeErr == nil {
+		pager.writeErr = writeErr
+	}
+	return writeErr
+}
@@ -0,0 +1,112 @@
+package pager
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	list "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/list"
+)
+
+// Counters kept by pagers and buffer pools.
+type statCounter int
+
+const (
+	statHits statCounter = iota
+	statMisses
+	statEvictions
+	statDirtyFlushes
+	statBytesRead
+	statBytesWritten
+	statWritebacks
+	statPrefetches
+	numStatCounters
+)
+
+// statCounters holds a set of counters that are updated atomically.
+type statCounters [numStatCounters]int64
+
+// Stats is a snapshot of a pager's or a buffer pool's activity.
+type Stats struct {
+	Hits         int64 // Requests for pages that were in the buffer.
+	Misses       int64 // Requests for pages that had to be read or created.
+	Evictions    int64 // Pages evicted to make room for others.
+	DirtyFlushes int64 // Dirty pages written back to disk.
+	BytesRead    int64 // Bytes read from disk.
+	BytesWritten int64 // Bytes written to disk.
+	Writebacks   int64 // Dirty pages written by the background flusher.
+	Prefetches   int64 // Pages read in the background by Prefetch.
+	Pinned       int64 // Pages currently pinned.
+	Unpinned     int64 // Pages currently in the buffer but unpinned.
+}
+
+// count adds n to one of the pager's counters, and to its pool's.
+func (pager *Pager) count(counter statCounter, n int64) {
+	atomic.AddInt64(&pager.counters[counter], n)
+	atomic.AddInt64(&pager.pool.counters[counter], n)
+}
+
+// snapshot reads a set of counters into a Stats.
+func (counters *statCounters) snapshot() Stats {
+	return Stats{
+		Hits:         atomic.LoadInt64(&counters[statHits]),
+		Misses:       atomic.LoadInt64(&counters[statMisses]),
+		Evictions:    atomic.LoadInt64(&counters[statEvictions]),
+		DirtyFlushes: atomic.LoadInt64(&counters[statDirtyFlushes]),
+		BytesRead:    atomic.LoadInt64(&counters[statBytesRead]),
+		BytesWritten: atomic.LoadInt64(&counters[statBytesWritten]),
+		Writebacks:   atomic.LoadInt64(&counters[statWritebacks]),
+		Prefetches:   atomic.LoadInt64(&counters[statPrefetches]),
+	}
+}
+
+// GetStats returns the pager's statistics. Evictions count the pager's
+// pages that were evicted, whichever pager needed the frame.
+func (pager *Pager) GetStats() Stats {
+	stats := pager.counters.snapshot()
+	pager.pool.mtx.Lock()
+	defer pager.pool.mtx.Unlock()
+	for _, page := range pager.pool.pagesOf(pager) {
+		if page.pinCount > 0 {
+			stats.Pinned++
+		} else {
+			stats.Unpinned++
+		}
+	}
+	return stats
+}
+
+// GetStats returns the statistics of every pager that uses the pool.
+func (pool *BufferPool) GetStats() Stats {
+	stats := pool.counters.snapshot()
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	pool.unpinnedList.Map(func(*list.Link) {
+		stats.Unpinned++
+	})
+	stats.Pinned = int64(len(pool.pageTable)) - stats.Unpinned
+	return stats
+}
+
+// HitRatio returns the fraction of requests that were hits.
+func (stats Stats) HitRatio() float64 {
+	if stats.Hits+stats.Misses == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+// Print writes the statistics out, one per line.
+func (stats Stats) Print(w io.Writer) {
+	io.WriteString(w, fmt.Sprintf("hits: %v\n", stats.Hits))
+	io.WriteString(w, fmt.Sprintf("misses: %v\n", stats.Misses))
+	io.WriteString(w, fmt.Sprintf("hit ratio: %.3f\n", stats.HitRatio()))
+	io.WriteString(w, fmt.Sprintf("evictions: %v\n", stats.Evictions))
+	io.WriteString(w, fmt.Sprintf("dirty flushes: %v\n", stats.DirtyFlushes))
+	io.WriteString(w, fmt.Sprintf("bytes read: %v\n", stats.BytesRead))
+	io.WriteString(w, fmt.Sprintf("bytes written: %v\n", stats.BytesWritten))
+	io.WriteString(w, fmt.Sprintf("writebacks: %v\n", stats.Writebacks))
+	io.WriteString(w, fmt.Sprintf("prefetches: %v\n", stats.Prefetches))
+	io.WriteString(w, fmt.Sprintf("pinned: %v\n", stats.Pinned))
+	io.WriteString(w, fmt.Sprintf("unpinned: %v\n", stats.Unpinned))
+}
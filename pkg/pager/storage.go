@@ -0,0 +1,295 @@
+package pager
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	directio "github.com/ncw/directio"
+)
+
+// Storage is where a pager keeps its file. Reads and writes may happen
+// concurrently, so implementations must allow that.
+type Storage interface {
+	io.ReaderAt
+	io.WriterAt
+	Name() string         // Name the storage was opened with.
+	Size() (int64, error) // Current size in bytes.
+	Sync() error          // Make previous writes durable.
+	Close() error
+}
+
+// A Backend opens storage for pagers, creating it if it doesn't exist.
+type Backend interface {
+	// Open opens the named storage. Direct storage bypasses the operating
+	// system's cache, and needs aligned buffers and offsets.
+	Open(name string, direct bool) (Storage, error)
+	// Exists returns true if the named storage has been created.
+	Exists(name string) bool
+	// Remove deletes the named storage, which mustn't be open.
+	Remove(name string) error
+	// List returns the names of the storage directly inside the named
+	// directory, in sorted order.
+	List(dir string) ([]string, error)
+}
+
+// WithBackend makes the pager keep its file in the given backend.
+func WithBackend(backend Backend) Option {
+	return func(pager *Pager) {
+		pager.backend = backend
+	}
+}
+
+// GetBackend returns the backend the pager keeps its file in.
+func (pager *Pager) GetBackend() Backend {
+	return pager.backend
+}
+
+// CopyStorage copies the named storage to another name in the same
+// backend, replacing anything there, and syncs the copy.
+func CopyStorage(backend Backend, from string, to string) (err error) {
+	src, err := backend.Open(from, false)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if backend.Exists(to) {
+		if err = backend.Remove(to); err != nil {
+			return err
+		}
+	}
+	dst, err := backend.Open(to, false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	size, err := src.Size()
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, PAGESIZE)
+	for off := int64(0); off < size; off += int64(len(buf)) {
+		n, err := src.ReadAt(buf, off)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if _, err = dst.WriteAt(buf[:n], off); err != nil {
+			return err
+		}
+	}
+	return dst.Sync()
+}
+
+/////////////////////////////////////////////////////////////////////////////
+/////////////////////////////////// FILES ///////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////
+
+// Backend that keeps each pager's file on disk; pagers use it by default.
+var FileBackend Backend = fileBackend{}
+
+// fileBackend opens files on disk.
+type fileBackend struct{}
+
+// fileStorage is a file on disk.
+type fileStorage struct {
+	*os.File
+}
+
+// Open opens or creates a file, along with the directories leading to it.
+func (fileBackend) Open(name string, direct bool) (Storage, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0775); err != nil {
+		return nil, err
+	}
+	var file *os.File
+	var err error
+	if direct {
+		file, err = directio.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	} else {
+		file, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0666)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fileStorage{file}, nil
+}
+
+// Exists returns true if the file exists.
+func (fileBackend) Exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Remove deletes the file.
+func (fileBackend) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// List returns the files in a directory; a missing directory is empty.
+func (fileBackend) List(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, filepath.Join(dir, info.Name()))
+		}
+	}
+	return names, nil
+}
+
+// Size returns the size of the file.
+func (storage fileStorage) Size() (int64, error) {
+	info, err := storage.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+/////////////////////////////////////////////////////////////////////////////
+////////////////////////////////// MEMORY ///////////////////////////////////
+/////////////////////////////////////////////////////////////////////////////
+
+// MemoryBackend keeps files in memory. Files outlive the storage opened on
+// them, so they can be reopened until the backend itself is dropped.
+type MemoryBackend struct {
+	mtx   sync.Mutex
+	files map[string]*memoryFile
+}
+
+// memoryFile is the contents of a file in a MemoryBackend.
+type memoryFile struct {
+	mtx  sync.RWMutex
+	data []byte
+}
+
+// memoryStorage is a file in a MemoryBackend opened by a pager.
+type memoryStorage struct {
+	name   string
+	file   *memoryFile
+	closed bool
+}
+
+// NewMemoryBackend returns an empty MemoryBackend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: make(map[string]*memoryFile)}
+}
+
+// Open opens a file, creating it if needed.
+func (backend *MemoryBackend) Open(name string, direct bool) (Storage, error) {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	file, ok := backend.files[name]
+	if !ok {
+		file = &memoryFile{}
+		backend.files[name] = file
+	}
+	return &memoryStorage{name: name, file: file}, nil
+}
+
+// Exists returns true if the file has been created.
+func (backend *MemoryBackend) Exists(name string) bool {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	_, ok := backend.files[name]
+	return ok
+}
+
+// Remove deletes the file.
+func (backend *MemoryBackend) Remove(name string) error {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	if _, ok := backend.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(backend.files, name)
+	return nil
+}
+
+// List returns the files whose names are directly inside a directory.
+func (backend *MemoryBackend) List(dir string) ([]string, error) {
+	backend.mtx.Lock()
+	defer backend.mtx.Unlock()
+	dir = filepath.Clean(dir)
+	names := make([]string, 0)
+	for name := range backend.files {
+		if filepath.Dir(filepath.Clean(name)) == dir {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Name returns the file's name.
+func (storage *memoryStorage) Name() string {
+	return storage.name
+}
+
+// ReadAt reads from the file; reads past the end return io.EOF.
+func (storage *memoryStorage) ReadAt(p []byte, off int64) (int, error) {
+	if storage.closed {
+		return 0, os.ErrClosed
+	}
+	storage.file.mtx.RLock()
+	defer storage.file.mtx.RUnlock()
+	if off >= int64(len(storage.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, storage.file.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes to the file, growing it if needed.
+func (storage *memoryStorage) WriteAt(p []byte, off int64) (int, error) {
+	if storage.closed {
+		return 0, os.ErrClosed
+	}
+	storage.file.mtx.Lock()
+	defer storage.file.mtx.Unlock()
+	if end := off + int64(len(p)); end > int64(len(storage.file.data)) {
+		storage.file.data = append(storage.file.data, make([]byte, end-int64(len(storage.file.data)))...)
+	}
+	return copy(storage.file.data[off:], p), nil
+}
+
+// Size returns the size of the file.
+func (storage *memoryStorage) Size() (int64, error) {
+	storage.file.mtx.RLock()
+	defer storage.file.mtx.RUnlock()
+	return int64(len(storage.file.data)), nil
+}
+
+// Sync does nothing; memory is as durable as it gets.
+func (storage *memoryStorage) Sync() error {
+	return nil
+}
+
+// Map returns the file's contents; writes through other storage opened on
+// the file show up in them unless the file has to grow.
+func (storage *memoryStorage) Map() ([]byte, func() error, error) {
+	storage.file.mtx.RLock()
+	defer storage.file.mtx.RUnlock()
+	data := storage.file.data
+	return data[:len(data):len(data)], func() error { return nil }, nil
+}
+
+// Close closes the storage; the file's contents are kept.
+func (storage *memoryStorage) Close() error {
+	storage.closed = true
+	return nil
+}
@@ -0,0 +1,133 @@
+package pager
+
+import (
+	"sync/atomic"
+	"time"
+
+	list "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/list"
+)
+
+// A pager can run a background flusher that writes its dirty, unpinned
+// pages back before they are evicted, so that NewPage usually finds a
+// clean victim and doesn't have to wait for a write. The flusher writes
+// every dirty page of its pager once too much of the buffer pool is
+// dirty, and otherwise only pages that have been dirty for too long.
+
+// WritebackConfig controls a pager's background flusher.
+type WritebackConfig struct {
+	DirtyRatio float64       // Flush once this fraction of the pool's frames is dirty.
+	MaxAge     time.Duration // Flush pages that have been dirty for longer than this.
+	Interval   time.Duration // How often the flusher wakes up.
+}
+
+// Writeback configuration used by databases unless told otherwise.
+var DEFAULT_WRITEBACK = WritebackConfig{
+	DirtyRatio: 0.25,
+	MaxAge:     time.Second,
+	Interval:   100 * time.Millisecond,
+}
+
+// writeback is the state of a running flusher.
+type writeback struct {
+	stop chan struct{} // Closed to stop the flusher.
+	done chan struct{} // Closed once the flusher has stopped.
+}
+
+// WithWriteback makes the pager run a background flusher with the given
+// configuration while its file is open. A zero Interval disables it.
+func WithWriteback(config WritebackConfig) Option {
+	return func(pager *Pager) {
+		pager.writebackConfig = config
+	}
+}
+
+// startWriteback starts the pager's flusher, if it has one.
+func (pager *Pager) startWriteback() {
+	if pager.writebackConfig.Interval <= 0 || pager.writeback != nil {
+		return
+	}
+	wb := &writeback{stop: make(chan struct{}), done: make(chan struct{})}
+	pager.writeback = wb
+	go pager.runWriteback(wb)
+}
+
+// stopWriteback stops the pager's flusher and waits for it to finish.
+func (pager *Pager) stopWriteback() {
+	if pager.writeback == nil {
+		return
+	}
+	close(pager.writeback.stop)
+	<-pager.writeback.done
+	pager.writeback = nil
+}
+
+// runWriteback is the body of the flusher goroutine.
+func (pager *Pager) runWriteback(wb *writeback) {
+	defer close(wb.done)
+	ticker := time.NewTicker(pager.writebackConfig.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-wb.stop:
+			return
+		case now := <-ticker.C:
+			pager.writeBack(now)
+		}
+	}
+}
+
+// writeBack writes the pages chosen by writebackCandidates, taking the
+// locks for one page at a time so that other work can go on in between.
+// Stops at the first failed write, which leaves the pager read-only.
+// Returns the number of pages written.
+func (pager *Pager) writeBack(now time.Time) (written int) {
+	if pager.IsReadOnly() {
+		return 0
+	}
+	pager.lock()
+	candidates := pager.writebackCandidates(now)
+	pager.unlock()
+	for _, page := range candidates {
+		pager.lock()
+		// The frame may have been pinned or handed to another page meanwhile.
+		link, ok := pager.pool.pageTable[pageKey{pager: pager, pagenum: page.pagenum}]
+		if ok && link.GetKey().(*Page) == page && atomic.LoadInt64(&page.pinCount) == 0 && page.IsDirty() {
+			if err := pager.FlushPage(page); err != nil {
+				pager.unlock()
+				return written
+			}
+			pager.count(statWritebacks, 1)
+			written++
+		}
+		pager.unlock()
+	}
+	return written
+}
+
+// writebackCandidates returns the pager's dirty, unpinned pages that should
+// be written back now.
+// the pool mutex should be locked on entry
+func (pager *Pager) writebackCandidates(now time.Time) []*Page {
+	pool := pager.pool
+	config := pager.writebackConfig
+	dirty := int64(0)
+	candidates := make([]*Page, 0)
+	countDirty := func(link *list.Link) {
+		if link.GetKey().(*Page).IsDirty() {
+			dirty++
+		}
+	}
+	pool.pinnedList.Map(countDirty)
+	pool.unpinnedList.Map(countDirty)
+	overRatio := config.DirtyRatio > 0 && float64(dirty) >= config.DirtyRatio*float64(pool.numPages)
+	pool.unpinnedList.Map(func(link *list.Link) {
+		page := link.GetKey().(*Page)
+		if page.pager != pager || !page.IsDirty() {
+			return
+		}
+		if overRatio || (config.MaxAge > 0 && now.Sub(page.dirtiedAt) >= config.MaxAge) {
+			candidates = append(candidates, page)
+		}
+	})
+	return candidates
+}
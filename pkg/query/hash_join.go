@@ -47,6 +47,7 @@ func buildHashIndex(
 	if cursor_error != nil {
 		return nil, dbName, cursor_error
 	}
+	defer cursor.Close()
 	// Loop over all entries using cursor
 	for {
 		if !cursor.IsEnd() {
@@ -170,7 +171,8 @@ func probeBuckets(
 	return nil
 }
 
-// Join leftTable on rightTable using Grace Hash Join.
+// Join leftTable on rightTable using Grace Hash Join, or an index nested
+// loop join if a table joined on its values has a value index.
 func Join(
 	ctx context.Context,
 	leftTable db.Index,
@@ -178,6 +180,14 @@ func Join(
 	joinOnLeftKey bool,
 	joinOnRightKey bool,
 ) (chan EntryPair, context.Context, *errgroup.Group, func(), error) {
+	// A table joined on its values that has an index on them is probed
+	// through the index, and neither table is hashed.
+	if inner, ok := rightTable.(*db.IndexedTable); ok && !joinOnRightKey {
+		return indexJoin(ctx, leftTable, inner, joinOnLeftKey, true)
+	}
+	if inner, ok := leftTable.(*db.IndexedTable); ok && !joinOnLeftKey {
+		return indexJoin(ctx, rightTable, inner, joinOnRightKey, false)
+	}
 	leftHashIndex, leftDbName, err := buildHashIndex(leftTable, joinOnLeftKey)
 	// join on left key tells us if the left bucket's useKey is true or not
 	if err != nil {
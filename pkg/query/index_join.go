@@ -0,0 +1,81 @@
+package query
+
+import (
+	"context"
+
+	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	db "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/db"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
+
+	errgroup "golang.org/x/sync/errgroup"
+)
+
+// indexJoin joins the outer table on the values of the inner table by
+// looking up each outer entry in the inner table's value index, rather than
+// hashing both tables into temporary indexes. Entries are swapped the way
+// Join swaps them, and the outer table's are on the left if outerIsLeft.
+func indexJoin(
+	ctx context.Context,
+	outer db.Index,
+	inner *db.IndexedTable,
+	joinOnOuterKey bool,
+	outerIsLeft bool,
+) (chan EntryPair, context.Context, *errgroup.Group, func(), error) {
+	cursor, err := outer.TableStart()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	group, ctx := errgroup.WithContext(ctx)
+	resultsChan := make(chan EntryPair, 1024)
+	group.Go(func() error {
+		defer cursor.Close()
+		for {
+			if !cursor.IsEnd() {
+				entry, err := cursor.GetEntry()
+				if err != nil {
+					return err
+				}
+				if err := probeValueIndex(ctx, resultsChan, entry, inner, joinOnOuterKey, outerIsLeft); err != nil {
+					return err
+				}
+			}
+			if cursor.StepForward() {
+				return nil
+			}
+		}
+	})
+	return resultsChan, ctx, group, nil, nil
+}
+
+// probeValueIndex sends a result for each entry of the inner table whose
+// value matches the outer entry's join attribute.
+func probeValueIndex(
+	ctx context.Context,
+	resultsChan chan EntryPair,
+	entry utils.Entry,
+	inner *db.IndexedTable,
+	joinOnOuterKey bool,
+	outerIsLeft bool,
+) error {
+	outerEntry := btree.NewBTreeEntry(entry.GetKeyBytes(), entry.GetValueBytes())
+	if !joinOnOuterKey {
+		outerEntry = btree.NewBTreeEntry(entry.GetValueBytes(), entry.GetKeyBytes())
+	}
+	matches, err := inner.GetValueIndex().Find(outerEntry.GetKeyBytes())
+	if err != nil {
+		return err
+	}
+	defer matches.Close()
+	for matches.Next() {
+		// The value index's entries are already swapped: the value, then the key.
+		innerEntry := matches.Entry()
+		result := EntryPair{l: outerEntry, r: innerEntry}
+		if !outerIsLeft {
+			result = EntryPair{l: innerEntry, r: outerEntry}
+		}
+		if err := sendResult(ctx, resultsChan, result); err != nil {
+			return err
+		}
+	}
+	return matches.Err()
+}
@@ -2,7 +2,11 @@ package recovery
 
 import (
 	"bytes"
+	"encoding/base64"
 	"io"
+	"path/filepath"
+
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 
 	uuid "github.com/google/uuid"
 	backscanner "github.com/icza/backscanner"
@@ -11,17 +15,20 @@ import (
 // Helper method that gets all log strings and most recent checkpoint position from the log file.
 func (rm *RecoveryManager) getRelevantStrings() (
 	relevantStrings []string, checkpointPos int, err error) {
-	fstats, err := rm.fd.Stat()
+	size, err := rm.log.Size()
 	if err != nil {
 		return nil, 0, err
 	}
 
-	scanner := backscanner.New(rm.fd, int(fstats.Size()))
+	scanner := backscanner.New(rm.log, int(size))
 	checkpointTarget := []byte("checkpoint")
 	startTarget := []byte("start")
 	relevantStrings = make([]string, 0)
 	checkpointHit := false
 	txs := make(map[uuid.UUID]bool)
+	// Every record ends in a newline, so the last line is empty, unless a
+	// crash tore the last record; either way it isn't a record.
+	tail := true
 	for {
 		line, _, err := scanner.LineBytes()
 		if err != nil {
@@ -31,6 +38,13 @@ func (rm *RecoveryManager) getRelevantStrings() (
 				return nil, 0, err
 			}
 		}
+		if tail {
+			tail = false
+			continue
+		}
+		if line, err = rm.openRecord(line); err != nil {
+			return nil, 0, err
+		}
 		relevantStrings = append([]string{string(line)}, relevantStrings...)
 		checkpointPos += 1
 		if checkpointHit {
@@ -61,6 +75,23 @@ func (rm *RecoveryManager) getRelevantStrings() (
 	return relevantStrings, checkpointPos, err
 }
 
+// Decrypts a line of the log file if the database is encrypted.
+func (rm *RecoveryManager) openRecord(line []byte) ([]byte, error) {
+	if rm.cipher == nil || len(line) == 0 {
+		return line, nil
+	}
+	authErr := &pager.AuthError{File: filepath.Base(rm.log.Name()), PageNum: pager.NOPAGE}
+	sealed, err := base64.StdEncoding.DecodeString(string(line))
+	if err != nil {
+		return nil, authErr
+	}
+	record, err := rm.cipher.Open(sealed, logRecordAAD)
+	if err != nil {
+		return nil, authErr
+	}
+	return record, nil
+}
+
 // Reads in the logs and most recent checkpoint position from disk.
 func (rm *RecoveryManager) readLogs() (
 	logs []Log, checkpointPos int, err error) {
@@ -68,17 +99,13 @@ func (rm *RecoveryManager) readLogs() (
 	if err != nil {
 		return nil, 0, err
 	}
-	if len(strings) > 0 {
-		logs = make([]Log, len(strings)-1)
-		for i, s := range strings[:len(strings)-1] {
-			log, err := FromString(s)
-			if err != nil {
-				return nil, 0, err
-			}
-			logs[i] = log
+	logs = make([]Log, len(strings))
+	for i, s := range strings {
+		log, err := FromString(s)
+		if err != nil {
+			return nil, 0, err
 		}
-	} else {
-		logs = make([]Log, 0)
+		logs[i] = log
 	}
 	return logs, checkpointPos, nil
 }
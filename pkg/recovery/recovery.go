@@ -1,15 +1,17 @@
 package recovery
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 
 	concurrency "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/concurrency"
 	db "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/db"
-	"github.com/otiai10/copy"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 
 	uuid "github.com/google/uuid"
 )
@@ -19,8 +21,10 @@ type RecoveryManager struct {
 	d       *db.Database
 	tm      *concurrency.TransactionManager
 	txStack map[uuid.UUID]([]Log)
-	fd      *os.File
+	log     pager.Storage // The log file, kept in the database's backend.
+	logSize int64         // Where the next record is appended.
 	mtx     sync.Mutex
+	cipher  *pager.Cipher // Encrypts log records; the database's cipher.
 }
 
 // Construct a recovery manager.
@@ -29,25 +33,45 @@ func NewRecoveryManager(
 	tm *concurrency.TransactionManager,
 	logName string,
 ) (*RecoveryManager, error) {
-	fd, err := os.OpenFile(logName, os.O_APPEND|os.O_RDWR, 0666)
+	if !d.GetBackend().Exists(logName) {
+		return nil, fmt.Errorf("log file %v does not exist", logName)
+	}
+	log, err := d.GetBackend().Open(logName, false)
 	if err != nil {
 		return nil, err
 	}
+	logSize, err := log.Size()
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
 	return &RecoveryManager{
 		d:       d,
 		tm:      tm,
 		txStack: make(map[uuid.UUID][]Log),
-		fd:      fd,
+		log:     log,
+		logSize: logSize,
+		cipher:  d.GetCipher(),
 	}, nil
 }
 
+// Additional data authenticated with every encrypted log record.
+var logRecordAAD = []byte("bumble log record")
+
 // Write the string `s` to the log file. Expects rm.mtx to be locked
+// If the database is encrypted, the record is sealed and written as one
+// line of base64.
 func (rm *RecoveryManager) writeToBuffer(s string) error {
-	_, err := rm.fd.WriteString(s)
+	if rm.cipher != nil {
+		sealed := rm.cipher.Seal([]byte(strings.TrimSuffix(s, "\n")), logRecordAAD)
+		s = base64.StdEncoding.EncodeToString(sealed) + "\n"
+	}
+	n, err := rm.log.WriteAt([]byte(s), rm.logSize)
+	rm.logSize += int64(n)
 	if err != nil {
 		return err
 	}
-	err = rm.fd.Sync()
+	err = rm.log.Sync()
 	return err
 }
 
@@ -110,15 +134,25 @@ func (rm *RecoveryManager) Commit(clientId uuid.UUID) {
 	delete(rm.txStack, clientId)
 }
 
-// Flush all pages to disk and write a checkpoint log.
-func (rm *RecoveryManager) Checkpoint() {
+// Flush all pages to disk and write a checkpoint log. No checkpoint is
+// written if a table couldn't be flushed, since recovery would then skip
+// changes that never reached the disk.
+func (rm *RecoveryManager) Checkpoint() (err error) {
 	rm.mtx.Lock()
 	defer rm.mtx.Unlock()
-	// Lock all pages to prevent tables from being changed while making checkpointing
-	all_tables := rm.d.GetTables()
-	for _, table := range(all_tables) {
-		table.GetPager().LockAllUpdates()
-		table.GetPager().FlushAllPages()
+	// Lock all pages, value indexes' included, to prevent tables from being changed while making checkpointing
+	all_pagers := rm.d.GetPagers()
+	for _, p := range(all_pagers) {
+		p.LockAllUpdates()
+		if flushErr := p.FlushAllPages(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	if err != nil {
+		for _, p := range(all_pagers) {
+			p.UnlockAllUpdates()
+		}
+		return err
 	}
 	// Create checkpoint log by getting all active transactions
 	active_transaction_ids := make([]uuid.UUID, 0)
@@ -131,10 +165,10 @@ func (rm *RecoveryManager) Checkpoint() {
 	rm.writeToBuffer(checkpointl.toString())
 
 	// Unlock all table pages
-	for _, table := range(all_tables) {
-		table.GetPager().UnlockAllUpdates()
+	for _, p := range(all_pagers) {
+		p.UnlockAllUpdates()
 	}
-	rm.Delta() // Sorta-semi-pseudo-copy-on-write (to ensure db recoverability)
+	return rm.Delta() // Sorta-semi-pseudo-copy-on-write (to ensure db recoverability)
 }
 
 // Redo a given log's action.
@@ -218,7 +252,7 @@ func (rm *RecoveryManager) Recover() error {
 
 	all_logs, checkpoint_pos, read_log_error := rm.readLogs()
 	if read_log_error != nil {
-		return errors.New("couldn't read all logs")
+		return fmt.Errorf("couldn't read all logs: %w", read_log_error)
 	}
 	checkpoint_log := all_logs[checkpoint_pos]
 	active_transactions := make(map[uuid.UUID]int)
@@ -318,34 +352,31 @@ func (rm *RecoveryManager) Rollback(clientId uuid.UUID) error {
 	// Commit to both teh RecoveryManager and Transactionmanager when done
 }
 
-// Primes the database for recovery
-func Prime(folder string) (*db.Database, error) {
+// Primes the database for recovery, replacing its files with the copies
+// taken at the last checkpoint, if there are any.
+// Options are passed on to db.Open; the files are kept in its backend.
+func Prime(folder string, opts ...db.Option) (*db.Database, error) {
 	// Ensure folder is of the form */
 	base := strings.TrimSuffix(folder, "/")
 	recoveryFolder := base + "-recovery/"
 	dbFolder := base + "/"
-	if _, err := os.Stat(dbFolder); err != nil {
-		if os.IsNotExist(err) {
-			err := os.MkdirAll(recoveryFolder, 0775)
-			if err != nil {
-				return nil, err
-			}
-			return db.Open(dbFolder)
-		}
+	d, err := db.Open(dbFolder, opts...)
+	if err != nil {
 		return nil, err
 	}
-	if _, err := os.Stat(recoveryFolder); err != nil {
-		if os.IsNotExist(err) {
-			return db.Open(dbFolder)
-		}
+	// No tables are open yet, so their files can be swapped out.
+	backend := d.GetBackend()
+	snapshot, err := backend.List(recoveryFolder)
+	if err != nil || len(snapshot) == 0 {
+		return d, err
+	}
+	if err = removeFolder(backend, dbFolder); err != nil {
 		return nil, err
 	}
-	os.RemoveAll(dbFolder)
-	err := copy.Copy(recoveryFolder, dbFolder)
-	if err != nil {
+	if err = copyFolder(backend, recoveryFolder, dbFolder); err != nil {
 		return nil, err
 	}
-	return db.Open(dbFolder)
+	return d, nil
 }
 
 // Should be called at end of Checkpoint.
@@ -353,7 +384,37 @@ func (rm *RecoveryManager) Delta() error {
 	folder := strings.TrimSuffix(rm.d.GetBasePath(), "/")
 	recoveryFolder := folder + "-recovery/"
 	folder += "/"
-	os.RemoveAll(recoveryFolder)
-	err := copy.Copy(folder, recoveryFolder)
-	return err
+	backend := rm.d.GetBackend()
+	if err := removeFolder(backend, recoveryFolder); err != nil {
+		return err
+	}
+	return copyFolder(backend, folder, recoveryFolder)
+}
+
+// removeFolder removes the files in a folder of a backend.
+func removeFolder(backend pager.Backend, folder string) error {
+	names, err := backend.List(folder)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := backend.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFolder copies the files in a folder of a backend to another folder.
+func copyFolder(backend pager.Backend, from string, to string) error {
+	names, err := backend.List(from)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := pager.CopyStorage(backend, name, filepath.Join(to, filepath.Base(name))); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -270,8 +270,7 @@ func HandleCheckpoint(d *db.Database, tm *concurrency.TransactionManager, rm *Re
 		return fmt.Errorf("usage: checkpoint")
 	}
 	// Get the transaction, run the find, release lock and rollback if error.
-	rm.Checkpoint()
-	return err
+	return rm.Checkpoint()
 }
 
 // Handle abort.
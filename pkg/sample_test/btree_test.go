@@ -1,11 +1,21 @@
 package test
 
 import (
+	"bytes"
+	"errors"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	db "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/db"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 // Set to some other value
@@ -352,3 +362,1683 @@ func testBTreeUpdateTen(t *testing.T) {
 	}
 	index.Close()
 }
+
+func TestBTreeDelete(t *testing.T) {
+	t.Run("TestBTreeDeleteAscending", testBTreeDeleteAscending)
+	t.Run("TestBTreeDeleteDescending", testBTreeDeleteDescending)
+	t.Run("TestBTreeDeleteRandom", testBTreeDeleteRandom)
+	t.Run("TestBTreeDeleteReusesPages", testBTreeDeleteReusesPages)
+}
+
+// Enough entries for a B+ tree with three levels.
+var btree_delete_n = int64(30000)
+
+// openMemoryBTree opens a B+ tree in a memory backend.
+func openMemoryBTree(t *testing.T) *btree.BTreeIndex {
+	index, err := btree.OpenTable("btree", pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+// checkBTree fails the test if the index isn't a valid B+ tree.
+func checkBTree(t *testing.T, index *btree.BTreeIndex) {
+	if _, _, ok, err := btree.IsBTree(index); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("index is not a valid B+ tree")
+	}
+}
+
+// checkBTreeEntries fails the test if the index doesn't hold exactly the
+// given entries.
+func checkBTreeEntries(t *testing.T, index *btree.BTreeIndex, want map[int64]int64) {
+	entries, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("index has %v entries; expected %v", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if i > 0 && entries[i-1].GetKey() >= entry.GetKey() {
+			t.Fatalf("entries out of order: %v before %v", entries[i-1].GetKey(), entry.GetKey())
+		}
+		if value, ok := want[entry.GetKey()]; !ok || value != entry.GetValue() {
+			t.Fatalf("unexpected entry (%v, %v)", entry.GetKey(), entry.GetValue())
+		}
+	}
+}
+
+// checkBTreeEmpty fails the test if the index isn't a single empty leaf.
+func checkBTreeEmpty(t *testing.T, index *btree.BTreeIndex) {
+	var buf bytes.Buffer
+	index.Print(&buf)
+	if !strings.HasPrefix(buf.String(), "[0] Leaf (root) size: 0\n") {
+		t.Fatalf("empty tree didn't collapse into its root:\n%v", buf.String())
+	}
+	p := index.GetPager()
+	if p.GetNumFreePages() != p.GetNumPages()-1 {
+		t.Errorf("%v of %v pages are free; expected all but the root", p.GetNumFreePages(), p.GetNumPages())
+	}
+}
+
+// deleteBTreeKeys deletes the given keys, checking the tree as it shrinks.
+func deleteBTreeKeys(t *testing.T, index *btree.BTreeIndex, keys []int64) {
+	for i, key := range keys {
+		if err := index.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		if i%1000 == 0 {
+			checkBTree(t, index)
+		}
+	}
+	checkBTree(t, index)
+}
+
+func testBTreeDeleteAscending(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	keys := make([]int64, 0)
+	for i := int64(0); i < btree_delete_n; i++ {
+		if err := index.Insert(i, i%btree_salt); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, i)
+	}
+	checkBTree(t, index)
+	deleteBTreeKeys(t, index, keys)
+	checkBTreeEmpty(t, index)
+}
+
+func testBTreeDeleteDescending(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	keys := make([]int64, 0)
+	for i := int64(0); i < btree_delete_n; i++ {
+		if err := index.Insert(i, i%btree_salt); err != nil {
+			t.Fatal(err)
+		}
+		keys = append([]int64{i}, keys...)
+	}
+	deleteBTreeKeys(t, index, keys)
+	checkBTreeEmpty(t, index)
+}
+
+func testBTreeDeleteRandom(t *testing.T) {
+	index := openMemoryBTree(t)
+	r := rand.New(rand.NewSource(1270))
+	want := make(map[int64]int64)
+	for _, i := range r.Perm(int(btree_delete_n)) {
+		key := int64(i)
+		if err := index.Insert(key, key%btree_salt); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = key % btree_salt
+	}
+	// Delete two thirds of the keys, along with some that aren't there.
+	keys := make([]int64, 0)
+	for _, i := range r.Perm(int(btree_delete_n + btree_delete_n/10)) {
+		if i%3 != 0 {
+			keys = append(keys, int64(i))
+			delete(want, int64(i))
+		}
+	}
+	deleteBTreeKeys(t, index, keys)
+	checkBTreeEntries(t, index, want)
+	for key := range want {
+		if entry, err := index.Find(key); err != nil || entry.GetValue() != want[key] {
+			t.Fatalf("couldn't find remaining key %v", key)
+		}
+	}
+	// The rebalanced tree survives a reopen.
+	p := index.GetPager()
+	backend, name := p.GetBackend(), p.GetFilePath()
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	index, err := btree.OpenTable(name, pager.WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	checkBTree(t, index)
+	checkBTreeEntries(t, index, want)
+	// Delete the rest.
+	keys = keys[:0]
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	deleteBTreeKeys(t, index, keys)
+	checkBTreeEmpty(t, index)
+}
+
+func testBTreeDeleteReusesPages(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	keys := make([]int64, 0)
+	for i := int64(0); i < btree_delete_n; i++ {
+		if err := index.Insert(i, i); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, i)
+	}
+	numPages := index.GetPager().GetNumPages()
+	deleteBTreeKeys(t, index, keys)
+	// Growing the tree again takes its pages from the free list.
+	for i := int64(0); i < btree_delete_n; i++ {
+		if err := index.Insert(i, -i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, index)
+	if got := index.GetPager().GetNumPages(); got != numPages {
+		t.Errorf("tree grew to %v pages; expected it to reuse its %v", got, numPages)
+	}
+}
+
+func TestBTreeBytes(t *testing.T) {
+	t.Run("TestBTreeStringKeys", testBTreeStringKeys)
+	t.Run("TestBTreeComparator", testBTreeComparator)
+	t.Run("TestBTreeEntryTooLarge", testBTreeEntryTooLarge)
+	t.Run("TestBTreeQuotedRepl", testBTreeQuotedRepl)
+	t.Run("TestBTreeOverflow", testBTreeOverflow)
+	t.Run("TestBTreeOverflowCorrupt", testBTreeOverflowCorrupt)
+}
+
+// randomBytes returns a string of random letters of a random length below n.
+func randomBytes(r *rand.Rand, n int) string {
+	b := make([]byte, r.Intn(n))
+	for i := range b {
+		b[i] = byte('a' + r.Intn(26))
+	}
+	return string(b)
+}
+
+func testBTreeStringKeys(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	r := rand.New(rand.NewSource(1270))
+	// Keys and values of very different sizes, up to a fifth of a page.
+	want := make(map[string]string)
+	for len(want) < 5000 {
+		key := randomBytes(r, 200)
+		if _, ok := want[key]; ok {
+			continue
+		}
+		value := randomBytes(r, 600)
+		if err := index.InsertBytes(utils.EncodeString(key), utils.EncodeString(value)); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	checkBTree(t, index)
+	for key := range want {
+		if err := index.InsertBytes(utils.EncodeString(key), nil); err == nil {
+			t.Error("expected an error inserting a duplicate key")
+		}
+		break
+	}
+	// Updates grow and shrink entries, splitting and rebalancing nodes.
+	for key := range want {
+		value := strings.Repeat("v", r.Intn(2)*r.Intn(800))
+		if err := index.UpdateBytes(utils.EncodeString(key), utils.EncodeString(value)); err != nil {
+			t.Fatal(err)
+		}
+		want[key] = value
+	}
+	checkBTree(t, index)
+	keys := make([]string, 0)
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(keys) {
+		t.Fatalf("index has %v entries; expected %v", len(entries), len(keys))
+	}
+	for i, entry := range entries {
+		key, _ := utils.DecodeString(entry.GetKeyBytes())
+		value, _ := utils.DecodeString(entry.GetValueBytes())
+		if key != keys[i] || value != want[key] {
+			t.Fatalf("entry %v is (%q, %q); expected key %q", i, key, value, keys[i])
+		}
+	}
+	for _, key := range keys {
+		entry, err := index.FindBytes(utils.EncodeString(key))
+		if err != nil {
+			t.Fatalf("couldn't find key %q: %v", key, err)
+		}
+		if value, _ := utils.DecodeString(entry.GetValueBytes()); value != want[key] {
+			t.Fatalf("key %q has the wrong value", key)
+		}
+	}
+	// Delete everything in random order.
+	r.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	for i, key := range keys {
+		if err := index.DeleteBytes(utils.EncodeString(key)); err != nil {
+			t.Fatal(err)
+		}
+		if i%500 == 0 {
+			checkBTree(t, index)
+		}
+	}
+	checkBTreeEmpty(t, index)
+}
+
+func testBTreeComparator(t *testing.T) {
+	reverse := func(a []byte, b []byte) int { return bytes.Compare(b, a) }
+	index, err := btree.OpenTableWithComparator("btree", reverse, pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	for i := int64(0); i < 1000; i++ {
+		if err := index.Insert(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, index)
+	entries, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if entry.GetKey() != int64(999-i) {
+			t.Fatalf("entry %v has key %v; expected keys in descending order", i, entry.GetKey())
+		}
+	}
+}
+
+func testBTreeEntryTooLarge(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	if err := index.InsertBytes(make([]byte, pager.PAGESIZE/4), utils.EncodeInt(1)); err == nil {
+		t.Fatal("expected an error inserting a key larger than a quarter of a page")
+	}
+	if err := index.Insert(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	checkBTree(t, index)
+	if n := index.GetPager().GetNumPages(); n != 1 {
+		t.Errorf("table has %v pages; expected only the root", n)
+	}
+}
+
+func testBTreeQuotedRepl(t *testing.T) {
+	database, err := db.Open("db", db.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	defer database.Close()
+	if err := db.HandleCreateTable(database, "create btree table t", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{
+		`insert "bumble bee" "buzz" into t`,
+		`insert 7 "seven \"7\"" into t`,
+		`insert "" 0 into t`,
+	} {
+		if err := db.HandleInsert(database, cmd); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	if err := db.HandleUpdate(database, `update t "bumble bee" "buzz buzz"`); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := db.HandleFind(database, `find "bumble bee" from t`, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "found entry: (\"bumble bee\", \"buzz buzz\")\n" {
+		t.Errorf("unexpected find output: %q", buf.String())
+	}
+	if err := db.HandleDelete(database, `delete "" from t`); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := db.HandleSelect(database, "select from t", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "(7, \"seven \\\"7\\\"\")\n(\"bumble bee\", \"buzz buzz\")\n"; buf.String() != want {
+		t.Errorf("unexpected select output: %q", buf.String())
+	}
+	if err := db.HandleInsert(database, `insert "unterminated 1 into t`); err == nil {
+		t.Error("expected an error for an unterminated string")
+	}
+}
+
+func testBTreeOverflow(t *testing.T) {
+	index := openMemoryBTree(t)
+	r := rand.New(rand.NewSource(1270))
+	// Values from a few bytes up to many pages, so some entries overflow.
+	values := make(map[int64][]byte)
+	for i := int64(0); i < 300; i++ {
+		value := make([]byte, r.Intn(int(pager.PAGESIZE))<<uint(r.Intn(5)))
+		r.Read(value)
+		if err := index.InsertBytes(utils.EncodeInt(i), value); err != nil {
+			t.Fatal(err)
+		}
+		values[i] = value
+	}
+	checkBTree(t, index)
+	checkValues := func() {
+		for key, value := range values {
+			entry, err := index.Find(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(entry.GetValueBytes(), value) {
+				t.Fatalf("key %v has the wrong value", key)
+			}
+		}
+		entries, err := index.Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range entries {
+			if !bytes.Equal(entry.GetValueBytes(), values[entry.GetKey()]) {
+				t.Fatalf("key %v has the wrong value", entry.GetKey())
+			}
+		}
+	}
+	checkValues()
+	// Updates free the chains they replace.
+	for key := range values {
+		value := make([]byte, r.Intn(int(pager.PAGESIZE))<<uint(r.Intn(5)))
+		r.Read(value)
+		if err := index.UpdateBytes(utils.EncodeInt(key), value); err != nil {
+			t.Fatal(err)
+		}
+		values[key] = value
+	}
+	checkBTree(t, index)
+	checkValues()
+	// A failed insert doesn't leave its value's pages behind.
+	used := index.GetPager().GetNumPages() - index.GetPager().GetNumFreePages()
+	if err := index.InsertBytes(utils.EncodeInt(0), make([]byte, 4*pager.PAGESIZE)); err == nil {
+		t.Fatal("expected an error inserting a duplicate key")
+	}
+	if got := index.GetPager().GetNumPages() - index.GetPager().GetNumFreePages(); got != used {
+		t.Errorf("%v pages are in use after a failed insert; expected %v", got, used)
+	}
+	// Overflow pages survive a reopen.
+	p := index.GetPager()
+	backend, name := p.GetBackend(), p.GetFilePath()
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	index, err := btree.OpenTable(name, pager.WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	checkValues()
+	// Deletes free every overflow page.
+	for key := range values {
+		if err := index.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTreeEmpty(t, index)
+}
+
+// A value whose overflow pages are corrupted can't be found, and the
+// lookup says why rather than that the key is missing.
+func testBTreeOverflowCorrupt(t *testing.T) {
+	index := openMemoryBTree(t)
+	if err := index.Insert(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.InsertBytes(utils.EncodeInt(1), make([]byte, 3*pager.PAGESIZE)); err != nil {
+		t.Fatal(err)
+	}
+	p := index.GetPager()
+	backend, name := p.GetBackend(), p.GetFilePath()
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The root is page 0, so the value's chain starts at page 1.
+	file, err := backend.Open(name, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xff}, (pager.HEADER_PAGES+1)*pager.PAGESIZE+pager.PAGE_HEADER_SIZE+100); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	index, err = btree.OpenTable(name, pager.WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	if _, err := index.Find(0); err != nil {
+		t.Error(err)
+	}
+	_, err = index.FindBytes(utils.EncodeInt(1))
+	var corrupt *pager.CorruptPageError
+	if !errors.As(err, &corrupt) {
+		t.Errorf("expected a corruption error, got %v", err)
+	}
+}
+
+func TestBTreeCursor(t *testing.T) {
+	t.Run("TestBTreeCursorBackward", testBTreeCursorBackward)
+	t.Run("TestBTreeCursorSeek", testBTreeCursorSeek)
+	t.Run("TestBTreeCursorClose", testBTreeCursorClose)
+}
+
+func testBTreeCursorBackward(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	keys := rand.New(rand.NewSource(1270)).Perm(2000)
+	for _, key := range keys {
+		if err := index.Insert(int64(key), int64(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Delete every third key, so that leaves merge and borrow.
+	for key := int64(0); key < 2000; key += 3 {
+		if err := index.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, index)
+	cursor, err := index.TableEnd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	want := int64(1999)
+	for {
+		if want%3 == 0 {
+			want--
+		}
+		entry, err := cursor.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetKey() != want {
+			t.Fatalf("backward scan found %v; expected %v", entry.GetKey(), want)
+		}
+		want--
+		if cursor.StepBackward() {
+			break
+		}
+	}
+	if want != 0 {
+		t.Errorf("backward scan stopped before %v", want)
+	}
+}
+
+func testBTreeCursorSeek(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	for key := int64(0); key < 1000; key++ {
+		if err := index.Insert(2*key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursor, err := index.TableStart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	// Seek lands on the key, or on the next key if it is missing.
+	for _, key := range []int64{1500, 0, 777, 1998} {
+		if err := cursor.Seek(utils.EncodeInt(key)); err != nil {
+			t.Fatal(err)
+		}
+		entry, err := cursor.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (key + 1) / 2 * 2; entry.GetKey() != want {
+			t.Errorf("seek to %v found %v; expected %v", key, entry.GetKey(), want)
+		}
+	}
+	// Seeking past the last key leaves the cursor at the end.
+	if err := cursor.Seek(utils.EncodeInt(1999)); err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.IsEnd() {
+		t.Error("seek past the last key didn't end the cursor")
+	}
+	// The last N entries, in descending order.
+	if err := cursor.Seek(utils.EncodeInt(1990)); err != nil {
+		t.Fatal(err)
+	}
+	for want := int64(1990); want >= 1980; want -= 2 {
+		entry, err := cursor.GetEntry()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetKey() != want {
+			t.Fatalf("found %v stepping back; expected %v", entry.GetKey(), want)
+		}
+		cursor.StepBackward()
+	}
+}
+
+func testBTreeCursorClose(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	for key := int64(0); key < 1000; key++ {
+		if err := index.Insert(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursor, err := index.TableFind(500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 300; i++ {
+		cursor.StepForward()
+	}
+	for i := 0; i < 600; i++ {
+		cursor.StepBackward()
+	}
+	if pinned := index.GetPager().GetStats().Pinned; pinned != 1 {
+		t.Errorf("open cursor holds %v pins; expected 1", pinned)
+	}
+	cursor.Close()
+	if pinned := index.GetPager().GetStats().Pinned; pinned != 0 {
+		t.Errorf("%v pages are pinned after closing the cursor", pinned)
+	}
+	if _, err := cursor.GetEntry(); err == nil {
+		t.Error("expected an error reading from a closed cursor")
+	}
+}
+
+func TestBTreeRange(t *testing.T) {
+	t.Run("TestBTreeRangeBounds", testBTreeRangeBounds)
+	t.Run("TestBTreeRangeRepl", testBTreeRangeRepl)
+}
+
+func testBTreeRangeBounds(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	// Even keys from 0 to 1998.
+	for key := int64(0); key < 1000; key++ {
+		if err := index.Insert(2*key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bound := func(key int64, inclusive bool) utils.Bound {
+		return utils.Bound{Key: utils.EncodeInt(key), Inclusive: inclusive}
+	}
+	tests := []struct {
+		rng  utils.Range
+		want []int64
+	}{
+		{utils.Range{Lo: bound(10, true), Hi: bound(16, true)}, []int64{10, 12, 14, 16}},
+		{utils.Range{Lo: bound(10, false), Hi: bound(16, false)}, []int64{12, 14}},
+		{utils.Range{Lo: bound(9, false), Hi: bound(17, false)}, []int64{10, 12, 14, 16}},
+		{utils.Range{Lo: bound(10, true), Hi: bound(16, true), Reverse: true}, []int64{16, 14, 12, 10}},
+		{utils.Range{Lo: bound(10, false), Hi: bound(16, false), Reverse: true}, []int64{14, 12}},
+		{utils.Range{Lo: bound(9, true), Hi: bound(17, true), Reverse: true}, []int64{16, 14, 12, 10}},
+		{utils.Range{Hi: bound(4, true)}, []int64{0, 2, 4}},
+		{utils.Range{Lo: bound(1994, true)}, []int64{1994, 1996, 1998}},
+		{utils.Range{Lo: bound(1994, true), Hi: bound(5000, true), Reverse: true}, []int64{1998, 1996, 1994}},
+		{utils.Range{Limit: 3, Reverse: true}, []int64{1998, 1996, 1994}},
+		{utils.Range{Lo: bound(500, false), Limit: 2}, []int64{502, 504}},
+		{utils.Range{Lo: bound(16, true), Hi: bound(10, true)}, []int64{}},
+		{utils.Range{Lo: bound(2000, true)}, []int64{}},
+		{utils.Range{Hi: bound(0, false), Reverse: true}, []int64{}},
+	}
+	for _, test := range tests {
+		it, err := index.TableRange(test.rng)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]int64, 0)
+		for it.Next() {
+			got = append(got, it.Entry().GetKey())
+		}
+		if err := it.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("range %+v returned %v; expected %v", test.rng, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("range %+v returned %v; expected %v", test.rng, got, test.want)
+				break
+			}
+		}
+	}
+	// A full scan streams every entry, and releases its cursor at the end.
+	it, err := index.TableRange(utils.Range{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 1000 {
+		t.Errorf("full range returned %v entries; expected 1000", n)
+	}
+	if pinned := index.GetPager().GetStats().Pinned; pinned != 0 {
+		t.Errorf("%v pages are pinned after a finished range scan", pinned)
+	}
+}
+
+func testBTreeRangeRepl(t *testing.T) {
+	database, err := db.Open("db", db.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	defer database.Close()
+	if err := db.HandleCreateTable(database, "create btree table t", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"ant"`, `"bee"`, `"cat"`, `"dog"`, `"eel"`} {
+		if err := db.HandleInsert(database, "insert "+key+" 1 into t"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for cmd, want := range map[string]string{
+		`range t "bee" "dog"`:           "(\"bee\", 1)\n(\"cat\", 1)\n(\"dog\", 1)\n",
+		`range t ("bee" "dog")`:         "(\"cat\", 1)\n",
+		`range t * "cat" limit 2`:       "(\"ant\", 1)\n(\"bee\", 1)\n",
+		`range t "b" * limit 2 reverse`: "(\"eel\", 1)\n(\"dog\", 1)\n",
+		`range t "with spaces" "z z"`:   "",
+	} {
+		var buf bytes.Buffer
+		if err := db.HandleRange(database, cmd, &buf); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+		if buf.String() != want {
+			t.Errorf("%v printed %q; expected %q", cmd, buf.String(), want)
+		}
+	}
+	for _, cmd := range []string{`range t 1`, `range t 1 2 limit`, `range t 1 2 limit 0`, `range t 1 2 sideways`} {
+		if err := db.HandleRange(database, cmd, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", cmd)
+		}
+	}
+}
+
+func TestBTreeBulkLoad(t *testing.T) {
+	t.Run("TestBTreeBulkLoadFill", testBTreeBulkLoadFill)
+	t.Run("TestBTreeBulkLoadErrors", testBTreeBulkLoadErrors)
+	t.Run("TestBTreeBulkLoadSort", testBTreeBulkLoadSort)
+	t.Run("TestBTreeBulkLoadRepl", testBTreeBulkLoadRepl)
+}
+
+// sliceEntries iterates over a slice of entries.
+type sliceEntries struct {
+	entries []utils.Entry
+	cur     utils.Entry
+}
+
+func (s *sliceEntries) Next() bool {
+	if len(s.entries) == 0 {
+		return false
+	}
+	s.cur, s.entries = s.entries[0], s.entries[1:]
+	return true
+}
+func (s *sliceEntries) Entry() utils.Entry { return s.cur }
+func (s *sliceEntries) Err() error         { return nil }
+func (s *sliceEntries) Close()             {}
+
+// intEntries returns entries with the given integer keys, each valued at
+// its key times the salt.
+func intEntries(keys []int64) *sliceEntries {
+	entries := make([]utils.Entry, len(keys))
+	for i, key := range keys {
+		entries[i] = btree.NewBTreeEntry(utils.EncodeInt(key), utils.EncodeInt(key*btree_salt))
+	}
+	return &sliceEntries{entries: entries}
+}
+
+func testBTreeBulkLoadFill(t *testing.T) {
+	keys := make([]int64, 20000)
+	want := make(map[int64]int64)
+	for i := range keys {
+		keys[i] = int64(3 * i)
+		want[keys[i]] = keys[i] * btree_salt
+	}
+	// Inserting in order leaves the leaves half full.
+	inserted := openMemoryBTree(t)
+	defer inserted.Close()
+	for _, key := range keys {
+		if err := inserted.Insert(key, key*btree_salt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lastPages := int64(0)
+	for _, fill := range []float64{1, 0.9, 0.5, 0.01} {
+		index := openMemoryBTree(t)
+		if err := index.BulkLoad(intEntries(keys), fill); err != nil {
+			t.Fatal(err)
+		}
+		checkBTree(t, index)
+		checkBTreeEntries(t, index, want)
+		pages := index.GetPager().GetNumPages() - index.GetPager().GetNumFreePages()
+		if fill == 1 && pages >= inserted.GetPager().GetNumPages() {
+			t.Errorf("bulk load used %v pages; inserting used %v", pages, inserted.GetPager().GetNumPages())
+		}
+		if pages < lastPages {
+			t.Errorf("fill factor %v used %v pages, fewer than a fuller load", fill, pages)
+		}
+		lastPages = pages
+		// The tree takes further changes.
+		for key := int64(1); key < 3000; key += 3 {
+			if err := index.Insert(key, key); err != nil {
+				t.Fatal(err)
+			}
+			if err := index.Delete(key + 2); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkBTree(t, index)
+		index.Close()
+	}
+	// One entry, or none, stays in the root.
+	for _, n := range []int{0, 1} {
+		index := openMemoryBTree(t)
+		if err := index.BulkLoad(intEntries(keys[:n]), 0.9); err != nil {
+			t.Fatal(err)
+		}
+		checkBTree(t, index)
+		if pages := index.GetPager().GetNumPages() - index.GetPager().GetNumFreePages(); pages != 1 {
+			t.Errorf("loading %v entries used %v pages", n, pages)
+		}
+		index.Close()
+	}
+}
+
+func testBTreeBulkLoadErrors(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	if err := index.BulkLoad(intEntries([]int64{1, 2}), 1.5); err == nil {
+		t.Error("expected an error for a fill factor above 1")
+	}
+	// Out of order input leaves the table empty and frees what was written.
+	keys := make([]int64, 5000)
+	for i := range keys {
+		keys[i] = int64(i)
+	}
+	keys[4000] = 10
+	if err := index.BulkLoad(intEntries(keys), 0.9); err == nil {
+		t.Error("expected an error loading out of order keys")
+	}
+	checkBTreeEmpty(t, index)
+	if err := index.BulkLoad(intEntries(keys[:10]), 0.9); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.BulkLoad(intEntries([]int64{20}), 0.9); err == nil {
+		t.Error("expected an error loading a table that isn't empty")
+	}
+}
+
+func testBTreeBulkLoadSort(t *testing.T) {
+	defer func(size int64) { btree.SORT_RUN_SIZE = size }(btree.SORT_RUN_SIZE)
+	btree.SORT_RUN_SIZE = 1000
+	// Random string keys with values large enough to overflow now and then.
+	r := rand.New(rand.NewSource(1270))
+	want := make(map[string][]byte)
+	entries := make([]utils.Entry, 0)
+	for len(entries) < 3000 {
+		key := randomBytes(r, 20)
+		if _, ok := want[key]; ok {
+			continue
+		}
+		value := make([]byte, r.Intn(int(pager.PAGESIZE)/2))
+		r.Read(value)
+		want[key] = value
+		entries = append(entries, btree.NewBTreeEntry(utils.EncodeString(key), value))
+	}
+	index := openMemoryBTree(t)
+	defer index.Close()
+	sorted, err := index.SortEntries(&sliceEntries{entries: entries})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.BulkLoad(sorted, 0.8); err != nil {
+		t.Fatal(err)
+	}
+	checkBTree(t, index)
+	got, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("loaded %v entries; expected %v", len(got), len(want))
+	}
+	for _, entry := range got {
+		key, _ := utils.DecodeString(entry.GetKeyBytes())
+		if !bytes.Equal(entry.GetValueBytes(), want[key]) {
+			t.Fatalf("key %q has the wrong value", key)
+		}
+	}
+}
+
+func testBTreeBulkLoadRepl(t *testing.T) {
+	database, err := db.Open("db", db.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	defer database.Close()
+	for _, cmd := range []string{"create btree table t", "create hash table h"} {
+		if err := db.HandleCreateTable(database, cmd, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file, err := ioutil.TempFile("", "load-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("3 \"three\"\n\n1 \"one\"\n\"two\" 2\n")
+	file.Close()
+	var buf bytes.Buffer
+	if err := db.HandleLoad(database, "load t "+file.Name()+" fill 0.7", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "loaded 3 entries into t.\n"; buf.String() != want {
+		t.Errorf("load printed %q; expected %q", buf.String(), want)
+	}
+	buf.Reset()
+	if err := db.HandleSelect(database, "select from t", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "(1, \"one\")\n(3, \"three\")\n(\"two\", 2)\n"; buf.String() != want {
+		t.Errorf("unexpected select output: %q", buf.String())
+	}
+	for _, cmd := range []string{"load h " + file.Name(), "load t " + file.Name(), "load t", "load t nofile"} {
+		if err := db.HandleLoad(database, cmd, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", cmd)
+		}
+	}
+}
+
+func TestBTreeFormat(t *testing.T) {
+	t.Run("TestBTreeFormatUpgrade", testBTreeFormatUpgrade)
+	t.Run("TestBTreeFormatPrefix", testBTreeFormatPrefix)
+}
+
+// testdata/format0.db was written before nodes had a format version. It
+// holds keys 0 to 499, each valued at ten times its key, and key 1000,
+// whose 10000-byte value is in overflow pages.
+func testBTreeFormatUpgrade(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/format0.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbName := getTempBTreeDB(t)
+	defer os.Remove(dbName)
+	if err := ioutil.WriteFile(dbName, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[int64]int64)
+	for i := int64(0); i < 500; i++ {
+		want[i] = i * 10
+	}
+	check := func(index *btree.BTreeIndex) {
+		checkBTree(t, index)
+		for key, value := range want {
+			entry, err := index.Find(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.GetValue() != value {
+				t.Fatalf("key %v has value %v; expected %v", key, entry.GetValue(), value)
+			}
+		}
+		entry, err := index.Find(1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(entry.GetValueBytes(), bytes.Repeat([]byte("v"), 10000)) {
+			t.Fatal("overflow value was not read back")
+		}
+	}
+	// A read-only table is read in the original format.
+	index, err := btree.OpenTable(dbName, pager.WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(index)
+	index.Close()
+	// A writable table is rebuilt in the compact format.
+	index, err = btree.OpenTable(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(index)
+	for i := int64(2000); i < 3500; i++ {
+		if err := index.Insert(i, i*10); err != nil {
+			t.Fatal(err)
+		}
+		want[i] = i * 10
+	}
+	for i := int64(0); i < 300; i++ {
+		if err := index.Delete(i); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, i)
+	}
+	if err := index.Close(); err != nil {
+		t.Fatal(err)
+	}
+	index, err = btree.OpenTable(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	check(index)
+}
+
+func testBTreeFormatPrefix(t *testing.T) {
+	// Keys only share prefixes in byte-ordered tables.
+	byteOrder := func(a []byte, b []byte) int { return bytes.Compare(a, b) }
+	whole, err := btree.OpenTableWithComparator("btree", byteOrder, pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer whole.Close()
+	shared := openMemoryBTree(t)
+	defer shared.Close()
+	prefix := strings.Repeat("users/accounts/", 4)
+	for _, i := range rand.Perm(5000) {
+		key := []byte(prefix + strconv.Itoa(i))
+		for _, index := range []*btree.BTreeIndex{whole, shared} {
+			if err := index.InsertBytes(key, utils.EncodeInt(int64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < 5000; i += 2 {
+		key := []byte(prefix + strconv.Itoa(i))
+		for _, index := range []*btree.BTreeIndex{whole, shared} {
+			if err := index.DeleteBytes(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Keys without the prefix land at either end.
+	for _, key := range []string{"a", "zz"} {
+		for _, index := range []*btree.BTreeIndex{whole, shared} {
+			if err := index.InsertBytes([]byte(key), utils.EncodeInt(0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, index := range []*btree.BTreeIndex{whole, shared} {
+		checkBTree(t, index)
+		entries, err := index.Select()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2502 {
+			t.Fatalf("index has %v entries; expected 2502", len(entries))
+		}
+		if _, err := index.FindBytes([]byte(prefix + "4999")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wholePages := whole.GetPager().GetNumPages() - whole.GetPager().GetNumFreePages()
+	sharedPages := shared.GetPager().GetNumPages() - shared.GetPager().GetNumFreePages()
+	if sharedPages*2 > wholePages {
+		t.Errorf("keys sharing a prefix take up %v pages; expected at most half of %v", sharedPages, wholePages)
+	}
+}
+
+func TestBTreeMulti(t *testing.T) {
+	t.Run("TestBTreeMultiDuplicates", testBTreeMultiDuplicates)
+	t.Run("TestBTreeMultiEscaping", testBTreeMultiEscaping)
+	t.Run("TestBTreeMultiBulkLoad", testBTreeMultiBulkLoad)
+}
+
+// openMemoryMulti opens a MultiIndex in memory.
+func openMemoryMulti(t *testing.T) *btree.MultiIndex {
+	index, err := btree.OpenMultiTable("multi", pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+// collectEntries reads the rest of an iterator's entries.
+func collectEntries(t *testing.T, it utils.Iterator) []utils.Entry {
+	defer it.Close()
+	entries := make([]utils.Entry, 0)
+	for it.Next() {
+		entries = append(entries, it.Entry())
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func testBTreeMultiDuplicates(t *testing.T) {
+	index := openMemoryMulti(t)
+	defer index.Close()
+	// Row i has key i % 10.
+	for _, i := range rand.Perm(1000) {
+		if err := index.Insert(utils.EncodeInt(int64(i%10)), utils.EncodeInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := index.Insert(utils.EncodeInt(3), utils.EncodeInt(3)); err == nil {
+		t.Error("inserting a key and row id twice should fail")
+	}
+	for i := 3; i < 1000; i += 20 {
+		if err := index.Delete(utils.EncodeInt(3), utils.EncodeInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, index.GetTable())
+	// Each key's rows come back in row id order.
+	it, err := index.Find(utils.EncodeInt(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := collectEntries(t, it)
+	if len(entries) != 50 {
+		t.Fatalf("found %v entries with key 3; expected 50", len(entries))
+	}
+	for i, entry := range entries {
+		if want := int64(13 + 20*i); entry.GetKey() != 3 || entry.GetValue() != want {
+			t.Fatalf("unexpected entry (%v, %v); expected (3, %v)", entry.GetKey(), entry.GetValue(), want)
+		}
+	}
+	// Ranges take in every row of the keys in them.
+	it, err = index.TableRange(utils.Range{
+		Lo: utils.Bound{Key: utils.EncodeInt(3)},
+		Hi: utils.Bound{Key: utils.EncodeInt(5), Inclusive: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := collectEntries(t, it); len(entries) != 200 || entries[0].GetKey() != 4 || entries[199].GetKey() != 5 {
+		t.Errorf("range (3, 5] returned %v entries; expected 200", len(entries))
+	}
+	it, err = index.TableRange(utils.Range{Hi: utils.Bound{Key: utils.EncodeInt(9)}, Limit: 3, Reverse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries = collectEntries(t, it)
+	if len(entries) != 3 || entries[0].GetKey() != 8 || entries[0].GetValue() != 998 || entries[2].GetValue() != 978 {
+		t.Errorf("unexpected reverse range %v", entries)
+	}
+}
+
+func testBTreeMultiEscaping(t *testing.T) {
+	index := openMemoryMulti(t)
+	defer index.Close()
+	// Keys that are prefixes of each other, or hold zero bytes, stay apart.
+	keys := []string{"", "a", "a\x00", "a\x00\x00", "a\x00\x01", "a\x01", "ab", "b"}
+	for _, i := range rand.Perm(len(keys) * 5) {
+		key, rowid := utils.EncodeString(keys[i%len(keys)]), []byte{byte(i), 0x00, 0x01}
+		if err := index.Insert(key, rowid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(keys)*5 {
+		t.Fatalf("index has %v entries; expected %v", len(entries), len(keys)*5)
+	}
+	for i, entry := range entries {
+		if want := utils.EncodeString(keys[i/5]); !bytes.Equal(entry.GetKeyBytes(), want) {
+			t.Fatalf("entry %v has key %v; expected %v", i, utils.FormatBytes(entry.GetKeyBytes()), utils.FormatBytes(want))
+		}
+	}
+	for _, key := range keys {
+		it, err := index.Find(utils.EncodeString(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, entry := range collectEntries(t, it) {
+			if rowid := entry.GetValueBytes(); len(rowid) != 3 || keys[int(rowid[0])%len(keys)] != key {
+				t.Fatalf("key %q has row id %x", key, rowid)
+			}
+		}
+	}
+}
+
+func testBTreeMultiBulkLoad(t *testing.T) {
+	inserted, loaded := openMemoryMulti(t), openMemoryMulti(t)
+	defer inserted.Close()
+	defer loaded.Close()
+	entries := make([]utils.Entry, 0)
+	for _, i := range rand.Perm(5000) {
+		key, rowid := utils.EncodeInt(int64(i%37)), utils.EncodeInt(int64(i))
+		if err := inserted.Insert(key, rowid); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, btree.NewBTreeEntry(key, rowid))
+	}
+	// Rows needn't be given in order.
+	if err := loaded.BulkLoad(&sliceEntries{entries: entries}, btree.DEFAULT_FILL_FACTOR); err != nil {
+		t.Fatal(err)
+	}
+	checkBTree(t, loaded.GetTable())
+	want, err := inserted.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := loaded.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("loaded %v entries; expected %v", len(got), len(want))
+	}
+	for i := range got {
+		if got[i].GetKey() != want[i].GetKey() || got[i].GetValue() != want[i].GetValue() {
+			t.Fatalf("entry %v is (%v, %v); expected (%v, %v)", i,
+				got[i].GetKey(), got[i].GetValue(), want[i].GetKey(), want[i].GetValue())
+		}
+	}
+}
+
+func TestBTreeTuple(t *testing.T) {
+	t.Run("TestBTreeTupleOrder", testBTreeTupleOrder)
+	t.Run("TestBTreeTupleCollation", testBTreeTupleCollation)
+	t.Run("TestBTreeTupleRepl", testBTreeTupleRepl)
+}
+
+// tenantKey returns the tuple key of an id of a tenant.
+func tenantKey(tenant int64, id int64) []byte {
+	return utils.EncodeTuple(utils.EncodeInt(tenant), utils.EncodeInt(id))
+}
+
+// checkTenantEntries fails the test unless the entries are those of the
+// given ids of a tenant, in order, each valued at its id.
+func checkTenantEntries(t *testing.T, entries []utils.Entry, tenant int64, ids []int64) {
+	if len(entries) != len(ids) {
+		t.Fatalf("got %v entries of tenant %v; expected %v", len(entries), tenant, len(ids))
+	}
+	for i, entry := range entries {
+		if !bytes.Equal(entry.GetKeyBytes(), tenantKey(tenant, ids[i])) || entry.GetValue() != ids[i] {
+			t.Fatalf("entry %v is (%v, %v); expected ([%v, %v], %v)", i,
+				utils.FormatBytes(entry.GetKeyBytes()), entry.GetValue(), tenant, ids[i], ids[i])
+		}
+	}
+}
+
+func testBTreeTupleOrder(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	// Ids run from -500 to 499, so that some compare differently as
+	// unsigned bytes.
+	for _, i := range rand.Perm(20 * 1000) {
+		tenant, id := int64(i/1000), int64(i%1000-500)
+		if err := index.InsertBytes(tenantKey(tenant, id), utils.EncodeInt(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, index)
+	entries, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries {
+		if want := tenantKey(int64(i/1000), int64(i%1000-500)); !bytes.Equal(entry.GetKeyBytes(), want) {
+			t.Fatalf("entry %v has key %v; expected %v", i, utils.FormatBytes(entry.GetKeyBytes()), utils.FormatBytes(want))
+		}
+	}
+	// A prefix takes in every key of the tenant, and no other.
+	ids := make([]int64, 1000)
+	for i := range ids {
+		ids[i] = int64(i - 500)
+	}
+	it, err := index.TablePrefix(utils.EncodeInt(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTenantEntries(t, collectEntries(t, it), 7, ids)
+	it, err = index.TableRange(utils.Range{
+		Lo:      utils.PrefixRange(utils.EncodeInt(19)).Lo,
+		Hi:      utils.PrefixRange(utils.EncodeInt(19)).Hi,
+		Limit:   3,
+		Reverse: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTenantEntries(t, collectEntries(t, it), 19, []int64{499, 498, 497})
+	it, err = index.TablePrefix(utils.EncodeInt(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := collectEntries(t, it); len(entries) != 0 {
+		t.Errorf("got %v entries of a missing tenant", len(entries))
+	}
+	// A cursor seeks to the first key of a tenant.
+	cursor, err := index.TableFindBytes(utils.EncodeTuple(utils.EncodeInt(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cursor.Close()
+	entry, err := cursor.GetEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTenantEntries(t, []utils.Entry{entry}, 3, []int64{-500})
+}
+
+func testBTreeTupleCollation(t *testing.T) {
+	// Companies are ordered without regard to case, and ids from highest to
+	// lowest.
+	cmp := btree.TupleComparator(btree.FoldCase, btree.Descending(nil))
+	index, err := btree.OpenTableWithComparator("btree", cmp, pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	names := []string{"acme", "Acme", "ACME", "beta", "Zeta"}
+	for _, i := range rand.Perm(500) {
+		key := utils.EncodeTuple(utils.EncodeString(names[i%5]), utils.EncodeInt(int64(i)))
+		if err := index.InsertBytes(key, utils.EncodeInt(int64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkBTree(t, index)
+	// Keys that differ only in case are the same key.
+	if err := index.InsertBytes(utils.EncodeTuple(utils.EncodeString("aCmE"), utils.EncodeInt(1)), nil); err == nil {
+		t.Error("inserting a key that differs only in case should fail")
+	}
+	it, err := index.TablePrefix(utils.EncodeString("ACme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := collectEntries(t, it)
+	if len(entries) != 300 {
+		t.Fatalf("got %v entries of acme; expected 300", len(entries))
+	}
+	for i, entry := range entries {
+		if i > 0 && entry.GetValue() >= entries[i-1].GetValue() {
+			t.Fatalf("ids out of order: %v after %v", entry.GetValue(), entries[i-1].GetValue())
+		}
+	}
+	it, err = index.TablePrefix(utils.EncodeString("zeta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := collectEntries(t, it); len(entries) != 100 || entries[0].GetValue() != 499 {
+		t.Errorf("got %v entries of zeta, starting with %v; expected 100, starting with 499", len(entries), entries[0].GetValue())
+	}
+}
+
+func testBTreeTupleRepl(t *testing.T) {
+	database, err := db.Open("db", db.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	defer database.Close()
+	if err := db.HandleCreateTable(database, "create btree table t", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{
+		`insert [7, 2] "b" into t`,
+		`insert [7,1] "a" into t`,
+		`insert [7, "x y", [1, 2]] "c" into t`,
+		`insert [8, 0] "d" into t`,
+		`insert [6, 9] "e" into t`,
+		`insert 7 "f" into t`,
+	} {
+		if err := db.HandleInsert(database, cmd); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+	for cmd, want := range map[string]string{
+		`prefix t 7`:                   "([7, 1], \"a\")\n([7, 2], \"b\")\n([7, \"x y\", [1, 2]], \"c\")\n",
+		`prefix t [7, "x y"]`:          "([7, \"x y\", [1, 2]], \"c\")\n",
+		`prefix t [7] limit 1 reverse`: "([7, \"x y\", [1, 2]], \"c\")\n",
+		`prefix t []`:                  "([6, 9], \"e\")\n([7, 1], \"a\")\n([7, 2], \"b\")\n([7, \"x y\", [1, 2]], \"c\")\n([8, 0], \"d\")\n",
+		`range t ([7, 1] [8, 0])`:      "([7, 2], \"b\")\n([7, \"x y\", [1, 2]], \"c\")\n",
+	} {
+		handle := db.HandlePrefix
+		if strings.HasPrefix(cmd, "range") {
+			handle = db.HandleRange
+		}
+		var buf bytes.Buffer
+		if err := handle(database, cmd, &buf); err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+		if buf.String() != want {
+			t.Errorf("%v printed %q; expected %q", cmd, buf.String(), want)
+		}
+	}
+	var buf bytes.Buffer
+	if err := db.HandleFind(database, `find [7, "x y", [1, 2]] from t`, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "found entry: ([7, \"x y\", [1, 2]], \"c\")\n" {
+		t.Errorf("unexpected find output: %q", buf.String())
+	}
+	for _, cmd := range []string{`prefix t`, `prefix t [7`, `prefix t 7 limit 0`, `prefix t [7, x]`} {
+		if err := db.HandlePrefix(database, cmd, ioutil.Discard); err == nil {
+			t.Errorf("%v: expected an error", cmd)
+		}
+	}
+}
+
+/*
+Concurrency tests: writers change the tree from several goroutines while
+readers look up keys that are always in it and scan it, as nodes split and
+merge under them.
+*/
+func TestBTreeConcurrent(t *testing.T) {
+	t.Run("TestBTreeConcurrentInsert", testBTreeConcurrentInsert)
+	t.Run("TestBTreeConcurrentDelete", testBTreeConcurrentDelete)
+}
+
+// Number of keys the concurrency tests start with, and add or remove, and
+// the number of goroutines that add or remove them.
+var btree_concurrent_n = int64(20000)
+var btree_concurrent_writers = int64(8)
+
+// readBTreeConcurrently looks up the negative keys below n, which must stay
+// in the index, and scans the index, until done is closed. Fails the test
+// if a key can't be found or a scan is out of order.
+func readBTreeConcurrently(t *testing.T, index *btree.BTreeIndex, n int64, seed int64, done chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; ; i++ {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if i%100 != 0 {
+			key := -1 - r.Int63n(n)
+			if entry, err := index.Find(key); err != nil {
+				t.Errorf("couldn't find %v: %v", key, err)
+				return
+			} else if entry.GetValue() != key {
+				t.Errorf("found (%v, %v); expected (%v, %v)", entry.GetKey(), entry.GetValue(), key, key)
+				return
+			}
+			continue
+		}
+		it, err := index.TableRange(utils.Range{Reverse: i%200 == 0})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var prev []byte
+		for it.Next() {
+			key := it.Entry().GetKeyBytes()
+			if c := bytes.Compare(prev, key); prev != nil && (c >= 0) != (i%200 == 0) {
+				t.Errorf("scan out of order: %v then %v", utils.FormatBytes(prev), utils.FormatBytes(key))
+			}
+			prev = key
+		}
+		if err := it.Err(); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+// runBTreeConcurrently runs the given change on each of the keys from 0 to
+// n, split between the writers, while readers read the index.
+func runBTreeConcurrently(t *testing.T, index *btree.BTreeIndex, n int64, change func(int64) error) {
+	done := make(chan struct{})
+	var readers, writers sync.WaitGroup
+	for i := int64(0); i < 4; i++ {
+		readers.Add(1)
+		go readBTreeConcurrently(t, index, n, btree_salt+i, done, &readers)
+	}
+	for w := int64(0); w < btree_concurrent_writers; w++ {
+		writers.Add(1)
+		go func(w int64) {
+			defer writers.Done()
+			r := rand.New(rand.NewSource(btree_salt + w))
+			for _, i := range r.Perm(int(n / btree_concurrent_writers)) {
+				if err := change(int64(i)*btree_concurrent_writers + w); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(done)
+	readers.Wait()
+}
+
+// openConcurrentBTree opens a B+ tree holding the n negative keys that the
+// readers look up.
+func openConcurrentBTree(t *testing.T, n int64) (*btree.BTreeIndex, map[int64]int64) {
+	index := openMemoryBTree(t)
+	want := make(map[int64]int64)
+	for i := int64(1); i <= n; i++ {
+		if err := index.Insert(-i, -i); err != nil {
+			t.Fatal(err)
+		}
+		want[-i] = -i
+	}
+	return index, want
+}
+
+func testBTreeConcurrentInsert(t *testing.T) {
+	n := btree_concurrent_n
+	index, want := openConcurrentBTree(t, n)
+	defer index.Close()
+	runBTreeConcurrently(t, index, n, func(key int64) error {
+		return index.Insert(key, key)
+	})
+	for i := int64(0); i < n; i++ {
+		want[i] = i
+	}
+	checkBTree(t, index)
+	checkBTreeEntries(t, index, want)
+}
+
+func testBTreeConcurrentDelete(t *testing.T) {
+	n := btree_concurrent_n
+	index, want := openConcurrentBTree(t, n)
+	defer index.Close()
+	for i := int64(0); i < n; i++ {
+		if err := index.Insert(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runBTreeConcurrently(t, index, n, func(key int64) error {
+		if key%2 == 0 {
+			return index.Update(key, key)
+		}
+		return index.Delete(key)
+	})
+	for i := int64(0); i < n; i += 2 {
+		want[i] = i
+	}
+	checkBTree(t, index)
+	checkBTreeEntries(t, index, want)
+}
+
+func TestBTreeAnalyze(t *testing.T) {
+	t.Run("TestBTreeAnalyzeShape", testBTreeAnalyzeShape)
+	t.Run("TestBTreeAnalyzeBulkLoad", testBTreeAnalyzeBulkLoad)
+	t.Run("TestBTreeAnalyzeRepl", testBTreeAnalyzeRepl)
+	t.Run("TestBTreeAnalyzeConcurrent", testBTreeAnalyzeConcurrent)
+}
+
+// analyzeBTree returns the statistics of a B+tree, and checks that they
+// count each node once.
+func analyzeBTree(t *testing.T, index *btree.BTreeIndex) *btree.TreeStats {
+	analysis, err := index.Analyze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := analysis.(*btree.TreeStats)
+	if int64(len(stats.NodesPerLevel)) != stats.Height || stats.NodesPerLevel[0] != 1 {
+		t.Errorf("expected %v levels under a single root, got %v", stats.Height, stats.NodesPerLevel)
+	}
+	nodes := int64(0)
+	for _, n := range stats.NodesPerLevel {
+		nodes += n
+	}
+	if nodes != stats.InternalNodes+stats.LeafNodes || stats.NodesPerLevel[stats.Height-1] != stats.LeafNodes {
+		t.Errorf("levels hold %v nodes, %v of them leaves; expected %v internal nodes and %v leaves",
+			nodes, stats.NodesPerLevel[stats.Height-1], stats.InternalNodes, stats.LeafNodes)
+	}
+	if stats.LeafChainLength != stats.LeafNodes {
+		t.Errorf("leaf chain holds %v of %v leaves", stats.LeafChainLength, stats.LeafNodes)
+	}
+	return stats
+}
+
+func testBTreeAnalyzeShape(t *testing.T) {
+	index := openMemoryBTree(t)
+	defer index.Close()
+	stats := analyzeBTree(t, index)
+	if stats.Height != 1 || stats.LeafNodes != 1 || stats.Entries != 0 {
+		t.Errorf("empty tree has height %v, %v leaves and %v entries", stats.Height, stats.LeafNodes, stats.Entries)
+	}
+	for _, key := range rand.New(rand.NewSource(2501)).Perm(5000) {
+		if err := index.Insert(int64(key), int64(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats = analyzeBTree(t, index)
+	if stats.Height < 2 || stats.Entries != 5000 {
+		t.Errorf("tree has height %v and %v entries; expected a taller tree with 5000", stats.Height, stats.Entries)
+	}
+	// Splits leave nodes at least half full.
+	if stats.LeafFill < 0.5 || stats.LeafFill > 1 || stats.InternalFill <= 0 || stats.InternalFill > 1 {
+		t.Errorf("unexpected fill: %.3f for leaves, %.3f for internal nodes", stats.LeafFill, stats.InternalFill)
+	}
+	// Emptying the tree merges every leaf back into the root.
+	for key := int64(0); key < 5000; key++ {
+		if err := index.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats = analyzeBTree(t, index)
+	if stats.Height != 1 || stats.Entries != 0 || stats.FreePages == 0 {
+		t.Errorf("emptied tree has height %v, %v entries and %v free pages", stats.Height, stats.Entries, stats.FreePages)
+	}
+}
+
+func testBTreeAnalyzeBulkLoad(t *testing.T) {
+	keys := make([]int64, 5000)
+	for i := range keys {
+		keys[i] = int64(i)
+	}
+	fills := make([]float64, 0)
+	for _, fill := range []float64{0.5, 1} {
+		index := openMemoryBTree(t)
+		if err := index.BulkLoad(intEntries(keys), fill); err != nil {
+			t.Fatal(err)
+		}
+		stats := analyzeBTree(t, index)
+		index.Close()
+		if stats.Entries != 5000 {
+			t.Errorf("bulk load with fill %v left %v entries", fill, stats.Entries)
+		}
+		fills = append(fills, stats.LeafFill)
+	}
+	if fills[0] >= fills[1] || fills[1] < 0.9 {
+		t.Errorf("leaf fill was %.3f and %.3f after bulk loads at 0.5 and 1", fills[0], fills[1])
+	}
+}
+
+// Analyzing a tree while it is written to gives approximate statistics,
+// and exact ones once the writers are done. Deletes refill underfull
+// leaves, which makes the walk start over.
+func testBTreeAnalyzeConcurrent(t *testing.T) {
+	n := btree_concurrent_n / 4
+	index, _ := openConcurrentBTree(t, n)
+	defer index.Close()
+	done := make(chan struct{})
+	var analyzer sync.WaitGroup
+	analyzer.Add(1)
+	go func() {
+		defer analyzer.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			analysis, err := index.Analyze()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if entries := analysis.(*btree.TreeStats).Entries; entries > 2*n {
+				t.Errorf("counted %v entries; there are at most %v", entries, 2*n)
+				return
+			}
+		}
+	}()
+	runBTreeConcurrently(t, index, n, func(key int64) error {
+		return index.Insert(key, key)
+	})
+	runBTreeConcurrently(t, index, n, func(key int64) error {
+		if key%4 == 0 {
+			return nil
+		}
+		return index.Delete(key)
+	})
+	close(done)
+	analyzer.Wait()
+	if stats := analyzeBTree(t, index); stats.Entries != n+n/4 {
+		t.Errorf("counted %v entries; expected %v", stats.Entries, n+n/4)
+	}
+}
+
+func testBTreeAnalyzeRepl(t *testing.T) {
+	database, dir := openSizedDatabase(t, pager.PAGESIZE)
+	defer os.RemoveAll(dir)
+	defer database.Close()
+	for _, command := range []string{"create btree table b", "create hash table h", "create index on b"} {
+		if err := db.HandleCreateTable(database, command, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key := 0; key < 100; key++ {
+		for _, table := range []string{"b", "h"} {
+			command := "insert " + strconv.Itoa(key) + " " + strconv.Itoa(key%7) + " into " + table
+			if err := db.HandleInsert(database, command); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := db.HandleAnalyze(database, "analyze b", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "height: 1\n") || strings.Count(out, "entries: 100\n") != 2 ||
+		!strings.Contains(out, "value index:\n") {
+		t.Errorf("unexpected B+tree analysis:\n%v", out)
+	}
+	buf.Reset()
+	if err := db.HandleAnalyze(database, "analyze h", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "global depth: ") || !strings.Contains(out, "entries: 100\n") {
+		t.Errorf("unexpected hash table analysis:\n%v", out)
+	}
+	if err := db.HandleAnalyze(database, "analyze missing", &buf); err == nil {
+		t.Error("expected an error for a missing table")
+	}
+	if err := db.HandleAnalyze(database, "analyze", &buf); err == nil {
+		t.Error("expected a usage error")
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"testing"
 
 	hash "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/hash"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 type hash_kv struct {
@@ -389,3 +391,97 @@ func testHashUpdateTen(t *testing.T) {
 	}
 	index.Close()
 }
+
+func TestHashCursor(t *testing.T) {
+	index, err := hash.OpenTable("hash", pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	for key := int64(0); key < 1000; key++ {
+		if err := index.Insert(key, key*hash_salt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cursor, err := index.TableStart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Seek finds a key in its bucket.
+	if err := cursor.Seek(utils.EncodeInt(700)); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := cursor.GetEntry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.GetKey() != 700 || entry.GetValue() != 700*hash_salt {
+		t.Errorf("seek found (%v, %v); expected (700, %v)", entry.GetKey(), entry.GetValue(), 700*hash_salt)
+	}
+	if err := cursor.Seek(utils.EncodeInt(1000)); err == nil {
+		t.Error("expected an error seeking a missing key")
+	}
+	// Stepping back from the end visits every entry.
+	for !cursor.StepForward() {
+	}
+	seen := make(map[int64]bool)
+	for {
+		if !cursor.IsEnd() {
+			entry, err := cursor.GetEntry()
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[entry.GetKey()] = true
+		}
+		if cursor.StepBackward() {
+			break
+		}
+	}
+	if len(seen) != 1000 {
+		t.Errorf("backward scan saw %v keys; expected 1000", len(seen))
+	}
+	cursor.Close()
+	if pinned := index.GetPager().GetStats().Pinned; pinned != 0 {
+		t.Errorf("%v pages are pinned after closing the cursor", pinned)
+	}
+}
+
+func TestHashAnalyze(t *testing.T) {
+	index, err := hash.OpenTable("hash", pager.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	for key := int64(0); key < 5000; key++ {
+		if err := index.Insert(key, key*hash_salt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	analysis, err := index.Analyze()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := analysis.(*hash.TableStats)
+	table := index.GetTable()
+	if stats.GlobalDepth != table.GetDepth() || stats.Buckets != int64(len(table.GetBucketPNs())) {
+		t.Errorf("found depth %v and %v buckets; expected %v and %v",
+			stats.GlobalDepth, stats.Buckets, table.GetDepth(), len(table.GetBucketPNs()))
+	}
+	if stats.Entries != 5000 || stats.MaxBucketKeys > stats.BucketSize {
+		t.Errorf("found %v entries, at most %v in a bucket of %v", stats.Entries, stats.MaxBucketKeys, stats.BucketSize)
+	}
+	// Every bucket has one local depth and one occupancy.
+	byDepth, byOccupancy := int64(0), int64(0)
+	for _, n := range stats.LocalDepths {
+		byDepth += n
+	}
+	for _, n := range stats.Occupancy {
+		byOccupancy += n
+	}
+	if byDepth != stats.Buckets || byOccupancy != stats.Buckets {
+		t.Errorf("%v buckets by depth and %v by occupancy; expected %v", byDepth, byOccupancy, stats.Buckets)
+	}
+	if want := float64(5000) / float64(stats.Buckets*stats.BucketSize); stats.Fill != want {
+		t.Errorf("fill is %.3f; expected %.3f", stats.Fill, want)
+	}
+}
@@ -0,0 +1,1992 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	btree "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/btree"
+	db "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/db"
+	hash "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/hash"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+)
+
+func getTempPagerDB(t *testing.T) string {
+	tmpfile, err := ioutil.TempFile(".", "db-*")
+	if err != nil {
+		t.Error(err)
+	}
+	defer tmpfile.Close()
+	return tmpfile.Name()
+}
+
+// openPager opens a pager built from the given options on the named file.
+func openPager(t *testing.T, dbName string, opts ...pager.Option) *pager.Pager {
+	p := pager.NewPager(opts...)
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// openTempPager opens a pager built from the given options on a fresh file,
+// and returns the pager and the file's name.
+func openTempPager(t *testing.T, opts ...pager.Option) (*pager.Pager, string) {
+	dbName := getTempPagerDB(t)
+	return openPager(t, dbName, opts...), dbName
+}
+
+// openPolicyPager opens a pager on a fresh file using the named policy.
+func openPolicyPager(t *testing.T, policyName string) (*pager.Pager, string) {
+	policy, err := pager.NewPolicy(policyName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return openTempPager(t, pager.WithPolicy(policy))
+}
+
+// touchPage gets and immediately releases the given page.
+func touchPage(t *testing.T, p *pager.Pager, pagenum int64) {
+	page, err := p.GetPage(pagenum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Put()
+}
+
+// isResident checks the pager's page table for the given page.
+func isResident(p *pager.Pager, pagenum int64) bool {
+	var buf bytes.Buffer
+	pager.HandlePagerPrint(p, "pager_print", &buf)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, "pageTable: ") {
+			return strings.Contains(" "+line[len("pageTable:"):], fmt.Sprintf(" %v,", pagenum))
+		}
+	}
+	return false
+}
+
+func TestPagerPolicies(t *testing.T) {
+	t.Run("TestPagerPolicyLRU", testPagerPolicyLRU)
+	t.Run("TestPagerPolicyClock", testPagerPolicyClock)
+	t.Run("TestPagerPolicyLRUK", testPagerPolicyLRUK)
+	t.Run("TestPagerPolicy2Q", testPagerPolicy2Q)
+	t.Run("TestPagerPolicyUnknown", testPagerPolicyUnknown)
+}
+
+func testPagerPolicyLRU(t *testing.T) {
+	p, dbName := openPolicyPager(t, "lru")
+	defer os.Remove(dbName)
+	defer p.Close()
+	// Fill the buffer, but unpin in reverse order.
+	pages := make([]*pager.Page, pager.MAXPAGES)
+	for i := range pages {
+		page, err := p.GetPage(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages[i] = page
+	}
+	for i := len(pages) - 1; i >= 0; i-- {
+		pages[i].Put()
+	}
+	// Page 0 was requested least recently, even though it was unpinned last.
+	touchPage(t, p, pager.MAXPAGES)
+	if isResident(p, 0) {
+		t.Error("LRU did not evict the least recently used page")
+	}
+	if !isResident(p, pager.MAXPAGES-1) {
+		t.Error("LRU evicted a recently used page")
+	}
+}
+
+func testPagerPolicyClock(t *testing.T) {
+	p, dbName := openPolicyPager(t, "clock")
+	defer os.Remove(dbName)
+	defer p.Close()
+	for i := int64(0); i < pager.MAXPAGES; i++ {
+		touchPage(t, p, i)
+	}
+	// The first sweep clears every reference bit, then evicts page 0.
+	touchPage(t, p, pager.MAXPAGES)
+	if isResident(p, 0) {
+		t.Error("clock did not evict the first page")
+	}
+	// Page 1 gets a second chance; page 2 does not.
+	touchPage(t, p, 1)
+	touchPage(t, p, pager.MAXPAGES+1)
+	if !isResident(p, 1) || isResident(p, 2) {
+		t.Error("clock did not honor the reference bit")
+	}
+}
+
+func testPagerPolicyLRUK(t *testing.T) {
+	p, dbName := openPolicyPager(t, "lru-k")
+	defer os.Remove(dbName)
+	defer p.Close()
+	for i := int64(0); i < pager.MAXPAGES; i++ {
+		touchPage(t, p, i)
+	}
+	// Page 0 has two references; everything else has one.
+	touchPage(t, p, 0)
+	touchPage(t, p, pager.MAXPAGES)
+	if !isResident(p, 0) {
+		t.Error("LRU-K evicted the only page with K references")
+	}
+	if isResident(p, 1) {
+		t.Error("LRU-K did not evict the oldest page with fewer than K references")
+	}
+}
+
+func testPagerPolicy2Q(t *testing.T) {
+	p, dbName := openPolicyPager(t, "2q")
+	defer os.Remove(dbName)
+	defer p.Close()
+	for i := int64(0); i < pager.MAXPAGES; i++ {
+		touchPage(t, p, i)
+	}
+	// Evict page 0 into the ghost queue, then bring it back so it is hot.
+	touchPage(t, p, pager.MAXPAGES)
+	if isResident(p, 0) {
+		t.Fatal("2Q did not evict from A1in first")
+	}
+	touchPage(t, p, 0)
+	// A long scan should not push the hot page out.
+	for i := int64(pager.MAXPAGES + 1); i < 4*pager.MAXPAGES; i++ {
+		touchPage(t, p, i)
+	}
+	if !isResident(p, 0) {
+		t.Error("2Q let a scan evict a hot page")
+	}
+}
+
+func testPagerPolicyUnknown(t *testing.T) {
+	if _, err := pager.NewPolicy("random"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+}
+
+func TestPagerFreeList(t *testing.T) {
+	t.Run("TestPagerFreeListReuse", testPagerFreeListReuse)
+	t.Run("TestPagerFreeListPersists", testPagerFreeListPersists)
+	t.Run("TestPagerFreeListInvalid", testPagerFreeListInvalid)
+	t.Run("TestPagerFreeListCorrupted", testPagerFreeListCorrupted)
+	t.Run("TestHashDeleteFreesBuckets", testHashDeleteFreesBuckets)
+}
+
+func testPagerFreeListReuse(t *testing.T) {
+	p, dbName := openPolicyPager(t, pager.DEFAULT_POLICY)
+	defer os.Remove(dbName)
+	defer p.Close()
+	for i := int64(0); i < 4; i++ {
+		touchPage(t, p, i)
+	}
+	if err := p.FreePage(1); err != nil {
+		t.Fatal(err)
+	}
+	if p.GetNumFreePages() != 1 {
+		t.Error("freed page is not on the free list")
+	}
+	if pn, err := p.GetFreePN(); err != nil || pn != 1 {
+		t.Errorf("expected freed page 1 to be reused, got %v", pn)
+	}
+	if p.GetNumFreePages() != 0 || p.GetNumPages() != 4 {
+		t.Error("reusing a free page should not grow the file")
+	}
+	// Reused pages come back zeroed.
+	page, err := p.GetPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	for _, b := range *page.GetData() {
+		if b != 0 {
+			t.Fatal("reused page was not zeroed")
+		}
+	}
+}
+
+func testPagerFreeListPersists(t *testing.T) {
+	p, dbName := openPolicyPager(t, pager.DEFAULT_POLICY)
+	defer os.Remove(dbName)
+	for i := int64(0); i < 4; i++ {
+		touchPage(t, p, i)
+	}
+	if err := p.FreePage(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.FreePage(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The free list survives a restart, most recently freed page first.
+	p = pager.NewPager()
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.GetNumFreePages() != 2 {
+		t.Fatalf("expected 2 free pages after reopening, got %v", p.GetNumFreePages())
+	}
+	if pn, err := p.GetFreePN(); err != nil || pn != 0 {
+		t.Errorf("expected page 0, got %v", pn)
+	}
+	if pn, err := p.GetFreePN(); err != nil || pn != 2 {
+		t.Errorf("expected page 2, got %v", pn)
+	}
+	if pn, err := p.GetFreePN(); err != nil || pn != 4 {
+		t.Errorf("expected a new page once the free list is empty, got %v", pn)
+	}
+}
+
+func testPagerFreeListInvalid(t *testing.T) {
+	p, dbName := openPolicyPager(t, pager.DEFAULT_POLICY)
+	defer os.Remove(dbName)
+	defer p.Close()
+	touchPage(t, p, 0)
+	if err := p.FreePage(5); err == nil {
+		t.Error("expected an error freeing a page past the end of the file")
+	}
+	if err := p.FreePage(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.FreePage(0); err == nil {
+		t.Error("expected an error freeing a page twice")
+	}
+}
+
+func testPagerFreeListCorrupted(t *testing.T) {
+	p, dbName := openPolicyPager(t, pager.DEFAULT_POLICY)
+	defer os.Remove(dbName)
+	defer p.Close()
+	for i := int64(0); i < 4; i++ {
+		touchPage(t, p, i)
+	}
+	if err := p.FreePage(1); err != nil {
+		t.Fatal(err)
+	}
+	// Overwrite the free page's marker.
+	page, err := p.GetPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Update([]byte("not free"), pager.FREE_MAGIC_OFFSET, pager.FREE_MAGIC_SIZE)
+	page.Put()
+	// Handing out a new page fails, rather than growing the file past the
+	// pages on the list.
+	if _, err := p.GetFreePN(); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("expected a corrupted free list error, got %v", err)
+	}
+	if page, err := p.GetNewPage(); err == nil {
+		page.Put()
+		t.Error("expected an error getting a new page")
+	}
+	if p.GetNumPages() != 4 {
+		t.Errorf("file grew to %v pages past a corrupted free list", p.GetNumPages())
+	}
+}
+
+func testHashDeleteFreesBuckets(t *testing.T) {
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	defer os.Remove(dbName + ".meta")
+	index, err := hash.OpenTable(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int64(2000)
+	for i := int64(0); i < n; i++ {
+		if err := index.Insert(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	numPages := index.GetPager().GetNumPages()
+	for i := int64(0); i < n; i++ {
+		if err := index.Delete(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if index.GetPager().GetNumFreePages() == 0 {
+		t.Error("deleting every entry did not free any buckets")
+	}
+	if entries, err := index.Select(); err != nil || len(entries) != 0 {
+		t.Error("table is not empty after deleting every entry")
+	}
+	// Reinsert after a restart; freed buckets should be reused.
+	index.Close()
+	index, err = hash.OpenTable(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	for i := int64(0); i < n; i++ {
+		if err := index.Insert(i, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if index.GetPager().GetNumPages() > numPages+1 {
+		t.Error("reinserting grew the file instead of reusing free pages")
+	}
+	entries, err := index.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(entries)) != n {
+		t.Errorf("expected %v entries, got %v", n, len(entries))
+	}
+}
+
+// corruptFile flips a byte at the given offset of a file.
+func corruptFile(t *testing.T, dbName string, offset int64) {
+	file, err := os.OpenFile(dbName, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	b := make([]byte, 1)
+	if _, err := file.ReadAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xff
+	if _, err := file.WriteAt(b, offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPagerChecksums(t *testing.T) {
+	t.Run("TestPagerChecksumValid", testPagerChecksumValid)
+	t.Run("TestPagerChecksumCorruptPage", testPagerChecksumCorruptPage)
+	t.Run("TestPagerChecksumCorruptHeader", testPagerChecksumCorruptHeader)
+}
+
+// writePages creates a file with a few pages of non-zero data.
+func writePages(t *testing.T, n int64) string {
+	p, dbName := openPolicyPager(t, pager.DEFAULT_POLICY)
+	for i := int64(0); i < n; i++ {
+		page, err := p.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.Update([]byte(fmt.Sprintf("page %v", i)), 0, 8)
+		page.Put()
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return dbName
+}
+
+func testPagerChecksumValid(t *testing.T) {
+	dbName := writePages(t, 3)
+	defer os.Remove(dbName)
+	p := pager.NewPager()
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	for i := int64(0); i < 3; i++ {
+		page, err := p.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(*page.GetData()), fmt.Sprintf("page %v\x00", i)) {
+			t.Error("page data was not read back")
+		}
+		page.Put()
+	}
+}
+
+func testPagerChecksumCorruptPage(t *testing.T) {
+	dbName := writePages(t, 3)
+	defer os.Remove(dbName)
+	// Flip a byte in the middle of page 1.
+	corruptFile(t, dbName, (pager.HEADER_PAGES+1)*pager.PAGESIZE+pager.PAGE_HEADER_SIZE+100)
+	p := pager.NewPager()
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	touchPage(t, p, 0)
+	touchPage(t, p, 2)
+	_, err := p.GetPage(1)
+	var corrupt *pager.CorruptPageError
+	if !errors.As(err, &corrupt) {
+		t.Fatalf("expected a corruption error, got %v", err)
+	}
+	if corrupt.PageNum != 1 || corrupt.File != filepath.Base(dbName) {
+		t.Errorf("corruption error names the wrong page: %v", err)
+	}
+}
+
+func testPagerChecksumCorruptHeader(t *testing.T) {
+	dbName := writePages(t, 1)
+	defer os.Remove(dbName)
+	corruptFile(t, dbName, pager.FREE_HEAD_OFFSET)
+	p := pager.NewPager()
+	err := p.Open(dbName)
+	var corrupt *pager.CorruptPageError
+	if !errors.As(err, &corrupt) || corrupt.PageNum != pager.NOPAGE {
+		t.Errorf("expected a header corruption error, got %v", err)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	t.Run("TestBufferPoolShared", testBufferPoolShared)
+	t.Run("TestBufferPoolCloseReleasesFrames", testBufferPoolCloseReleasesFrames)
+	t.Run("TestBufferPoolDatabase", testBufferPoolDatabase)
+}
+
+// newPool creates a buffer pool of default-sized pages.
+func newPool(t *testing.T, numPages int64) *pager.BufferPool {
+	pool, err := pager.NewBufferPool(numPages, pager.PAGESIZE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func testBufferPoolShared(t *testing.T) {
+	pool := newPool(t, 4)
+	p1, dbName1 := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName1)
+	defer p1.Close()
+	p2, dbName2 := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName2)
+	defer p2.Close()
+	// Both pagers use page numbers 0 and 1; the pool must keep them apart.
+	for _, p := range []*pager.Pager{p1, p2} {
+		for i := int64(0); i < 2; i++ {
+			page, err := p.GetPage(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page.Update([]byte(fmt.Sprintf("%v %v", p.GetFileName(), i)), 0, int64(len(p.GetFileName())+2))
+			page.Put()
+		}
+	}
+	// Touching a fifth page evicts the least recently used page of either pager.
+	touchPage(t, p2, 2)
+	if isResident(p1, 0) {
+		t.Error("shared pool did not evict the least recently used page")
+	}
+	if !isResident(p2, 0) || !isResident(p2, 2) {
+		t.Error("shared pool evicted the wrong page")
+	}
+	// Evicted pages are read back intact.
+	for _, p := range []*pager.Pager{p1, p2} {
+		for i := int64(0); i < 2; i++ {
+			page, err := p.GetPage(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := fmt.Sprintf("%v %v\x00", p.GetFileName(), i)
+			if !strings.HasPrefix(string(*page.GetData()), expected) {
+				t.Errorf("page %v of %v has the wrong contents", i, p.GetFileName())
+			}
+			page.Put()
+		}
+	}
+}
+
+func testBufferPoolCloseReleasesFrames(t *testing.T) {
+	pool := newPool(t, 2)
+	p1, dbName1 := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName1)
+	// Pin every frame, then give them back by closing the pager.
+	for i := int64(0); i < 2; i++ {
+		touchPage(t, p1, i)
+	}
+	pages := make([]*pager.Page, 0)
+	for i := int64(0); i < 2; i++ {
+		page, err := p1.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	p2, dbName2 := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName2)
+	defer p2.Close()
+	if _, err := p2.GetPage(0); err == nil {
+		t.Fatal("expected an error when every frame is pinned")
+	}
+	for _, page := range pages {
+		page.Put()
+	}
+	p1.Close()
+	for i := int64(0); i < 2; i++ {
+		page, err := p2.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer page.Put()
+	}
+}
+
+func testBufferPoolDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	database, err := db.Open(dir, db.WithBufferPoolPages(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	if database.GetBufferPool().GetNumPages() != 8 {
+		t.Error("database did not use the configured buffer pool size")
+	}
+	// Two tables much larger than the pool share its eight frames.
+	n := int64(5000)
+	tables := make([]db.Index, 0)
+	for _, name := range []string{"first", "second"} {
+		if err := db.HandleCreateTable(database, "create btree table "+name, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		table, err := database.GetTable(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table.GetPager().GetBufferPool() != database.GetBufferPool() {
+			t.Error("table does not use the database's buffer pool")
+		}
+		tables = append(tables, table)
+	}
+	for i := int64(0); i < n; i++ {
+		for j, table := range tables {
+			if err := table.Insert(i, i*int64(j+1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := int64(0); i < n; i++ {
+		for j, table := range tables {
+			entry, err := table.Find(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.GetValue() != i*int64(j+1) {
+				t.Fatalf("table %v has the wrong value for key %v", j, i)
+			}
+		}
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	t.Run("TestPageSizeInvalid", testPageSizeInvalid)
+	t.Run("TestPageSizeMismatch", testPageSizeMismatch)
+	t.Run("TestPageSizeBTree", testPageSizeBTree)
+	t.Run("TestPageSizeHash", testPageSizeHash)
+}
+
+func testPageSizeInvalid(t *testing.T) {
+	for _, size := range []int64{0, 1000, pager.PAGESIZE + 1, 2 * pager.MAX_PAGESIZE} {
+		if _, err := pager.NewBufferPool(4, size, nil); err == nil {
+			t.Errorf("expected an error for page size %v", size)
+		}
+	}
+}
+
+func testPageSizeMismatch(t *testing.T) {
+	// Write a file with large pages.
+	bigPool, err := pager.NewBufferPool(4, 4*pager.PAGESIZE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, dbName := openTempPager(t, pager.WithBufferPool(bigPool))
+	defer os.Remove(dbName)
+	touchPage(t, p, 0)
+	if p.GetDataSize() != 4*pager.PAGESIZE-pager.PAGE_HEADER_SIZE {
+		t.Error("data size does not follow the page size")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Reopening it with the default page size is rejected.
+	p = pager.NewPager()
+	if err := p.Open(dbName); err == nil || !strings.Contains(err.Error(), "page size") {
+		t.Errorf("expected a page size mismatch error, got %v", err)
+	}
+	// Reopening it with the right page size works.
+	p = pager.NewPager(pager.WithBufferPool(bigPool))
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.GetNumPages() != 1 {
+		t.Errorf("expected 1 page, got %v", p.GetNumPages())
+	}
+}
+
+// openSizedDatabase opens a database with the given page size in a fresh folder.
+func openSizedDatabase(t *testing.T, pageSize int64) (*db.Database, string) {
+	dir := t.TempDir()
+	database, err := db.Open(dir, db.WithPageSize(pageSize), db.WithBufferPoolPages(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database, dir
+}
+
+// fillTable inserts n entries into a new table of a database with the given
+// page size, then checks that every entry can be found.
+func fillTable(t *testing.T, pageSize int64, indexType string, n int64) {
+	database, dir := openSizedDatabase(t, pageSize)
+	defer os.RemoveAll(dir)
+	if err := db.HandleCreateTable(database, "create "+indexType+" table sized", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	table, err := database.GetTable("sized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < n; i++ {
+		if err := table.Insert(i, -i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := table.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(entries)) != n {
+		t.Errorf("expected %v entries, got %v", n, len(entries))
+	}
+	for i := int64(0); i < n; i++ {
+		entry, err := table.Find(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetValue() != -i {
+			t.Fatalf("wrong value for key %v", i)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testPageSizeBTree(t *testing.T) {
+	fillTable(t, 4*pager.PAGESIZE, "btree", 10000)
+}
+
+func testPageSizeHash(t *testing.T) {
+	fillTable(t, 2*pager.PAGESIZE, "hash", 10000)
+}
+
+func TestPagerStats(t *testing.T) {
+	t.Run("TestPagerStatsCounts", testPagerStatsCounts)
+	t.Run("TestPagerStatsPool", testPagerStatsPool)
+	t.Run("TestPagerStatsRepl", testPagerStatsRepl)
+}
+
+func testPagerStatsCounts(t *testing.T) {
+	pool := newPool(t, 2)
+	p, dbName := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName)
+	defer p.Close()
+	touchPage(t, p, 0)
+	touchPage(t, p, 0)
+	page, err := p.GetPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both frames are in use, so page 2 evicts (and flushes) page 0.
+	touchPage(t, p, 2)
+	stats := p.GetStats()
+	if stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("expected 1 hit and 3 misses, got %v and %v", stats.Hits, stats.Misses)
+	}
+	if stats.Evictions != 1 || stats.DirtyFlushes != 1 {
+		t.Errorf("expected 1 eviction and 1 flush, got %v and %v", stats.Evictions, stats.DirtyFlushes)
+	}
+	if stats.Pinned != 1 || stats.Unpinned != 1 {
+		t.Errorf("expected 1 pinned and 1 unpinned page, got %v and %v", stats.Pinned, stats.Unpinned)
+	}
+	page.Put()
+	// Reading page 0 back counts a full page read.
+	before := p.GetStats().BytesRead
+	touchPage(t, p, 0)
+	if read := p.GetStats().BytesRead - before; read != pager.PAGESIZE {
+		t.Errorf("expected %v bytes read, got %v", pager.PAGESIZE, read)
+	}
+	if written := p.GetStats().BytesWritten; written < 2*pager.PAGESIZE {
+		t.Errorf("expected at least %v bytes written, got %v", 2*pager.PAGESIZE, written)
+	}
+	if ratio := p.GetStats().HitRatio(); ratio != 0.2 {
+		t.Errorf("expected a hit ratio of 0.2, got %v", ratio)
+	}
+}
+
+func testPagerStatsPool(t *testing.T) {
+	pool := newPool(t, 4)
+	p1, dbName1 := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName1)
+	defer p1.Close()
+	p2, dbName2 := openTempPager(t, pager.WithBufferPool(pool))
+	defer os.Remove(dbName2)
+	defer p2.Close()
+	touchPage(t, p1, 0)
+	touchPage(t, p2, 0)
+	touchPage(t, p2, 0)
+	page, err := p2.GetPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	stats := pool.GetStats()
+	if stats.Hits != 1 || stats.Misses != 3 {
+		t.Errorf("expected 1 hit and 3 misses, got %v and %v", stats.Hits, stats.Misses)
+	}
+	if stats.Pinned != 1 || stats.Unpinned != 2 {
+		t.Errorf("expected 1 pinned and 2 unpinned pages, got %v and %v", stats.Pinned, stats.Unpinned)
+	}
+	if p1.GetStats().Misses != 1 || p2.GetStats().Misses != 2 {
+		t.Error("pager statistics include other pagers' requests")
+	}
+}
+
+func testPagerStatsRepl(t *testing.T) {
+	database, dir := openSizedDatabase(t, pager.PAGESIZE)
+	defer os.RemoveAll(dir)
+	defer database.Close()
+	if err := db.HandleCreateTable(database, "create btree table stats", ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HandleInsert(database, "insert 1 2 into stats"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := db.HandleStats(database, "stats stats", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "hits: ") || !strings.Contains(buf.String(), "pinned: 0\n") {
+		t.Errorf("unexpected table stats output:\n%v", buf.String())
+	}
+	buf.Reset()
+	if err := db.HandleStats(database, "stats", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "pages: 16 ") {
+		t.Errorf("unexpected database stats output:\n%v", buf.String())
+	}
+	if err := db.HandleStats(database, "stats missing", &buf); err == nil {
+		t.Error("expected an error for a missing table")
+	}
+}
+
+func TestPagerCompression(t *testing.T) {
+	t.Run("TestPagerCompressionSize", testPagerCompressionSize)
+	t.Run("TestPagerCompressionReuse", testPagerCompressionReuse)
+	t.Run("TestPagerCompressionNeverWritten", testPagerCompressionNeverWritten)
+	t.Run("TestPagerCompressionSyncMap", testPagerCompressionSyncMap)
+}
+
+// fillCompressedTable inserts n entries into a btree table and returns the
+// size of its file once the database is closed.
+func fillCompressedTable(t *testing.T, dir string, name string, compressed bool, n int64) int64 {
+	database, err := db.Open(dir, db.WithBufferPoolPages(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := "create btree table " + name
+	if compressed {
+		command += " compressed"
+	}
+	if err := db.HandleCreateTable(database, command, ioutil.Discard); err != nil {
+		t.Fatal(err)
+	}
+	table, err := database.GetTable(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < n; i++ {
+		if err := table.Insert(i, i%7); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func testPagerCompressionSize(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plain := fillCompressedTable(t, dir, "plain", false, 5000)
+	compressed := fillCompressedTable(t, dir, "compressed", true, 5000)
+	if compressed*2 > plain {
+		t.Errorf("compressed table is %v bytes, uncompressed table is %v bytes", compressed, plain)
+	}
+	// Reopen the table without asking for compression.
+	database, err := db.Open(dir, db.WithBufferPoolPages(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	table, err := database.GetTable("compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !table.GetPager().IsCompressed() {
+		t.Error("table was not reopened as compressed")
+	}
+	for i := int64(0); i < 5000; i++ {
+		entry, err := table.Find(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetValue() != i%7 {
+			t.Fatalf("wrong value for key %v", i)
+		}
+	}
+}
+
+func testPagerCompressionReuse(t *testing.T) {
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	var sizes []int64
+	for round := 0; round < 20; round++ {
+		p := pager.NewPager(pager.WithCompression())
+		if err := p.Open(dbName); err != nil {
+			t.Fatal(err)
+		}
+		// Rewrite every page, so every round frees the previous round's sectors.
+		for i := int64(0); i < 8; i++ {
+			page, err := p.GetPage(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := []byte(fmt.Sprintf("round %v page %v", round, i))
+			page.Update(data, 0, int64(len(data)))
+			page.Put()
+		}
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(dbName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, info.Size())
+	}
+	if sizes[len(sizes)-1] > 2*sizes[0] {
+		t.Errorf("file grew from %v to %v bytes; sectors are not reused", sizes[0], sizes[len(sizes)-1])
+	}
+	// The last round's data survives.
+	p := pager.NewPager()
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	page, err := p.GetPage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if !bytes.HasPrefix(*page.GetData(), []byte("round 19 page 3\x00")) {
+		t.Error("page data was not read back")
+	}
+}
+
+func testPagerCompressionNeverWritten(t *testing.T) {
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	p := pager.NewPager(pager.WithCompression())
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	// Page 2 is written, so pages 0 and 1 are allocated but empty.
+	for i := int64(0); i < 3; i++ {
+		page, err := p.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 2 {
+			page.Update([]byte("x"), 0, 1)
+		}
+		page.Put()
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.GetNumPages() != 3 {
+		t.Errorf("expected 3 pages, got %v", p.GetNumPages())
+	}
+	page, err := p.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if !bytes.Equal(*page.GetData(), make([]byte, len(*page.GetData()))) {
+		t.Error("a page that was never written is not empty")
+	}
+}
+
+// The new page map is synced before the header points at it, so a file
+// whose sync failed opens at the last page map that was synced.
+func testPagerCompressionSyncMap(t *testing.T) {
+	backend := pager.NewFaultBackend(pager.NewMemoryBackend())
+	writeStrings(t, "compressed", []string{"old 0", "old 1"}, pager.WithCompression(), pager.WithBackend(backend))
+	p := pager.NewPager(pager.WithCompression(), pager.WithBackend(backend))
+	if err := p.Open("compressed"); err != nil {
+		t.Fatal(err)
+	}
+	update := func(s string) {
+		page, err := p.GetPage(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.Update([]byte(s), 0, int64(len(s)))
+		page.Put()
+	}
+	// The header, at the start of the file, is written after the sync.
+	synced, headerFirst := false, false
+	backend.SetSchedule(func(op pager.Op) pager.Fault {
+		if op.Sync {
+			synced = true
+		} else if op.Write && op.Offset == 0 && !synced {
+			headerFirst = true
+		}
+		return pager.FaultNone
+	})
+	update("new 0")
+	if err := p.FlushAllPages(); err != nil {
+		t.Fatal(err)
+	}
+	if !synced || headerFirst {
+		t.Error("header was written before the page map was synced")
+	}
+	// The header isn't written when the sync fails.
+	backend.SetSchedule(pager.FaultAt(pager.FaultFailSync, 0))
+	update("lost 0")
+	var writeErr *pager.WriteError
+	if err := p.FlushAllPages(); !errors.As(err, &writeErr) || !writeErr.Sync {
+		t.Fatalf("expected a sync error, got %v", err)
+	}
+	backend.SetSchedule(nil)
+	p.Close()
+	p = pager.NewPager(pager.WithBackend(backend))
+	if err := p.Open("compressed"); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	page, err := p.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if !bytes.HasPrefix(*page.GetData(), []byte("new 0\x00")) {
+		t.Errorf("expected the synced page, got %q", (*page.GetData())[:8])
+	}
+}
+
+func TestPagerEncryption(t *testing.T) {
+	t.Run("TestPagerEncryptionRoundTrip", testPagerEncryptionRoundTrip)
+	t.Run("TestPagerEncryptionWrongKey", testPagerEncryptionWrongKey)
+	t.Run("TestPagerEncryptionCompressed", testPagerEncryptionCompressed)
+	t.Run("TestPagerEncryptionLoadKey", testPagerEncryptionLoadKey)
+}
+
+// writeStrings writes one string to each page of a new file with a pager
+// built from the given options.
+func writeStrings(t *testing.T, dbName string, data []string, opts ...pager.Option) {
+	p := pager.NewPager(opts...)
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range data {
+		page, err := p.GetPage(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		page.Update([]byte(s), 0, int64(len(s)))
+		page.Put()
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newCipher returns a cipher whose key is the given byte repeated.
+func newCipher(t *testing.T, b byte) *pager.Cipher {
+	c, err := pager.NewCipher(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func testPagerEncryptionRoundTrip(t *testing.T) {
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	c := newCipher(t, 1)
+	writeStrings(t, dbName, []string{"sensitive identifier"}, pager.WithCipher(c))
+	contents, err := ioutil.ReadFile(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, []byte("sensitive identifier")) {
+		t.Error("page data was written in the clear")
+	}
+	p := pager.NewPager(pager.WithCipher(c))
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if !p.IsEncrypted() {
+		t.Error("file is not marked as encrypted")
+	}
+	page, err := p.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if !bytes.HasPrefix(*page.GetData(), []byte("sensitive identifier\x00")) {
+		t.Error("page data was not read back")
+	}
+}
+
+func testPagerEncryptionWrongKey(t *testing.T) {
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	writeStrings(t, dbName, []string{"data"}, pager.WithCipher(newCipher(t, 1)))
+	p := pager.NewPager(pager.WithCipher(newCipher(t, 2)))
+	err := p.Open(dbName)
+	var authErr *pager.AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected an authentication error, got %v", err)
+	}
+	if authErr.PageNum != pager.NOPAGE || !strings.Contains(err.Error(), "wrong encryption key") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := pager.NewPager().Open(dbName); err == nil {
+		t.Error("opened an encrypted file without a key")
+	}
+	plainName := getTempPagerDB(t)
+	defer os.Remove(plainName)
+	writeStrings(t, plainName, []string{"data"})
+	if err := pager.NewPager(pager.WithCipher(newCipher(t, 1))).Open(plainName); err == nil {
+		t.Error("opened an unencrypted file with a key")
+	}
+}
+
+func testPagerEncryptionCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	database, err := db.Open(dir, db.WithBufferPoolPages(16), db.WithCipher(newCipher(t, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range []string{"create btree table plain", "create hash table hashed compressed"} {
+		if err := db.HandleCreateTable(database, command, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, table := range database.GetTables() {
+		for i := int64(0); i < 2000; i++ {
+			if err := table.Insert(i, i*3); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The right key reads every entry back.
+	database, err = db.Open(dir, db.WithBufferPoolPages(16), db.WithCipher(newCipher(t, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := database.GetTable("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 2000; i++ {
+		entry, err := table.Find(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetValue() != i*3 {
+			t.Fatalf("wrong value for key %v", i)
+		}
+	}
+	database.Close()
+	// The wrong key is rejected when the table is opened.
+	database, err = db.Open(dir, db.WithBufferPoolPages(16), db.WithCipher(newCipher(t, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var authErr *pager.AuthError
+	if _, err := database.GetTable("plain"); !errors.As(err, &authErr) {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+}
+
+func testPagerEncryptionLoadKey(t *testing.T) {
+	keyFile := getTempPagerDB(t)
+	defer os.Remove(keyFile)
+	hexKey := strings.Repeat("ab", 32)
+	if err := ioutil.WriteFile(keyFile, []byte(hexKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := pager.LoadKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(key, bytes.Repeat([]byte{0xab}, 32)) {
+		t.Error("key file was not decoded")
+	}
+	os.Setenv(pager.KEY_ENV_VAR, strings.Repeat("cd", 16))
+	defer os.Unsetenv(pager.KEY_ENV_VAR)
+	if key, err := pager.LoadKey(""); err != nil || len(key) != 16 {
+		t.Errorf("key was not read from %v: %v", pager.KEY_ENV_VAR, err)
+	}
+	if _, err := pager.ParseKey("abcd"); err == nil {
+		t.Error("accepted a key of the wrong length")
+	}
+	if _, err := pager.ParseKey("not hex"); err == nil {
+		t.Error("accepted a key that isn't hex")
+	}
+}
+
+func TestPagerWriteback(t *testing.T) {
+	t.Run("TestPagerWritebackAge", testPagerWritebackAge)
+	t.Run("TestPagerWritebackRatio", testPagerWritebackRatio)
+	t.Run("TestPagerWritebackPinned", testPagerWritebackPinned)
+	t.Run("TestPagerWritebackStops", testPagerWritebackStops)
+}
+
+// waitFor polls the condition until it holds, failing after two seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// openWritebackPager opens a pager on a fresh file with a pool of n pages
+// and a background flusher.
+func openWritebackPager(t *testing.T, n int64, config pager.WritebackConfig) (*pager.Pager, string) {
+	return openTempPager(t, pager.WithBufferPool(newPool(t, n)), pager.WithWriteback(config))
+}
+
+// dirtyPage writes to a page and releases it.
+func dirtyPage(t *testing.T, p *pager.Pager, pagenum int64) {
+	page, err := p.GetPage(pagenum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(fmt.Sprintf("page %v", pagenum))
+	page.Update(data, 0, int64(len(data)))
+	page.Put()
+}
+
+func testPagerWritebackAge(t *testing.T) {
+	p, dbName := openWritebackPager(t, 8, pager.WritebackConfig{MaxAge: 10 * time.Millisecond, Interval: time.Millisecond})
+	defer os.Remove(dbName)
+	dirtyPage(t, p, 0)
+	waitFor(t, "an old page to be written back", func() bool { return p.GetStats().Writebacks == 1 })
+	contents, err := ioutil.ReadFile(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(contents, []byte("page 0")) {
+		t.Error("page was not written to disk")
+	}
+	// Nothing is left for Close to write.
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.GetStats(); stats.DirtyFlushes != 1 {
+		t.Errorf("expected 1 dirty flush, got %v", stats.DirtyFlushes)
+	}
+}
+
+func testPagerWritebackRatio(t *testing.T) {
+	p, dbName := openWritebackPager(t, 8, pager.WritebackConfig{DirtyRatio: 0.5, MaxAge: time.Hour, Interval: time.Millisecond})
+	defer os.Remove(dbName)
+	defer p.Close()
+	for i := int64(0); i < 3; i++ {
+		dirtyPage(t, p, i)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if writebacks := p.GetStats().Writebacks; writebacks != 0 {
+		t.Fatalf("flushed %v pages below the dirty ratio", writebacks)
+	}
+	dirtyPage(t, p, 3)
+	waitFor(t, "dirty pages to be written back", func() bool { return p.GetStats().Writebacks == 4 })
+}
+
+func testPagerWritebackPinned(t *testing.T) {
+	p, dbName := openWritebackPager(t, 8, pager.WritebackConfig{MaxAge: time.Millisecond, Interval: time.Millisecond})
+	defer os.Remove(dbName)
+	defer p.Close()
+	page, err := p.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Update([]byte("pinned"), 0, 6)
+	time.Sleep(20 * time.Millisecond)
+	if writebacks := p.GetStats().Writebacks; writebacks != 0 {
+		t.Errorf("flushed %v pinned pages", writebacks)
+	}
+	page.Put()
+	waitFor(t, "the unpinned page to be written back", func() bool { return p.GetStats().Writebacks == 1 })
+}
+
+func testPagerWritebackStops(t *testing.T) {
+	before := runtime.NumGoroutine()
+	p, dbName := openWritebackPager(t, 8, pager.WritebackConfig{MaxAge: time.Millisecond, Interval: time.Millisecond})
+	defer os.Remove(dbName)
+	if runtime.NumGoroutine() != before+1 {
+		t.Errorf("expected one flusher goroutine")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.NumGoroutine() != before {
+		t.Errorf("flusher is still running after Close")
+	}
+}
+
+func TestPagerPrefetch(t *testing.T) {
+	t.Run("TestPagerPrefetchHits", testPagerPrefetchHits)
+	t.Run("TestPagerPrefetchPinned", testPagerPrefetchPinned)
+	t.Run("TestPagerPrefetchCorrupt", testPagerPrefetchCorrupt)
+	t.Run("TestPagerPrefetchClose", testPagerPrefetchClose)
+	t.Run("TestPagerPrefetchCursors", testPagerPrefetchCursors)
+}
+
+// openWrittenPager writes n pages and reopens them with a pool of the given size.
+func openWrittenPager(t *testing.T, n int64, poolPages int64) (*pager.Pager, string) {
+	dbName := writePages(t, n)
+	return openPager(t, dbName, pager.WithBufferPool(newPool(t, poolPages))), dbName
+}
+
+func testPagerPrefetchHits(t *testing.T) {
+	p, dbName := openWrittenPager(t, 8, 8)
+	defer os.Remove(dbName)
+	defer p.Close()
+	// Pages past the end of the file are skipped.
+	p.Prefetch(0, 1, 2, 3, 100)
+	for i := int64(0); i < 4; i++ {
+		page, err := p.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(*page.GetData(), []byte(fmt.Sprintf("page %v", i))) {
+			t.Errorf("page %v was not read correctly", i)
+		}
+		page.Put()
+	}
+	stats := p.GetStats()
+	if stats.Prefetches != 4 || stats.Misses != 0 || stats.Hits != 4 {
+		t.Errorf("expected 4 prefetches and hits, got %v prefetches, %v hits, %v misses", stats.Prefetches, stats.Hits, stats.Misses)
+	}
+	if p.GetNumPages() != 8 {
+		t.Errorf("prefetching changed the number of pages to %v", p.GetNumPages())
+	}
+}
+
+func testPagerPrefetchPinned(t *testing.T) {
+	p, dbName := openWrittenPager(t, 8, 4)
+	defer os.Remove(dbName)
+	defer p.Close()
+	pages := make([]*pager.Page, 0)
+	for i := int64(0); i < 4; i++ {
+		page, err := p.GetPage(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, page)
+	}
+	// Every frame is pinned, so there is nowhere to read page 4 into.
+	p.Prefetch(4)
+	if isResident(p, 4) || p.GetStats().Evictions != 0 {
+		t.Error("prefetch took a pinned frame")
+	}
+	// Once page 0 is unpinned, its frame can be reused.
+	pages[0].Put()
+	p.Prefetch(4)
+	waitFor(t, "the prefetch to finish", func() bool { return p.GetStats().Prefetches == 1 })
+	if isResident(p, 0) || !isResident(p, 4) {
+		t.Error("prefetch did not reuse the unpinned frame")
+	}
+	for _, page := range pages[1:] {
+		page.Put()
+	}
+}
+
+func testPagerPrefetchCorrupt(t *testing.T) {
+	dbName := writePages(t, 3)
+	defer os.Remove(dbName)
+	corruptFile(t, dbName, (pager.HEADER_PAGES+1)*pager.PAGESIZE+pager.PAGE_HEADER_SIZE+100)
+	p := pager.NewPager()
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	p.Prefetch(1)
+	var corrupt *pager.CorruptPageError
+	if _, err := p.GetPage(1); !errors.As(err, &corrupt) {
+		t.Errorf("expected a corrupt page error, got %v", err)
+	}
+	if isResident(p, 1) {
+		t.Error("a page that failed to load was left in the buffer pool")
+	}
+}
+
+func testPagerPrefetchClose(t *testing.T) {
+	p, dbName := openWrittenPager(t, 8, 8)
+	defer os.Remove(dbName)
+	p.Prefetch(0, 1, 2, 3, 4, 5, 6, 7)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := p.GetStats(); stats.Pinned != 0 || stats.Unpinned != 0 {
+		t.Errorf("pages left in the pool after close: %v pinned, %v unpinned", stats.Pinned, stats.Unpinned)
+	}
+}
+
+func testPagerPrefetchCursors(t *testing.T) {
+	for _, indexType := range []string{"btree", "hash"} {
+		database, dir := openSizedDatabase(t, pager.PAGESIZE)
+		defer os.RemoveAll(dir)
+		if err := db.HandleCreateTable(database, "create "+indexType+" table scan", ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		table, err := database.GetTable("scan")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := int64(0); i < 5000; i++ {
+			if err := table.Insert(i, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cursor, err := table.TableStart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := 0
+		for {
+			if !cursor.IsEnd() {
+				if _, err := cursor.GetEntry(); err != nil {
+					t.Fatal(err)
+				}
+				seen++
+			}
+			if cursor.StepForward() {
+				break
+			}
+		}
+		if seen != 5000 {
+			t.Errorf("%v cursor saw %v entries, expected 5000", indexType, seen)
+		}
+		if table.GetPager().GetStats().Prefetches == 0 {
+			t.Errorf("%v cursor did not read ahead", indexType)
+		}
+		cursor.Close()
+		database.Close()
+	}
+}
+
+func TestPagerStorage(t *testing.T) {
+	t.Run("TestPagerStorageMemory", testPagerStorageMemory)
+	t.Run("TestPagerStorageDatabase", testPagerStorageDatabase)
+	t.Run("TestPagerStorageTornWrite", testPagerStorageTornWrite)
+	t.Run("TestPagerStorageDroppedWrite", testPagerStorageDroppedWrite)
+	t.Run("TestPagerStorageReadErrors", testPagerStorageReadErrors)
+}
+
+// fillPage overwrites the whole data of a page with the given byte.
+func fillPage(t *testing.T, p *pager.Pager, pagenum int64, b byte) {
+	page, err := p.GetPage(pagenum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.Update(bytes.Repeat([]byte{b}, int(p.GetDataSize())), 0, p.GetDataSize())
+	page.Put()
+}
+
+// openFaultPager opens a pager built from the given options on a file in a
+// fault-injecting memory backend, with two pages of data filled with 'a'
+// and 'b'.
+func openFaultPager(t *testing.T, opts ...pager.Option) (*pager.Pager, *pager.FaultBackend) {
+	backend := pager.NewFaultBackend(pager.NewMemoryBackend())
+	p := pager.NewPager(append(opts, pager.WithBackend(backend))...)
+	if err := p.Open("faulty"); err != nil {
+		t.Fatal(err)
+	}
+	fillPage(t, p, 0, 'a')
+	fillPage(t, p, 1, 'b')
+	if err := p.FlushAllPages(); err != nil {
+		t.Fatal(err)
+	}
+	return p, backend
+}
+
+// reopen closes a pager and opens its file again in the same backend.
+func reopen(t *testing.T, p *pager.Pager) *pager.Pager {
+	name := p.GetFilePath()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p = pager.NewPager(pager.WithBackend(p.GetBackend()))
+	if err := p.Open(name); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testPagerStorageMemory(t *testing.T) {
+	backend := pager.NewMemoryBackend()
+	dbName := filepath.Join(t.Name(), "memory")
+	writeStrings(t, dbName, []string{"first", "second"}, pager.WithBackend(backend))
+	if _, err := os.Stat(dbName); !os.IsNotExist(err) {
+		t.Error("memory backend created a file on disk")
+	}
+	if !backend.Exists(dbName) {
+		t.Fatal("memory backend lost the file")
+	}
+	p := pager.NewPager(pager.WithBackend(backend))
+	if err := p.Open(dbName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.GetNumPages() != 2 {
+		t.Errorf("expected 2 pages, got %v", p.GetNumPages())
+	}
+	for i, s := range []string{"first", "second"} {
+		page, err := p.GetPage(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(*page.GetData()), s+"\x00") {
+			t.Errorf("page %v was not read back", i)
+		}
+		page.Put()
+	}
+}
+
+func testPagerStorageDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend := pager.NewMemoryBackend()
+	n := int64(1000)
+	for _, indexType := range []string{"btree", "hash"} {
+		database, err := db.Open(dir, db.WithBackend(backend))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.HandleCreateTable(database, "create "+indexType+" table "+indexType, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		table, err := database.GetTable(indexType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := int64(0); i < n; i++ {
+			if err := table.Insert(i, i*2); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := database.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, indexType)); !os.IsNotExist(err) {
+			t.Errorf("%v table was written to disk", indexType)
+		}
+	}
+	// The btree table can be opened again from the backend.
+	database, err := db.Open(dir, db.WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	table, err := database.GetTable("btree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < n; i++ {
+		entry, err := table.Find(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetValue() != i*2 {
+			t.Fatalf("wrong value for key %v", i)
+		}
+	}
+}
+
+func testPagerStorageTornWrite(t *testing.T) {
+	p, backend := openFaultPager(t)
+	// Tear the next write of page 1.
+	backend.SetSchedule(func(op pager.Op) pager.Fault {
+		if op.Write && op.Offset == (pager.HEADER_PAGES+1)*pager.PAGESIZE {
+			return pager.FaultTearWrite
+		}
+		return pager.FaultNone
+	})
+	fillPage(t, p, 1, 'c')
+	p = reopen(t, p)
+	defer p.Close()
+	touchPage(t, p, 0)
+	_, err := p.GetPage(1)
+	var corrupt *pager.CorruptPageError
+	if !errors.As(err, &corrupt) || corrupt.PageNum != 1 {
+		t.Errorf("expected a corruption error for page 1, got %v", err)
+	}
+}
+
+func testPagerStorageDroppedWrite(t *testing.T) {
+	p, backend := openFaultPager(t)
+	// Drop the write of page 0, which is the first one Close makes.
+	backend.SetSchedule(pager.FaultAt(pager.FaultDropWrite, 0))
+	fillPage(t, p, 0, 'c')
+	p = reopen(t, p)
+	defer p.Close()
+	if backend.Writes() == 0 {
+		t.Error("no writes were counted")
+	}
+	page, err := p.GetPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if (*page.GetData())[0] != 'a' {
+		t.Error("dropped write reached the file")
+	}
+}
+
+func testPagerStorageReadErrors(t *testing.T) {
+	p, backend := openFaultPager(t)
+	p = reopen(t, p)
+	defer p.Close()
+	backend.SetSchedule(pager.FaultAt(pager.FaultFailRead, 0))
+	if _, err := p.GetPage(0); !errors.Is(err, syscall.EIO) {
+		t.Errorf("expected EIO, got %v", err)
+	}
+	backend.SetSchedule(pager.FaultAt(pager.FaultShortRead, 0))
+	_, err := p.GetPage(1)
+	var corrupt *pager.CorruptPageError
+	if !errors.As(err, &corrupt) {
+		t.Errorf("expected a short read to be caught, got %v", err)
+	}
+	// Once the faults are past, both pages read fine.
+	touchPage(t, p, 0)
+	touchPage(t, p, 1)
+}
+
+func TestPagerWriteErrors(t *testing.T) {
+	t.Run("TestPagerWriteErrorsFlush", testPagerWriteErrorsFlush)
+	t.Run("TestPagerWriteErrorsEviction", testPagerWriteErrorsEviction)
+	t.Run("TestPagerWriteErrorsDatabase", testPagerWriteErrorsDatabase)
+}
+
+// checkPageByte checks the first byte of a page's data.
+func checkPageByte(t *testing.T, p *pager.Pager, pagenum int64, b byte) {
+	page, err := p.GetPage(pagenum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if (*page.GetData())[0] != b {
+		t.Errorf("page %v starts with %q, expected %q", pagenum, (*page.GetData())[0], b)
+	}
+}
+
+func testPagerWriteErrorsFlush(t *testing.T) {
+	p, backend := openFaultPager(t)
+	backend.SetSchedule(pager.FaultAfter(pager.FaultFailWrite, 0))
+	fillPage(t, p, 0, 'c')
+	err := p.FlushAllPages()
+	var flushErr *pager.FlushError
+	if !errors.As(err, &flushErr) {
+		t.Fatalf("expected a flush error, got %v", err)
+	}
+	if len(flushErr.PageNums) != 1 || flushErr.PageNums[0] != 0 {
+		t.Errorf("flush error names the wrong pages: %v", flushErr.PageNums)
+	}
+	var writeErr *pager.WriteError
+	if !errors.As(err, &writeErr) || !errors.Is(err, syscall.EIO) {
+		t.Errorf("expected a write error caused by EIO, got %v", err)
+	}
+	if !p.IsReadOnly() {
+		t.Fatal("pager did not become read-only")
+	}
+	// Nothing else can change, but reads still work.
+	var readOnly *pager.ReadOnlyError
+	if _, err := p.GetPage(2); !errors.As(err, &readOnly) {
+		t.Errorf("expected a new page to be refused, got %v", err)
+	}
+	if err := p.FreePage(1); !errors.As(err, &readOnly) {
+		t.Errorf("expected freeing a page to be refused, got %v", err)
+	}
+	checkPageByte(t, p, 0, 'c')
+	checkPageByte(t, p, 1, 'b')
+	// The failed page is still dirty, so closing reports it again.
+	if err := p.Close(); !errors.As(err, &flushErr) || !errors.As(err, &readOnly) {
+		t.Errorf("expected close to report the unwritten page, got %v", err)
+	}
+}
+
+func testPagerWriteErrorsEviction(t *testing.T) {
+	p, backend := openFaultPager(t, pager.WithBufferPool(newPool(t, 2)))
+	fillPage(t, p, 2, 'x')
+	if err := p.FlushAllPages(); err != nil {
+		t.Fatal(err)
+	}
+	// Leave a dirty page 0 as the next victim.
+	fillPage(t, p, 0, 'c')
+	touchPage(t, p, 2)
+	backend.SetSchedule(pager.FaultAfter(pager.FaultFailWrite, 0))
+	if _, err := p.GetPage(1); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("expected eviction to fail with EIO, got %v", err)
+	}
+	if !p.IsReadOnly() {
+		t.Fatal("pager did not become read-only")
+	}
+	// Page 0 can't be evicted any more, but page 2 can, so reads go on and
+	// page 0 keeps its changes.
+	checkPageByte(t, p, 1, 'b')
+	checkPageByte(t, p, 0, 'c')
+	var flushErr *pager.FlushError
+	if err := p.Close(); !errors.As(err, &flushErr) || len(flushErr.PageNums) != 1 {
+		t.Errorf("expected close to report page 0, got %v", err)
+	}
+}
+
+func testPagerWriteErrorsDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	backend := pager.NewFaultBackend(pager.NewMemoryBackend())
+	database, err := db.Open(dir, db.WithBackend(backend), db.WithWriteback(pager.WritebackConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, indexType := range []string{"btree", "hash"} {
+		if err := db.HandleCreateTable(database, "create "+indexType+" table "+indexType, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		table, err := database.GetTable(indexType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := int64(0); i < 100; i++ {
+			if err := table.Insert(i, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backend.SetSchedule(pager.FaultAfter(pager.FaultFailWrite, 0))
+		if err := table.GetPager().FlushAllPages(); !errors.Is(err, syscall.EIO) {
+			t.Fatalf("expected the flush to fail with EIO, got %v", err)
+		}
+		var readOnly *pager.ReadOnlyError
+		if err := table.Insert(100, 100); !errors.As(err, &readOnly) {
+			t.Errorf("%v table accepted an insert after a write error: %v", indexType, err)
+		}
+		if err := table.Update(0, 1); !errors.As(err, &readOnly) {
+			t.Errorf("%v table accepted an update after a write error: %v", indexType, err)
+		}
+		if err := table.Delete(0); !errors.As(err, &readOnly) {
+			t.Errorf("%v table accepted a delete after a write error: %v", indexType, err)
+		}
+		if entry, err := table.Find(42); err != nil || entry.GetValue() != 42 {
+			t.Errorf("%v table can't be read after a write error: %v", indexType, err)
+		}
+		backend.SetSchedule(nil)
+	}
+	if err := database.Close(); err == nil {
+		t.Error("expected closing the database to report the lost pages")
+	}
+}
+
+func TestPagerMmap(t *testing.T) {
+	t.Run("TestPagerMmapBTree", testPagerMmapBTree)
+	t.Run("TestPagerMmapHash", testPagerMmapHash)
+	t.Run("TestPagerMmapMemory", testPagerMmapMemory)
+	t.Run("TestPagerMmapErrors", testPagerMmapErrors)
+}
+
+// openTableFunc opens a table of some index type.
+type openTableFunc func(filename string, opts ...pager.Option) (db.Index, error)
+
+// testMmapTable fills a table, then checks that it can be read through a
+// memory-mapped pager without touching the buffer pool, and not written.
+func testMmapTable(t *testing.T, open openTableFunc) {
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	defer os.Remove(dbName + ".meta")
+	n := int64(2000)
+	table, err := open(dbName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < n; i++ {
+		if err := table.Insert(i, i*3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pool := newPool(t, 4)
+	table, err = open(dbName, pager.WithBufferPool(pool), pager.WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !table.GetPager().IsMapped() {
+		t.Fatal("pager is not memory-mapped")
+	}
+	for i := int64(0); i < n; i++ {
+		entry, err := table.Find(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.GetValue() != i*3 {
+			t.Fatalf("wrong value for key %v", i)
+		}
+	}
+	entries, err := table.Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(entries)) != n {
+		t.Errorf("expected %v entries, got %v", n, len(entries))
+	}
+	if stats := pool.GetStats(); stats.Pinned+stats.Unpinned != 0 || stats.Misses != 0 {
+		t.Error("memory-mapped table used the buffer pool")
+	}
+	var readOnly *pager.ReadOnlyError
+	if err := table.Insert(n, n); !errors.As(err, &readOnly) {
+		t.Errorf("expected an insert to be refused, got %v", err)
+	}
+	if err := table.Delete(0); !errors.As(err, &readOnly) {
+		t.Errorf("expected a delete to be refused, got %v", err)
+	}
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testPagerMmapBTree(t *testing.T) {
+	testMmapTable(t, func(filename string, opts ...pager.Option) (db.Index, error) {
+		return btree.OpenTable(filename, opts...)
+	})
+}
+
+func testPagerMmapHash(t *testing.T) {
+	testMmapTable(t, func(filename string, opts ...pager.Option) (db.Index, error) {
+		return hash.OpenTable(filename, opts...)
+	})
+}
+
+func testPagerMmapMemory(t *testing.T) {
+	backend := pager.NewMemoryBackend()
+	writeStrings(t, "mapped", []string{"first", "second"}, pager.WithBackend(backend))
+	p := pager.NewPager(pager.WithBackend(backend), pager.WithMmap())
+	if err := p.Open("mapped"); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	page, err := p.GetPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer page.Put()
+	if !strings.HasPrefix(string(*page.GetData()), "second\x00") {
+		t.Error("page was not read from the mapping")
+	}
+	again, err := p.GetPage(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Put()
+	if again != page {
+		t.Error("a pinned page was handed out twice")
+	}
+}
+
+func testPagerMmapErrors(t *testing.T) {
+	// Files aren't created.
+	p := pager.NewPager(pager.WithMmap())
+	if err := p.Open(filepath.Join(t.Name(), "missing")); err == nil {
+		t.Error("expected opening a missing file to fail")
+	}
+	if _, err := os.Stat(t.Name()); !os.IsNotExist(err) {
+		t.Error("opening a missing file created it")
+	}
+	// Compressed files can't be mapped.
+	dbName := getTempPagerDB(t)
+	defer os.Remove(dbName)
+	os.Remove(dbName)
+	writeStrings(t, dbName, []string{"compressed"}, pager.WithCompression())
+	if err := pager.NewPager(pager.WithMmap()).Open(dbName); err == nil {
+		t.Error("expected mapping a compressed file to fail")
+	}
+	// Corrupt pages are still caught.
+	corruptName := writePages(t, 3)
+	defer os.Remove(corruptName)
+	corruptFile(t, corruptName, (pager.HEADER_PAGES+1)*pager.PAGESIZE+pager.PAGE_HEADER_SIZE+100)
+	p = pager.NewPager(pager.WithMmap())
+	if err := p.Open(corruptName); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	touchPage(t, p, 0)
+	var corrupt *pager.CorruptPageError
+	if _, err := p.GetPage(1); !errors.As(err, &corrupt) {
+		t.Errorf("expected a corruption error, got %v", err)
+	}
+}
+
+func TestPagerPins(t *testing.T) {
+	t.Run("TestPagerPinsReport", testPagerPinsReport)
+	t.Run("TestPagerPinsNested", testPagerPinsNested)
+	t.Run("TestPagerPinsOff", testPagerPinsOff)
+	t.Run("TestPagerPinsTables", testPagerPinsTables)
+}
+
+// openPinPager opens a pager on a fresh in-memory file with pin tracking.
+func openPinPager(t *testing.T) *pager.Pager {
+	return openPager(t, "pins", pager.WithBackend(pager.NewMemoryBackend()), pager.WithPinTracking())
+}
+
+// leakPage pins a page and never releases it.
+func leakPage(t *testing.T, p *pager.Pager, pagenum int64) *pager.Page {
+	page, err := p.GetPage(pagenum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func testPagerPinsReport(t *testing.T) {
+	p := openPinPager(t)
+	touchPage(t, p, 1)
+	leaked := leakPage(t, p, 0)
+	pins := p.GetPins()
+	if len(pins) != 1 || pins[0].PageNum != 0 {
+		t.Fatalf("expected one pin of page 0, got %v", pins)
+	}
+	if !strings.Contains(pins[0].Stack, "leakPage") {
+		t.Errorf("pin stack does not name the code that took it:\n%v", pins[0].Stack)
+	}
+	var buf bytes.Buffer
+	if n := p.ReportPins(&buf); n != 1 || !strings.Contains(buf.String(), "page 0 pinned by") {
+		t.Errorf("unexpected report of %v pins:\n%v", n, buf.String())
+	}
+	leaked.Put()
+	if pins := p.GetPins(); len(pins) != 0 {
+		t.Errorf("expected no pins after Put, got %v", len(pins))
+	}
+	p.Close()
+}
+
+func testPagerPinsNested(t *testing.T) {
+	p := openPinPager(t)
+	defer p.Close()
+	first := leakPage(t, p, 0)
+	second := leakPage(t, p, 0)
+	second.Get()
+	if pins := p.GetPins(); len(pins) != 3 {
+		t.Fatalf("expected 3 pins, got %v", len(pins))
+	}
+	second.Put()
+	second.Put()
+	if pins := p.GetPins(); len(pins) != 1 || pins[0].PageNum != 0 {
+		t.Errorf("expected one pin to be left, got %v", pins)
+	}
+	first.Put()
+}
+
+func testPagerPinsOff(t *testing.T) {
+	p, dbName := openPolicyPager(t, pager.DEFAULT_POLICY)
+	defer os.Remove(dbName)
+	defer p.Close()
+	page := leakPage(t, p, 0)
+	defer page.Put()
+	if p.IsTrackingPins() || p.GetPins() != nil {
+		t.Error("pins were tracked without being asked for")
+	}
+}
+
+func testPagerPinsTables(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	database, err := db.Open(dir, db.WithBackend(pager.NewMemoryBackend()), db.WithPinTracking())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	n := int64(1000)
+	for _, indexType := range []string{"btree", "hash"} {
+		if err := db.HandleCreateTable(database, "create "+indexType+" table "+indexType, ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+		table, err := database.GetTable(indexType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := int64(0); i < n; i++ {
+			if err := table.Insert(i, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := int64(0); i < n; i += 2 {
+			if err := table.Update(i, -i); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := table.Find(i + 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := table.Select(); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if n := table.GetPager().ReportPins(&buf); n != 0 {
+			t.Errorf("%v table leaked %v pins:\n%v", indexType, n, buf.String())
+		}
+	}
+}
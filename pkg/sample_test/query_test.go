@@ -1,15 +1,20 @@
 package test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
 
+	db "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/db"
 	hash "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/hash"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
 	"github.com/csci1270-fall-2023/dbms-projects-handout/pkg/query"
+	utils "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/utils"
 )
 
 func TestQueryTA(t *testing.T) {
@@ -48,7 +53,7 @@ func setupQuery(t *testing.T) (string, string, *hash.HashIndex, *hash.HashIndex)
 	return dbName1, dbName2, index1, index2
 }
 
-func getresults(t *testing.T, index1 *hash.HashIndex, index2 *hash.HashIndex, joinOnLeftKey bool, joinOnRightKey bool) ([]query.EntryPair, error) {
+func getresults(t *testing.T, index1 db.Index, index2 db.Index, joinOnLeftKey bool, joinOnRightKey bool) ([]query.EntryPair, error) {
 	// Create context.
 	ctx, cancelCtx := context.WithCancel(context.Background())
 	defer cancelCtx()
@@ -136,3 +141,231 @@ func testFilterInsertAndCheckSmall(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryValueIndex(t *testing.T) {
+	t.Run("TestQueryValueIndexMaintained", testQueryValueIndexMaintained)
+	t.Run("TestQueryValueIndexJoin", testQueryValueIndexJoin)
+	t.Run("TestQueryValueIndexRollback", testQueryValueIndexRollback)
+}
+
+// runCommands runs create, insert, update and delete commands, failing the
+// test on an error.
+func runCommands(t *testing.T, database *db.Database, cmds ...string) {
+	for _, cmd := range cmds {
+		var err error
+		switch strings.Fields(cmd)[0] {
+		case "create":
+			err = db.HandleCreateTable(database, cmd, ioutil.Discard)
+		case "insert":
+			err = db.HandleInsert(database, cmd)
+		case "update":
+			err = db.HandleUpdate(database, cmd)
+		case "delete":
+			err = db.HandleDelete(database, cmd)
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", cmd, err)
+		}
+	}
+}
+
+// checkValueIndex fails the test unless looking up each value in the
+// table's value index finds the same entries as scanning the table does.
+func checkValueIndex(t *testing.T, database *db.Database, name string, numValues int64) {
+	table, err := database.GetTable(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed, ok := table.(*db.IndexedTable)
+	if !ok {
+		t.Fatalf("table %v has no value index", name)
+	}
+	total := 0
+	for value := int64(0); value < numValues; value++ {
+		want, err := db.FindValue(indexed.GetPrimary(), utils.EncodeInt(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.FindValue(indexed, utils.EncodeInt(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("value %v has %v entries in the index; expected %v", value, len(got), len(want))
+		}
+		for i := range got {
+			if got[i].GetKey() != want[i].GetKey() || got[i].GetValue() != want[i].GetValue() {
+				t.Fatalf("value %v has entry (%v, %v) in the index; expected (%v, %v)", value,
+					got[i].GetKey(), got[i].GetValue(), want[i].GetKey(), want[i].GetValue())
+			}
+		}
+		total += len(got)
+	}
+	entries, err := indexed.GetValueIndex().Select()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != total {
+		t.Fatalf("value index has %v entries; expected %v", len(entries), total)
+	}
+}
+
+func testQueryValueIndexMaintained(t *testing.T) {
+	backend := pager.NewMemoryBackend()
+	database, err := db.Open("db", db.WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	runCommands(t, database, "create btree table t")
+	for i := 0; i < 200; i++ {
+		runCommands(t, database, fmt.Sprintf("insert %v %v into t", i, i%7))
+	}
+	// The index is filled from the entries already in the table, then
+	// follows each change.
+	runCommands(t, database, "create index on t")
+	if err := database.CreateValueIndex("t"); err == nil {
+		t.Error("creating a second value index should fail")
+	}
+	if err := database.CreateValueIndex("missing"); err == nil {
+		t.Error("indexing a missing table should fail")
+	}
+	for i := 200; i < 300; i++ {
+		runCommands(t, database, fmt.Sprintf("insert %v %v into t", i, i%7))
+	}
+	for i := 0; i < 300; i += 3 {
+		runCommands(t, database, fmt.Sprintf("update t %v %v", i, i%11))
+	}
+	for i := 0; i < 300; i += 5 {
+		runCommands(t, database, fmt.Sprintf("delete %v from t", i))
+	}
+	checkValueIndex(t, database, "t", 11)
+	var buf bytes.Buffer
+	if err := db.HandleFind(database, "find value 10 from t", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if want := "(21, 10)\n(54, 10)\n(87, 10)\n(153, 10)\n(186, 10)\n(219, 10)\n(252, 10)\n"; buf.String() != want {
+		t.Errorf("unexpected find output: %q", buf.String())
+	}
+	// The index is opened again with its table.
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	database, err = db.Open("db", db.WithBackend(backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer database.Close()
+	runCommands(t, database, "insert 1000 3 into t")
+	checkValueIndex(t, database, "t", 11)
+}
+
+func testQueryValueIndexJoin(t *testing.T) {
+	database, err := db.Open("db", db.WithBackend(pager.NewMemoryBackend()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	defer database.Close()
+	runCommands(t, database, "create btree table a", "create btree table b")
+	for i := 0; i < 100; i++ {
+		runCommands(t, database, fmt.Sprintf("insert %v %v into a", i, i%10))
+	}
+	for i := 0; i < 20; i++ {
+		runCommands(t, database, fmt.Sprintf("insert %v %v into b", i, i%4))
+	}
+	runCommands(t, database, "create index on a")
+	a, err := database.GetTable("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := database.GetTable("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a's values are 0 to 9, ten times each; b's keys are 0 to 19 and its
+	// values are 0 to 3, five times each.
+	cases := []struct {
+		left, right           db.Index
+		leftOnKey, rightOnKey bool
+		want                  int
+	}{
+		{a, b, false, true, 100},
+		{b, a, true, false, 100},
+		{b, a, false, false, 200},
+		{a, a, false, false, 1000},
+		{a, a, true, false, 100},
+	}
+	for _, c := range cases {
+		results, err := getresults(t, c.left, c.right, c.leftOnKey, c.rightOnKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != c.want {
+			t.Errorf("join %v: got %v results; expected %v", c, len(results), c.want)
+		}
+	}
+}
+
+// A change that the value index rejects is undone in the table too.
+func testQueryValueIndexRollback(t *testing.T) {
+	backend := pager.NewFaultBackend(pager.NewMemoryBackend())
+	database, err := db.Open("db", db.WithBackend(backend), db.WithWriteback(pager.WritebackConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("db")
+	defer database.Close()
+	runCommands(t, database, "create btree table t")
+	for i := 0; i < 10; i++ {
+		runCommands(t, database, fmt.Sprintf("insert %v %v into t", i, i))
+	}
+	runCommands(t, database, "create index on t")
+	table, err := database.GetTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := table.(*db.IndexedTable).GetValueIndex()
+	checkValue := func(key int64, want int64) {
+		entry, err := table.Find(key)
+		if err != nil {
+			t.Fatal(err)
+		} else if entry.GetValue() != want {
+			t.Errorf("key %v has value %v; expected %v", key, entry.GetValue(), want)
+		}
+	}
+	// The index can't insert the new value, since a stale entry is in the
+	// way.
+	if err := values.Insert(utils.EncodeInt(50), utils.EncodeInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Update(5, 50); err == nil {
+		t.Error("update should fail when the index can't insert the new value")
+	}
+	checkValue(5, 5)
+	if err := values.Delete(utils.EncodeInt(50), utils.EncodeInt(5)); err != nil {
+		t.Fatal(err)
+	}
+	checkValueIndex(t, database, "t", 10)
+	// The index can't delete the old value once a failed write has made it
+	// read-only.
+	backend.SetSchedule(func(op pager.Op) pager.Fault {
+		if strings.HasSuffix(op.Name, db.VALUE_INDEX_SUFFIX) {
+			return pager.FaultFailWrite
+		}
+		return pager.FaultNone
+	})
+	if err := values.GetPager().FlushAllPages(); err == nil {
+		t.Fatal("expected the index's flush to fail")
+	}
+	backend.SetSchedule(nil)
+	if err := table.Update(3, 30); err == nil {
+		t.Error("update should fail when the index can't delete the old value")
+	}
+	checkValue(3, 3)
+	if err := table.Delete(3); err == nil {
+		t.Error("delete should fail when the index can't delete the value")
+	}
+	checkValue(3, 3)
+	checkValueIndex(t, database, "t", 10)
+}
@@ -0,0 +1,269 @@
+package test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	concurrency "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/concurrency"
+	db "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/db"
+	pager "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/pager"
+	recovery "github.com/csci1270-fall-2023/dbms-projects-handout/pkg/recovery"
+
+	uuid "github.com/google/uuid"
+)
+
+// openRecoveryManager opens the database in dir with the given cipher and
+// a recovery manager writing to the log file in it.
+func openRecoveryManager(t *testing.T, dir string, c *pager.Cipher) (*db.Database, *recovery.RecoveryManager) {
+	database, err := db.Open(dir, db.WithBufferPoolPages(16), db.WithCipher(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logName := filepath.Join(dir, "bumble.log")
+	if err := database.CreateLogFile(logName); err != nil {
+		t.Fatal(err)
+	}
+	tm := concurrency.NewTransactionManager(concurrency.NewLockManager())
+	rm, err := recovery.NewRecoveryManager(database, tm, logName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return database, rm
+}
+
+func TestRecoveryEncryptedLog(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "db-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	database, rm := openRecoveryManager(t, dir, newCipher(t, 1))
+	id := uuid.New()
+	rm.Table("btree", "secrets")
+	rm.Start(id)
+	rm.Commit(id)
+	database.Close()
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "bumble.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(contents, []byte("secrets")) || bytes.Contains(contents, []byte(id.String())) {
+		t.Error("log records were written in the clear")
+	}
+	// The wrong key can't read the log.
+	database, rm = openRecoveryManager(t, dir, newCipher(t, 2))
+	var authErr *pager.AuthError
+	if err := rm.Recover(); !errors.As(err, &authErr) {
+		t.Errorf("expected an authentication error, got %v", err)
+	}
+	database.Close()
+	// The right key replays the log, recreating the table.
+	database, rm = openRecoveryManager(t, dir, newCipher(t, 1))
+	defer database.Close()
+	if err := rm.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.GetTable("secrets"); err != nil {
+		t.Error(err)
+	}
+}
+
+// crashDatabase holds a database kept in a fault backend, with its log
+// outside the database folder so that priming leaves the log alone.
+type crashDatabase struct {
+	backend  *pager.FaultBackend
+	dir      string
+	database *db.Database
+	tm       *concurrency.TransactionManager
+	rm       *recovery.RecoveryManager
+}
+
+// openCrashDatabase primes and opens the database in dir, kept in backend.
+func openCrashDatabase(t *testing.T, backend *pager.FaultBackend, dir string) *crashDatabase {
+	database, err := recovery.Prime(dir, db.WithBackend(backend), db.WithBufferPoolPages(16), db.WithWriteback(pager.WritebackConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logName := dir + ".log"
+	if err := database.CreateLogFile(logName); err != nil {
+		t.Fatal(err)
+	}
+	tm := concurrency.NewTransactionManager(concurrency.NewLockManager())
+	rm, err := recovery.NewRecoveryManager(database, tm, logName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &crashDatabase{backend: backend, dir: dir, database: database, tm: tm, rm: rm}
+}
+
+// run runs the given recovery commands for the client.
+func (c *crashDatabase) run(t *testing.T, id uuid.UUID, commands ...string) {
+	for _, command := range commands {
+		var err error
+		switch strings.Fields(command)[0] {
+		case "transaction":
+			err = recovery.HandleTransaction(c.database, c.tm, c.rm, command, ioutil.Discard, id)
+		case "create":
+			err = recovery.HandleCreateTable(c.database, c.tm, c.rm, command, ioutil.Discard, id)
+		case "insert":
+			err = recovery.HandleInsert(c.database, c.tm, c.rm, command, id)
+		case "update":
+			err = recovery.HandleUpdate(c.database, c.tm, c.rm, command, id)
+		case "delete":
+			err = recovery.HandleDelete(c.database, c.tm, c.rm, command, id)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", command, err)
+		}
+	}
+}
+
+// crash closes the database while the backend hits every write with the
+// fault, then reopens and recovers it.
+func (c *crashDatabase) crash(t *testing.T, fault pager.Fault) *crashDatabase {
+	c.backend.SetSchedule(pager.FaultAfter(fault, 0))
+	c.database.Close()
+	c.backend.SetSchedule(nil)
+	recovered := openCrashDatabase(t, c.backend, c.dir)
+	if err := recovered.rm.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	return recovered
+}
+
+// check checks that the keys of table t map to the wanted values, and that
+// the gone keys aren't in it.
+func (c *crashDatabase) check(t *testing.T, want map[int64]int64, gone []int64) {
+	table, err := c.database.GetTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range want {
+		entry, err := table.Find(key)
+		if err != nil {
+			t.Errorf("key %d: %v", key, err)
+		} else if entry.GetValue() != value {
+			t.Errorf("key %d: expected %d, got %d", key, value, entry.GetValue())
+		}
+	}
+	for _, key := range gone {
+		if _, err := table.Find(key); err == nil {
+			t.Errorf("key %d shouldn't have been recovered", key)
+		}
+	}
+}
+
+// commands formats a command for each key in [from, to), passing the key
+// and, unless value is nil, its value.
+func commands(format string, from int64, to int64, value func(int64) int64) []string {
+	cmds := make([]string, 0, to-from)
+	for key := from; key < to; key++ {
+		if value == nil {
+			cmds = append(cmds, fmt.Sprintf(format, key))
+		} else {
+			cmds = append(cmds, fmt.Sprintf(format, key, value(key)))
+		}
+	}
+	return cmds
+}
+
+func identity(key int64) int64 { return key }
+
+func TestRecoveryCrash(t *testing.T) {
+	t.Run("TornPages", testRecoveryCrashTornPages)
+	t.Run("TornCommit", testRecoveryCrashTornCommit)
+	t.Run("FailedSync", testRecoveryCrashFailedSync)
+}
+
+// setUpCrash creates table t with keys [0, 50) in a committed transaction,
+// then checkpoints.
+func setUpCrash(t *testing.T) *crashDatabase {
+	c := openCrashDatabase(t, pager.NewFaultBackend(pager.NewMemoryBackend()), filepath.Join(t.TempDir(), "db"))
+	id := uuid.New()
+	c.run(t, id, "transaction begin", "create btree table t")
+	c.run(t, id, commands("insert %d %d into t", 0, 50, identity)...)
+	c.run(t, id, "transaction commit")
+	if err := c.rm.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Committed changes after the checkpoint are redone and uncommitted ones
+// undone, even though the table's pages were torn by the crash.
+func testRecoveryCrashTornPages(t *testing.T) {
+	c := setUpCrash(t)
+	committed, uncommitted := uuid.New(), uuid.New()
+	c.run(t, committed, "transaction begin")
+	c.run(t, committed, commands("insert %d %d into t", 50, 100, identity)...)
+	c.run(t, committed, commands("update t %d %d", 0, 10, func(key int64) int64 { return key + 1000 })...)
+	c.run(t, committed, "transaction commit")
+	c.run(t, uncommitted, "transaction begin")
+	c.run(t, uncommitted, commands("insert %d %d into t", 100, 110, identity)...)
+	c.run(t, uncommitted, commands("delete %d from t", 10, 20, nil)...)
+	c = c.crash(t, pager.FaultTearWrite)
+	defer c.database.Close()
+	want := make(map[int64]int64)
+	for key := int64(0); key < 100; key++ {
+		want[key] = key
+		if key < 10 {
+			want[key] += 1000
+		}
+	}
+	c.check(t, want, []int64{100, 105, 109})
+}
+
+// A transaction whose commit record was torn didn't commit.
+func testRecoveryCrashTornCommit(t *testing.T) {
+	c := setUpCrash(t)
+	id := uuid.New()
+	c.run(t, id, "transaction begin")
+	c.run(t, id, commands("insert %d %d into t", 50, 60, identity)...)
+	c.run(t, id, commands("update t %d %d", 0, 10, func(key int64) int64 { return key + 1000 })...)
+	c.backend.SetSchedule(pager.FaultAt(pager.FaultTearWrite, 0))
+	c.run(t, id, "transaction commit")
+	c = c.crash(t, pager.FaultDropWrite)
+	defer c.database.Close()
+	want := make(map[int64]int64)
+	for key := int64(0); key < 50; key++ {
+		want[key] = key
+	}
+	c.check(t, want, []int64{50, 55, 59})
+}
+
+// A checkpoint whose sync fails isn't written, so recovery replays the
+// whole log.
+func testRecoveryCrashFailedSync(t *testing.T) {
+	c := openCrashDatabase(t, pager.NewFaultBackend(pager.NewMemoryBackend()), filepath.Join(t.TempDir(), "db"))
+	id := uuid.New()
+	c.run(t, id, "transaction begin", "create btree table t")
+	c.run(t, id, commands("insert %d %d into t", 0, 50, identity)...)
+	c.run(t, id, "transaction commit")
+	c.backend.SetSchedule(pager.FaultAt(pager.FaultFailSync, 0))
+	var writeErr *pager.WriteError
+	if err := c.rm.Checkpoint(); !errors.As(err, &writeErr) || !writeErr.Sync {
+		t.Fatalf("expected a sync error, got %v", err)
+	}
+	c.backend.SetSchedule(nil)
+	// The table can't be written to any more.
+	table, err := c.database.GetTable("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := table.Insert(50, 50); err == nil {
+		t.Error("expected the table to be read-only")
+	}
+	c = c.crash(t, pager.FaultDropWrite)
+	defer c.database.Close()
+	want := make(map[int64]int64)
+	for key := int64(0); key < 50; key++ {
+		want[key] = key
+	}
+	c.check(t, want, nil)
+}
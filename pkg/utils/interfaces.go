@@ -1,15 +1,39 @@
 package utils
 
+import (
+	"io"
+)
+
 // Interface for an entry in a table.
 type Entry interface {
 	GetKey() int64
 	GetValue() int64
+	GetKeyBytes() []byte
+	GetValueBytes() []byte
 	Marshal() []byte
 }
 
 // Interface for a cursor that traverses a table.
 type Cursor interface {
 	StepForward() bool
+	StepBackward() bool
+	Seek([]byte) error
 	IsEnd() bool
 	GetEntry() (Entry, error)
+	Close()
+}
+
+// Interface for an iterator over a range of a table's entries. Next
+// advances to the next entry, returning false once there are none left
+// or an error occurred.
+type Iterator interface {
+	Next() bool
+	Entry() Entry
+	Err() error
+	Close()
+}
+
+// Interface for the statistics an index reports on its structure.
+type IndexStats interface {
+	Print(io.Writer)
 }
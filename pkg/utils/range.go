@@ -0,0 +1,16 @@
+package utils
+
+// Bound is one end of a range of keys. A nil key leaves that end of the
+// range open.
+type Bound struct {
+	Key       []byte
+	Inclusive bool
+}
+
+// Range describes a scan over some of a table's keys.
+type Range struct {
+	Lo      Bound // Lowest key in the range.
+	Hi      Bound // Highest key in the range.
+	Limit   int64 // Maximum number of entries to return; 0 for no limit.
+	Reverse bool  // Return entries from the highest key to the lowest.
+}
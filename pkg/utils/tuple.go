@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// A tuple is a key made of several components, each itself a key, such as
+// an integer or a string. It is stored as a tag byte followed by its
+// components, each behind a marker byte, with its zero bytes escaped and a
+// terminator after it. Comparing two tuples byte by byte thus orders them
+// lexicographically by component, and orders a tuple before the longer
+// tuples that start with it.
+
+// Tag of encoded tuples.
+const TUPLE_TAG byte = 3
+
+// Bytes that start a component of a tuple, and that end the prefix made by
+// EncodeTuplePrefixEnd.
+const (
+	componentMarker byte = 0x01
+	prefixEndMarker byte = 0x02
+)
+
+// Bytes that end a component of a tuple, and that stand for a zero byte in
+// it.
+var (
+	componentTerminator = []byte{0x00, 0x01}
+	componentEscape     = []byte{0x00, 0xff}
+)
+
+// EncodeTuple returns the byte string for a tuple of the given components.
+func EncodeTuple(components ...[]byte) []byte {
+	size := 1
+	for _, component := range components {
+		size += len(component) + 3
+	}
+	data := make([]byte, 1, size)
+	data[0] = TUPLE_TAG
+	for _, component := range components {
+		data = append(data, componentMarker)
+		for _, b := range component {
+			if b == 0x00 {
+				data = append(data, componentEscape...)
+			} else {
+				data = append(data, b)
+			}
+		}
+		data = append(data, componentTerminator...)
+	}
+	return data
+}
+
+// EncodeTuplePrefixEnd returns a byte string that sorts after every tuple
+// that starts with the given components, and before every other tuple
+// after them.
+func EncodeTuplePrefixEnd(components ...[]byte) []byte {
+	return append(EncodeTuple(components...), prefixEndMarker)
+}
+
+// PrefixRange returns the range of tuples that start with the given
+// components.
+func PrefixRange(components ...[]byte) Range {
+	return Range{
+		Lo: Bound{Key: EncodeTuple(components...), Inclusive: true},
+		Hi: Bound{Key: EncodeTuplePrefixEnd(components...)},
+	}
+}
+
+// parseTuple returns the components of a byte string made by EncodeTuple
+// or EncodeTuplePrefixEnd, and whether it was made by the latter.
+func parseTuple(data []byte) (components [][]byte, prefixEnd bool, err error) {
+	if len(data) == 0 || data[0] != TUPLE_TAG {
+		return nil, false, errors.New("not a tuple")
+	}
+	components = make([][]byte, 0)
+	for rest := data[1:]; len(rest) > 0; {
+		switch rest[0] {
+		case prefixEndMarker:
+			if len(rest) > 1 {
+				return nil, false, errors.New("tuple continues past its end")
+			}
+			return components, true, nil
+		case componentMarker:
+		default:
+			return nil, false, errors.New("invalid tuple component")
+		}
+		component := make([]byte, 0)
+		for i := 1; ; i++ {
+			if i+1 >= len(rest) {
+				return nil, false, errors.New("tuple component is not terminated")
+			}
+			if rest[i] != 0x00 {
+				component = append(component, rest[i])
+			} else if rest[i+1] == componentEscape[1] {
+				component = append(component, 0x00)
+				i++
+			} else if rest[i+1] == componentTerminator[1] {
+				rest = rest[i+2:]
+				break
+			} else {
+				return nil, false, errors.New("invalid escape in tuple component")
+			}
+		}
+		components = append(components, component)
+	}
+	return components, false, nil
+}
+
+// DecodeTuple returns the components of a byte string made by EncodeTuple,
+// or false if the byte string isn't a tuple.
+func DecodeTuple(data []byte) ([][]byte, bool) {
+	components, prefixEnd, err := parseTuple(data)
+	if err != nil || prefixEnd {
+		return nil, false
+	}
+	return components, true
+}
+
+// CompareTuples compares two tuples component by component, comparing the
+// i-th components with collate(i, a, b). A tuple that runs out of
+// components first comes first, and the end of a prefix made by
+// EncodeTuplePrefixEnd comes after every tuple that starts with it. Byte
+// strings that aren't tuples are compared byte by byte.
+func CompareTuples(a []byte, b []byte, collate func(i int, a []byte, b []byte) int) int {
+	aComponents, aEnd, aErr := parseTuple(a)
+	bComponents, bEnd, bErr := parseTuple(b)
+	if aErr != nil || bErr != nil {
+		return bytes.Compare(a, b)
+	}
+	shared := len(aComponents)
+	if len(bComponents) < shared {
+		shared = len(bComponents)
+	}
+	for i := 0; i < shared; i++ {
+		if c := collate(i, aComponents[i], bComponents[i]); c != 0 {
+			return c
+		}
+	}
+	// Rank what comes after the shared components: nothing, another
+	// component, or the end of a prefix.
+	rank := func(components [][]byte, end bool) int {
+		switch {
+		case len(components) > shared:
+			return 1
+		case end:
+			return 2
+		}
+		return 0
+	}
+	aRank, bRank := rank(aComponents, aEnd), rank(bComponents, bEnd)
+	switch {
+	case aRank < bRank:
+		return -1
+	case aRank > bRank:
+		return 1
+	}
+	return 0
+}
+
+// formatTuple formats a tuple for printing as its components in square
+// brackets, the way tuples are typed at the REPL.
+func formatTuple(components [][]byte) string {
+	formatted := make([]string, len(components))
+	for i, component := range components {
+		formatted[i] = FormatBytes(component)
+	}
+	return "[" + strings.Join(formatted, ", ") + "]"
+}
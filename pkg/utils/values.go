@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// Keys and values are byte strings. Integers and strings are stored with a
+// leading tag byte, so that they can be told apart when printed, and
+// integers are stored big-endian with the sign bit flipped, so that
+// comparing two encoded keys byte by byte orders integers numerically,
+// strings lexicographically, and every integer before every string.
+
+// Tags of encoded integers and strings.
+const (
+	INT_TAG    byte = 1
+	STRING_TAG byte = 2
+)
+
+// Size of an encoded integer.
+const INT_SIZE = 1 + 8
+
+// EncodeInt returns the byte string for an integer key or value.
+func EncodeInt(i int64) []byte {
+	data := make([]byte, INT_SIZE)
+	data[0] = INT_TAG
+	binary.BigEndian.PutUint64(data[1:], uint64(i)^(1<<63))
+	return data
+}
+
+// DecodeInt returns the integer in a byte string made by EncodeInt, or
+// false if the byte string isn't an integer.
+func DecodeInt(data []byte) (int64, bool) {
+	if len(data) != INT_SIZE || data[0] != INT_TAG {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(data[1:]) ^ (1 << 63)), true
+}
+
+// EncodeString returns the byte string for a string key or value.
+func EncodeString(s string) []byte {
+	return append([]byte{STRING_TAG}, s...)
+}
+
+// DecodeString returns the string in a byte string made by EncodeString,
+// or false if the byte string isn't a string.
+func DecodeString(data []byte) (string, bool) {
+	if len(data) == 0 || data[0] != STRING_TAG {
+		return "", false
+	}
+	return string(data[1:]), true
+}
+
+// FormatBytes formats a key or value for printing: integers in decimal,
+// strings quoted, tuples as their components in square brackets, and
+// anything else in hexadecimal.
+func FormatBytes(data []byte) string {
+	if i, ok := DecodeInt(data); ok {
+		return strconv.FormatInt(i, 10)
+	}
+	if s, ok := DecodeString(data); ok {
+		return strconv.Quote(s)
+	}
+	if components, ok := DecodeTuple(data); ok {
+		return formatTuple(components)
+	}
+	return fmt.Sprintf("0x%x", data)
+}